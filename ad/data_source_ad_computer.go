@@ -1,10 +1,11 @@
 package ad
 
 import (
-	"fmt"
+	"context"
 
 	"github.com/hashicorp/terraform-provider-ad/ad/internal/config"
 
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"github.com/hashicorp/terraform-provider-ad/ad/internal/winrmhelper"
 )
@@ -12,7 +13,7 @@ import (
 func dataSourceADComputer() *schema.Resource {
 	return &schema.Resource{
 		Description: "Get the details of an Active Directory Computer object.",
-		Read:        dataSourceADComputerRead,
+		ReadContext: dataSourceADComputerRead,
 		Schema: map[string]*schema.Schema{
 			"computer_id": {
 				Type:        schema.TypeString,
@@ -45,14 +46,14 @@ func dataSourceADComputer() *schema.Resource {
 	}
 }
 
-func dataSourceADComputerRead(d *schema.ResourceData, meta interface{}) error {
-	dn := winrmhelper.SanitiseTFInput(d, "dn")
-	guid := winrmhelper.SanitiseTFInput(d, "guid")
-	computerID := winrmhelper.SanitiseTFInput(d, "computer_id")
+func dataSourceADComputerRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	dn := d.Get("dn").(string)
+	guid := d.Get("guid").(string)
+	computerID := d.Get("computer_id").(string)
 
 	var identity string
 	if computerID == "" && guid == "" && dn == "" {
-		return fmt.Errorf("invalid inputs for AD computer datasource. computer_id dn or guid is required")
+		return diag.Errorf("invalid inputs for AD computer datasource. computer_id dn or guid is required")
 	} else if computerID != "" {
 		identity = computerID
 	} else if guid != "" {
@@ -61,9 +62,9 @@ func dataSourceADComputerRead(d *schema.ResourceData, meta interface{}) error {
 		identity = dn
 	}
 
-	computer, err := winrmhelper.NewComputerFromHost(meta.(*config.ProviderConf), identity)
+	computer, err := winrmhelper.NewComputerFromHost(ctx, meta.(*config.ProviderConf), identity)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
 	d.SetId(computer.GUID)
@@ -0,0 +1,170 @@
+package ad
+
+import (
+	"context"
+
+	"github.com/hashicorp/terraform-provider-ad/ad/internal/config"
+
+	"github.com/hashicorp/terraform-provider-ad/ad/internal/winrmhelper"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func dataSourceADContact() *schema.Resource {
+	return &schema.Resource{
+		Description: "Get the details of an Active Directory contact object.",
+		ReadContext: dataSourceADContactRead,
+		Schema: map[string]*schema.Schema{
+			"contact_id": {
+				Type:        schema.TypeString,
+				Required:    true,
+				Description: "The contact's identifier. It can be the contact's GUID or Distinguished Name.",
+			},
+			"name": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The name of the contact object.",
+			},
+			"container": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The DN of the container object holding the contact.",
+			},
+			"dn": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The distinguished name of the contact object.",
+			},
+			"display_name": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The display name of the contact.",
+			},
+			"given_name": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The given name of the contact.",
+			},
+			"surname": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The surname of the contact.",
+			},
+			"initials": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The initials of the contact.",
+			},
+			"description": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The description of the contact.",
+			},
+			"email_address": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The email address of the contact.",
+			},
+			"office_phone": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The office phone number of the contact.",
+			},
+			"mobile_phone": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The mobile phone number of the contact.",
+			},
+			"home_phone": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The home phone number of the contact.",
+			},
+			"fax": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The fax number of the contact.",
+			},
+			"street_address": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The street address of the contact.",
+			},
+			"city": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The city of the contact.",
+			},
+			"state": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The state or province of the contact.",
+			},
+			"postal_code": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The postal code of the contact.",
+			},
+			"country": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The country of the contact, as a two-letter ISO 3166 code.",
+			},
+			"company": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The company of the contact.",
+			},
+			"department": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The department of the contact.",
+			},
+			"title": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The job title of the contact.",
+			},
+			"office": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The office of the contact.",
+			},
+		},
+	}
+}
+
+func dataSourceADContactRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	contactID := d.Get("contact_id").(string)
+	c, err := winrmhelper.GetContactFromHost(ctx, meta.(*config.ProviderConf), contactID, nil)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	_ = d.Set("name", c.Name)
+	_ = d.Set("container", c.Container)
+	_ = d.Set("dn", c.DistinguishedName)
+	_ = d.Set("display_name", c.DisplayName)
+	_ = d.Set("given_name", c.GivenName)
+	_ = d.Set("surname", c.Surname)
+	_ = d.Set("initials", c.Initials)
+	_ = d.Set("description", c.Description)
+	_ = d.Set("email_address", c.EmailAddress)
+	_ = d.Set("office_phone", c.OfficePhone)
+	_ = d.Set("mobile_phone", c.MobilePhone)
+	_ = d.Set("home_phone", c.HomePhone)
+	_ = d.Set("fax", c.Fax)
+	_ = d.Set("street_address", c.StreetAddress)
+	_ = d.Set("city", c.City)
+	_ = d.Set("state", c.State)
+	_ = d.Set("postal_code", c.PostalCode)
+	_ = d.Set("country", c.Country)
+	_ = d.Set("company", c.Company)
+	_ = d.Set("department", c.Department)
+	_ = d.Set("title", c.Title)
+	_ = d.Set("office", c.Office)
+	d.SetId(c.GUID)
+
+	return nil
+}
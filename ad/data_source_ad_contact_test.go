@@ -0,0 +1,50 @@
+package ad
+
+import (
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+)
+
+func TestAccDataSourceADContact_basic(t *testing.T) {
+	envVars := []string{
+		"TF_VAR_ad_contact_name",
+		"TF_VAR_ad_contact_container",
+	}
+	resource.Test(t, resource.TestCase{
+		PreCheck:  func() { testAccPreCheck(t, envVars) },
+		Providers: testAccProviders,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccDataSourceADContactBasic(),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttrPair(
+						"data.ad_contact.d", "id",
+						"ad_contact.c", "id",
+					),
+					resource.TestCheckResourceAttrPair(
+						"data.ad_contact.d", "email_address",
+						"ad_contact.c", "email_address",
+					),
+				),
+			},
+		},
+	})
+}
+
+func testAccDataSourceADContactBasic() string {
+	return `
+	variable "ad_contact_name" {}
+	variable "ad_contact_container" {}
+
+	resource "ad_contact" "c" {
+		name          = var.ad_contact_name
+		container     = var.ad_contact_container
+		email_address = "vendor@example.com"
+	}
+
+	data "ad_contact" "d" {
+		contact_id = ad_contact.c.dn
+	}
+`
+}
@@ -1,10 +1,12 @@
 package ad
 
 import (
-	"strings"
+	"context"
+	"errors"
 
 	"github.com/hashicorp/terraform-provider-ad/ad/internal/config"
 
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"github.com/hashicorp/terraform-provider-ad/ad/internal/winrmhelper"
 )
@@ -12,7 +14,7 @@ import (
 func dataSourceADGPO() *schema.Resource {
 	return &schema.Resource{
 		Description: "Get the details of an Active Directory Group Policy Object.",
-		Read:        dataSourceADGPORead,
+		ReadContext: dataSourceADGPORead,
 		Schema: map[string]*schema.Schema{
 			"name": {
 				Type:        schema.TypeString,
@@ -33,16 +35,16 @@ func dataSourceADGPO() *schema.Resource {
 	}
 }
 
-func dataSourceADGPORead(d *schema.ResourceData, meta interface{}) error {
-	name := winrmhelper.SanitiseTFInput(d, "name")
-	guid := winrmhelper.SanitiseTFInput(d, "guid")
-	gpo, err := winrmhelper.GetGPOFromHost(meta.(*config.ProviderConf), name, guid)
+func dataSourceADGPORead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	name := d.Get("name").(string)
+	guid := d.Get("guid").(string)
+	gpo, err := winrmhelper.GetGPOFromHost(ctx, meta.(*config.ProviderConf), name, guid)
 	if err != nil {
-		if strings.Contains(err.Error(), "GpoWithNameNotFound") || strings.Contains(err.Error(), "GpoWithIdNotFound") {
+		if errors.Is(err, winrmhelper.ErrNotFound) {
 			d.SetId("")
 			return nil
 		}
-		return err
+		return diag.FromErr(err)
 	}
 
 	_ = d.Set("name", gpo.Name)
@@ -1,19 +1,20 @@
 package ad
 
 import (
-	"fmt"
+	"context"
 
 	"github.com/hashicorp/terraform-provider-ad/ad/internal/config"
 
 	"github.com/hashicorp/terraform-provider-ad/ad/internal/winrmhelper"
 
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 )
 
 func dataSourceADGroup() *schema.Resource {
 	return &schema.Resource{
 		Description: "Get the details of an Active Directory Group object.",
-		Read:        dataSourceADGroupRead,
+		ReadContext: dataSourceADGroupRead,
 		Schema: map[string]*schema.Schema{
 			"group_id": {
 				Type:        schema.TypeString,
@@ -69,15 +70,15 @@ func dataSourceADGroup() *schema.Resource {
 	}
 }
 
-func dataSourceADGroupRead(d *schema.ResourceData, meta interface{}) error {
+func dataSourceADGroupRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	groupID := d.Get("group_id").(string)
 
-	g, err := winrmhelper.GetGroupFromHost(meta.(*config.ProviderConf), groupID)
+	g, err := winrmhelper.GetGroupFromHost(ctx, meta.(*config.ProviderConf), groupID)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 	if g == nil {
-		return fmt.Errorf("No group found with group_id %q", groupID)
+		return diag.Errorf("No group found with group_id %q", groupID)
 	}
 	_ = d.Set("sam_account_name", g.SAMAccountName)
 	_ = d.Set("display_name", g.Name)
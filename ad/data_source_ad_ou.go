@@ -1,11 +1,12 @@
 package ad
 
 import (
-	"fmt"
+	"context"
 	"strconv"
 
 	"github.com/hashicorp/terraform-provider-ad/ad/internal/config"
 
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"github.com/hashicorp/terraform-provider-ad/ad/internal/winrmhelper"
 )
@@ -13,7 +14,7 @@ import (
 func dataSourceADOU() *schema.Resource {
 	return &schema.Resource{
 		Description: "Get the details of an Organizational Unit Active Directory object.",
-		Read:        dataSourceADOURead,
+		ReadContext: dataSourceADOURead,
 		Schema: map[string]*schema.Schema{
 			"ou_id": {
 				Type:        schema.TypeString,
@@ -50,14 +51,14 @@ func dataSourceADOU() *schema.Resource {
 	}
 }
 
-func dataSourceADOURead(d *schema.ResourceData, meta interface{}) error {
-	name := winrmhelper.SanitiseTFInput(d, "name")
-	path := winrmhelper.SanitiseTFInput(d, "path")
-	dn := winrmhelper.SanitiseTFInput(d, "dn")
-	ouID := winrmhelper.SanitiseTFInput(d, "ou_id")
+func dataSourceADOURead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	name := d.Get("name").(string)
+	path := d.Get("path").(string)
+	dn := d.Get("dn").(string)
+	ouID := d.Get("ou_id").(string)
 
 	if dn == "" && (name == "" || path == "") && ouID == "" {
-		return fmt.Errorf("invalid inputs, ou_id or dn or a combination of path and name are required")
+		return diag.Errorf("invalid inputs, ou_id or dn or a combination of path and name are required")
 	}
 
 	var ouIdentifier string
@@ -66,9 +67,9 @@ func dataSourceADOURead(d *schema.ResourceData, meta interface{}) error {
 	} else {
 		ouIdentifier = dn
 	}
-	ou, err := winrmhelper.NewOrgUnitFromHost(meta.(*config.ProviderConf), ouIdentifier, name, path)
+	ou, err := winrmhelper.NewOrgUnitFromHost(ctx, meta.(*config.ProviderConf), ouIdentifier, name, path)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
 	_ = d.Set("name", ou.Name)
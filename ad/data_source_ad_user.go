@@ -1,19 +1,20 @@
 package ad
 
 import (
-	"fmt"
+	"context"
 
 	"github.com/hashicorp/terraform-provider-ad/ad/internal/config"
 
 	"github.com/hashicorp/terraform-provider-ad/ad/internal/winrmhelper"
 
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 )
 
 func dataSourceADUser() *schema.Resource {
 	return &schema.Resource{
 		Description: "Get the details of an Active Directory user object.",
-		Read:        dataSourceADUserRead,
+		ReadContext: dataSourceADUserRead,
 		Schema: map[string]*schema.Schema{
 			"user_id": {
 				Type:        schema.TypeString,
@@ -194,15 +195,15 @@ func dataSourceADUser() *schema.Resource {
 	}
 }
 
-func dataSourceADUserRead(d *schema.ResourceData, meta interface{}) error {
+func dataSourceADUserRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	userID := d.Get("user_id").(string)
-	u, err := winrmhelper.GetUserFromHost(meta.(*config.ProviderConf), userID, nil)
+	u, err := winrmhelper.GetUserFromHost(ctx, meta.(*config.ProviderConf), userID, nil)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
 	if u == nil {
-		return fmt.Errorf("No user found with user_id %q", userID)
+		return diag.Errorf("No user found with user_id %q", userID)
 	}
 	_ = d.Set("sam_account_name", u.SAMAccountName)
 	_ = d.Set("display_name", u.DisplayName)
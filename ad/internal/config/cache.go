@@ -0,0 +1,119 @@
+package config
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+)
+
+// ObjectCache holds the objects returned by the bulk queries of a run, for the reads of the resources
+// to be served without sending a command for each object. The objects are held for each identity the
+// queries are run as, since credential profiles may not be allowed to read the same attributes.
+type ObjectCache struct {
+	mx      sync.Mutex
+	queries map[string]*cachedQuery
+	// objects holds the JSON documents of the objects by lowercase GUID, then by identity.
+	objects map[string]map[string][]byte
+	// modified holds the GUIDs of the objects modified during the run. They are not cached anymore, since
+	// a bulk query running at the same time could return them as they were before.
+	modified map[string]bool
+}
+
+// cachedQuery is a bulk query run once per identity. done is closed once it completed.
+type cachedQuery struct {
+	done chan struct{}
+	err  error
+}
+
+// NewObjectCache returns an empty object cache.
+func NewObjectCache() *ObjectCache {
+	return &ObjectCache{
+		queries:  map[string]*cachedQuery{},
+		objects:  map[string]map[string][]byte{},
+		modified: map[string]bool{},
+	}
+}
+
+// Prefetch runs fetch, which returns JSON documents by GUID, and caches its result for identity. The
+// query is only run once per identity and key, concurrent calls wait for the first one to complete and
+// return its error.
+func (c *ObjectCache) Prefetch(ctx context.Context, identity, key string, fetch func(context.Context) (map[string][]byte, error)) error {
+	queryKey := identity + "\x00" + key
+	c.mx.Lock()
+	query, ok := c.queries[queryKey]
+	if !ok {
+		query = &cachedQuery{done: make(chan struct{})}
+		c.queries[queryKey] = query
+	}
+	c.mx.Unlock()
+
+	if ok {
+		select {
+		case <-query.done:
+			return query.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	objects, err := fetch(ctx)
+	c.mx.Lock()
+	for guid, doc := range objects {
+		guid = strings.ToLower(guid)
+		if c.modified[guid] {
+			continue
+		}
+		if c.objects[guid] == nil {
+			c.objects[guid] = map[string][]byte{}
+		}
+		c.objects[guid][identity] = doc
+	}
+	c.mx.Unlock()
+	query.err = err
+	close(query.done)
+	log.Printf("[DEBUG] Cached %d objects for %s", len(objects), key)
+	return err
+}
+
+// Get returns the JSON document of the object guid cached for identity, or nil.
+func (c *ObjectCache) Get(identity, guid string) []byte {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return c.objects[strings.ToLower(guid)][identity]
+}
+
+// Invalidate drops the object guid, which was modified, for the next reads to retrieve it again.
+func (c *ObjectCache) Invalidate(guid string) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	guid = strings.ToLower(guid)
+	delete(c.objects, guid)
+	c.modified[guid] = true
+}
+
+// Prefetch runs the bulk query fetch identified by key unless it already ran, and caches the objects it
+// returns for the next calls to CachedObject. It does nothing if object_cache is not set.
+func (pcfg *ProviderConf) Prefetch(ctx context.Context, key string, fetch func(context.Context) (map[string][]byte, error)) error {
+	if pcfg.objectCache == nil {
+		return nil
+	}
+	return pcfg.objectCache.Prefetch(ctx, pcfg.CredentialUsername(), key, fetch)
+}
+
+// CachedObject returns the JSON document of the object guid retrieved by a bulk query, or nil if it must
+// be retrieved on its own.
+func (pcfg *ProviderConf) CachedObject(guid string) []byte {
+	if pcfg.objectCache == nil || guid == "" {
+		return nil
+	}
+	return pcfg.objectCache.Get(pcfg.CredentialUsername(), guid)
+}
+
+// InvalidateObject drops the object guid from the cache. It must be called when the object is created,
+// modified or deleted.
+func (pcfg *ProviderConf) InvalidateObject(guid string) {
+	if pcfg.objectCache != nil {
+		pcfg.objectCache.Invalidate(guid)
+	}
+}
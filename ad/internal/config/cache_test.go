@@ -0,0 +1,79 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestObjectCache(t *testing.T) {
+	cache := NewObjectCache()
+	queries := 0
+	fetch := func(context.Context) (map[string][]byte, error) {
+		queries++
+		return map[string][]byte{
+			"5D2F0B8E-1C9A-4F4B-9C1E-2A6B3F1D7E01": []byte(`{"Name":"jdoe"}`),
+			"8a1c0f3e-7b2d-4e5f-a6b7-c8d9e0f1a2b3": []byte(`{"Name":"asmith"}`),
+		}, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := cache.Prefetch(context.Background(), "svc-terraform", "Get-ADUser ou=users", fetch); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if queries != 1 {
+		t.Errorf("expected the query to run once, ran %d times", queries)
+	}
+	if doc := cache.Get("svc-terraform", "5d2f0b8e-1c9a-4f4b-9c1e-2a6b3f1d7e01"); string(doc) != `{"Name":"jdoe"}` {
+		t.Errorf("unexpected document %q", doc)
+	}
+	if doc := cache.Get("helpdesk", "5d2f0b8e-1c9a-4f4b-9c1e-2a6b3f1d7e01"); doc != nil {
+		t.Errorf("expected the objects to be cached for the identity that retrieved them, got %q", doc)
+	}
+
+	cache.Invalidate("8A1C0F3E-7B2D-4E5F-A6B7-C8D9E0F1A2B3")
+	if doc := cache.Get("svc-terraform", "8a1c0f3e-7b2d-4e5f-a6b7-c8d9e0f1a2b3"); doc != nil {
+		t.Errorf("expected the modified object to be dropped, got %q", doc)
+	}
+	// Modified objects are not cached again by later queries.
+	if err := cache.Prefetch(context.Background(), "helpdesk", "Get-ADUser ou=users", fetch); err != nil {
+		t.Fatal(err)
+	}
+	if doc := cache.Get("helpdesk", "8a1c0f3e-7b2d-4e5f-a6b7-c8d9e0f1a2b3"); doc != nil {
+		t.Errorf("expected the modified object not to be cached, got %q", doc)
+	}
+	if doc := cache.Get("helpdesk", "5d2f0b8e-1c9a-4f4b-9c1e-2a6b3f1d7e01"); doc == nil {
+		t.Errorf("expected the object to be cached for the second identity")
+	}
+
+	failure := errors.New("unable to contact the server")
+	failed := func(context.Context) (map[string][]byte, error) { return nil, failure }
+	for i := 0; i < 2; i++ {
+		if err := cache.Prefetch(context.Background(), "svc-terraform", "Get-ADGroup ou=groups", failed); !errors.Is(err, failure) {
+			t.Errorf("expected the error of the query, got %v", err)
+		}
+	}
+}
+
+func TestObjectCacheDisabled(t *testing.T) {
+	pcfg := NewProviderConf(&Settings{WinRMHost: "dc1"})
+	fetch := func(context.Context) (map[string][]byte, error) {
+		t.Errorf("expected no query when the cache is disabled")
+		return nil, nil
+	}
+	if err := pcfg.Prefetch(context.Background(), "Get-ADUser ou=users", fetch); err != nil {
+		t.Error(err)
+	}
+	if doc := pcfg.CachedObject("5d2f0b8e-1c9a-4f4b-9c1e-2a6b3f1d7e01"); doc != nil {
+		t.Errorf("unexpected document %q", doc)
+	}
+	pcfg.InvalidateObject("5d2f0b8e-1c9a-4f4b-9c1e-2a6b3f1d7e01")
+}
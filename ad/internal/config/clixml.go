@@ -0,0 +1,613 @@
+package config
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// PSObject is a powershell object deserialized from CLIXML.
+type PSObject struct {
+	// TypeNames holds the type hierarchy of the object, most specific type first.
+	TypeNames []string
+	// ToString is the string representation of the object, as computed by the remote side.
+	ToString string
+	// Value holds the payload of objects that wrap a primitive value, a list or a dictionary.
+	Value interface{}
+	// Properties holds both the adapted and the extended properties of the object.
+	Properties map[string]interface{}
+}
+
+// IsType returns true if name is one of the object's type names.
+func (o *PSObject) IsType(name string) bool {
+	for _, t := range o.TypeNames {
+		if strings.EqualFold(t, name) || strings.EqualFold(t, "Deserialized."+name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Property returns the value of a property or nil if the object has no such property.
+func (o *PSObject) Property(name string) interface{} {
+	return o.Properties[name]
+}
+
+// StringProperty returns the string representation of a property or an empty string if the
+// object has no such property.
+func (o *PSObject) StringProperty(name string) string {
+	return CLIXMLString(o.Properties[name])
+}
+
+// ObjectProperty returns the value of a property if it is an object, nil otherwise.
+func (o *PSObject) ObjectProperty(name string) *PSObject {
+	obj, _ := o.Properties[name].(*PSObject)
+	return obj
+}
+
+func (o *PSObject) String() string {
+	if o.ToString != "" {
+		return o.ToString
+	}
+	if o.Value != nil {
+		return CLIXMLString(o.Value)
+	}
+	return ""
+}
+
+// CLIXMLString returns the string representation of a deserialized CLIXML value.
+func CLIXMLString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case *PSObject:
+		return v.String()
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+type clixmlElement struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr       `xml:",any,attr"`
+	Text     string           `xml:",chardata"`
+	Children []*clixmlElement `xml:",any"`
+}
+
+func (e *clixmlElement) attr(name string) string {
+	for _, a := range e.Attrs {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+type clixmlDecoder struct {
+	objects   map[string]*PSObject
+	typeNames map[string][]string
+}
+
+// DecodeCLIXML deserializes a CLIXML document. The document can either be an <Objs> element holding
+// several values, or a single value such as the <Obj> elements exchanged by the PSRP protocol. Streams
+// serialized by powershell.exe are prefixed with "#< CLIXML", which is ignored.
+func DecodeCLIXML(doc []byte) ([]interface{}, error) {
+	str := strings.TrimPrefix(strings.TrimSpace(string(doc)), "\ufeff")
+	str = strings.TrimSpace(strings.TrimPrefix(str, "#< CLIXML"))
+
+	root := &clixmlElement{}
+	if err := xml.Unmarshal([]byte(str), root); err != nil {
+		return nil, fmt.Errorf("while unmarshalling CLIXML document: %s", err)
+	}
+
+	d := &clixmlDecoder{objects: map[string]*PSObject{}, typeNames: map[string][]string{}}
+	if root.XMLName.Local != "Objs" {
+		value, err := d.value(root)
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{value}, nil
+	}
+
+	values := make([]interface{}, 0, len(root.Children))
+	for _, child := range root.Children {
+		value, err := d.value(child)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+func (d *clixmlDecoder) value(e *clixmlElement) (interface{}, error) {
+	switch e.XMLName.Local {
+	case "Nil":
+		return nil, nil
+	case "S", "G", "URI", "Version", "XD", "SBK", "SS", "TS":
+		return DecodeCLIXMLString(e.Text), nil
+	case "C":
+		c, err := strconv.ParseUint(e.Text, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid char value %q: %s", e.Text, err)
+		}
+		return string(rune(c)), nil
+	case "B":
+		return strings.EqualFold(strings.TrimSpace(e.Text), "true"), nil
+	case "By", "U16", "U32", "U64":
+		v, err := strconv.ParseUint(strings.TrimSpace(e.Text), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value %q: %s", e.XMLName.Local, e.Text, err)
+		}
+		return v, nil
+	case "SB", "I16", "I32", "I64":
+		v, err := strconv.ParseInt(strings.TrimSpace(e.Text), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value %q: %s", e.XMLName.Local, e.Text, err)
+		}
+		return v, nil
+	case "Sg", "Db", "D":
+		v, err := strconv.ParseFloat(strings.TrimSpace(e.Text), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value %q: %s", e.XMLName.Local, e.Text, err)
+		}
+		return v, nil
+	case "DT":
+		v, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(e.Text))
+		if err != nil {
+			return strings.TrimSpace(e.Text), nil
+		}
+		return v, nil
+	case "BA":
+		v, err := base64.StdEncoding.DecodeString(strings.TrimSpace(e.Text))
+		if err != nil {
+			return nil, fmt.Errorf("invalid byte array value: %s", err)
+		}
+		return v, nil
+	case "Ref":
+		obj, ok := d.objects[e.attr("RefId")]
+		if !ok {
+			return nil, fmt.Errorf("reference to unknown object %q", e.attr("RefId"))
+		}
+		return obj, nil
+	case "Obj":
+		return d.object(e)
+	case "PR":
+		return d.progressRecord(e)
+	default:
+		return nil, fmt.Errorf("unsupported CLIXML element %q", e.XMLName.Local)
+	}
+}
+
+func (d *clixmlDecoder) object(e *clixmlElement) (*PSObject, error) {
+	obj := &PSObject{Properties: map[string]interface{}{}}
+	if refID := e.attr("RefId"); refID != "" {
+		d.objects[refID] = obj
+	}
+
+	for _, child := range e.Children {
+		var err error
+		switch child.XMLName.Local {
+		case "TN":
+			for _, t := range child.Children {
+				obj.TypeNames = append(obj.TypeNames, DecodeCLIXMLString(t.Text))
+			}
+			d.typeNames[child.attr("RefId")] = obj.TypeNames
+		case "TNRef":
+			obj.TypeNames = d.typeNames[child.attr("RefId")]
+		case "ToString":
+			obj.ToString = DecodeCLIXMLString(child.Text)
+		case "MS", "Props":
+			err = d.properties(child, obj.Properties)
+		case "LST", "IE", "STK", "QUE":
+			obj.Value, err = d.list(child)
+		case "DCT":
+			obj.Value, err = d.dictionary(child)
+		default:
+			obj.Value, err = d.value(child)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return obj, nil
+}
+
+// progressRecordFields maps the children of a <PR> element to the ProgressRecord properties they hold.
+var progressRecordFields = map[string]string{
+	"AV": "Activity",
+	"AI": "ActivityId",
+	"CO": "CurrentOperation",
+	"PI": "ParentActivityId",
+	"PC": "PercentComplete",
+	"T":  "RecordType",
+	"SR": "SecondsRemaining",
+	"SD": "StatusDescription",
+}
+
+// progressRecord decodes the compact <PR> serialization used for progress records. Its fields are
+// identified by their position rather than by name, a <Nil /> element standing for a missing current
+// operation.
+func (d *clixmlDecoder) progressRecord(e *clixmlElement) (*PSObject, error) {
+	obj := &PSObject{
+		TypeNames:  []string{"System.Management.Automation.ProgressRecord"},
+		Properties: map[string]interface{}{},
+	}
+	for _, child := range e.Children {
+		name := child.XMLName.Local
+		if name == "Nil" {
+			obj.Properties["CurrentOperation"] = nil
+			continue
+		}
+		field, ok := progressRecordFields[name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported progress record element %q", name)
+		}
+		switch name {
+		case "AI", "PI", "PC", "SR":
+			v, err := strconv.ParseInt(strings.TrimSpace(child.Text), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid progress record %s value %q: %s", field, child.Text, err)
+			}
+			obj.Properties[field] = v
+		default:
+			obj.Properties[field] = DecodeCLIXMLString(child.Text)
+		}
+	}
+	obj.ToString = obj.StringProperty("Activity")
+	return obj, nil
+}
+
+func (d *clixmlDecoder) properties(e *clixmlElement, props map[string]interface{}) error {
+	for _, child := range e.Children {
+		value, err := d.value(child)
+		if err != nil {
+			return err
+		}
+		props[DecodeCLIXMLString(child.attr("N"))] = value
+	}
+	return nil
+}
+
+func (d *clixmlDecoder) list(e *clixmlElement) ([]interface{}, error) {
+	out := make([]interface{}, 0, len(e.Children))
+	for _, child := range e.Children {
+		value, err := d.value(child)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, value)
+	}
+	return out, nil
+}
+
+func (d *clixmlDecoder) dictionary(e *clixmlElement) (map[string]interface{}, error) {
+	out := map[string]interface{}{}
+	for _, entry := range e.Children {
+		var key string
+		var value interface{}
+		for _, child := range entry.Children {
+			v, err := d.value(child)
+			if err != nil {
+				return nil, err
+			}
+			switch child.attr("N") {
+			case "Key":
+				key = CLIXMLString(v)
+			case "Value":
+				value = v
+			}
+		}
+		out[key] = value
+	}
+	return out, nil
+}
+
+// DecodeCLIXMLString decodes the _xHHHH_ escape sequences CLIXML uses for characters that are not
+// allowed in XML documents, such as control characters.
+func DecodeCLIXMLString(s string) string {
+	if !strings.Contains(s, "_x") {
+		return s
+	}
+
+	var units []uint16
+	var out strings.Builder
+	flush := func() {
+		if len(units) > 0 {
+			out.WriteString(string(utf16.Decode(units)))
+			units = nil
+		}
+	}
+	for i := 0; i < len(s); {
+		if i+7 <= len(s) && s[i] == '_' && s[i+1] == 'x' && s[i+6] == '_' {
+			if v, err := strconv.ParseUint(s[i+2:i+6], 16, 16); err == nil {
+				units = append(units, uint16(v))
+				i += 7
+				continue
+			}
+		}
+		flush()
+		out.WriteByte(s[i])
+		i++
+	}
+	flush()
+	return out.String()
+}
+
+// EncodeCLIXMLString returns s escaped so that it can be used as the content of a CLIXML string element.
+func EncodeCLIXMLString(s string) string {
+	var out strings.Builder
+	for i, r := range s {
+		switch {
+		case r < 0x20 || r == 0xfffe || r == 0xffff:
+			fmt.Fprintf(&out, "_x%04X_", r)
+		case r == '_' && strings.HasPrefix(s[i:], "_x"):
+			out.WriteString("_x005F_")
+		default:
+			out.WriteRune(r)
+		}
+	}
+
+	var escaped strings.Builder
+	_ = xml.EscapeText(&escaped, []byte(out.String()))
+	return escaped.String()
+}
+
+// errorCategories holds the names of the System.Management.Automation.ErrorCategory values.
+var errorCategories = []string{
+	"NotSpecified", "OpenError", "CloseError", "DeviceError", "DeadlockDetected", "InvalidArgument",
+	"InvalidData", "InvalidOperation", "InvalidResult", "InvalidType", "MetadataError", "NotImplemented",
+	"NotInstalled", "ObjectNotFound", "OperationStopped", "OperationTimeout", "SyntaxError", "ParserError",
+	"PermissionDenied", "ResourceBusy", "ResourceExists", "ResourceUnavailable", "ReadError", "WriteError",
+	"FromStdErr", "SecurityError", "ProtocolError", "ConnectionError", "AuthenticationError",
+	"LimitsExceeded", "QuotaExceeded", "NotEnabled",
+}
+
+var (
+	errorRecordFieldRe  = regexp.MustCompile(`^\s+\+ (\w+)\s*: ?(.*)$`)
+	errorCategoryInfoRe = regexp.MustCompile(`^(\w+): \((.*)\) \[(.*)\], (.*)$`)
+)
+
+// ErrorRecord is a powershell error record, either deserialized from CLIXML or parsed from the text
+// powershell writes to stderr.
+type ErrorRecord struct {
+	// Message is the message of the exception that caused the error.
+	Message string
+	// ExceptionType is the type of the exception that caused the error. Error records parsed from text
+	// only know the short name of the type.
+	ExceptionType string
+	// FullyQualifiedErrorID identifies the error, along with the command that reported it.
+	FullyQualifiedErrorID string
+	// Category is the name of the error category, such as ObjectNotFound.
+	Category string
+	// Activity is the name of the command that reported the error.
+	Activity   string
+	Reason     string
+	TargetName string
+	TargetType string
+}
+
+// NewErrorRecord converts a deserialized System.Management.Automation.ErrorRecord.
+func NewErrorRecord(obj *PSObject) *ErrorRecord {
+	record := &ErrorRecord{
+		Message:               obj.String(),
+		FullyQualifiedErrorID: obj.StringProperty("FullyQualifiedErrorId"),
+		Activity:              obj.StringProperty("ErrorCategory_Activity"),
+		Reason:                obj.StringProperty("ErrorCategory_Reason"),
+		TargetName:            obj.StringProperty("ErrorCategory_TargetName"),
+		TargetType:            obj.StringProperty("ErrorCategory_TargetType"),
+	}
+
+	if exception := obj.ObjectProperty("Exception"); exception != nil {
+		if len(exception.TypeNames) > 0 {
+			record.ExceptionType = strings.TrimPrefix(exception.TypeNames[0], "Deserialized.")
+		}
+		if record.Message == "" {
+			record.Message = exception.StringProperty("Message")
+		}
+	}
+
+	if category, ok := obj.Property("ErrorCategory_Category").(int64); ok && category >= 0 && int(category) < len(errorCategories) {
+		record.Category = errorCategories[category]
+	} else if info := errorCategoryInfoRe.FindStringSubmatch(obj.StringProperty("ErrorCategory_Message")); info != nil {
+		record.Category = info[1]
+	}
+	return record
+}
+
+// ParseErrorRecords extracts the error records from the text powershell writes to stderr, which
+// looks like this for each record:
+//
+//	Get-ADUser : Cannot find an object with identity: 'nobody' under: 'DC=contoso,DC=com'.
+//	At line:1 char:1
+//	+ Get-ADUser -Identity nobody
+//	+ ~~~~~~~~~~~~~~~~~~~~~~~~~~~
+//	    + CategoryInfo          : ObjectNotFound: (nobody:ADUser) [Get-ADUser], ADIdentityNotFoundException
+//	    + FullyQualifiedErrorId : ActiveDirectoryCmdlet:Microsoft.ActiveDirectory.Management.ADIdentityNotFoundException,Microsoft.ActiveDirectory.Management.Commands.GetADUser
+//
+// Text that does not follow this layout is returned as the message of a single record.
+func ParseErrorRecords(text string) []*ErrorRecord {
+	var records []*ErrorRecord
+	var current *ErrorRecord
+	var message []string
+	var field string
+	var inPosition bool
+
+	flush := func() {
+		if current == nil {
+			return
+		}
+		if current.Message == "" {
+			current.Message = strings.Join(message, " ")
+		}
+		records = append(records, current)
+		current, message, field, inPosition = nil, nil, "", false
+	}
+
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			continue
+		}
+
+		if m := errorRecordFieldRe.FindStringSubmatch(line); m != nil {
+			if current == nil {
+				current = &ErrorRecord{}
+			}
+			field = m[1]
+			current.setField(field, strings.TrimSpace(m[2]))
+			continue
+		}
+
+		if field != "" {
+			if line[0] == ' ' || line[0] == '\t' {
+				current.appendField(field, trimmed)
+				continue
+			}
+			// The previous record is complete, this line starts a new one.
+			flush()
+		}
+
+		if current == nil {
+			current = &ErrorRecord{}
+		}
+		switch {
+		case strings.HasPrefix(trimmed, "At line:") || strings.HasPrefix(line, "At ") && len(message) > 0:
+			inPosition = true
+		case inPosition && strings.HasPrefix(line, "+"):
+		case inPosition:
+			// Continuation of a wrapped position line.
+		default:
+			message = append(message, trimmed)
+		}
+	}
+	flush()
+
+	if len(records) == 1 && records[0].Category == "" && records[0].FullyQualifiedErrorID == "" {
+		records[0].Message = strings.TrimSpace(text)
+		return records
+	}
+
+	for _, record := range records {
+		if activity, msg, ok := strings.Cut(record.Message, " : "); ok && !strings.Contains(activity, " ") {
+			if record.Activity == "" {
+				record.Activity = activity
+			}
+			record.Message = msg
+		}
+	}
+	return records
+}
+
+func (r *ErrorRecord) setField(name, value string) {
+	switch name {
+	case "CategoryInfo":
+		r.setCategoryInfo(value)
+	case "FullyQualifiedErrorId":
+		r.FullyQualifiedErrorID = value
+	}
+}
+
+// appendField appends a line to a field that powershell wrapped to fit the console width.
+func (r *ErrorRecord) appendField(name, value string) {
+	switch name {
+	case "CategoryInfo":
+		r.setCategoryInfo(joinWrapped(r.categoryInfo(), value))
+	case "FullyQualifiedErrorId":
+		r.FullyQualifiedErrorID = joinWrapped(r.FullyQualifiedErrorID, value)
+	}
+}
+
+func (r *ErrorRecord) setCategoryInfo(value string) {
+	info := errorCategoryInfoRe.FindStringSubmatch(value)
+	if info == nil {
+		r.Category, _, _ = strings.Cut(value, ":")
+		r.Reason = value
+		return
+	}
+	r.Category, r.Activity, r.Reason = info[1], info[3], info[4]
+	r.ExceptionType = info[4]
+	if idx := strings.LastIndex(info[2], ":"); idx >= 0 {
+		r.TargetName, r.TargetType = info[2][:idx], info[2][idx+1:]
+	} else {
+		r.TargetName = info[2]
+	}
+}
+
+func (r *ErrorRecord) categoryInfo() string {
+	if r.Activity == "" && r.ExceptionType == "" {
+		return r.Reason
+	}
+	target := r.TargetName
+	if r.TargetType != "" {
+		target = fmt.Sprintf("%s:%s", r.TargetName, r.TargetType)
+	}
+	return fmt.Sprintf("%s: (%s) [%s], %s", r.Category, target, r.Activity, r.Reason)
+}
+
+// joinWrapped joins the lines of a field wrapped by powershell. Wrapping happens at word boundaries
+// except for dotted type names.
+func joinWrapped(head, tail string) string {
+	head = strings.TrimSpace(head)
+	if strings.HasPrefix(tail, ".") || strings.HasSuffix(head, ".") {
+		return head + tail
+	}
+	return head + " " + tail
+}
+
+// String renders the error record the way powershell writes it to stderr.
+func (r *ErrorRecord) String() string {
+	out := r.Message
+	if r.Activity != "" {
+		out = fmt.Sprintf("%s : %s", r.Activity, out)
+	}
+	if r.Category != "" {
+		out = fmt.Sprintf("%s\n    + CategoryInfo          : %s", out, r.categoryInfo())
+	}
+	if r.FullyQualifiedErrorID != "" {
+		out = fmt.Sprintf("%s\n    + FullyQualifiedErrorId : %s", out, r.FullyQualifiedErrorID)
+	}
+	return out
+}
+
+// DecodeCLIXMLStderr extracts the text of the strings serialized in a CLIXML stream, dropping progress
+// records. Error messages are concatenated the way they were originally wrapped, with the error record
+// fields on their own lines. Text that is not serialized as CLIXML is returned as is, along with the
+// text with its original line breaks, which ParseErrorRecords expects.
+func DecodeCLIXMLStderr(xmlDoc string) (string, string, error) {
+	if !strings.Contains(xmlDoc, "#< CLIXML") {
+		return xmlDoc, xmlDoc, nil
+	}
+
+	values, err := DecodeCLIXML([]byte(xmlDoc))
+	if err != nil {
+		return "", "", err
+	}
+
+	var msg, raw strings.Builder
+	for _, value := range values {
+		str, ok := value.(string)
+		if !ok {
+			continue
+		}
+		raw.WriteString(str)
+
+		str = strings.TrimLeft(strings.NewReplacer("\r", "", "\n", "").Replace(str), " \t")
+		if strings.HasPrefix(str, "+") && len(str) > 2 {
+			str = "\n" + str[2:]
+		}
+		msg.WriteString(str)
+	}
+	return strings.TrimSpace(msg.String()), raw.String(), nil
+}
@@ -0,0 +1,261 @@
+package config
+
+import (
+	"bytes"
+	"testing"
+)
+
+const clixmlError = ` #< CLIXML
+	<Objs Version="1.1.0.1" xmlns="http://schemas.microsoft.com/powershell/2004/04"><Obj S="progress" RefId="0">
+    <TN RefId="0"><T>System.Management.Automation.PSCustomObject</T><T>System.Object</T></TN><MS>
+    <I64 N="SourceId">1</I64><PR N="Record"><AV>Loading Active Directory module for Windows PowerShell with default drive 'AD:'</AV>
+	<AI>0</AI><Nil /><PI>-1</PI><PC>0</PC><T>Processing</T><SR>-1</SR><SD> </SD></PR></MS></Obj><Obj S="progress" RefId="1">
+	<TNRef RefId="0" /><MS><I64 N="SourceId">1</I64><PR N="Record"><AV>Loading Active Directory module for Windows PowerShell with default drive 'AD:'</AV>
+	<AI>0</AI><Nil /><PI>-1</PI><PC>25</PC><T>Processing</T><SR>-1</SR><SD> </SD></PR></MS></Obj><Obj S="progress" RefId="2">
+	<TNRef RefId="0" /><MS><I64 N="SourceId">1</I64><PR N="Record"><AV>Loading Active Directory module for Windows PowerShell with default drive 'AD:'</AV>
+	<AI>0</AI><Nil /><PI>-1</PI><PC>50</PC><T>Processing</T><SR>-1</SR><SD> </SD></PR></MS></Obj><Obj S="progress" RefId="3">
+	<TNRef RefId="0" /><MS><I64 N="SourceId">1</I64><PR N="Record"><AV>Loading Active Directory module for Windows PowerShell with default drive 'AD:'</AV>
+	<AI>0</AI><Nil /><PI>-1</PI><PC>75</PC><T>Processing</T><SR>-1</SR><SD> </SD></PR></MS></Obj><Obj S="progress" RefId="4">
+	<TNRef RefId="0" /><MS><I64 N="SourceId">1</I64><PR N="Record"><AV>Loading Active Directory module for Windows PowerShell with default drive 'AD:'</AV>
+	<AI>0</AI><Nil /><PI>-1</PI><PC>100</PC><T>Processing</T><SR>-1</SR><SD> </SD></PR></MS></Obj><Obj S="progress" RefId="5">
+	<TNRef RefId="0" /><MS><I64 N="SourceId">1</I64><PR N="Record"><AV>Loading Active Directory module for Windows PowerShell with default drive 'AD:'</AV>
+	<AI>0</AI><Nil /><PI>-1</PI><PC>100</PC><T>Completed</T><SR>-1</SR><SD> </SD></PR></MS></Obj>
+	<S S="Error">Set-ADOrganizationalUnit : A parameter cannot be found that matches parameter _x000D__x000A_</S>
+	<S S="Error">name 'Path'._x000D__x000A_</S><S S="Error">At line:1 char:101_x000D__x000A_</S>
+	<S S="Error">+ ... e description" -Path "DC=yourdomain,DC=com" _x000D__x000A_</S>
+	<S S="Error">-ProtectedFromAccidentalDeletion $tr ..._x000D__x000A_</S>
+	<S S="Error">+                    ~~~~~_x000D__x000A_</S>
+	<S S="Error">    + CategoryInfo          : InvalidArgument: (:) [Set-ADOrganizationalUnit], _x000D__x000A_</S
+	><S S="Error">    ParameterBindingException_x000D__x000A_</S>
+	<S S="Error">    + FullyQualifiedErrorId : NamedParameterNotFound,Microsoft.ActiveDirectory _x000D__x000A_</S>
+	<S S="Error">   .Management.Commands.SetADOrganizationalUnit_x000D__x000A_</S><S S="Error"> _x000D__x000A_</S>
+	</Objs>`
+
+const textErrorRecords = "Get-ADUser : Cannot find an object with identity: 'nobody' under: 'DC=contoso,DC=com'.\r\n" +
+	"At line:1 char:1\r\n" +
+	"+ Get-ADUser -Identity nobody\r\n" +
+	"+ ~~~~~~~~~~~~~~~~~~~~~~~~~~~\r\n" +
+	"    + CategoryInfo          : ObjectNotFound: (nobody:ADUser) [Get-ADUser], ADIdentityNotFoundException\r\n" +
+	"    + FullyQualifiedErrorId : ActiveDirectoryCmdlet:Microsoft.ActiveDirectory.Management.ADIdentityNotFoundException,Microsoft.ActiveDirectory.Management.Commands.GetADUser\r\n" +
+	"\r\n" +
+	"New-ADGroup : Unable to contact the server. This may be because this server does not exist, it is currently down, or it does not have the Active Directory Web Services running.\r\n" +
+	"At line:2 char:1\r\n" +
+	"+ New-ADGroup -Name test\r\n" +
+	"+ ~~~~~~~~~~~~~~~~~~~~~~\r\n" +
+	"    + CategoryInfo          : ResourceUnavailable: (:) [New-ADGroup], ADServerDownException\r\n" +
+	"    + FullyQualifiedErrorId : ActiveDirectoryServer:0,Microsoft.ActiveDirectory.Management.Commands.NewADGroup\r\n"
+
+const testErrorRecord = `#< CLIXML
+<Objs Version="1.1.0.1" xmlns="http://schemas.microsoft.com/powershell/2004/04">
+<Obj S="Error" RefId="0"><TN RefId="0"><T>System.Management.Automation.ErrorRecord</T><T>System.Object</T></TN>
+<ToString>Cannot find an object with identity: 'nobody' under: 'DC=contoso,DC=com'.</ToString>
+<Props>
+<Obj N="Exception" RefId="1"><TN RefId="1"><T>Microsoft.ActiveDirectory.Management.ADIdentityNotFoundException</T><T>System.Exception</T><T>System.Object</T></TN>
+<ToString>Microsoft.ActiveDirectory.Management.ADIdentityNotFoundException: Cannot find an object with identity</ToString>
+<Props><S N="Message">Cannot find an object with identity</S><I32 N="HResult">-2146233088</I32></Props></Obj>
+<Obj N="TargetObject" RefId="2"><TNRef RefId="1" /><ToString>nobody</ToString></Obj>
+<S N="FullyQualifiedErrorId">ActiveDirectoryCmdlet:Microsoft.ActiveDirectory.Management.ADIdentityNotFoundException,Microsoft.ActiveDirectory.Management.Commands.GetADUser</S>
+<Nil N="InvocationInfo" />
+<I32 N="ErrorCategory_Category">13</I32>
+<S N="ErrorCategory_Reason">ADIdentityNotFoundException</S>
+<S N="ErrorCategory_Message">ObjectNotFound: (nobody:ADUser) [Get-ADUser], ADIdentityNotFoundException</S>
+<B N="SerializeExtendedInfo">false</B>
+<Ref N="Self" RefId="2" />
+<BA N="Bytes">AQID</BA>
+<Obj N="Tags" RefId="3"><TN RefId="2"><T>System.Collections.ArrayList</T><T>System.Object</T></TN><LST><S>a_x000D__x000A_b</S><I64>42</I64></LST></Obj>
+<Obj N="Map" RefId="4"><TN RefId="3"><T>System.Collections.Hashtable</T><T>System.Object</T></TN><DCT><En><S N="Key">k</S><U32 N="Value">7</U32></En></DCT></Obj>
+</Props></Obj>
+</Objs>`
+
+func TestDecodeCLIXML(t *testing.T) {
+	values, err := DecodeCLIXML([]byte(testErrorRecord))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 1 {
+		t.Fatalf("expected 1 value, found %d", len(values))
+	}
+	obj, ok := values[0].(*PSObject)
+	if !ok {
+		t.Fatalf("expected an object, found %T", values[0])
+	}
+	if !obj.IsType("System.Management.Automation.ErrorRecord") {
+		t.Errorf("unexpected type names %v", obj.TypeNames)
+	}
+	if obj.StringProperty("ErrorCategory_Reason") != "ADIdentityNotFoundException" {
+		t.Errorf("unexpected category reason %q", obj.StringProperty("ErrorCategory_Reason"))
+	}
+	if obj.Property("ErrorCategory_Category") != int64(13) {
+		t.Errorf("unexpected category %#v", obj.Property("ErrorCategory_Category"))
+	}
+	if obj.Property("InvocationInfo") != nil {
+		t.Errorf("expected nil invocation info, found %#v", obj.Property("InvocationInfo"))
+	}
+	exception := obj.ObjectProperty("Exception")
+	if exception == nil || !exception.IsType("Microsoft.ActiveDirectory.Management.ADIdentityNotFoundException") {
+		t.Fatalf("unexpected exception %#v", exception)
+	}
+	if exception.StringProperty("Message") != "Cannot find an object with identity" {
+		t.Errorf("unexpected exception message %q", exception.StringProperty("Message"))
+	}
+	target := obj.ObjectProperty("TargetObject")
+	if target == nil || target.String() != "nobody" || obj.ObjectProperty("Self") != target {
+		t.Errorf("references were not resolved: %#v", obj.Property("Self"))
+	}
+	if target != nil && len(target.TypeNames) != 3 {
+		t.Errorf("type name reference was not resolved: %v", target.TypeNames)
+	}
+	if b, _ := obj.Property("Bytes").([]byte); !bytes.Equal(b, []byte{1, 2, 3}) {
+		t.Errorf("unexpected byte array %#v", obj.Property("Bytes"))
+	}
+	tags, _ := obj.ObjectProperty("Tags").Value.([]interface{})
+	if len(tags) != 2 || tags[0] != "a\r\nb" || tags[1] != int64(42) {
+		t.Errorf("unexpected list %#v", tags)
+	}
+	m, _ := obj.ObjectProperty("Map").Value.(map[string]interface{})
+	if len(m) != 1 || m["k"] != uint64(7) {
+		t.Errorf("unexpected dictionary %#v", m)
+	}
+}
+
+func TestDecodeCLIXMLSingleObject(t *testing.T) {
+	values, err := DecodeCLIXML([]byte("\ufeff<Obj RefId=\"0\"><MS><I32 N=\"PipelineState\">4</I32></MS></Obj>"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj, ok := values[0].(*PSObject); !ok || obj.Property("PipelineState") != int64(4) {
+		t.Errorf("unexpected value %#v", values[0])
+	}
+
+	if _, err := DecodeCLIXML([]byte("<Objs><Unknown /></Objs>")); err == nil {
+		t.Errorf("expected an error for an unsupported element")
+	}
+}
+
+func TestCLIXMLStringRoundTrip(t *testing.T) {
+	for _, s := range []string{"plain", "line\r\nbreak\ttab", "<tag attr=\"x\"> & more", "_x000D_ literal", "ünïcødé “quotes” 🎉"} {
+		encoded := EncodeCLIXMLString(s)
+		values, err := DecodeCLIXML([]byte("<S>" + encoded + "</S>"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if values[0] != s {
+			t.Errorf("string did not survive a round trip. Expected %q got %q (encoded as %q)", s, values[0], encoded)
+		}
+	}
+}
+
+func TestDecodeCLIXMLProgressRecord(t *testing.T) {
+	doc := `#< CLIXML
+<Objs Version="1.1.0.1" xmlns="http://schemas.microsoft.com/powershell/2004/04"><Obj S="progress" RefId="0">
+<TN RefId="0"><T>System.Management.Automation.PSCustomObject</T><T>System.Object</T></TN><MS>
+<I64 N="SourceId">1</I64><PR N="Record"><AV>Loading Active Directory module</AV><AI>0</AI><Nil /><PI>-1</PI><PC>25</PC><T>Processing</T><SR>-1</SR><SD> </SD></PR></MS></Obj>
+<S S="Error">boom_x000D__x000A_</S></Objs>`
+	values, err := DecodeCLIXML([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 2 || values[1] != "boom\r\n" {
+		t.Fatalf("unexpected values %#v", values)
+	}
+	record := values[0].(*PSObject).ObjectProperty("Record")
+	if record == nil || !record.IsType("System.Management.Automation.ProgressRecord") {
+		t.Fatalf("unexpected progress record %#v", record)
+	}
+	if record.StringProperty("Activity") != "Loading Active Directory module" || record.Property("PercentComplete") != int64(25) {
+		t.Errorf("unexpected progress record properties %#v", record.Properties)
+	}
+}
+
+func TestDecodeCLIXMLStderr(t *testing.T) {
+	expected := `Set-ADOrganizationalUnit : A parameter cannot be found that matches parameter name 'Path'.At line:1 char:101
+... e description" -Path "DC=yourdomain,DC=com" -ProtectedFromAccidentalDeletion $tr ...
+                   ~~~~~
+CategoryInfo          : InvalidArgument: (:) [Set-ADOrganizationalUnit], ParameterBindingException
+FullyQualifiedErrorId : NamedParameterNotFound,Microsoft.ActiveDirectory .Management.Commands.SetADOrganizationalUnit`
+
+	msg, _, err := DecodeCLIXMLStderr(clixmlError)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg != expected {
+		t.Errorf("actual result did not match the expected one:\nactual: ---%s---\nexpected: ---%s---", msg, expected)
+	}
+}
+
+func TestParseErrorRecords(t *testing.T) {
+	records := ParseErrorRecords(textErrorRecords)
+	if len(records) != 2 {
+		t.Fatalf("expected 2 error records, found %d", len(records))
+	}
+
+	notFound := records[0]
+	expected := ErrorRecord{
+		Message:               "Cannot find an object with identity: 'nobody' under: 'DC=contoso,DC=com'.",
+		ExceptionType:         "ADIdentityNotFoundException",
+		FullyQualifiedErrorID: "ActiveDirectoryCmdlet:Microsoft.ActiveDirectory.Management.ADIdentityNotFoundException,Microsoft.ActiveDirectory.Management.Commands.GetADUser",
+		Category:              "ObjectNotFound",
+		Activity:              "Get-ADUser",
+		Reason:                "ADIdentityNotFoundException",
+		TargetName:            "nobody",
+		TargetType:            "ADUser",
+	}
+	if *notFound != expected {
+		t.Errorf("unexpected error record:\nactual: %#v\nexpected: %#v", *notFound, expected)
+	}
+	if records[1].Category != "ResourceUnavailable" || records[1].Activity != "New-ADGroup" || records[1].ExceptionType != "ADServerDownException" {
+		t.Errorf("unexpected error record %#v", records[1])
+	}
+}
+
+func TestParseErrorRecordsWrapped(t *testing.T) {
+	_, raw, err := DecodeCLIXMLStderr(clixmlError)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := ParseErrorRecords(raw)
+	if len(records) != 1 {
+		t.Fatalf("expected 1 error record, found %d", len(records))
+	}
+	record := records[0]
+	if record.Message != "A parameter cannot be found that matches parameter name 'Path'." {
+		t.Errorf("unexpected message %q", record.Message)
+	}
+	if record.Category != "InvalidArgument" || record.ExceptionType != "ParameterBindingException" || record.Activity != "Set-ADOrganizationalUnit" {
+		t.Errorf("unexpected category info %#v", record)
+	}
+	if record.FullyQualifiedErrorID != "NamedParameterNotFound,Microsoft.ActiveDirectory.Management.Commands.SetADOrganizationalUnit" {
+		t.Errorf("unexpected error id %q", record.FullyQualifiedErrorID)
+	}
+}
+
+func TestParseErrorRecordsPlainText(t *testing.T) {
+	records := ParseErrorRecords("Access is denied.\r\n")
+	if len(records) != 1 || records[0].Message != "Access is denied." {
+		t.Fatalf("unexpected error records %#v", records)
+	}
+	if records := ParseErrorRecords(""); len(records) != 0 {
+		t.Errorf("expected no error records, found %d", len(records))
+	}
+}
+
+func TestNewErrorRecord(t *testing.T) {
+	obj := &PSObject{
+		TypeNames: []string{"Deserialized.System.Management.Automation.ErrorRecord"},
+		ToString:  "A GPO with the name \"test\" already exists.",
+		Properties: map[string]interface{}{
+			"Exception": &PSObject{
+				TypeNames:  []string{"Deserialized.System.ArgumentException", "Deserialized.System.Exception"},
+				Properties: map[string]interface{}{},
+			},
+			"FullyQualifiedErrorId":  "GpoWithNameAlreadyExists,Microsoft.GroupPolicy.Commands.NewGpoCommand",
+			"ErrorCategory_Category": int64(20),
+			"ErrorCategory_Activity": "New-GPO",
+			"ErrorCategory_Reason":   "ArgumentException",
+		},
+	}
+	record := NewErrorRecord(obj)
+	if record.ExceptionType != "System.ArgumentException" || record.Category != "ResourceExists" || record.Activity != "New-GPO" {
+		t.Errorf("unexpected error record %#v", record)
+	}
+}
@@ -1,18 +1,24 @@
 package config
 
 import (
+	"context"
 	"crypto/tls"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
+	"os"
 	"runtime"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/hashicorp/terraform-provider-ad/ad/internal/redact"
+
 	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
 	"github.com/jcmturner/gokrb5/v8/keytab"
 
@@ -23,24 +29,104 @@ import (
 	"github.com/masterzen/winrm"
 	"github.com/masterzen/winrm/soap"
 	"github.com/packer-community/winrmcp/winrmcp"
+	"golang.org/x/crypto/ssh"
 )
 
 // Settings holds all the information necessary to configure the provider
 type Settings struct {
-	WinRMUsername        string
-	WinRMPassword        string
-	WinRMHost            string
-	WinRMPort            int
-	WinRMProto           string
-	WinRMInsecure        bool
-	KrbRealm             string
-	KrbConfig            string
-	KrbKeytab            string
-	KrbSpn               string
-	WinRMUseNTLM         bool
-	WinRMPassCredentials bool
-	DomainName           string
-	DomainController     string
+	WinRMUsername          string
+	WinRMPassword          string
+	WinRMHost              string
+	WinRMPort              int
+	WinRMProto             string
+	WinRMInsecure          bool
+	KrbRealm               string
+	KrbConfig              string
+	KrbKeytab              string
+	KrbCCache              string
+	KrbSpn                 string
+	WinRMUseNTLM           bool
+	WinRMPassCredentials   bool
+	WinRMPersistentSession bool
+	WinRMTransport         string
+	DomainName             string
+	DomainController       string
+	Backend                string
+	LDAPProto              string
+	LDAPPort               int
+	LDAPInsecure           bool
+	MaxRetries             int
+	RetryMinBackoff        time.Duration
+	RetryMaxBackoff        time.Duration
+	// SensitiveCustomAttributes holds the names of the custom attributes whose values are redacted.
+	SensitiveCustomAttributes []string
+	// WinRMHosts and DomainControllers list the hosts to fail over to. WinRMHost and DomainController hold
+	// the first of them, or the ones in use in the copies of the settings clients are created with.
+	WinRMHosts        []string
+	DomainControllers []string
+	// DCDiscovery enables the discovery of the domain controllers from DNS when none is configured, the
+	// ones of DCSite come first.
+	DCDiscovery bool
+	DCSite      string
+	// WinRMCACert, WinRMClientCert and WinRMClientKey hold PEM data. The client certificate is used to
+	// authenticate instead of the username and password when it is set.
+	WinRMCACert     []byte
+	WinRMClientCert []byte
+	WinRMClientKey  []byte
+	// WinRMMessageEncryption seals the messages sent over HTTP with the Kerberos or NTLM session keys.
+	WinRMMessageEncryption bool
+	// WinRMConfigurationName is the PowerShell session configuration runspaces are opened in. Commands
+	// are restricted to the language features of Just Enough Administration endpoints when it is set.
+	WinRMConfigurationName string
+	// ConnectionType is either ConnectionTypeWinRM or ConnectionTypeSSH. Over SSH, commands are sent to the
+	// WinRM hosts on SSHPort, authenticating with SSHSigner and the WinRM username and password.
+	ConnectionType string
+	SSHPort        int
+	SSHSigner      ssh.Signer
+	SSHKnownHosts  string
+	SSHInsecure    bool
+	// CredentialProfiles holds the identities resources can run their commands as, by name.
+	CredentialProfiles map[string]CredentialProfile
+	// MaxConcurrentOperations bounds the number of commands sent to the WinRM hosts at the same time, and
+	// the number of files uploaded at the same time. MaxPoolSize bounds the number of idle clients kept in
+	// each pool. Zero means no limit.
+	MaxConcurrentOperations int
+	MaxPoolSize             int
+	// BatchWindow is how long commands that can be batched wait for other ones, zero disables batching.
+	BatchWindow time.Duration
+	// JSONDepth is the depth of the JSON documents commands return, the default one when zero.
+	JSONDepth int
+	// ObjectCache enables the cache of the users, groups and computers retrieved by bulk queries.
+	ObjectCache bool
+	// Preflight enables the checks of the connection, the modules, the domain and SYSVOL that run when
+	// the provider is configured.
+	Preflight bool
+	// kerberos is the kerberos client shared by the connections of a ProviderConf.
+	kerberos *KerberosClient
+}
+
+// CredentialProfile is an identity the cmdlets are run as, instead of the one of the provider. The
+// connection to the remote host is still authenticated with the credentials of the provider.
+type CredentialProfile struct {
+	Username string
+	Password string
+	// kerberos is the kerberos client LDAP connections are bound with on behalf of the profile.
+	kerberos *KerberosClient
+}
+
+// hostList returns the hosts of the list argument listKey, or the host of the argument key if the list is
+// empty.
+func hostList(d *schema.ResourceData, listKey, key string) []string {
+	var hosts []string
+	for _, host := range d.Get(listKey).([]interface{}) {
+		if host, ok := host.(string); ok && strings.TrimSpace(host) != "" {
+			hosts = append(hosts, strings.TrimSpace(host))
+		}
+	}
+	if len(hosts) > 0 {
+		return hosts
+	}
+	return splitHosts(d.Get(key).(string))
 }
 
 // NewConfig returns a new Config struct populated with Resource Data.
@@ -48,62 +134,190 @@ func NewConfig(d *schema.ResourceData) (*Settings, error) {
 	// winRM
 	winRMUsername := d.Get("winrm_username").(string)
 	winRMPassword := d.Get("winrm_password").(string)
-	winRMHost := d.Get("winrm_hostname").(string)
+	winRMHosts := hostList(d, "winrm_hostnames", "winrm_hostname")
 	winRMPort := d.Get("winrm_port").(int)
 	winRMProto := d.Get("winrm_proto").(string)
 	winRMInsecure := d.Get("winrm_insecure").(bool)
+	winRMCACert, winRMClientCert, winRMClientKey, err := readTLSSettings(
+		d.Get("winrm_ca_cert").(string), d.Get("winrm_client_cert").(string), d.Get("winrm_client_key").(string))
+	if err != nil {
+		return nil, err
+	}
 	krbRealm := d.Get("krb_realm").(string)
 	krbConfig := d.Get("krb_conf").(string)
 	krbKeytab := d.Get("krb_keytab").(string)
+	krbCCache := d.Get("krb_ccache").(string)
 	krbSpn := d.Get("krb_spn").(string)
 	winRMUseNTLM := d.Get("winrm_use_ntlm").(bool)
+	winRMMessageEncryption := d.Get("winrm_message_encryption").(bool)
 	winRMPassCredentials := d.Get("winrm_pass_credentials").(bool)
-	domainController := d.Get("domain_controller").(string)
+	winRMPersistentSession := d.Get("winrm_persistent_session").(bool)
+	winRMTransport := d.Get("winrm_transport").(string)
+	winRMConfigurationName := d.Get("winrm_configuration_name").(string)
+	// ssh
+	connectionType := d.Get("connection_type").(string)
+	sshPort := d.Get("ssh_port").(int)
+	sshPrivateKey := d.Get("ssh_private_key").(string)
+	sshPassphrase := d.Get("ssh_private_key_passphrase").(string)
+	sshKnownHosts := d.Get("ssh_known_hosts").(string)
+	sshInsecure := d.Get("ssh_insecure").(bool)
+	domainControllers := hostList(d, "domain_controllers", "domain_controller")
+	dcDiscovery := d.Get("domain_controller_discovery").(bool)
+	dcSite := d.Get("domain_controller_site").(string)
+	credentialProfiles := map[string]CredentialProfile{}
+	for _, raw := range d.Get("credential_profile").([]interface{}) {
+		profile := raw.(map[string]interface{})
+		name := profile["name"].(string)
+		if _, ok := credentialProfiles[name]; ok {
+			return nil, fmt.Errorf("credential_profile %q is defined more than once", name)
+		}
+		credentialProfiles[name] = CredentialProfile{
+			Username: profile["username"].(string),
+			Password: profile["password"].(string),
+		}
+		redact.Register(profile["password"].(string))
+	}
+	// ldap
+	backend := d.Get("backend").(string)
+	ldapProto := d.Get("ldap_proto").(string)
+	ldapPort := d.Get("ldap_port").(int)
+	ldapInsecure := d.Get("ldap_insecure").(bool)
+	// retries
+	maxConcurrentOperations := d.Get("max_concurrent_operations").(int)
+	maxPoolSize := d.Get("max_pool_size").(int)
+	objectCache := d.Get("object_cache").(bool)
+	jsonDepth := d.Get("json_depth").(int)
+	batchWindow := time.Duration(d.Get("batch_window").(int)) * time.Millisecond
+	maxRetries := d.Get("max_retries").(int)
+	retryMinBackoff := time.Duration(d.Get("retry_min_backoff").(int)) * time.Second
+	retryMaxBackoff := time.Duration(d.Get("retry_max_backoff").(int)) * time.Second
+	preflight := d.Get("preflight").(bool)
+	// redaction
+	var sensitiveCustomAttributes []string
+	for _, name := range d.Get("sensitive_custom_attributes").([]interface{}) {
+		sensitiveCustomAttributes = append(sensitiveCustomAttributes, name.(string))
+	}
+
+	if krbCCache != "" && krbRealm == "" {
+		cc, err := loadCCache(krbCCache)
+		if err != nil {
+			return nil, err
+		}
+		krbRealm = cc.GetClientRealm()
+	}
+	if len(winRMHosts) == 0 && runtime.GOOS != "windows" {
+		return nil, fmt.Errorf("winrm_hostname or winrm_hostnames is required, they are allowed to be empty only if terraform runs on windows, (current os: %q)", runtime.GOOS)
+	}
+	isSSH := strings.ToLower(connectionType) == ConnectionTypeSSH
+	if isSSH {
+		if winRMUsername == "" {
+			return nil, fmt.Errorf("winrm_username is required when connection_type is %s", ConnectionTypeSSH)
+		}
+		if sshPrivateKey == "" && winRMPassword == "" {
+			return nil, fmt.Errorf("ssh_private_key or winrm_password is required when connection_type is %s", ConnectionTypeSSH)
+		}
+		if strings.ToLower(winRMTransport) == TransportPSRP || winRMPersistentSession {
+			return nil, fmt.Errorf("winrm_transport and winrm_persistent_session cannot be used when connection_type is %s", ConnectionTypeSSH)
+		}
+	}
+	// The passwords of the profiles are part of the commands, they must not be sent in clear text.
+	if len(credentialProfiles) > 0 && !isSSH && strings.ToLower(winRMProto) != "https" && !winRMMessageEncryption {
+		return nil, fmt.Errorf("credential_profile requires winrm_proto to be https, winrm_message_encryption or connection_type to be %s", ConnectionTypeSSH)
+	}
+	redact.Register(sshPassphrase)
+	sshSigner, err := readSSHKey(sshPrivateKey, sshPassphrase)
+	if err != nil {
+		return nil, err
+	}
+	if winRMUsername == "" && krbCCache == "" && winRMClientCert == nil && runtime.GOOS != "windows" {
+		return nil, fmt.Errorf("winrm_username is allowed to be empty only if terraform runs on windows or if krb_ccache or winrm_client_cert is set, (current os: %q)", runtime.GOOS)
+	}
+	if winRMClientCert != nil && strings.ToLower(winRMProto) != "https" {
+		return nil, fmt.Errorf("winrm_client_cert requires winrm_proto to be https")
+	}
+	if winRMMessageEncryption && krbRealm == "" && !winRMUseNTLM {
+		return nil, fmt.Errorf("winrm_message_encryption requires kerberos (krb_realm) or NTLM (winrm_use_ntlm) authentication")
+	}
+	// winrs shells always run in the default session configuration.
+	if winRMConfigurationName != "" && strings.ToLower(winRMTransport) != TransportPSRP {
+		return nil, fmt.Errorf("winrm_configuration_name requires winrm_transport to be %s", TransportPSRP)
+	}
+
+	redact.Register(winRMPassword, string(winRMClientKey))
+	if sshSigner != nil && strings.HasPrefix(strings.TrimSpace(sshPrivateKey), "-----BEGIN") {
+		redact.Register(sshPrivateKey)
+	}
+	if krbKeytab != "" {
+		// The keytab is loaded when authenticating, a missing file is reported then.
+		if content, err := os.ReadFile(krbKeytab); err == nil {
+			redact.Register(string(content), base64.StdEncoding.EncodeToString(content))
+		}
+	}
 
 	cfg := &Settings{
-		DomainName:           krbRealm,
-		WinRMHost:            winRMHost,
-		WinRMPort:            winRMPort,
-		WinRMProto:           winRMProto,
-		WinRMUsername:        winRMUsername,
-		WinRMPassword:        winRMPassword,
-		WinRMInsecure:        winRMInsecure,
-		KrbRealm:             krbRealm,
-		KrbConfig:            krbConfig,
-		KrbKeytab:            krbKeytab,
-		KrbSpn:               krbSpn,
-		WinRMUseNTLM:         winRMUseNTLM,
-		WinRMPassCredentials: winRMPassCredentials,
-		DomainController:     domainController,
+		DomainName:                krbRealm,
+		WinRMHost:                 firstHost(winRMHosts),
+		WinRMHosts:                winRMHosts,
+		WinRMPort:                 winRMPort,
+		WinRMProto:                winRMProto,
+		WinRMUsername:             winRMUsername,
+		WinRMPassword:             winRMPassword,
+		WinRMInsecure:             winRMInsecure,
+		WinRMCACert:               winRMCACert,
+		WinRMClientCert:           winRMClientCert,
+		WinRMClientKey:            winRMClientKey,
+		KrbRealm:                  krbRealm,
+		KrbConfig:                 krbConfig,
+		KrbKeytab:                 krbKeytab,
+		KrbCCache:                 krbCCache,
+		KrbSpn:                    krbSpn,
+		WinRMUseNTLM:              winRMUseNTLM,
+		WinRMMessageEncryption:    winRMMessageEncryption,
+		WinRMPassCredentials:      winRMPassCredentials,
+		WinRMPersistentSession:    winRMPersistentSession,
+		WinRMTransport:            winRMTransport,
+		WinRMConfigurationName:    winRMConfigurationName,
+		ConnectionType:            connectionType,
+		SSHPort:                   sshPort,
+		SSHSigner:                 sshSigner,
+		SSHKnownHosts:             sshKnownHosts,
+		SSHInsecure:               sshInsecure,
+		CredentialProfiles:        credentialProfiles,
+		Preflight:                 preflight,
+		DomainController:          firstHost(domainControllers),
+		DomainControllers:         domainControllers,
+		DCDiscovery:               dcDiscovery,
+		DCSite:                    dcSite,
+		Backend:                   backend,
+		LDAPProto:                 ldapProto,
+		LDAPPort:                  ldapPort,
+		LDAPInsecure:              ldapInsecure,
+		MaxConcurrentOperations:   maxConcurrentOperations,
+		MaxPoolSize:               maxPoolSize,
+		ObjectCache:               objectCache,
+		JSONDepth:                 jsonDepth,
+		BatchWindow:               batchWindow,
+		MaxRetries:                maxRetries,
+		RetryMinBackoff:           retryMinBackoff,
+		RetryMaxBackoff:           retryMaxBackoff,
+		SensitiveCustomAttributes: sensitiveCustomAttributes,
 	}
 
 	return cfg, nil
 }
 
-// GetWinRMConnection returns a WinRM connection
-func GetWinRMConnection(settings *Settings) (*winrm.Client, error) {
-	useHTTPS := false
-	if strings.ToLower(settings.WinRMProto) == "https" {
-		useHTTPS = true
+// GetWinRMConnection returns a WinRM connection. Clients are pooled and outlive ctx, which is only
+// checked before the client is set up. Commands are cancelled using the context they are run with.
+func GetWinRMConnection(ctx context.Context, settings *Settings) (*winrm.Client, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
 
-	endpoint := winrm.NewEndpoint(settings.WinRMHost, settings.WinRMPort, useHTTPS,
-		settings.WinRMInsecure, nil, nil, nil, 0)
-
-	var winrmClient *winrm.Client
-	var err error
-	if settings.KrbRealm != "" {
-		params := winrm.DefaultParameters
-		params.TransportDecorator = NewKerberosTransporter(settings)
-		winrmClient, err = winrm.NewClientWithParameters(endpoint, "", "", params)
-	} else {
-		params := winrm.DefaultParameters
-		if settings.WinRMUseNTLM {
-			params.TransportDecorator = func() winrm.Transporter { return &winrm.ClientNTLM{} }
-		}
-		winrmClient, err = winrm.NewClientWithParameters(endpoint, settings.WinRMUsername, settings.WinRMPassword, params)
-	}
+	endpoint := newWinRMEndpoint(settings)
 
+	params := *winrm.DefaultParameters
+	params.TransportDecorator = func() winrm.Transporter { return newWinRMTransporter(settings) }
+	winrmClient, err := winrm.NewClientWithParameters(endpoint, settings.WinRMUsername, settings.WinRMPassword, &params)
 	if err != nil {
 		return nil, err
 	}
@@ -111,8 +325,13 @@ func GetWinRMConnection(settings *Settings) (*winrm.Client, error) {
 	return winrmClient, nil
 }
 
-// GetWinRMCPConnection sets up a winrmcp client that can be used to upload files to the DC.
-func GetWinRMCPConnection(settings *Settings) (*winrmcp.Winrmcp, error) {
+// GetWinRMCPConnection sets up a winrmcp client that can be used to upload files to the DC. Like
+// GetWinRMConnection, ctx is only checked before the client is set up.
+func GetWinRMCPConnection(ctx context.Context, settings *Settings) (*winrmcp.Winrmcp, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	useHTTPS := false
 	if settings.WinRMProto == "https" {
 		useHTTPS = true
@@ -125,11 +344,21 @@ func GetWinRMCPConnection(settings *Settings) (*winrmcp.Winrmcp, error) {
 		},
 		Https:                 useHTTPS,
 		Insecure:              settings.WinRMInsecure,
+		CACertBytes:           settings.WinRMCACert,
 		MaxOperationsPerShell: 15,
 	}
 
-	if settings.KrbRealm != "" {
-		cfg.TransportDecorator = NewKerberosTransporter(settings)
+	if settings.WinRMClientCert != nil {
+		// winrmcp does not set the client certificate on the endpoints it builds
+		cfg.TransportDecorator = func() winrm.Transporter {
+			return &certTransporter{
+				Transporter: newWinRMTransporter(settings),
+				cert:        settings.WinRMClientCert,
+				key:         settings.WinRMClientKey,
+			}
+		}
+	} else {
+		cfg.TransportDecorator = func() winrm.Transporter { return newWinRMTransporter(settings) }
 	}
 
 	return winrmcp.New(addr, &cfg)
@@ -146,9 +375,16 @@ type KerberosTransporter struct {
 	KrbConf   string
 	KrbKeytab string
 	transport *http.Transport
+	kerberos  *KerberosClient
 }
 
+// NewKerberosTransporter returns a transport decorator authenticating with the kerberos client of
+// settings. The transporters it returns share a kerberos client if settings do not hold one already.
 func NewKerberosTransporter(settings *Settings) func() winrm.Transporter {
+	kerberos := settings.kerberos
+	if kerberos == nil {
+		kerberos = NewKerberosClient(settings)
+	}
 	return func() winrm.Transporter {
 		return &KerberosTransporter{
 			Username:  settings.WinRMUsername,
@@ -160,6 +396,7 @@ func NewKerberosTransporter(settings *Settings) func() winrm.Transporter {
 			KrbConf:   settings.KrbConfig,
 			KrbKeytab: settings.KrbKeytab,
 			SPN:       settings.KrbSpn,
+			kerberos:  kerberos,
 		}
 	}
 }
@@ -172,11 +409,17 @@ func (c *KerberosTransporter) Transport(endpoint *winrm.Endpoint) error {
 
 	proxyfunc := http.ProxyFromEnvironment
 
+	rootCAs, err := caCertPool(endpoint)
+	if err != nil {
+		return err
+	}
+
 	transport := &http.Transport{
 		Proxy: proxyfunc,
 		TLSClientConfig: &tls.Config{
 			InsecureSkipVerify: endpoint.Insecure,
 			ServerName:         endpoint.TLSServerName,
+			RootCAs:            rootCAs,
 		},
 		Dial:                  dial,
 		ResponseHeaderTimeout: endpoint.Timeout,
@@ -188,72 +431,10 @@ func (c *KerberosTransporter) Transport(endpoint *winrm.Endpoint) error {
 }
 
 func (c *KerberosTransporter) Post(_ *winrm.Client, request *soap.SoapMessage) (string, error) {
-	var cfg *config.Config
-	if c.KrbConf != "" {
-		loadedCfg, err := config.Load(c.KrbConf)
-		if err != nil {
-			return "", err
-		}
-		cfg = loadedCfg
-	} else {
-		cfg = config.New()
-		cfg.LibDefaults.DNSLookupKDC = false
-		cfg.LibDefaults.DNSLookupRealm = false
-		cfg.LibDefaults.PermittedEnctypes = []string{"aes128-cts-hmac-sha1-96", "aes256-cts-hmac-sha1-96",
-			"aes128-cts-hmac-sha256-128", "aes256-cts-hmac-sha384-192"}
-		cfg.LibDefaults.DefaultTGSEnctypes = []string{"aes128-cts-hmac-sha1-96", "aes256-cts-hmac-sha1-96",
-			"aes128-cts-hmac-sha256-128", "aes256-cts-hmac-sha384-192"}
-		cfg.LibDefaults.DefaultTktEnctypes = []string{"aes128-cts-hmac-sha1-96", "aes256-cts-hmac-sha1-96",
-			"aes128-cts-hmac-sha256-128", "aes256-cts-hmac-sha384-192"}
-		cfg.LibDefaults.DefaultRealm = c.Domain
-		cfg.LibDefaults.UDPPreferenceLimit = 1
-		cfg.LibDefaults.PreferredPreauthTypes = []int{17, 16, 15, 14}
-
-		var encTypeIds []int32
-		for _, encType := range cfg.LibDefaults.PermittedEnctypes {
-			encTypeIds = append(encTypeIds, etypeID.EtypeSupported(encType))
-		}
-		cfg.LibDefaults.PermittedEnctypeIDs = encTypeIds
-
-		var dflTGSEncTypeIds []int32
-		for _, encType := range cfg.LibDefaults.DefaultTGSEnctypes {
-			dflTGSEncTypeIds = append(dflTGSEncTypeIds, etypeID.EtypeSupported(encType))
-		}
-		cfg.LibDefaults.DefaultTGSEnctypeIDs = dflTGSEncTypeIds
-
-		var dflTKTEncTypeIds []int32
-		for _, encType := range cfg.LibDefaults.DefaultTktEnctypes {
-			dflTKTEncTypeIds = append(dflTKTEncTypeIds, etypeID.EtypeSupported(encType))
-		}
-		cfg.LibDefaults.DefaultTktEnctypeIDs = dflTKTEncTypeIds
-
-		cfg.Realms = []config.Realm{
-			{
-				AdminServer:   []string{fmt.Sprintf("%s:749", c.Hostname)},
-				KDC:           []string{fmt.Sprintf("%s:88", c.Hostname)},
-				KPasswdServer: []string{c.Hostname},
-				Realm:         c.Domain,
-			},
-		}
-
-		cfg.DomainRealm = config.DomainRealm{
-			c.Domain: c.Domain,
-		}
-	}
-
-	// setup the kerberos client
-	var kerberosClient *client.Client
-	if c.KrbKeytab != "" {
-		cfg.LibDefaults.DefaultKeytabName = c.KrbKeytab
-		keytab, err := keytab.Load(cfg.LibDefaults.DefaultKeytabName)
-		if err != nil {
-			return "", err
-		}
-		kerberosClient = client.NewWithKeytab(c.Username, c.Domain, keytab, cfg, client.DisablePAFXFAST(true),
-			client.AssumePreAuthentication(true))
-	} else {
-		kerberosClient = client.NewWithPassword(c.Username, c.Domain, c.Password, cfg, client.DisablePAFXFAST(true),
-			client.AssumePreAuthentication(true))
+	// the kerberos client keeps its tickets across requests
+	kerberosClient, err := c.kerberos.Client()
+	if err != nil {
+		return "", err
 	}
 
 	// setup the spnego client using the kerberos client we got above
@@ -294,68 +475,741 @@ func (c *KerberosTransporter) Post(_ *winrm.Client, request *soap.SoapMessage) (
 	return string(body), err
 }
 
+// getKrb5Config loads the kerberos configuration from krbConf if it is set. Otherwise it returns a
+// configuration that uses the given hosts as the realm's KDCs, in order.
+func getKrb5Config(krbConf, realm string, hostnames ...string) (*config.Config, error) {
+	if krbConf != "" {
+		return config.Load(krbConf)
+	}
+
+	cfg := config.New()
+	cfg.LibDefaults.DNSLookupKDC = false
+	cfg.LibDefaults.DNSLookupRealm = false
+	cfg.LibDefaults.PermittedEnctypes = []string{"aes128-cts-hmac-sha1-96", "aes256-cts-hmac-sha1-96",
+		"aes128-cts-hmac-sha256-128", "aes256-cts-hmac-sha384-192"}
+	cfg.LibDefaults.DefaultTGSEnctypes = []string{"aes128-cts-hmac-sha1-96", "aes256-cts-hmac-sha1-96",
+		"aes128-cts-hmac-sha256-128", "aes256-cts-hmac-sha384-192"}
+	cfg.LibDefaults.DefaultTktEnctypes = []string{"aes128-cts-hmac-sha1-96", "aes256-cts-hmac-sha1-96",
+		"aes128-cts-hmac-sha256-128", "aes256-cts-hmac-sha384-192"}
+	cfg.LibDefaults.DefaultRealm = realm
+	cfg.LibDefaults.UDPPreferenceLimit = 1
+	cfg.LibDefaults.PreferredPreauthTypes = []int{17, 16, 15, 14}
+
+	var encTypeIds []int32
+	for _, encType := range cfg.LibDefaults.PermittedEnctypes {
+		encTypeIds = append(encTypeIds, etypeID.EtypeSupported(encType))
+	}
+	cfg.LibDefaults.PermittedEnctypeIDs = encTypeIds
+
+	var dflTGSEncTypeIds []int32
+	for _, encType := range cfg.LibDefaults.DefaultTGSEnctypes {
+		dflTGSEncTypeIds = append(dflTGSEncTypeIds, etypeID.EtypeSupported(encType))
+	}
+	cfg.LibDefaults.DefaultTGSEnctypeIDs = dflTGSEncTypeIds
+
+	var dflTKTEncTypeIds []int32
+	for _, encType := range cfg.LibDefaults.DefaultTktEnctypes {
+		dflTKTEncTypeIds = append(dflTKTEncTypeIds, etypeID.EtypeSupported(encType))
+	}
+	cfg.LibDefaults.DefaultTktEnctypeIDs = dflTKTEncTypeIds
+
+	var adminServers, kdcs []string
+	for _, hostname := range hostnames {
+		adminServers = append(adminServers, fmt.Sprintf("%s:749", hostname))
+		kdcs = append(kdcs, fmt.Sprintf("%s:88", hostname))
+	}
+	cfg.Realms = []config.Realm{
+		{
+			AdminServer:   adminServers,
+			KDC:           kdcs,
+			KPasswdServer: hostnames,
+			Realm:         realm,
+		},
+	}
+
+	cfg.DomainRealm = config.DomainRealm{
+		realm: realm,
+	}
+
+	return cfg, nil
+}
+
+// getKerberosClient returns a kerberos client that authenticates with a keytab if one was
+// configured, or with the given password otherwise.
+func getKerberosClient(username, password, realm, krbKeytab string, cfg *config.Config) (*client.Client, error) {
+	if krbKeytab != "" {
+		cfg.LibDefaults.DefaultKeytabName = krbKeytab
+		keytab, err := keytab.Load(cfg.LibDefaults.DefaultKeytabName)
+		if err != nil {
+			return nil, err
+		}
+		return client.NewWithKeytab(username, realm, keytab, cfg, client.DisablePAFXFAST(true),
+			client.AssumePreAuthentication(true)), nil
+	}
+	return client.NewWithPassword(username, realm, password, cfg, client.DisablePAFXFAST(true),
+		client.AssumePreAuthentication(true)), nil
+}
+
 // ProviderConf holds structures that are useful to the provider at runtime.
 type ProviderConf struct {
-	Settings       *Settings
+	Settings *Settings
+	*clientPool
+	// credentials is the credential profile the commands are run with, nil for the provider credentials.
+	credentials       *CredentialProfile
+	winRMHosts        *failoverList
+	domainControllers *failoverList
+	resolver          SRVResolver
+	// pingNetlogon returns the site of the client as reported by a domain controller.
+	pingNetlogon func(ctx context.Context, dc, realm string) (string, error)
+	mx           *sync.Mutex
+}
+
+// clientPool holds the idle clients of a provider, shared by the ProviderConf of its credential profiles.
+type clientPool struct {
 	winRMClients   []*winrm.Client
 	winRMCPClients []*winrmcp.Winrmcp
-	mx             *sync.Mutex
+	// ldapClients holds the LDAP connections by the username they are bound as, LDAP connections are
+	// bound with the credentials of the profile instead of the ones of the provider.
+	ldapClients map[string][]*LDAPClient
+	psSessions  []*PSSession
+	psrpClients []*PSRPClient
+	sshClients  []*SSHClient
+	// clientHosts records the host each pooled client is connected to, for clients connected to a host
+	// that was failed over from to be dropped.
+	clientHosts map[interface{}]string
+	// clientReleased records when each pooled client was released, for idle clients to be evicted.
+	clientReleased map[interface{}]time.Time
+	// operations and copies hold a slot for each command being run and each file being uploaded. They
+	// are nil when the number of concurrent operations is not limited.
+	operations chan struct{}
+	copies     chan struct{}
+	// objectCache is nil unless the object cache is enabled.
+	objectCache *ObjectCache
+	// batcher collects the commands run in batches, it is created on first use.
+	batcher     interface{}
+	batcherOnce sync.Once
+	// closed is set once the provider stops, clients released after it are closed instead of pooled.
+	closed bool
 }
 
 func NewProviderConf(settings *Settings) *ProviderConf {
+	if settings.kerberos == nil {
+		settings.kerberos = NewKerberosClient(settings)
+	}
 	pcfg := &ProviderConf{
-		Settings:       settings,
-		winRMClients:   make([]*winrm.Client, 0),
-		winRMCPClients: make([]*winrmcp.Winrmcp, 0),
-		mx:             &sync.Mutex{},
+		Settings: settings,
+		clientPool: &clientPool{
+			winRMClients:   make([]*winrm.Client, 0),
+			winRMCPClients: make([]*winrmcp.Winrmcp, 0),
+			ldapClients:    map[string][]*LDAPClient{},
+			psSessions:     make([]*PSSession, 0),
+			psrpClients:    make([]*PSRPClient, 0),
+			sshClients:     make([]*SSHClient, 0),
+			clientHosts:    map[interface{}]string{},
+			clientReleased: map[interface{}]time.Time{},
+			operations:     newSlots(settings.MaxConcurrentOperations),
+			copies:         newSlots(settings.MaxConcurrentOperations),
+		},
+		winRMHosts:        newFailoverList("WinRM hosts", winRMHosts(settings), winRMPort(settings)),
+		domainControllers: newFailoverList("domain controllers", domainControllers(settings), domainControllerPort(settings)),
+		resolver:          net.DefaultResolver,
+		pingNetlogon:      DetectSite,
+		mx:                &sync.Mutex{},
+	}
+	if settings.KrbRealm != "" {
+		for name, profile := range settings.CredentialProfiles {
+			profile.kerberos = NewKerberosClient(profileSettings(settings, &profile))
+			settings.CredentialProfiles[name] = profile
+		}
+	}
+	if settings.ObjectCache {
+		pcfg.objectCache = NewObjectCache()
+	}
+	if settings.DCDiscovery && len(pcfg.domainControllers.hosts) == 0 {
+		pcfg.domainControllers.discover = pcfg.discoverDomainControllers
 	}
 	return pcfg
 }
 
+func winRMHosts(settings *Settings) []string {
+	if len(settings.WinRMHosts) > 0 {
+		return settings.WinRMHosts
+	}
+	return splitHosts(settings.WinRMHost)
+}
+
+// winRMPort returns the port commands are sent to on the WinRM hosts.
+func winRMPort(settings *Settings) int {
+	if strings.ToLower(settings.ConnectionType) == ConnectionTypeSSH {
+		return settings.SSHPort
+	}
+	return settings.WinRMPort
+}
+
+// domainControllerPort returns the port the domain controllers are checked on: the LDAP port with the LDAP
+// backend, the port of the Active Directory Web Services the cmdlets talk to otherwise.
+func domainControllerPort(settings *Settings) int {
+	if strings.ToLower(settings.Backend) == BackendLDAP {
+		return ldapPort(settings)
+	}
+	return adwsPort
+}
+
+func domainControllers(settings *Settings) []string {
+	if len(settings.DomainControllers) > 0 {
+		return settings.DomainControllers
+	}
+	return splitHosts(settings.DomainController)
+}
+
+// WinRMHost returns the WinRM host commands are sent to, failing over to the next reachable one when it
+// was marked down.
+func (pcfg *ProviderConf) WinRMHost(ctx context.Context) string {
+	return pcfg.winRMHosts.Pick(ctx)
+}
+
+// MarkWinRMHostDown reports that host could not be reached, for the following commands to be sent to
+// another host. It returns true if another host will be used.
+func (pcfg *ProviderConf) MarkWinRMHostDown(host string) bool {
+	return pcfg.winRMHosts.MarkDown(host)
+}
+
+// MarkDomainControllerDown reports that the domain controller host could not be contacted, for the
+// following commands to use another one. It returns true if another domain controller will be used.
+func (pcfg *ProviderConf) MarkDomainControllerDown(host string) bool {
+	return pcfg.domainControllers.MarkDown(host)
+}
+
+// hostSettings returns a copy of the settings pointing at the WinRM host in use.
+func (pcfg *ProviderConf) hostSettings(ctx context.Context) *Settings {
+	settings := *pcfg.Settings
+	settings.WinRMHost = pcfg.winRMHosts.Pick(ctx)
+	return &settings
+}
+
+// trackClient records the host a new client is connected to. It must be called with mx held.
+func (pcfg *ProviderConf) trackClient(client interface{}, host string) {
+	pcfg.clientHosts[client] = host
+}
+
+// isStale reports whether client is connected to a host that is not in use anymore, and forgets about
+// it if so. It must be called with mx held.
+func (pcfg *ProviderConf) isStale(client interface{}, host string) bool {
+	if pcfg.clientHosts[client] == host {
+		return false
+	}
+	pcfg.forget(client)
+	return true
+}
+
+// forget stops tracking client, which is not pooled anymore. It must be called with mx held.
+func (pcfg *ProviderConf) forget(client interface{}) {
+	delete(pcfg.clientHosts, client)
+	delete(pcfg.clientReleased, client)
+}
+
+// keep reports whether a released client fits in a pool already holding n clients and the provider has not
+// stopped, and records when it was released if so. It must be called with mx held.
+func (pcfg *ProviderConf) keep(client interface{}, n int) bool {
+	if pcfg.closed || pcfg.Settings.MaxPoolSize > 0 && n >= pcfg.Settings.MaxPoolSize {
+		pcfg.forget(client)
+		return false
+	}
+	pcfg.clientReleased[client] = time.Now()
+	return true
+}
+
 // AcquireWinRMClient get a thread safe WinRM client from the pool. Create a new one if the pool is empty
-func (pcfg *ProviderConf) AcquireWinRMClient() (winRMClient *winrm.Client, err error) {
+// It blocks while max_concurrent_operations clients are in use.
+func (pcfg *ProviderConf) AcquireWinRMClient(ctx context.Context) (winRMClient *winrm.Client, err error) {
+	if err := acquireSlot(ctx, pcfg.operations); err != nil {
+		return nil, err
+	}
+	settings := pcfg.hostSettings(ctx)
 	pcfg.mx.Lock()
 	defer pcfg.mx.Unlock()
-	if len(pcfg.winRMClients) == 0 {
-		winRMClient, err = GetWinRMConnection(pcfg.Settings)
-		if err != nil {
-			return nil, err
+	pcfg.winRMClients = evictIdle(pcfg, pcfg.winRMClients, nil)
+	for len(pcfg.winRMClients) > 0 {
+		winRMClient, pcfg.winRMClients = popClient(pcfg.winRMClients)
+		if !pcfg.isStale(winRMClient, settings.WinRMHost) {
+			return winRMClient, nil
 		}
-	} else {
-		winRMClient = pcfg.winRMClients[0]
-		pcfg.winRMClients = pcfg.winRMClients[1:]
 	}
+	winRMClient, err = GetWinRMConnection(ctx, settings)
+	if err != nil {
+		releaseSlot(pcfg.operations)
+		return nil, err
+	}
+	pcfg.trackClient(winRMClient, settings.WinRMHost)
 	return winRMClient, nil
 }
 
 // ReleaseWinRMClient returns a thread safe WinRM client after usage to the pool.
 func (pcfg *ProviderConf) ReleaseWinRMClient(winRMClient *winrm.Client) {
+	defer releaseSlot(pcfg.operations)
+	host := pcfg.winRMHosts.Current()
 	pcfg.mx.Lock()
 	defer pcfg.mx.Unlock()
-	pcfg.winRMClients = append(pcfg.winRMClients, winRMClient)
+	if !pcfg.isStale(winRMClient, host) && pcfg.keep(winRMClient, len(pcfg.winRMClients)) {
+		pcfg.winRMClients = append(pcfg.winRMClients, winRMClient)
+	}
 }
 
 // AcquireWinRMCPClient get a thread safe WinRM client from the pool. Create a new one if the pool is empty
-func (pcfg *ProviderConf) AcquireWinRMCPClient() (winRMCPClient *winrmcp.Winrmcp, err error) {
+// It blocks while max_concurrent_operations clients are in use.
+func (pcfg *ProviderConf) AcquireWinRMCPClient(ctx context.Context) (winRMCPClient *winrmcp.Winrmcp, err error) {
+	if err := acquireSlot(ctx, pcfg.copies); err != nil {
+		return nil, err
+	}
+	settings := pcfg.hostSettings(ctx)
 	pcfg.mx.Lock()
 	defer pcfg.mx.Unlock()
-	if len(pcfg.winRMCPClients) == 0 {
-		winRMCPClient, err = GetWinRMCPConnection(pcfg.Settings)
-		if err != nil {
-			return nil, err
+	pcfg.winRMCPClients = evictIdle(pcfg, pcfg.winRMCPClients, nil)
+	for len(pcfg.winRMCPClients) > 0 {
+		winRMCPClient, pcfg.winRMCPClients = popClient(pcfg.winRMCPClients)
+		if !pcfg.isStale(winRMCPClient, settings.WinRMHost) {
+			return winRMCPClient, nil
 		}
-	} else {
-		winRMCPClient = pcfg.winRMCPClients[0]
-		pcfg.winRMCPClients = pcfg.winRMCPClients[1:]
 	}
+	winRMCPClient, err = GetWinRMCPConnection(ctx, settings)
+	if err != nil {
+		releaseSlot(pcfg.copies)
+		return nil, err
+	}
+	pcfg.trackClient(winRMCPClient, settings.WinRMHost)
 	return winRMCPClient, nil
 }
 
 // ReleaseWinRMCPClient returns a thread safe WinRM client after usage to the pool.
 func (pcfg *ProviderConf) ReleaseWinRMCPClient(winRMCPClient *winrmcp.Winrmcp) {
+	defer releaseSlot(pcfg.copies)
+	host := pcfg.winRMHosts.Current()
 	pcfg.mx.Lock()
 	defer pcfg.mx.Unlock()
-	pcfg.winRMCPClients = append(pcfg.winRMCPClients, winRMCPClient)
+	if !pcfg.isStale(winRMCPClient, host) && pcfg.keep(winRMCPClient, len(pcfg.winRMCPClients)) {
+		pcfg.winRMCPClients = append(pcfg.winRMCPClients, winRMCPClient)
+	}
+}
+
+// profileSettings returns a copy of settings authenticating with the credentials of profile.
+func profileSettings(settings *Settings, profile *CredentialProfile) *Settings {
+	s := *settings
+	s.WinRMUsername = profile.Username
+	s.WinRMPassword = profile.Password
+	s.KrbCCache = ""
+	s.KrbKeytab = ""
+	s.kerberos = profile.kerberos
+	return &s
+}
+
+// ldapSettings returns the settings LDAP connections are opened with, bound with the credentials of the
+// credential profile if any.
+func (pcfg *ProviderConf) ldapSettings(ctx context.Context) *Settings {
+	settings := pcfg.hostSettings(ctx)
+	if pcfg.credentials != nil {
+		settings = profileSettings(settings, pcfg.credentials)
+	}
+	settings.DomainController = pcfg.domainControllers.Pick(ctx)
+	return settings
+}
+
+// AcquireLDAPClient get a thread safe LDAP client from the pool. Create a new one if the pool is empty
+// or if all pooled connections have been closed by the server. If the domain controller cannot be
+// reached, the next one is tried. Connections are bound as the credential profile if any. The client is
+// closed if ctx is done before it is released, which makes the operations in progress return.
+// It blocks while max_concurrent_operations clients are in use.
+func (pcfg *ProviderConf) AcquireLDAPClient(ctx context.Context) (ldapClient *LDAPClient, err error) {
+	if err := acquireSlot(ctx, pcfg.operations); err != nil {
+		return nil, err
+	}
+	for {
+		settings := pcfg.ldapSettings(ctx)
+		host := ldapHost(settings)
+		user := settings.WinRMUsername
+
+		pcfg.mx.Lock()
+		pool := evictIdle(pcfg, pcfg.ldapClients[user], func(c *LDAPClient) { c.Close() })
+		for len(pool) > 0 {
+			ldapClient, pool = popClient(pool)
+			if !ldapClient.IsClosing() && !pcfg.isStale(ldapClient, host) {
+				pcfg.ldapClients[user] = pool
+				pcfg.mx.Unlock()
+				ldapClient.watch(ctx)
+				return ldapClient, nil
+			}
+			pcfg.forget(ldapClient)
+			ldapClient.Close()
+		}
+		pcfg.ldapClients[user] = pool
+		pcfg.mx.Unlock()
+
+		ldapClient, err = GetLDAPConnection(ctx, settings)
+		if err != nil {
+			if ctx.Err() == nil && IsDialError(err) && pcfg.failover(host) {
+				continue
+			}
+			releaseSlot(pcfg.operations)
+			return nil, err
+		}
+		pcfg.mx.Lock()
+		pcfg.trackClient(ldapClient, host)
+		pcfg.mx.Unlock()
+		ldapClient.watch(ctx)
+		return ldapClient, nil
+	}
+}
+
+// ReleaseLDAPClient returns a thread safe LDAP client after usage to the pool of the identity it is bound as.
+// Clients that were closed, because the connection failed or because their context is done, are discarded.
+func (pcfg *ProviderConf) ReleaseLDAPClient(ldapClient *LDAPClient) {
+	if !ldapClient.unwatch() || ldapClient.IsClosing() {
+		pcfg.discard(ldapClient, pcfg.operations)
+		return
+	}
+	defer releaseSlot(pcfg.operations)
+	host := ldapHost(&Settings{DomainController: pcfg.domainControllers.Current(), WinRMHost: pcfg.winRMHosts.Current()})
+	user := pcfg.Settings.WinRMUsername
+	if pcfg.credentials != nil {
+		user = pcfg.credentials.Username
+	}
+	pcfg.mx.Lock()
+	defer pcfg.mx.Unlock()
+	if pcfg.isStale(ldapClient, host) || !pcfg.keep(ldapClient, len(pcfg.ldapClients[user])) {
+		ldapClient.Close()
+		return
+	}
+	pcfg.ldapClients[user] = append(pcfg.ldapClients[user], ldapClient)
+}
+
+// failover marks host down, as a domain controller if it is one or as a WinRM host otherwise. It returns
+// true if another host will be used.
+func (pcfg *ProviderConf) failover(host string) bool {
+	return pcfg.domainControllers.MarkDown(host) || pcfg.winRMHosts.MarkDown(host)
+}
+
+// AcquirePSSession get a persistent powershell session from the pool. Start a new one if the pool is empty
+// It blocks while max_concurrent_operations clients are in use.
+func (pcfg *ProviderConf) AcquirePSSession(ctx context.Context) (*PSSession, error) {
+	if err := acquireSlot(ctx, pcfg.operations); err != nil {
+		return nil, err
+	}
+	settings := pcfg.hostSettings(ctx)
+	pcfg.mx.Lock()
+	pcfg.psSessions = evictIdle(pcfg, pcfg.psSessions, closeClient[*PSSession])
+	for len(pcfg.psSessions) > 0 {
+		var session *PSSession
+		session, pcfg.psSessions = popClient(pcfg.psSessions)
+		if !pcfg.isStale(session, settings.WinRMHost) {
+			pcfg.mx.Unlock()
+			return session, nil
+		}
+		_ = session.Close()
+	}
+	pcfg.mx.Unlock()
+	// Starting a session takes a while because of the module imports, don't hold the lock while doing it.
+	session, err := NewPSSession(ctx, settings)
+	if err != nil {
+		releaseSlot(pcfg.operations)
+		return nil, err
+	}
+	pcfg.mx.Lock()
+	pcfg.trackClient(session, settings.WinRMHost)
+	pcfg.mx.Unlock()
+	return session, nil
+}
+
+// ReleasePSSession returns a persistent powershell session after usage to the pool.
+func (pcfg *ProviderConf) ReleasePSSession(session *PSSession) {
+	defer releaseSlot(pcfg.operations)
+	host := pcfg.winRMHosts.Current()
+	pcfg.mx.Lock()
+	defer pcfg.mx.Unlock()
+	if pcfg.isStale(session, host) || !pcfg.keep(session, len(pcfg.psSessions)) {
+		_ = session.Close()
+		return
+	}
+	pcfg.psSessions = append(pcfg.psSessions, session)
+}
+
+// RunInPSSession runs a script in one of the pooled persistent powershell sessions. Sessions that fail
+// are closed instead of being returned to the pool. If the script could not be delivered because the
+// session went away (the remote shell timed out for instance), it is retried once in a new session.
+// If the WinRM host cannot be reached, the session is started on the next one.
+// Cancelling ctx stops the script by terminating the session.
+func (pcfg *ProviderConf) RunInPSSession(ctx context.Context, script string) (stdout string, stderr string, exitCode int, err error) {
+	for attempt := 0; ; attempt++ {
+		host := pcfg.WinRMHost(ctx)
+		session, err := pcfg.AcquirePSSession(ctx)
+		if err != nil {
+			if IsDialError(err) && pcfg.MarkWinRMHostDown(host) {
+				continue
+			}
+			return "", "", 0, fmt.Errorf("while acquiring powershell session: %s", err)
+		}
+
+		stdout, stderr, exitCode, err = session.Run(ctx, script)
+		if err == nil {
+			pcfg.ReleasePSSession(session)
+			return stdout, stderr, exitCode, nil
+		}
+		pcfg.discard(session, pcfg.operations)
+
+		var inputErr *PSSessionInputError
+		if attempt == 0 && errors.As(err, &inputErr) {
+			log.Printf("[DEBUG] Discarding stale powershell session: %s", err)
+			continue
+		}
+		return stdout, stderr, exitCode, err
+	}
+}
+
+// AcquirePSRPClient get a PSRP runspace pool from the pool. Open a new one if the pool is empty
+// It blocks while max_concurrent_operations clients are in use.
+func (pcfg *ProviderConf) AcquirePSRPClient(ctx context.Context) (*PSRPClient, error) {
+	if err := acquireSlot(ctx, pcfg.operations); err != nil {
+		return nil, err
+	}
+	settings := pcfg.hostSettings(ctx)
+	pcfg.mx.Lock()
+	pcfg.psrpClients = evictIdle(pcfg, pcfg.psrpClients, closeClient[*PSRPClient])
+	for len(pcfg.psrpClients) > 0 {
+		var client *PSRPClient
+		client, pcfg.psrpClients = popClient(pcfg.psrpClients)
+		if !pcfg.isStale(client, settings.WinRMHost) {
+			pcfg.mx.Unlock()
+			return client, nil
+		}
+		_ = client.Close()
+	}
+	pcfg.mx.Unlock()
+	// Opening a runspace pool takes a while because of the module imports, don't hold the lock while doing it.
+	client, err := NewPSRPClient(ctx, settings)
+	if err != nil {
+		releaseSlot(pcfg.operations)
+		return nil, err
+	}
+	pcfg.mx.Lock()
+	pcfg.trackClient(client, settings.WinRMHost)
+	pcfg.mx.Unlock()
+	return client, nil
+}
+
+// ReleasePSRPClient returns a PSRP runspace pool after usage to the pool.
+func (pcfg *ProviderConf) ReleasePSRPClient(client *PSRPClient) {
+	defer releaseSlot(pcfg.operations)
+	host := pcfg.winRMHosts.Current()
+	pcfg.mx.Lock()
+	defer pcfg.mx.Unlock()
+	if pcfg.isStale(client, host) || !pcfg.keep(client, len(pcfg.psrpClients)) {
+		_ = client.Close()
+		return
+	}
+	pcfg.psrpClients = append(pcfg.psrpClients, client)
+}
+
+// RunPSRP runs a script in one of the pooled PSRP runspace pools. Runspace pools that fail are closed
+// instead of being returned to the pool. If the pipeline could not be created because the runspace pool
+// went away, the script is retried once in a new one. If the WinRM host cannot be reached, the runspace
+// pool is opened on the next one. Cancelling ctx stops the pipeline.
+func (pcfg *ProviderConf) RunPSRP(ctx context.Context, script string) (*PSRPResult, error) {
+	for attempt := 0; ; attempt++ {
+		host := pcfg.WinRMHost(ctx)
+		client, err := pcfg.AcquirePSRPClient(ctx)
+		if err != nil {
+			if IsDialError(err) && pcfg.MarkWinRMHostDown(host) {
+				continue
+			}
+			return nil, fmt.Errorf("while acquiring PSRP runspace pool: %s", err)
+		}
+
+		result, err := client.Run(ctx, script)
+		if err == nil {
+			pcfg.ReleasePSRPClient(client)
+			return result, nil
+		}
+		pcfg.discard(client, pcfg.operations)
+
+		var cmdErr *PSRPCommandError
+		if attempt == 0 && errors.As(err, &cmdErr) {
+			log.Printf("[DEBUG] Discarding stale PSRP runspace pool: %s", err)
+			continue
+		}
+		return nil, err
+	}
+}
+
+// AcquireSSHClient get an SSH connection from the pool to run a command. Connect a new one if the pool
+// is empty. It blocks while max_concurrent_operations clients are in use.
+func (pcfg *ProviderConf) AcquireSSHClient(ctx context.Context) (*SSHClient, error) {
+	return pcfg.acquireSSHClient(ctx, pcfg.operations)
+}
+
+// acquireSSHClient get an SSH connection from the pool, holding one of slots until it is released.
+func (pcfg *ProviderConf) acquireSSHClient(ctx context.Context, slots chan struct{}) (*SSHClient, error) {
+	if err := acquireSlot(ctx, slots); err != nil {
+		return nil, err
+	}
+	settings := pcfg.hostSettings(ctx)
+	pcfg.mx.Lock()
+	pcfg.sshClients = evictIdle(pcfg, pcfg.sshClients, closeClient[*SSHClient])
+	for len(pcfg.sshClients) > 0 {
+		var client *SSHClient
+		client, pcfg.sshClients = popClient(pcfg.sshClients)
+		if !pcfg.isStale(client, settings.WinRMHost) {
+			pcfg.mx.Unlock()
+			return client, nil
+		}
+		_ = client.Close()
+	}
+	pcfg.mx.Unlock()
+	client, err := NewSSHClient(ctx, settings)
+	if err != nil {
+		releaseSlot(slots)
+		return nil, err
+	}
+	pcfg.mx.Lock()
+	pcfg.trackClient(client, settings.WinRMHost)
+	pcfg.mx.Unlock()
+	return client, nil
+}
+
+// ReleaseSSHClient returns an SSH connection acquired with AcquireSSHClient after usage to the pool.
+func (pcfg *ProviderConf) ReleaseSSHClient(client *SSHClient) {
+	pcfg.releaseSSHClient(client, pcfg.operations)
+}
+
+// releaseSSHClient returns an SSH connection after usage to the pool, releasing one of slots.
+func (pcfg *ProviderConf) releaseSSHClient(client *SSHClient, slots chan struct{}) {
+	defer releaseSlot(slots)
+	host := pcfg.winRMHosts.Current()
+	pcfg.mx.Lock()
+	defer pcfg.mx.Unlock()
+	if pcfg.isStale(client, host) || !pcfg.keep(client, len(pcfg.sshClients)) {
+		_ = client.Close()
+		return
+	}
+	pcfg.sshClients = append(pcfg.sshClients, client)
+}
+
+// RunSSH runs a command on one of the pooled SSH connections. If no session can be opened on the
+// connection because the server dropped it, the command is retried once on a new one. If the host
+// cannot be reached, the connection is made to the next one. Cancelling ctx kills the command.
+func (pcfg *ProviderConf) RunSSH(ctx context.Context, command string) (stdout string, stderr string, exitCode int, err error) {
+	for attempt := 0; ; attempt++ {
+		host := pcfg.WinRMHost(ctx)
+		client, err := pcfg.AcquireSSHClient(ctx)
+		if err != nil {
+			if IsDialError(err) && pcfg.MarkWinRMHostDown(host) {
+				continue
+			}
+			return "", "", 0, fmt.Errorf("while acquiring SSH connection: %s", err)
+		}
+
+		stdout, stderr, exitCode, err = client.Run(ctx, command)
+		var sessionErr *SSHSessionError
+		if err == nil || !errors.As(err, &sessionErr) {
+			pcfg.ReleaseSSHClient(client)
+			return stdout, stderr, exitCode, err
+		}
+		pcfg.discard(client, pcfg.operations)
+
+		if attempt == 0 {
+			log.Printf("[DEBUG] Discarding stale SSH connection: %s", err)
+			continue
+		}
+		return stdout, stderr, exitCode, err
+	}
+}
+
+// AcquireFileCopier returns a client uploading files to the host commands are sent to, an SSH
+// connection or a winrmcp client depending on the connection type. It must be released with
+// ReleaseFileCopier. Uploads are limited by max_concurrent_operations separately from commands, since
+// uploading a file involves running commands. Files are written as the user the connection is
+// authenticated as, so uploads are refused on behalf of a credential profile.
+func (pcfg *ProviderConf) AcquireFileCopier(ctx context.Context) (FileCopier, error) {
+	if pcfg.credentials != nil {
+		return nil, fmt.Errorf("files are uploaded as winrm_username, they cannot be uploaded with the credentials of %q of a credential_profile", pcfg.credentials.Username)
+	}
+	if pcfg.IsConnectionTypeSSH() {
+		return pcfg.acquireSSHClient(ctx, pcfg.copies)
+	}
+	return pcfg.AcquireWinRMCPClient(ctx)
+}
+
+// ReleaseFileCopier returns a client acquired with AcquireFileCopier to its pool.
+func (pcfg *ProviderConf) ReleaseFileCopier(copier FileCopier) {
+	switch c := copier.(type) {
+	case *SSHClient:
+		pcfg.releaseSSHClient(c, pcfg.copies)
+	case *winrmcp.Winrmcp:
+		pcfg.ReleaseWinRMCPClient(c)
+	}
+}
+
+// Batcher returns the batcher of the provider, created with create the first time it is requested. It is
+// shared by the ProviderConf of the credential profiles of the provider.
+func (pcfg *ProviderConf) Batcher(create func() interface{}) interface{} {
+	pcfg.batcherOnce.Do(func() {
+		pcfg.batcher = create()
+	})
+	return pcfg.batcher
+}
+
+// WithCredentialProfile returns a ProviderConf passing the credentials of the profile name to the cmdlets.
+// It shares its clients and failover state with pcfg. An empty name returns pcfg.
+func (pcfg *ProviderConf) WithCredentialProfile(name string) (*ProviderConf, error) {
+	if name == "" {
+		return pcfg, nil
+	}
+	profile, ok := pcfg.Settings.CredentialProfiles[name]
+	if !ok {
+		return nil, fmt.Errorf("credential profile %q is not defined in the provider configuration", name)
+	}
+	conf := *pcfg
+	conf.credentials = &profile
+	return &conf, nil
+}
+
+// CredentialUsername returns the username passed to the cmdlets, the one of the credential profile if any
+func (pcfg *ProviderConf) CredentialUsername() string {
+	if pcfg.credentials != nil {
+		return pcfg.credentials.Username
+	}
+	return pcfg.Settings.WinRMUsername
+}
+
+// CredentialPassword returns the password passed to the cmdlets, the one of the credential profile if any
+func (pcfg *ProviderConf) CredentialPassword() string {
+	if pcfg.credentials != nil {
+		return pcfg.credentials.Password
+	}
+	return pcfg.Settings.WinRMPassword
+}
+
+// IsConnectionTypeSSH check if commands should be run over SSH instead of WinRM
+func (pcfg *ProviderConf) IsConnectionTypeSSH() bool {
+	return strings.ToLower(pcfg.Settings.ConnectionType) == ConnectionTypeSSH
+}
+
+// IsTransportPSRP check if commands should be run using the PowerShell Remoting Protocol
+func (pcfg *ProviderConf) IsTransportPSRP() bool {
+	return strings.ToLower(pcfg.Settings.WinRMTransport) == TransportPSRP
+}
+
+// IsRestrictedLanguage check if commands are sent to a session configuration that can restrict the
+// language, such as a Just Enough Administration endpoint
+func (pcfg *ProviderConf) IsRestrictedLanguage() bool {
+	return pcfg.Settings.WinRMConfigurationName != ""
+}
+
+// IsPersistentSessionEnabled check if commands should run in persistent powershell sessions
+func (pcfg *ProviderConf) IsPersistentSessionEnabled() bool {
+	return pcfg.Settings.WinRMPersistentSession
+}
+
+// IsBackendLDAP check if the LDAP backend should be used for the object types that support it
+func (pcfg *ProviderConf) IsBackendLDAP() bool {
+	return strings.ToLower(pcfg.Settings.Backend) == BackendLDAP
 }
 
 // IsConnectionTypeLocal check if connection is local
@@ -373,9 +1227,13 @@ func (pcfg *ProviderConf) IsConnectionTypeLocal() bool {
 }
 
 // IsPassCredentialsEnabled check if credentials should be passed
-// requires that https be enabled
+// requires that https be enabled, or a credential profile
 func (pcfg *ProviderConf) IsPassCredentialsEnabled() bool {
 	log.Printf("[DEBUG] Checking to see if credentials should be passed")
+	if pcfg.credentials != nil {
+		log.Printf("[DEBUG] Passing the credentials of the credential profile")
+		return true
+	}
 	isPassCredentialsEnabled := false
 	if pcfg.Settings.WinRMProto == "https" && pcfg.Settings.WinRMPassCredentials {
 		log.Printf("[DEBUG] Matching criteria for passing credenitals")
@@ -385,12 +1243,14 @@ func (pcfg *ProviderConf) IsPassCredentialsEnabled() bool {
 	return isPassCredentialsEnabled
 }
 
-// If a
-func (pcfg *ProviderConf) IdentifyDomainController() string {
+// IdentifyDomainController returns the domain controller PowerShell commands should use. When several
+// domain controllers are configured or discovered, the first reachable one is returned. The domain name is
+// returned if no domain controller is known.
+func (pcfg *ProviderConf) IdentifyDomainController(ctx context.Context) string {
 	log.Printf("[DEBUG] Checking to see if a domain controller was specified.")
-	if pcfg.Settings.DomainController != "" {
-		log.Printf("[DEBUG] Using specified domain controller for PowerShell commands.")
-		return pcfg.Settings.DomainController
+	if dc := pcfg.domainControllers.Pick(ctx); dc != "" {
+		log.Printf("[DEBUG] Using domain controller %s for PowerShell commands.", dc)
+		return dc
 	}
 	log.Printf("[DEBUG] Using the domain name instead of a specific domain controller for PowerShell commands.")
 	return pcfg.Settings.DomainName
@@ -0,0 +1,107 @@
+package config
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/masterzen/winrm"
+)
+
+func TestWithCredentialProfile(t *testing.T) {
+	pcfg := NewProviderConf(&Settings{
+		WinRMUsername: "svc-terraform",
+		WinRMPassword: "secret",
+		WinRMProto:    "https",
+		CredentialProfiles: map[string]CredentialProfile{
+			"helpdesk": {Username: `CONTOSO\helpdesk`, Password: "helpdesk-secret"},
+		},
+	})
+
+	if conf, err := pcfg.WithCredentialProfile(""); err != nil || conf != pcfg {
+		t.Errorf("expected the provider configuration without a profile, got %v, %v", conf, err)
+	}
+	if _, err := pcfg.WithCredentialProfile("tier0"); err == nil {
+		t.Errorf("expected an error for an unknown profile")
+	}
+
+	conf, err := pcfg.WithCredentialProfile("helpdesk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.CredentialUsername() != `CONTOSO\helpdesk` || conf.CredentialPassword() != "helpdesk-secret" {
+		t.Errorf("unexpected credentials %s / %s", conf.CredentialUsername(), conf.CredentialPassword())
+	}
+	if !conf.IsPassCredentialsEnabled() {
+		t.Errorf("the credentials of a profile must be passed to the cmdlets")
+	}
+	if pcfg.CredentialUsername() != "svc-terraform" || pcfg.IsPassCredentialsEnabled() {
+		t.Errorf("the profile leaked into the provider configuration")
+	}
+
+	// The connections are authenticated with the provider credentials, they are shared with the profiles.
+	conf.ReleaseWinRMClient(&winrm.Client{})
+	if len(pcfg.winRMClients) != 1 {
+		t.Errorf("expected the client released by the profile to be pooled for the provider, got %d clients", len(pcfg.winRMClients))
+	}
+	if settings := conf.hostSettings(context.Background()); settings.WinRMUsername != "svc-terraform" {
+		t.Errorf("expected connections to use the provider credentials, got %s", settings.WinRMUsername)
+	}
+
+	// LDAP connections are bound as the profile, they are pooled separately.
+	if settings := conf.ldapSettings(context.Background()); settings.WinRMUsername != `CONTOSO\helpdesk` || settings.WinRMPassword != "helpdesk-secret" {
+		t.Errorf("expected LDAP connections to be bound as the profile, got %s", settings.WinRMUsername)
+	}
+	conf.ReleaseLDAPClient(newTestLDAPClient(t))
+	if len(pcfg.ldapClients[`CONTOSO\helpdesk`]) != 1 || len(pcfg.ldapClients["svc-terraform"]) != 0 {
+		t.Errorf("expected the LDAP client released by the profile to be pooled for the profile, got %v", pcfg.ldapClients)
+	}
+	if _, err := conf.AcquireFileCopier(context.Background()); err == nil {
+		t.Errorf("expected uploads on behalf of a profile to be refused")
+	}
+}
+
+func TestBatcher(t *testing.T) {
+	pcfg := NewProviderConf(&Settings{
+		CredentialProfiles: map[string]CredentialProfile{"helpdesk": {Username: "helpdesk"}},
+	})
+	conf, err := pcfg.WithCredentialProfile("helpdesk")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created := 0
+	create := func() interface{} {
+		created++
+		return &created
+	}
+	if pcfg.Batcher(create) != conf.Batcher(create) || created != 1 {
+		t.Errorf("expected the batcher to be created once and shared with the profiles, it was created %d times", created)
+	}
+	if NewProviderConf(&Settings{}).Batcher(func() interface{} { return new(int) }) == pcfg.Batcher(create) {
+		t.Errorf("expected each provider to have its own batcher")
+	}
+}
+
+func TestHostList(t *testing.T) {
+	s := map[string]*schema.Schema{
+		"domain_controller":  {Type: schema.TypeString, Optional: true},
+		"domain_controllers": {Type: schema.TypeList, Optional: true, Elem: &schema.Schema{Type: schema.TypeString}},
+	}
+	cases := []struct {
+		raw      map[string]interface{}
+		expected []string
+	}{
+		{map[string]interface{}{"domain_controller": "dc1"}, []string{"dc1"}},
+		{map[string]interface{}{"domain_controllers": []interface{}{"dc1", " dc2 ", ""}}, []string{"dc1", "dc2"}},
+		{map[string]interface{}{"domain_controller": "dc3", "domain_controllers": []interface{}{"dc1"}}, []string{"dc1"}},
+		{map[string]interface{}{}, nil},
+	}
+	for _, c := range cases {
+		d := schema.TestResourceDataRaw(t, s, c.raw)
+		if actual := hostList(d, "domain_controllers", "domain_controller"); !reflect.DeepEqual(actual, c.expected) {
+			t.Errorf("expected %v for %v, got %v", c.expected, c.raw, actual)
+		}
+	}
+}
@@ -0,0 +1,603 @@
+package config
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bodgit/ntlmssp"
+	"github.com/jcmturner/gofork/encoding/asn1"
+	"github.com/jcmturner/gokrb5/v8/asn1tools"
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/crypto/etype"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/chksumtype"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/flags"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/types"
+	"github.com/masterzen/winrm"
+	"github.com/masterzen/winrm/soap"
+)
+
+const (
+	encryptedBoundary       = "--Encrypted Boundary"
+	spnegoEncryptedProtocol = "application/HTTP-SPNEGO-session-encrypted"
+	encryptedContentType    = `multipart/encrypted;protocol="` + spnegoEncryptedProtocol + `";boundary="Encrypted Boundary"`
+	soapContentType         = "application/soap+xml;charset=UTF-8"
+	octetStreamHeader       = "\tContent-Type: application/octet-stream\r\n"
+)
+
+// messageSealer seals and unseals the messages exchanged once a security context is established.
+type messageSealer interface {
+	Wrap(message []byte) (sealed, signature []byte, err error)
+	Unwrap(sealed, signature []byte) ([]byte, error)
+}
+
+// securityContext establishes a security context with the server using HTTP Negotiate authentication.
+type securityContext interface {
+	// Step processes the token of the server, nil on the first call, and returns the token to send it.
+	Step(input []byte) ([]byte, error)
+	// Sealer returns the sealer of the context, or nil if the context is not established yet.
+	Sealer() messageSealer
+}
+
+// encryptedTransporter sends WinRM messages sealed with the keys of a Kerberos or NTLM security context
+// in the multipart/encrypted envelope of MS-WSMV, so that they do not cross the network in the clear
+// over HTTP. The security context is bound to the HTTP connection it was established on, so requests
+// are serialized on a single connection and the context is established again when the server closes it.
+type encryptedTransporter struct {
+	newContext func() (securityContext, error)
+	url        string
+	client     *http.Client
+
+	mx     sync.Mutex
+	sealer messageSealer
+}
+
+// newEncryptedTransporter returns a transporter sealing messages with the kerberos client of settings if a
+// realm is set, with NTLM otherwise.
+func newEncryptedTransporter(settings *Settings) *encryptedTransporter {
+	if settings.KrbRealm == "" {
+		return &encryptedTransporter{
+			newContext: func() (securityContext, error) {
+				return newNTLMContext(settings.WinRMUsername, settings.WinRMPassword)
+			},
+		}
+	}
+
+	kerberos := settings.kerberos
+	if kerberos == nil {
+		kerberos = NewKerberosClient(settings)
+	}
+	spn := settings.KrbSpn
+	if spn == "" {
+		spn = "HTTP/" + settings.WinRMHost
+	}
+	return &encryptedTransporter{
+		newContext: func() (securityContext, error) {
+			return &kerberosContext{kerberos: kerberos, spn: spn}, nil
+		},
+	}
+}
+
+func (t *encryptedTransporter) Transport(endpoint *winrm.Endpoint) error {
+	rootCAs, err := caCertPool(endpoint)
+	if err != nil {
+		return err
+	}
+
+	scheme := "http"
+	if endpoint.HTTPS {
+		scheme = "https"
+	}
+	t.url = fmt.Sprintf("%s://%s/wsman", scheme, net.JoinHostPort(endpoint.Host, strconv.Itoa(endpoint.Port)))
+	t.client = &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: endpoint.Insecure,
+				ServerName:         endpoint.TLSServerName,
+				RootCAs:            rootCAs,
+			},
+			DialContext: (&net.Dialer{
+				Timeout:   30 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			ResponseHeaderTimeout: endpoint.Timeout,
+			MaxConnsPerHost:       1,
+			MaxIdleConnsPerHost:   1,
+		},
+	}
+	return nil
+}
+
+func (t *encryptedTransporter) Post(_ *winrm.Client, request *soap.SoapMessage) (string, error) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	message := []byte(request.String())
+	for attempt := 0; ; attempt++ {
+		if t.sealer == nil {
+			if err := t.authenticate(); err != nil {
+				return "", err
+			}
+		}
+
+		status, body, err := t.send(message)
+		if err != nil {
+			// the context cannot be used on another connection
+			t.sealer = nil
+			return "", err
+		}
+		if status == http.StatusUnauthorized && attempt == 0 {
+			log.Printf("[DEBUG] The WinRM server dropped the security context, authenticating again")
+			t.sealer = nil
+			continue
+		}
+		if status != http.StatusOK {
+			return "", fmt.Errorf("http error %d: %s", status, body)
+		}
+		return string(body), nil
+	}
+}
+
+// authenticate establishes a security context on the connection of the transporter.
+func (t *encryptedTransporter) authenticate() error {
+	sc, err := t.newContext()
+	if err != nil {
+		return err
+	}
+	token, err := sc.Step(nil)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < 3; i++ {
+		req, err := http.NewRequest("POST", t.url, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", soapContentType)
+		req.Header.Set("Authorization", "Negotiate "+base64.StdEncoding.EncodeToString(token))
+
+		resp, err := t.client.Do(req)
+		if err != nil {
+			return fmt.Errorf("unknown error %w", err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		input, err := negotiateToken(resp.Header)
+		if err != nil {
+			return err
+		}
+		switch {
+		case resp.StatusCode == http.StatusOK:
+			if input != nil {
+				if _, err := sc.Step(input); err != nil {
+					return err
+				}
+			}
+			if t.sealer = sc.Sealer(); t.sealer == nil {
+				return fmt.Errorf("the WinRM server accepted the authentication without establishing a security context")
+			}
+			return nil
+		case resp.StatusCode == http.StatusUnauthorized && input != nil:
+			if token, err = sc.Step(input); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("http error %d: authentication failed", resp.StatusCode)
+		}
+	}
+	return fmt.Errorf("http error 401: authentication did not complete")
+}
+
+// send posts the sealed message and returns the status and the unsealed body of the response. Successful
+// responses that are not sealed are rejected.
+func (t *encryptedTransporter) send(message []byte) (int, []byte, error) {
+	body, err := sealMessage(t.sealer, message)
+	if err != nil {
+		return 0, nil, err
+	}
+	req, err := http.NewRequest("POST", t.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Content-Type", encryptedContentType)
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("unknown error %w", err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, fmt.Errorf("error while reading request body %w", err)
+	}
+
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "multipart/encrypted") {
+		respBody, err = unsealMessage(t.sealer, respBody)
+		if err != nil {
+			return 0, nil, fmt.Errorf("while decrypting the WinRM response: %s", err)
+		}
+	} else if resp.StatusCode == http.StatusOK {
+		// Only errors are sent in clear, a successful response must be sealed like the request.
+		return 0, nil, fmt.Errorf("the WinRM server answered an encrypted request with an unencrypted response")
+	}
+	return resp.StatusCode, respBody, nil
+}
+
+// negotiateToken returns the token of the Negotiate authentication header, or nil if there is none.
+func negotiateToken(header http.Header) ([]byte, error) {
+	for _, value := range header.Values("WWW-Authenticate") {
+		if strings.HasPrefix(value, "Negotiate ") {
+			token, err := base64.StdEncoding.DecodeString(strings.TrimSpace(strings.TrimPrefix(value, "Negotiate ")))
+			if err != nil {
+				return nil, fmt.Errorf("malformed Negotiate header: %s", err)
+			}
+			return token, nil
+		}
+	}
+	return nil, nil
+}
+
+// sealMessage returns the multipart/encrypted envelope of message.
+func sealMessage(sealer messageSealer, message []byte) ([]byte, error) {
+	sealed, signature, err := sealer.Wrap(message)
+	if err != nil {
+		return nil, fmt.Errorf("while encrypting the WinRM request: %s", err)
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s\r\n\tContent-Type: %s\r\n", encryptedBoundary, spnegoEncryptedProtocol)
+	fmt.Fprintf(&b, "\tOriginalContent: type=%s;Length=%d\r\n", soapContentType, len(message))
+	fmt.Fprintf(&b, "%s\r\n%s", encryptedBoundary, octetStreamHeader)
+	_ = binary.Write(&b, binary.LittleEndian, uint32(len(signature)))
+	b.Write(signature)
+	b.Write(sealed)
+	fmt.Fprintf(&b, "%s--\r\n", encryptedBoundary)
+	return b.Bytes(), nil
+}
+
+// unsealMessage returns the message of a multipart/encrypted envelope. The envelope cannot be parsed with
+// mime/multipart, its headers start with a tab.
+func unsealMessage(sealer messageSealer, body []byte) ([]byte, error) {
+	body = bytes.TrimSuffix(body, []byte(encryptedBoundary+"--\r\n"))
+	var parts [][]byte
+	for _, part := range bytes.Split(body, []byte(encryptedBoundary+"\r\n")) {
+		if len(part) > 0 {
+			parts = append(parts, part)
+		}
+	}
+	if len(parts) == 0 || len(parts)%2 != 0 {
+		return nil, errors.New("malformed multipart/encrypted message")
+	}
+
+	var message []byte
+	for i := 0; i < len(parts); i += 2 {
+		header, payload := parts[i], parts[i+1]
+		idx := bytes.Index(header, []byte("Length="))
+		if idx < 0 {
+			return nil, errors.New("the length of the original content is missing")
+		}
+		length, err := strconv.Atoi(string(bytes.TrimSpace(header[idx+len("Length="):])))
+		if err != nil {
+			return nil, fmt.Errorf("invalid original content length: %s", err)
+		}
+
+		payload = bytes.TrimPrefix(payload, []byte(octetStreamHeader))
+		if len(payload) < 4 {
+			return nil, errors.New("the encrypted payload is truncated")
+		}
+		signatureLength := int(binary.LittleEndian.Uint32(payload[:4]))
+		if len(payload) < 4+signatureLength {
+			return nil, errors.New("the encrypted payload is truncated")
+		}
+		data, err := sealer.Unwrap(payload[4+signatureLength:], payload[4:4+signatureLength])
+		if err != nil {
+			return nil, err
+		}
+		if len(data) != length {
+			return nil, errors.New("the length of the decrypted message does not match its original length")
+		}
+		message = append(message, data...)
+	}
+	return message, nil
+}
+
+// ntlmContext is an NTLM security context.
+type ntlmContext struct {
+	client *ntlmssp.Client
+}
+
+// newNTLMContext returns an NTLM context for username, given as DOMAIN\user, user@domain or user.
+func newNTLMContext(username, password string) (*ntlmContext, error) {
+	user, domain := username, ""
+	if idx := strings.Index(username, "@"); idx >= 0 {
+		user, domain = username[:idx], username[idx+1:]
+	} else if idx := strings.Index(username, `\`); idx >= 0 {
+		domain, user = username[:idx], username[idx+1:]
+	}
+	client, err := ntlmssp.NewClient(ntlmssp.SetUserInfo(user, password), ntlmssp.SetDomain(domain), ntlmssp.SetVersion(ntlmssp.DefaultVersion()))
+	if err != nil {
+		return nil, err
+	}
+	return &ntlmContext{client: client}, nil
+}
+
+func (c *ntlmContext) Step(input []byte) ([]byte, error) {
+	return c.client.Authenticate(input, nil)
+}
+
+func (c *ntlmContext) Sealer() messageSealer {
+	if session := c.client.SecuritySession(); c.client.Complete() && session != nil {
+		return session
+	}
+	return nil
+}
+
+// kerberosContext is a Kerberos security context negotiated through SPNEGO, with mutual authentication
+// so that the server returns the keys of the context.
+type kerberosContext struct {
+	kerberos   *KerberosClient
+	spn        string
+	sessionKey types.EncryptionKey
+	auth       types.Authenticator
+	sealer     *krb5Sealer
+}
+
+func (c *kerberosContext) Step(input []byte) ([]byte, error) {
+	if input == nil {
+		return c.initToken()
+	}
+
+	var token spnego.SPNEGOToken
+	if err := token.Unmarshal(input); err != nil {
+		return nil, fmt.Errorf("while reading the SPNEGO response: %s", err)
+	}
+	if !token.Resp || len(token.NegTokenResp.ResponseToken) == 0 {
+		return nil, fmt.Errorf("the SPNEGO response of the WinRM server does not hold a kerberos token")
+	}
+	var mech spnego.KRB5Token
+	if err := mech.Unmarshal(token.NegTokenResp.ResponseToken); err != nil {
+		return nil, err
+	}
+	if mech.IsKRBError() {
+		return nil, fmt.Errorf("the WinRM server rejected the kerberos ticket: %s", mech.KRBError.Error())
+	}
+	if !mech.IsAPRep() {
+		return nil, fmt.Errorf("the WinRM server did not answer with a kerberos AP-REP")
+	}
+
+	b, err := crypto.DecryptEncPart(mech.APRep.EncPart, c.sessionKey, keyusage.AP_REP_ENCPART)
+	if err != nil {
+		return nil, fmt.Errorf("while decrypting the kerberos AP-REP: %s", err)
+	}
+	var part messages.EncAPRepPart
+	if err := part.Unmarshal(b); err != nil {
+		return nil, err
+	}
+
+	key, acceptorSubkey := c.auth.SubKey, false
+	if len(part.Subkey.KeyValue) > 0 {
+		key, acceptorSubkey = part.Subkey, true
+	}
+	c.sealer, err = newKrb5Sealer(key, true, acceptorSubkey, uint64(c.auth.SeqNumber))
+	return nil, err
+}
+
+// initToken returns the SPNEGO token holding the AP-REQ for the service ticket of the WinRM server.
+func (c *kerberosContext) initToken() ([]byte, error) {
+	cl, err := c.kerberos.Client()
+	if err != nil {
+		return nil, err
+	}
+	tkt, key, err := cl.GetServiceTicket(c.spn)
+	if err != nil {
+		return nil, fmt.Errorf("while getting a service ticket for %s: %s", c.spn, err)
+	}
+	et, err := crypto.GetEtype(key.KeyType)
+	if err != nil {
+		return nil, err
+	}
+
+	auth, err := types.NewAuthenticator(cl.Credentials.Domain(), cl.Credentials.CName())
+	if err != nil {
+		return nil, err
+	}
+	auth.Cksum = types.Checksum{
+		CksumType: chksumtype.GSSAPI,
+		Checksum: gssapiChecksum(gssapi.ContextFlagMutual | gssapi.ContextFlagReplay | gssapi.ContextFlagSequence |
+			gssapi.ContextFlagConf | gssapi.ContextFlagInteg),
+	}
+	if err := auth.GenerateSeqNumberAndSubKey(key.KeyType, et.GetKeyByteSize()); err != nil {
+		return nil, err
+	}
+	apReq, err := messages.NewAPReq(tkt, key, auth)
+	if err != nil {
+		return nil, err
+	}
+	types.SetFlag(&apReq.APOptions, flags.APOptionMutualRequired)
+	c.sessionKey, c.auth = key, auth
+
+	b, err := apReq.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	oid, err := asn1.Marshal(gssapi.OIDKRB5.OID())
+	if err != nil {
+		return nil, err
+	}
+	// GSS-API KRB5 token: the mechanism OID, the AP-REQ token ID and the AP-REQ
+	mechToken := asn1tools.AddASNAppTag(append(append(oid, 0x01, 0x00), b...), 0)
+
+	token := spnego.SPNEGOToken{
+		Init: true,
+		NegTokenInit: spnego.NegTokenInit{
+			MechTypes:      []asn1.ObjectIdentifier{gssapi.OIDKRB5.OID()},
+			MechTokenBytes: mechToken,
+		},
+	}
+	return token.Marshal()
+}
+
+func (c *kerberosContext) Sealer() messageSealer {
+	if c.sealer == nil {
+		return nil
+	}
+	return c.sealer
+}
+
+// gssapiChecksum returns the authenticator checksum of RFC 4121 section 4.1.1 requesting contextFlags.
+func gssapiChecksum(contextFlags uint32) []byte {
+	b := make([]byte, 24)
+	binary.LittleEndian.PutUint32(b[:4], 16)
+	binary.LittleEndian.PutUint32(b[20:24], contextFlags)
+	return b
+}
+
+const (
+	wrapTokenHeaderLength       = 16
+	wrapTokenFlagSentByAcceptor = 0x01
+	wrapTokenFlagSealed         = 0x02
+	wrapTokenFlagAcceptorSubkey = 0x04
+)
+
+// krb5Sealer seals messages in the wrap tokens of RFC 4121, laid out like the Windows Kerberos SSP does
+// for WinRM: the header, the encrypted confounder and the rotated trailer make up the signature, and the
+// sealed message is as long as the message.
+type krb5Sealer struct {
+	key        types.EncryptionKey
+	etype      etype.EType
+	initiator  bool
+	tokenFlags byte
+
+	mx      sync.Mutex
+	sendSeq uint64
+}
+
+// newKrb5Sealer returns a sealer for one side of a security context. Only the AES encryption types are
+// supported, the RC4 ones use a different token format.
+func newKrb5Sealer(key types.EncryptionKey, initiator, acceptorSubkey bool, seq uint64) (*krb5Sealer, error) {
+	switch key.KeyType {
+	case etypeID.AES128_CTS_HMAC_SHA1_96, etypeID.AES256_CTS_HMAC_SHA1_96,
+		etypeID.AES128_CTS_HMAC_SHA256_128, etypeID.AES256_CTS_HMAC_SHA384_192:
+	default:
+		return nil, fmt.Errorf("kerberos message encryption requires an AES session key, got encryption type %d", key.KeyType)
+	}
+	et, err := crypto.GetEtype(key.KeyType)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &krb5Sealer{key: key, etype: et, initiator: initiator, sendSeq: seq}
+	if !initiator {
+		s.tokenFlags |= wrapTokenFlagSentByAcceptor
+	}
+	if acceptorSubkey {
+		s.tokenFlags |= wrapTokenFlagAcceptorSubkey
+	}
+	return s, nil
+}
+
+func (s *krb5Sealer) usages() (seal, unseal uint32) {
+	if s.initiator {
+		return keyusage.GSSAPI_INITIATOR_SEAL, keyusage.GSSAPI_ACCEPTOR_SEAL
+	}
+	return keyusage.GSSAPI_ACCEPTOR_SEAL, keyusage.GSSAPI_INITIATOR_SEAL
+}
+
+func (s *krb5Sealer) Wrap(message []byte) ([]byte, []byte, error) {
+	s.mx.Lock()
+	seq := s.sendSeq
+	s.sendSeq++
+	s.mx.Unlock()
+
+	header := make([]byte, wrapTokenHeaderLength)
+	header[0], header[1] = 0x05, 0x04
+	header[2] = wrapTokenFlagSealed | s.tokenFlags
+	header[3] = 0xff
+	binary.BigEndian.PutUint64(header[8:], seq)
+
+	// The header is encrypted along with the message, with an extra count and a rotation count of zero.
+	plain := make([]byte, 0, len(message)+wrapTokenHeaderLength)
+	plain = append(append(plain, message...), header...)
+	sealUsage, _ := s.usages()
+	_, cipherText, err := s.etype.EncryptMessage(s.key.KeyValue, plain, sealUsage)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The encrypted header and the checksum are rotated to the front of the token.
+	rrc := wrapTokenHeaderLength + s.etype.GetHMACBitLength()/8
+	binary.BigEndian.PutUint16(header[6:8], uint16(rrc))
+	cipherText = rotate(cipherText, rrc)
+
+	signatureLength := s.etype.GetConfounderByteSize() + rrc
+	signature := append(header, cipherText[:signatureLength]...)
+	return cipherText[signatureLength:], signature, nil
+}
+
+func (s *krb5Sealer) Unwrap(sealed, signature []byte) ([]byte, error) {
+	if len(signature) < wrapTokenHeaderLength {
+		return nil, errors.New("the kerberos wrap token is truncated")
+	}
+	header := signature[:wrapTokenHeaderLength]
+	if header[0] != 0x05 || header[1] != 0x04 || header[3] != 0xff {
+		return nil, errors.New("not a kerberos wrap token")
+	}
+	if header[2]&wrapTokenFlagSealed == 0 {
+		return nil, errors.New("the kerberos wrap token is not sealed")
+	}
+	if (header[2]&wrapTokenFlagSentByAcceptor != 0) != s.initiator {
+		return nil, errors.New("the kerberos wrap token was not sent by the peer")
+	}
+	ec := int(binary.BigEndian.Uint16(header[4:6]))
+	rrc := int(binary.BigEndian.Uint16(header[6:8]))
+
+	cipherText := make([]byte, 0, len(signature)-wrapTokenHeaderLength+len(sealed))
+	cipherText = append(append(cipherText, signature[wrapTokenHeaderLength:]...), sealed...)
+	if len(cipherText) == 0 {
+		return nil, errors.New("the kerberos wrap token is truncated")
+	}
+	// Windows includes the extra count in the rotation.
+	cipherText = rotate(cipherText, -(rrc + ec))
+
+	_, unsealUsage := s.usages()
+	plain, err := s.etype.DecryptMessage(s.key.KeyValue, cipherText, unsealUsage)
+	if err != nil {
+		return nil, err
+	}
+	if len(plain) < ec+wrapTokenHeaderLength {
+		return nil, errors.New("the kerberos wrap token is truncated")
+	}
+	encryptedHeader := plain[len(plain)-wrapTokenHeaderLength:]
+	if !bytes.Equal(encryptedHeader[:6], header[:6]) || !bytes.Equal(encryptedHeader[8:], header[8:]) {
+		return nil, errors.New("the header of the kerberos wrap token was tampered with")
+	}
+	return plain[:len(plain)-wrapTokenHeaderLength-ec], nil
+}
+
+// rotate returns a copy of b rotated right by n bytes, or left if n is negative.
+func rotate(b []byte, n int) []byte {
+	n %= len(b)
+	if n < 0 {
+		n += len(b)
+	}
+	return append(append(make([]byte, 0, len(b)), b[len(b)-n:]...), b[:len(b)-n]...)
+}
@@ -0,0 +1,295 @@
+package config
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/types"
+	"github.com/masterzen/winrm"
+	"github.com/masterzen/winrm/soap"
+)
+
+func newTestSealers(t *testing.T, keyType int32, keySize int) (initiator, acceptor *krb5Sealer) {
+	t.Helper()
+	key := types.EncryptionKey{KeyType: keyType, KeyValue: make([]byte, keySize)}
+	if _, err := rand.Read(key.KeyValue); err != nil {
+		t.Fatal(err)
+	}
+	initiator, err := newKrb5Sealer(key, true, true, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acceptor, err = newKrb5Sealer(key, false, true, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return initiator, acceptor
+}
+
+func TestKrb5Sealer(t *testing.T) {
+	message := []byte(`<s:Envelope><s:Body><rsp:Command>Set-ADAccountPassword</rsp:Command></s:Body></s:Envelope>`)
+
+	for name, keyType := range map[string]int32{
+		"aes128-sha1":   etypeID.AES128_CTS_HMAC_SHA1_96,
+		"aes256-sha1":   etypeID.AES256_CTS_HMAC_SHA1_96,
+		"aes256-sha384": etypeID.AES256_CTS_HMAC_SHA384_192,
+	} {
+		t.Run(name, func(t *testing.T) {
+			keySize := 32
+			if keyType == etypeID.AES128_CTS_HMAC_SHA1_96 {
+				keySize = 16
+			}
+			initiator, acceptor := newTestSealers(t, keyType, keySize)
+
+			sealed, signature, err := initiator.Wrap(message)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(sealed) != len(message) {
+				t.Errorf("expected the sealed message to be as long as the message, got %d bytes", len(sealed))
+			}
+			if bytes.Contains(append(signature, sealed...), []byte("Set-ADAccountPassword")) {
+				t.Errorf("the message was not encrypted")
+			}
+			if signature[2] != wrapTokenFlagSealed|wrapTokenFlagAcceptorSubkey {
+				t.Errorf("unexpected token flags %x", signature[2])
+			}
+			unsealed, err := acceptor.Unwrap(sealed, signature)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(unsealed, message) {
+				t.Errorf("expected %q, got %q", message, unsealed)
+			}
+
+			response := []byte("<s:Envelope/>")
+			sealed, signature, err = acceptor.Wrap(response)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if unsealed, err = initiator.Unwrap(sealed, signature); err != nil || !bytes.Equal(unsealed, response) {
+				t.Errorf("expected %q, got %q, %v", response, unsealed, err)
+			}
+
+			if _, err := initiator.Unwrap(sealed[:len(sealed)-1], signature); err == nil {
+				t.Errorf("expected an error for a truncated message")
+			}
+			tampered := append([]byte{}, sealed...)
+			tampered[0] ^= 0xff
+			if _, err := initiator.Unwrap(tampered, signature); err == nil {
+				t.Errorf("expected an error for a tampered message")
+			}
+			sealed, signature, _ = initiator.Wrap(message)
+			if _, err := initiator.Unwrap(sealed, signature); err == nil {
+				t.Errorf("expected an error for a token sent by the same side")
+			}
+		})
+	}
+
+	key := types.EncryptionKey{KeyType: etypeID.RC4_HMAC, KeyValue: make([]byte, 16)}
+	if _, err := newKrb5Sealer(key, true, false, 0); err == nil {
+		t.Errorf("expected an error for an RC4 key")
+	}
+}
+
+func TestSealMessage(t *testing.T) {
+	initiator, acceptor := newTestSealers(t, etypeID.AES256_CTS_HMAC_SHA1_96, 32)
+	message := []byte(strings.Repeat("<s:Envelope/>", 100))
+
+	body, err := sealMessage(initiator, message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := "--Encrypted Boundary\r\n\tContent-Type: application/HTTP-SPNEGO-session-encrypted\r\n" +
+		"\tOriginalContent: type=application/soap+xml;charset=UTF-8;Length=1300\r\n" +
+		"--Encrypted Boundary\r\n\tContent-Type: application/octet-stream\r\n"
+	if !bytes.HasPrefix(body, []byte(header)) || !bytes.HasSuffix(body, []byte("--Encrypted Boundary--\r\n")) {
+		t.Errorf("unexpected envelope %q", body)
+	}
+
+	unsealed, err := unsealMessage(acceptor, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(unsealed, message) {
+		t.Errorf("expected %q, got %q", message, unsealed)
+	}
+
+	for _, malformed := range [][]byte{
+		nil,
+		[]byte("<s:Envelope/>"),
+		bytes.Replace(body, []byte("Length=1300"), []byte("Length=13"), 1),
+		body[:len(header)+2],
+	} {
+		if _, err := unsealMessage(acceptor, malformed); err == nil {
+			t.Errorf("expected an error for %q", malformed)
+		}
+	}
+}
+
+// xorSealer is a sealer for tests, the signature is the length of the message.
+type xorSealer byte
+
+func (s xorSealer) Wrap(message []byte) ([]byte, []byte, error) {
+	sealed := make([]byte, len(message))
+	for i := range message {
+		sealed[i] = message[i] ^ byte(s)
+	}
+	return sealed, []byte(strconv.Itoa(len(message))), nil
+}
+
+func (s xorSealer) Unwrap(sealed, signature []byte) ([]byte, error) {
+	if string(signature) != strconv.Itoa(len(sealed)) {
+		return nil, errors.New("invalid signature")
+	}
+	message, _, _ := s.Wrap(sealed)
+	return message, nil
+}
+
+// fakeContext needs a challenge to be answered before it is established.
+type fakeContext struct {
+	established bool
+}
+
+func (c *fakeContext) Step(input []byte) ([]byte, error) {
+	switch string(input) {
+	case "":
+		return []byte("negotiate"), nil
+	case "challenge":
+		return []byte("authenticate"), nil
+	case "done":
+		c.established = true
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unexpected token %q", input)
+}
+
+func (c *fakeContext) Sealer() messageSealer {
+	if !c.established {
+		return nil
+	}
+	return xorSealer(0x5a)
+}
+
+// fakeEncryptedServer authenticates connections with the tokens of fakeContext and answers the sealed
+// messages of authenticated connections.
+type fakeEncryptedServer struct {
+	mx            sync.Mutex
+	authenticated map[string]bool
+	logins        int
+	status        int
+	// unencrypted makes the server answer in clear.
+	unencrypted bool
+}
+
+func (s *fakeEncryptedServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	body, _ := io.ReadAll(r.Body)
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		token, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(auth, "Negotiate "))
+		switch string(token) {
+		case "negotiate":
+			w.Header().Set("WWW-Authenticate", "Negotiate "+base64.StdEncoding.EncodeToString([]byte("challenge")))
+			w.WriteHeader(http.StatusUnauthorized)
+		case "authenticate":
+			s.authenticated[r.RemoteAddr] = true
+			s.logins++
+			w.Header().Set("WWW-Authenticate", "Negotiate "+base64.StdEncoding.EncodeToString([]byte("done")))
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+		return
+	}
+
+	if !s.authenticated[r.RemoteAddr] || r.Header.Get("Content-Type") != encryptedContentType {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	message, err := unsealMessage(xorSealer(0x5a), body)
+	if err != nil || !bytes.Contains(message, []byte("Envelope")) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if s.unencrypted {
+		w.Header().Set("Content-Type", soapContentType)
+		_, _ = w.Write([]byte("<s:Envelope>response</s:Envelope>"))
+		return
+	}
+	response, _ := sealMessage(xorSealer(0x5a), []byte("<s:Envelope>response</s:Envelope>"))
+	w.Header().Set("Content-Type", encryptedContentType)
+	if s.status != 0 {
+		w.WriteHeader(s.status)
+	}
+	_, _ = w.Write(response)
+}
+
+func TestEncryptedTransporter(t *testing.T) {
+	server := &fakeEncryptedServer{authenticated: map[string]bool{}}
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+
+	transporter := &encryptedTransporter{
+		newContext: func() (securityContext, error) { return &fakeContext{}, nil },
+	}
+	host, port, _ := strings.Cut(strings.TrimPrefix(srv.URL, "http://"), ":")
+	portNumber, _ := strconv.Atoi(port)
+	if err := transporter.Transport(winrm.NewEndpoint(host, portNumber, false, false, nil, nil, nil, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		body, err := transporter.Post(nil, soap.NewMessage())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body != "<s:Envelope>response</s:Envelope>" {
+			t.Errorf("unexpected response %q", body)
+		}
+	}
+	if server.logins != 1 {
+		t.Errorf("expected the security context to be reused, got %d logins", server.logins)
+	}
+
+	// The server dropped the context, it is established again.
+	server.authenticated = map[string]bool{}
+	if _, err := transporter.Post(nil, soap.NewMessage()); err != nil {
+		t.Fatal(err)
+	}
+	if server.logins != 2 {
+		t.Errorf("expected a new login, got %d logins", server.logins)
+	}
+
+	server.status = http.StatusInternalServerError
+	if _, err := transporter.Post(nil, soap.NewMessage()); err == nil || !strings.Contains(err.Error(), "http error 500: <s:Envelope>response") {
+		t.Errorf("expected the decrypted fault of the server, got %v", err)
+	}
+
+	server.status, server.unencrypted = 0, true
+	if _, err := transporter.Post(nil, soap.NewMessage()); err == nil || !strings.Contains(err.Error(), "unencrypted response") {
+		t.Errorf("expected an unencrypted response to be rejected, got %v", err)
+	}
+}
+
+func TestNewWinRMTransporterEncryption(t *testing.T) {
+	settings := &Settings{WinRMHost: "dc1.contoso.com", WinRMProto: "http", WinRMUseNTLM: true, WinRMMessageEncryption: true}
+	if _, ok := newWinRMTransporter(settings).(*encryptedTransporter); !ok {
+		t.Errorf("expected messages to be encrypted over http")
+	}
+	settings.WinRMProto = "https"
+	if _, ok := newWinRMTransporter(settings).(*winrm.ClientNTLM); !ok {
+		t.Errorf("expected messages not to be encrypted again over https")
+	}
+}
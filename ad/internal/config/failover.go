@@ -0,0 +1,230 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// healthCheckTimeout is how long a host has to accept a TCP connection before the next one is tried.
+const healthCheckTimeout = 5 * time.Second
+
+// splitHosts splits a comma separated list of hosts, dropping the blanks.
+func splitHosts(value string) []string {
+	var hosts []string
+	for _, host := range strings.Split(value, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+func firstHost(hosts []string) string {
+	if len(hosts) == 0 {
+		return ""
+	}
+	return hosts[0]
+}
+
+// failoverList picks the host commands are sent to among a list of equivalent hosts. The first host that
+// accepts TCP connections on port is used until it is marked down, the following ones are then checked
+// in order. Lists of a single host are never checked, errors are reported when it is used instead.
+type failoverList struct {
+	name  string
+	hosts []string
+	port  int
+	// discover, if set, is called the first time a host is picked to fill an empty list.
+	discover func(ctx context.Context) ([]string, error)
+	dial     func(ctx context.Context, network, address string) (net.Conn, error)
+
+	mx         sync.Mutex
+	discovered bool
+	current    int
+	selected   bool
+}
+
+func newFailoverList(name string, hosts []string, port int) *failoverList {
+	return &failoverList{
+		name:  name,
+		hosts: hosts,
+		port:  port,
+		dial:  (&net.Dialer{Timeout: healthCheckTimeout}).DialContext,
+	}
+}
+
+// Pick returns the host in use, selecting a reachable one if the previous one was marked down. If none of
+// the hosts is reachable, the first one is returned for the command to fail with a meaningful error.
+// Pick returns an empty string if the list is empty. The lock is not held while discovering or checking
+// the hosts, so that the other callers are not blocked behind the dial timeouts.
+func (l *failoverList) Pick(ctx context.Context) string {
+	l.discoverHosts(ctx)
+
+	l.mx.Lock()
+	switch {
+	case len(l.hosts) == 0:
+		l.mx.Unlock()
+		return ""
+	case len(l.hosts) == 1:
+		defer l.mx.Unlock()
+		return l.hosts[0]
+	case l.selected:
+		defer l.mx.Unlock()
+		return l.hosts[l.current]
+	}
+	hosts, start := l.hosts, l.current
+	l.mx.Unlock()
+
+	selected, found := 0, false
+	for i := range hosts {
+		idx := (start + i) % len(hosts)
+		if err := l.check(ctx, hosts[idx]); err != nil {
+			log.Printf("[WARN] Skipping %s %s: %s", l.name, hosts[idx], err)
+			continue
+		}
+		selected, found = idx, true
+		break
+	}
+	if !found {
+		log.Printf("[WARN] None of the %s is reachable, using %s", l.name, hosts[0])
+	}
+
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	// Another caller may have selected a host while this one was checking them.
+	if l.selected {
+		return l.hosts[l.current]
+	}
+	l.current, l.selected = selected, true
+	log.Printf("[DEBUG] Using %s %s", l.name, hosts[selected])
+	return hosts[selected]
+}
+
+// discoverHosts fills an empty list with the hosts returned by discover, the first time it is called.
+// Discovery runs without the lock, the result of the first caller to complete it is kept.
+func (l *failoverList) discoverHosts(ctx context.Context) {
+	l.mx.Lock()
+	needed := !l.discovered && l.discover != nil && len(l.hosts) == 0
+	l.mx.Unlock()
+	if !needed {
+		return
+	}
+
+	hosts, err := l.discover(ctx)
+	if err != nil {
+		log.Printf("[WARN] Discovery of %s failed: %s", l.name, err)
+		return
+	}
+
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	if l.discovered {
+		return
+	}
+	log.Printf("[DEBUG] Discovered %s: %s", l.name, strings.Join(hosts, ", "))
+	l.hosts, l.discovered = hosts, true
+}
+
+// Current returns the host in use without checking it. It returns an empty string if no host was picked
+// yet.
+func (l *failoverList) Current() string {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	if len(l.hosts) == 0 {
+		return ""
+	}
+	return l.hosts[l.current]
+}
+
+// MarkDown reports that host could not be reached. The next call to Pick selects another host, starting
+// with the one following host in the list. Hosts that are not in use are ignored, so that concurrent
+// failures on the same host only fail over once.
+func (l *failoverList) MarkDown(host string) bool {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	if len(l.hosts) < 2 || !l.selected || l.hosts[l.current] != host {
+		return false
+	}
+	log.Printf("[WARN] Marking %s %s as down", l.name, host)
+	l.current = (l.current + 1) % len(l.hosts)
+	l.selected = false
+	return true
+}
+
+func (l *failoverList) check(ctx context.Context, host string) error {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	conn, err := l.dial(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(l.port)))
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// IsDialError reports whether err happened while connecting to a host, in which case the request was not
+// sent. Some clients only keep the text of the errors, so it is checked as well.
+func IsDialError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	return strings.Contains(err.Error(), "dial tcp")
+}
+
+// SRVResolver looks up SRV records. *net.Resolver implements it.
+type SRVResolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// DiscoverDomainControllers returns the domain controllers of realm advertised in DNS, in the order of
+// the priority and weight of their SRV records. When site is set, the domain controllers of the site come
+// first, followed by the other ones.
+func DiscoverDomainControllers(ctx context.Context, resolver SRVResolver, realm, site string) ([]string, error) {
+	if realm == "" {
+		return nil, fmt.Errorf("a kerberos realm is required to discover the domain controllers")
+	}
+	realm = strings.ToLower(realm)
+
+	names := []string{fmt.Sprintf("dc._msdcs.%s", realm)}
+	if site != "" {
+		names = append([]string{fmt.Sprintf("%s._sites.dc._msdcs.%s", site, realm)}, names...)
+	}
+
+	var hosts []string
+	seen := map[string]bool{}
+	var lastErr error
+	for _, name := range names {
+		_, records, err := resolver.LookupSRV(ctx, "ldap", "tcp", name)
+		if err != nil {
+			log.Printf("[DEBUG] SRV lookup of _ldap._tcp.%s failed: %s", name, err)
+			lastErr = err
+			continue
+		}
+		for _, record := range records {
+			host := strings.TrimSuffix(record.Target, ".")
+			if host == "" || seen[strings.ToLower(host)] {
+				continue
+			}
+			seen[strings.ToLower(host)] = true
+			hosts = append(hosts, host)
+		}
+	}
+	if len(hosts) == 0 {
+		if lastErr != nil {
+			return nil, fmt.Errorf("while looking up the domain controllers of %s: %s", realm, lastErr)
+		}
+		return nil, fmt.Errorf("no domain controller is advertised for %s", realm)
+	}
+	return hosts, nil
+}
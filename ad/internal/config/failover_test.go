@@ -0,0 +1,322 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// fakeDNSServer answers SRV queries from records, and with NXDOMAIN for the other names.
+type fakeDNSServer struct {
+	conn    net.PacketConn
+	records map[string][]net.SRV
+	mx      sync.Mutex
+	queries []string
+}
+
+func newFakeDNSServer(t *testing.T, records map[string][]net.SRV) *fakeDNSServer {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeDNSServer{conn: conn, records: records}
+	t.Cleanup(func() { _ = conn.Close() })
+	go s.serve()
+	return s
+}
+
+// resolver returns a resolver that sends all its queries to the fake server.
+func (s *fakeDNSServer) resolver() *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "udp", s.conn.LocalAddr().String())
+		},
+	}
+}
+
+func (s *fakeDNSServer) serve() {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if resp, err := s.answer(buf[:n]); err == nil {
+			_, _ = s.conn.WriteTo(resp, addr)
+		}
+	}
+}
+
+func (s *fakeDNSServer) answer(query []byte) ([]byte, error) {
+	var p dnsmessage.Parser
+	header, err := p.Start(query)
+	if err != nil {
+		return nil, err
+	}
+	question, err := p.Question()
+	if err != nil {
+		return nil, err
+	}
+	name := strings.TrimSuffix(strings.ToLower(question.Name.String()), ".")
+	s.mx.Lock()
+	s.queries = append(s.queries, name)
+	s.mx.Unlock()
+
+	records, ok := s.records[name]
+	rcode := dnsmessage.RCodeSuccess
+	if !ok || question.Type != dnsmessage.TypeSRV {
+		rcode = dnsmessage.RCodeNameError
+	}
+
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: header.ID, Response: true, Authoritative: true, RecursionDesired: header.RecursionDesired, RCode: rcode})
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(question); err != nil {
+		return nil, err
+	}
+	if err := b.StartAnswers(); err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		target, err := dnsmessage.NewName(record.Target)
+		if err != nil {
+			return nil, err
+		}
+		err = b.SRVResource(
+			dnsmessage.ResourceHeader{Name: question.Name, Class: dnsmessage.ClassINET, TTL: 600},
+			dnsmessage.SRVResource{Priority: record.Priority, Weight: record.Weight, Port: record.Port, Target: target},
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b.Finish()
+}
+
+// fakeDialer accepts connections to the hosts in up only.
+type fakeDialer struct {
+	mx     sync.Mutex
+	up     map[string]bool
+	dialed []string
+}
+
+func (d *fakeDialer) dial(_ context.Context, _, address string) (net.Conn, error) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	d.dialed = append(d.dialed, address)
+	host, _, _ := net.SplitHostPort(address)
+	if !d.up[host] {
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	}
+	client, server := net.Pipe()
+	_ = server.Close()
+	return client, nil
+}
+
+func TestSplitHosts(t *testing.T) {
+	cases := map[string][]string{
+		"":                       nil,
+		"dc1.contoso.com":        {"dc1.contoso.com"},
+		" dc1 , dc2,,dc3 ":       {"dc1", "dc2", "dc3"},
+		"dc1.contoso.com:5986, ": {"dc1.contoso.com:5986"},
+	}
+	for value, expected := range cases {
+		if actual := splitHosts(value); !reflect.DeepEqual(actual, expected) {
+			t.Errorf("%q: expected %v, got %v", value, expected, actual)
+		}
+	}
+}
+
+func TestFailoverList(t *testing.T) {
+	ctx := context.Background()
+	dialer := &fakeDialer{up: map[string]bool{"dc2": true, "dc3": true}}
+	l := newFailoverList("domain controllers", []string{"dc1", "dc2", "dc3"}, 389)
+	l.dial = dialer.dial
+
+	if host := l.Pick(ctx); host != "dc2" {
+		t.Fatalf("expected dc2 to be picked, got %s", host)
+	}
+	if host := l.Pick(ctx); host != "dc2" || len(dialer.dialed) != 2 {
+		t.Fatalf("expected dc2 to be picked without checking it again, got %s after %v", host, dialer.dialed)
+	}
+	if !reflect.DeepEqual(dialer.dialed, []string{"dc1:389", "dc2:389"}) {
+		t.Errorf("unexpected health checks %v", dialer.dialed)
+	}
+
+	if l.MarkDown("dc1") {
+		t.Errorf("a host that is not in use should not fail over")
+	}
+	if !l.MarkDown("dc2") {
+		t.Errorf("the host in use should fail over")
+	}
+	if l.MarkDown("dc2") {
+		t.Errorf("concurrent failures should only fail over once")
+	}
+	if host := l.Pick(ctx); host != "dc3" {
+		t.Errorf("expected dc3 to be picked, got %s", host)
+	}
+
+	dialer.up = map[string]bool{}
+	l.MarkDown("dc3")
+	if host := l.Pick(ctx); host != "dc1" {
+		t.Errorf("expected the first host when none is reachable, got %s", host)
+	}
+
+	single := newFailoverList("WinRM hosts", []string{"dc1"}, 5985)
+	single.dial = dialer.dial
+	dialer.dialed = nil
+	if host := single.Pick(ctx); host != "dc1" || len(dialer.dialed) != 0 {
+		t.Errorf("a single host should be used without checking it, got %s after %v", host, dialer.dialed)
+	}
+	if single.MarkDown("dc1") {
+		t.Errorf("a single host cannot fail over")
+	}
+
+	if host := newFailoverList("domain controllers", nil, 389).Pick(ctx); host != "" {
+		t.Errorf("expected no host, got %s", host)
+	}
+}
+
+func TestFailoverListPickUnlocked(t *testing.T) {
+	dialer := &fakeDialer{up: map[string]bool{"dc2": true}}
+	l := newFailoverList("domain controllers", []string{"dc1", "dc2"}, 389)
+	// The other callers must not be blocked while the hosts are checked.
+	l.dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+		l.Current()
+		l.MarkDown("dc1")
+		return dialer.dial(ctx, network, address)
+	}
+	if host := l.Pick(context.Background()); host != "dc2" {
+		t.Errorf("expected dc2 to be picked, got %s", host)
+	}
+}
+
+func TestIsDialError(t *testing.T) {
+	cases := []struct {
+		err      error
+		expected bool
+	}{
+		{nil, false},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, true},
+		{fmt.Errorf("while connecting: %w", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("i/o timeout")}), true},
+		{errors.New(`unknown error Post "http://dc1:5985/wsman": dial tcp 10.0.0.1:5985: connect: connection refused`), true},
+		{&net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}, false},
+		{errors.New("http error 401: unauthorized"), false},
+	}
+	for _, c := range cases {
+		if actual := IsDialError(c.err); actual != c.expected {
+			t.Errorf("%v: expected %t, got %t", c.err, c.expected, actual)
+		}
+	}
+}
+
+func TestDiscoverDomainControllers(t *testing.T) {
+	server := newFakeDNSServer(t, map[string][]net.SRV{
+		"_ldap._tcp.dc._msdcs.contoso.com": {
+			{Target: "dc3.contoso.com.", Port: 389, Priority: 10, Weight: 100},
+			{Target: "dc1.contoso.com.", Port: 389, Priority: 0, Weight: 100},
+			{Target: "dc2.contoso.com.", Port: 389, Priority: 5, Weight: 100},
+		},
+		"_ldap._tcp.paris._sites.dc._msdcs.contoso.com": {
+			{Target: "dc2.contoso.com.", Port: 389, Priority: 0, Weight: 100},
+		},
+	})
+	ctx := context.Background()
+
+	hosts, err := DiscoverDomainControllers(ctx, server.resolver(), "CONTOSO.COM", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"dc1.contoso.com", "dc2.contoso.com", "dc3.contoso.com"}; !reflect.DeepEqual(hosts, expected) {
+		t.Errorf("expected %v, got %v", expected, hosts)
+	}
+
+	hosts, err = DiscoverDomainControllers(ctx, server.resolver(), "contoso.com", "paris")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"dc2.contoso.com", "dc1.contoso.com", "dc3.contoso.com"}; !reflect.DeepEqual(hosts, expected) {
+		t.Errorf("expected the domain controllers of the site first, got %v", hosts)
+	}
+
+	// Unknown sites fall back to all the domain controllers of the domain.
+	hosts, err = DiscoverDomainControllers(ctx, server.resolver(), "contoso.com", "london")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != 3 {
+		t.Errorf("expected the domain controllers of the domain, got %v", hosts)
+	}
+
+	if _, err := DiscoverDomainControllers(ctx, server.resolver(), "fabrikam.com", ""); err == nil {
+		t.Errorf("expected an error for a domain without domain controllers")
+	}
+	if _, err := DiscoverDomainControllers(ctx, server.resolver(), "", ""); err == nil {
+		t.Errorf("expected an error without a realm")
+	}
+}
+
+func TestProviderConfDomainControllerDiscovery(t *testing.T) {
+	server := newFakeDNSServer(t, map[string][]net.SRV{
+		"_ldap._tcp.dc._msdcs.contoso.com": {
+			{Target: "dc1.contoso.com.", Port: 389, Priority: 0, Weight: 100},
+			{Target: "dc2.contoso.com.", Port: 389, Priority: 10, Weight: 100},
+		},
+	})
+	dialer := &fakeDialer{up: map[string]bool{"dc2.contoso.com": true}}
+
+	pcfg := NewProviderConf(&Settings{
+		WinRMHost:   "dc1.contoso.com",
+		KrbRealm:    "contoso.com",
+		DomainName:  "contoso.com",
+		LDAPProto:   "ldaps",
+		Backend:     BackendLDAP,
+		DCDiscovery: true,
+	})
+	pcfg.resolver = server.resolver()
+	pcfg.pingNetlogon = func(context.Context, string, string) (string, error) {
+		return "", errors.New("no answer")
+	}
+	pcfg.domainControllers.dial = dialer.dial
+
+	ctx := context.Background()
+	if dc := pcfg.IdentifyDomainController(ctx); dc != "dc2.contoso.com" {
+		t.Errorf("expected the reachable domain controller, got %s", dc)
+	}
+	if !reflect.DeepEqual(dialer.dialed, []string{"dc1.contoso.com:636", "dc2.contoso.com:636"}) {
+		t.Errorf("unexpected health checks %v", dialer.dialed)
+	}
+
+	dialer.up["dc1.contoso.com"] = true
+	if !pcfg.MarkDomainControllerDown("dc2.contoso.com") {
+		t.Fatalf("expected a failover")
+	}
+	if dc := pcfg.IdentifyDomainController(ctx); dc != "dc1.contoso.com" {
+		t.Errorf("expected a failover to dc1, got %s", dc)
+	}
+	if len(server.queries) != 1 {
+		t.Errorf("expected a single discovery, got queries for %v", server.queries)
+	}
+
+	winrmBackend := NewProviderConf(&Settings{DomainControllers: []string{"dc1.contoso.com", "dc2.contoso.com"}, LDAPProto: "ldaps"})
+	if port := winrmBackend.domainControllers.port; port != 9389 {
+		t.Errorf("expected the domain controllers to be checked on the ADWS port with the WinRM backend, got %d", port)
+	}
+
+	noDiscovery := NewProviderConf(&Settings{WinRMHost: "dc1.contoso.com", DomainName: "contoso.com"})
+	if dc := noDiscovery.IdentifyDomainController(ctx); dc != "contoso.com" {
+		t.Errorf("expected the domain name without discovery, got %s", dc)
+	}
+}
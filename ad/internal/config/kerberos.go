@@ -0,0 +1,146 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jcmturner/gokrb5/v8/client"
+	"github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/credentials"
+	"github.com/jcmturner/gokrb5/v8/iana/nametype"
+	"github.com/jcmturner/gokrb5/v8/types"
+)
+
+const (
+	// ccacheReloadMargin is how long before the tickets of a credentials cache expire the cache is read
+	// again, to pick up the tickets of a new kinit.
+	ccacheReloadMargin = 5 * time.Minute
+	// ccacheReloadInterval limits how often an expiring credentials cache is read again.
+	ccacheReloadInterval = time.Minute
+)
+
+// KerberosClient is the kerberos client shared by the WinRM, winrmcp and LDAP connections of a
+// ProviderConf. The TGT and the service tickets are kept across requests, so that the KDC is only
+// contacted when they expire. TGTs obtained with a password or a keytab are renewed before they expire.
+// TGTs read from a credentials cache are renewed while they are renewable, and the cache is read again
+// when they are about to expire.
+type KerberosClient struct {
+	settings *Settings
+	mx       sync.Mutex
+	client   *client.Client
+	// expiry is when the tickets read from the credentials cache cannot be renewed anymore. It is zero
+	// for clients that can log in again.
+	expiry time.Time
+	loaded time.Time
+}
+
+// NewKerberosClient returns a kerberos client for settings. It logs in the first time it is used.
+func NewKerberosClient(settings *Settings) *KerberosClient {
+	return &KerberosClient{settings: settings}
+}
+
+// Client returns the kerberos client, logging in or reading the credentials cache if needed.
+func (k *KerberosClient) Client() (*client.Client, error) {
+	k.mx.Lock()
+	defer k.mx.Unlock()
+
+	if k.client != nil && (k.expiry.IsZero() || time.Until(k.expiry) > ccacheReloadMargin || time.Since(k.loaded) < ccacheReloadInterval) {
+		return k.client, nil
+	}
+
+	cfg, err := getKrb5Config(k.settings.KrbConfig, k.settings.KrbRealm, kdcHosts(k.settings)...)
+	if err != nil {
+		return nil, err
+	}
+
+	if k.settings.KrbCCache == "" {
+		cl, err := getKerberosClient(k.settings.WinRMUsername, k.settings.WinRMPassword, k.settings.KrbRealm, k.settings.KrbKeytab, cfg)
+		if err != nil {
+			return nil, err
+		}
+		if err := cl.Login(); err != nil {
+			return nil, fmt.Errorf("while logging in to the %s realm: %s", k.settings.KrbRealm, err)
+		}
+		k.client = cl
+		return cl, nil
+	}
+
+	cl, expiry, err := loadCCacheClient(k.settings.KrbCCache, cfg)
+	k.loaded = time.Now()
+	switch {
+	case err != nil && k.client != nil:
+		log.Printf("[WARN] Keeping the current kerberos tickets, the credentials cache could not be read: %s", err)
+		return k.client, nil
+	case err != nil:
+		return nil, err
+	case k.client != nil && !expiry.After(k.expiry):
+		log.Printf("[WARN] The kerberos tickets of %s expire at %s, run kinit to renew them", k.settings.KrbCCache, k.expiry)
+		return k.client, nil
+	case !time.Now().Before(expiry):
+		return nil, fmt.Errorf("the kerberos tickets of %s expired at %s, run kinit to renew them", k.settings.KrbCCache, expiry)
+	}
+
+	if k.client != nil {
+		k.client.Destroy()
+	}
+	k.client, k.expiry = cl, expiry
+	return cl, nil
+}
+
+// ccachePath strips the type prefix of a credentials cache name, only file caches are supported.
+func ccachePath(name string) string {
+	return strings.TrimPrefix(name, "FILE:")
+}
+
+// loadCCache reads a credentials cache file.
+func loadCCache(name string) (*credentials.CCache, error) {
+	cc, err := credentials.LoadCCache(ccachePath(name))
+	if err != nil {
+		return nil, fmt.Errorf("while reading the kerberos credentials cache %s: %s", name, err)
+	}
+	return cc, nil
+}
+
+// loadCCacheClient returns a client using the tickets of the credentials cache name, along with the
+// time its TGT cannot be renewed anymore.
+func loadCCacheClient(name string, cfg *config.Config) (*client.Client, time.Time, error) {
+	cc, err := loadCCache(name)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	tgt, ok := cc.GetEntry(types.PrincipalName{
+		NameType:   nametype.KRB_NT_SRV_INST,
+		NameString: []string{"krbtgt", cc.GetClientRealm()},
+	})
+	if !ok {
+		return nil, time.Time{}, fmt.Errorf("the kerberos credentials cache %s does not hold a TGT for %s", name, cc.GetClientRealm())
+	}
+	expiry := tgt.EndTime
+	if tgt.RenewTill.After(expiry) {
+		expiry = tgt.RenewTill
+	}
+
+	cl, err := client.NewFromCCache(cc, cfg, client.DisablePAFXFAST(true))
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("while loading the kerberos credentials cache %s: %s", name, err)
+	}
+	return cl, expiry, nil
+}
+
+// kdcHosts returns the hosts used as KDCs when no kerberos configuration file is given: the WinRM hosts,
+// which usually are domain controllers, and the domain controllers.
+func kdcHosts(settings *Settings) []string {
+	var hosts []string
+	seen := map[string]bool{}
+	candidates := append(append([]string{}, winRMHosts(settings)...), domainControllers(settings)...)
+	for _, host := range candidates {
+		if !seen[strings.ToLower(host)] {
+			seen[strings.ToLower(host)] = true
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
@@ -0,0 +1,158 @@
+package config
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jcmturner/gokrb5/v8/iana/nametype"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/types"
+)
+
+// writeCCache writes a version 4 credentials cache holding a TGT for admin@realm.
+func writeCCache(t *testing.T, path, realm string, endTime, renewTill time.Time) {
+	t.Helper()
+	ticket, err := (&messages.Ticket{
+		TktVNO: 5,
+		Realm:  realm,
+		SName:  types.NewPrincipalName(nametype.KRB_NT_SRV_INST, "krbtgt/"+realm),
+		EncPart: types.EncryptedData{
+			EType:  18,
+			KVNO:   2,
+			Cipher: []byte("not a real ticket"),
+		},
+	}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	write := func(v interface{}) {
+		if err := binary.Write(&b, binary.BigEndian, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeData := func(data []byte) {
+		write(uint32(len(data)))
+		b.Write(data)
+	}
+	writePrincipal := func(nameType int32, realm string, components ...string) {
+		write(nameType)
+		write(uint32(len(components)))
+		writeData([]byte(realm))
+		for _, component := range components {
+			writeData([]byte(component))
+		}
+	}
+
+	b.Write([]byte{5, 4})
+	write(uint16(0))
+	writePrincipal(nametype.KRB_NT_PRINCIPAL, realm, "admin")
+
+	writePrincipal(nametype.KRB_NT_PRINCIPAL, realm, "admin")
+	writePrincipal(nametype.KRB_NT_SRV_INST, realm, "krbtgt", realm)
+	write(uint16(18))
+	writeData(bytes.Repeat([]byte{1}, 32))
+	now := time.Now()
+	for _, ts := range []time.Time{now, now, endTime, renewTill} {
+		write(uint32(ts.Unix()))
+	}
+	write(uint8(0))
+	write(uint32(0))
+	write(uint32(0))
+	write(uint32(0))
+	writeData(ticket)
+	writeData(nil)
+
+	if err := os.WriteFile(path, b.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadCCacheClient(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "krb5cc")
+	endTime := time.Now().Add(10 * time.Hour).Truncate(time.Second)
+	renewTill := time.Now().Add(7 * 24 * time.Hour).Truncate(time.Second)
+	writeCCache(t, path, "CONTOSO.COM", endTime, renewTill)
+
+	cfg, err := getKrb5Config("", "CONTOSO.COM", "dc1.contoso.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl, expiry, err := loadCCacheClient("FILE:"+path, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !expiry.Equal(renewTill) {
+		t.Errorf("expected the tickets to expire at the end of their renewal, %s, got %s", renewTill, expiry)
+	}
+	if cl.Credentials.Domain() != "CONTOSO.COM" || cl.Credentials.UserName() != "admin" {
+		t.Errorf("unexpected credentials %s@%s", cl.Credentials.UserName(), cl.Credentials.Domain())
+	}
+
+	if _, _, err := loadCCacheClient(filepath.Join(t.TempDir(), "missing"), cfg); err == nil {
+		t.Errorf("expected an error for a missing credentials cache")
+	}
+}
+
+func TestKerberosClientCCacheReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "krb5cc")
+	expiring := time.Now().Add(time.Minute)
+	writeCCache(t, path, "CONTOSO.COM", expiring, expiring)
+
+	k := NewKerberosClient(&Settings{WinRMHost: "dc1.contoso.com", KrbRealm: "CONTOSO.COM", KrbCCache: path})
+	first, err := k.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second, err := k.Client(); err != nil || second != first {
+		t.Errorf("expected the client to be reused, got %v, %v", second, err)
+	}
+
+	// kinit ran again, the cache is read once the reload interval elapsed.
+	renewed := time.Now().Add(10 * time.Hour).Truncate(time.Second)
+	writeCCache(t, path, "CONTOSO.COM", renewed, renewed)
+	if cl, _ := k.Client(); cl != first {
+		t.Errorf("the credentials cache should not be read again before the reload interval")
+	}
+	k.loaded = k.loaded.Add(-ccacheReloadInterval)
+	reloaded, err := k.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded == first || !k.expiry.Equal(renewed) {
+		t.Errorf("expected the renewed tickets to be used, expiry %s", k.expiry)
+	}
+
+	expired := time.Now().Add(-time.Hour)
+	writeCCache(t, path, "CONTOSO.COM", expired, expired)
+	if _, err := NewKerberosClient(&Settings{KrbRealm: "CONTOSO.COM", KrbCCache: path}).Client(); err == nil || !strings.Contains(err.Error(), "kinit") {
+		t.Errorf("expected an error about the expired tickets, got %v", err)
+	}
+}
+
+func TestGetKrb5ConfigKDCs(t *testing.T) {
+	settings := &Settings{
+		WinRMHost:        "dc1.contoso.com",
+		WinRMHosts:       []string{"dc1.contoso.com", "dc2.contoso.com"},
+		DomainController: "DC2.contoso.com",
+	}
+	hosts := kdcHosts(settings)
+	if expected := []string{"dc1.contoso.com", "dc2.contoso.com"}; !reflect.DeepEqual(hosts, expected) {
+		t.Errorf("expected %v, got %v", expected, hosts)
+	}
+
+	cfg, err := getKrb5Config("", "CONTOSO.COM", hosts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"dc1.contoso.com:88", "dc2.contoso.com:88"}; !reflect.DeepEqual(cfg.Realms[0].KDC, expected) {
+		t.Errorf("expected the KDCs %v, got %v", expected, cfg.Realms[0].KDC)
+	}
+}
@@ -0,0 +1,149 @@
+package config
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/go-ldap/ldap/v3/gssapi"
+)
+
+const (
+	// BackendWinRM runs every operation as a powershell command over WinRM.
+	BackendWinRM = "winrm"
+	// BackendLDAP talks to the domain controller directly over LDAP for the object types that support it.
+	BackendLDAP = "ldap"
+)
+
+// adwsPort is the port of the Active Directory Web Services, which the ActiveDirectory module cmdlets
+// connect to.
+const adwsPort = 9389
+
+// LDAPClient wraps a bound LDAP connection along with the default naming context
+// of the domain it is connected to.
+type LDAPClient struct {
+	*ldap.Conn
+	BaseDN string
+	// stop stops closing the connection when the context it was acquired with is done.
+	stop func() bool
+}
+
+// ldapHost returns the host we should open LDAP connections to. A specific domain controller
+// takes precedence over the WinRM host.
+func ldapHost(settings *Settings) string {
+	if settings.DomainController != "" {
+		return settings.DomainController
+	}
+	return settings.WinRMHost
+}
+
+// ldapPort returns the configured LDAP port or the default port for the configured protocol.
+func ldapPort(settings *Settings) int {
+	if settings.LDAPPort != 0 {
+		return settings.LDAPPort
+	}
+	if strings.ToLower(settings.LDAPProto) == "ldaps" {
+		return 636
+	}
+	return 389
+}
+
+// GetLDAPConnection returns an LDAP connection to the domain controller. The connection is bound
+// using Kerberos (SASL GSSAPI) if a kerberos realm is configured, otherwise a simple bind is used.
+// The connection is closed if ctx is done before it is bound.
+func GetLDAPConnection(ctx context.Context, settings *Settings) (*LDAPClient, error) {
+	host := ldapHost(settings)
+	proto := strings.ToLower(settings.LDAPProto)
+	addr := net.JoinHostPort(host, strconv.Itoa(ldapPort(settings)))
+	ldapURL := fmt.Sprintf("%s://%s", proto, addr)
+
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: settings.LDAPInsecure,
+		ServerName:         host,
+	}
+
+	log.Printf("[DEBUG] Opening LDAP connection to %s", ldapURL)
+	var netConn net.Conn
+	var err error
+	if proto == "ldaps" {
+		netConn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		netConn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("while connecting to %s: %w", ldapURL, err)
+	}
+	conn := ldap.NewConn(netConn, proto == "ldaps")
+	conn.Start()
+
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	baseDN, err := bindLDAPConnection(conn, settings, host, ldapURL)
+	if !stop() {
+		err = ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &LDAPClient{Conn: conn, BaseDN: baseDN}, nil
+}
+
+// bindLDAPConnection binds conn and returns the default naming context of the domain.
+func bindLDAPConnection(conn *ldap.Conn, settings *Settings, host, ldapURL string) (string, error) {
+	if settings.KrbRealm != "" {
+		kerberos := settings.kerberos
+		if kerberos == nil {
+			kerberos = NewKerberosClient(settings)
+		}
+		kerberosClient, err := kerberos.Client()
+		if err != nil {
+			return "", err
+		}
+		if err := conn.GSSAPIBind(&gssapi.Client{Client: kerberosClient}, fmt.Sprintf("ldap/%s", host), ""); err != nil {
+			return "", fmt.Errorf("while binding to %s using kerberos: %s", ldapURL, err)
+		}
+	} else if err := conn.Bind(settings.WinRMUsername, settings.WinRMPassword); err != nil {
+		return "", fmt.Errorf("while binding to %s: %s", ldapURL, err)
+	}
+	return getDefaultNamingContext(conn)
+}
+
+// watch closes the connection when ctx is done, for the operations in progress to return.
+func (c *LDAPClient) watch(ctx context.Context) {
+	c.stop = context.AfterFunc(ctx, func() { _ = c.Close() })
+}
+
+// unwatch stops watching the context the connection was acquired with. It returns false if the
+// connection was closed because the context was done.
+func (c *LDAPClient) unwatch() bool {
+	if c.stop == nil {
+		return true
+	}
+	stopped := c.stop()
+	c.stop = nil
+	return stopped
+}
+
+// getDefaultNamingContext reads the DN of the domain from the server's RootDSE.
+func getDefaultNamingContext(conn *ldap.Conn) (string, error) {
+	req := ldap.NewSearchRequest("", ldap.ScopeBaseObject, ldap.NeverDerefAliases, 1, 0, false,
+		"(objectClass=*)", []string{"defaultNamingContext"}, nil)
+	res, err := conn.Search(req)
+	if err != nil {
+		return "", fmt.Errorf("while reading RootDSE: %s", err)
+	}
+	if len(res.Entries) == 0 || res.Entries[0].GetAttributeValue("defaultNamingContext") == "" {
+		return "", fmt.Errorf("RootDSE did not return a default naming context")
+	}
+	return res.Entries[0].GetAttributeValue("defaultNamingContext"), nil
+}
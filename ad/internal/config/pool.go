@@ -0,0 +1,129 @@
+package config
+
+import (
+	"context"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// clientIdleTimeout is how long a client can stay in a pool before it is evicted. It is shorter than the
+// default idle timeout of WinRM shells, so that evicted clients are closed before the server drops them.
+const clientIdleTimeout = 5 * time.Minute
+
+// newSlots returns a semaphore of n slots, or nil for no limit if n is not positive.
+func newSlots(n int) chan struct{} {
+	if n <= 0 {
+		return nil
+	}
+	return make(chan struct{}, n)
+}
+
+// acquireSlot takes one of slots, waiting for one to be released if all of them are taken. It returns the
+// error of ctx if it is done first.
+func acquireSlot(ctx context.Context, slots chan struct{}) error {
+	if slots == nil {
+		return nil
+	}
+	select {
+	case slots <- struct{}{}:
+		return nil
+	default:
+	}
+	log.Printf("[DEBUG] Waiting for one of the %d concurrent operations to complete", cap(slots))
+	select {
+	case slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// releaseSlot releases a slot taken with acquireSlot.
+func releaseSlot(slots chan struct{}) {
+	if slots != nil {
+		<-slots
+	}
+}
+
+// popClient returns the client of pool released last, and the pool without it. Reusing the most recent
+// clients leaves the other ones idle, for them to be evicted once they are not needed anymore.
+func popClient[T any](pool []T) (T, []T) {
+	n := len(pool) - 1
+	return pool[n], pool[:n]
+}
+
+// evictIdle closes the clients of pool that were idle for longer than clientIdleTimeout, and returns the
+// other ones. Clients are pooled in the order they are released, so the idle ones come first. closeFunc
+// may be nil for clients holding no connection. It must be called with mx held.
+func evictIdle[T comparable](pcfg *ProviderConf, pool []T, closeFunc func(T)) []T {
+	n := 0
+	for n < len(pool) && time.Since(pcfg.clientReleased[pool[n]]) > clientIdleTimeout {
+		pcfg.forget(pool[n])
+		if closeFunc != nil {
+			closeFunc(pool[n])
+		}
+		n++
+	}
+	if n > 0 {
+		log.Printf("[DEBUG] Evicted %d idle clients", n)
+	}
+	return pool[n:]
+}
+
+// closeClient closes client, ignoring the error. Evicted clients are not used anymore.
+func closeClient[T io.Closer](client T) {
+	_ = client.Close()
+}
+
+// discard closes a client that failed instead of returning it to its pool, and releases its slot.
+func (pcfg *ProviderConf) discard(client io.Closer, slots chan struct{}) {
+	pcfg.mx.Lock()
+	pcfg.forget(client)
+	pcfg.mx.Unlock()
+	_ = client.Close()
+	releaseSlot(slots)
+}
+
+// Close closes the idle clients of the pool of pcfg, for the powershell processes, shells and connections
+// they hold not to outlive the provider. Clients in use are closed as they are released, as they are not
+// pooled anymore then.
+func (pcfg *ProviderConf) Close() {
+	pcfg.mx.Lock()
+	var clients []io.Closer
+	for _, session := range pcfg.psSessions {
+		clients = append(clients, session)
+	}
+	for _, client := range pcfg.psrpClients {
+		clients = append(clients, client)
+	}
+	for _, client := range pcfg.sshClients {
+		clients = append(clients, client)
+	}
+	for _, pool := range pcfg.ldapClients {
+		for _, client := range pool {
+			clients = append(clients, client)
+		}
+	}
+	for _, client := range clients {
+		pcfg.forget(client)
+	}
+	pcfg.psSessions, pcfg.psrpClients, pcfg.sshClients = nil, nil, nil
+	pcfg.ldapClients = map[string][]*LDAPClient{}
+	pcfg.closed = true
+	pcfg.mx.Unlock()
+
+	var wg sync.WaitGroup
+	for _, client := range clients {
+		wg.Add(1)
+		go func(client io.Closer) {
+			defer wg.Done()
+			closeClient(client)
+		}(client)
+	}
+	wg.Wait()
+	if len(clients) > 0 {
+		log.Printf("[DEBUG] Closed %d pooled clients", len(clients))
+	}
+}
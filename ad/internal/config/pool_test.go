@@ -0,0 +1,212 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/masterzen/winrm"
+)
+
+func TestAcquireWinRMClientLimit(t *testing.T) {
+	pcfg := NewProviderConf(&Settings{
+		WinRMHost:               "dc1",
+		WinRMPort:               5985,
+		WinRMProto:              "http",
+		MaxConcurrentOperations: 1,
+	})
+
+	client, err := pcfg.AcquireWinRMClient(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := pcfg.AcquireWinRMClient(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the second client to wait, got %v", err)
+	}
+
+	// File uploads have slots of their own, they run commands while they hold a client.
+	cpClient, err := pcfg.AcquireWinRMCPClient(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acquired := make(chan error)
+	go func() {
+		other, err := pcfg.AcquireWinRMClient(context.Background())
+		if err == nil && other != client {
+			err = errors.New("expected the released client to be reused")
+		}
+		acquired <- err
+	}()
+	select {
+	case err := <-acquired:
+		t.Fatalf("expected the client to wait for the first one to be released, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	pcfg.ReleaseWinRMClient(client)
+	if err := <-acquired; err != nil {
+		t.Error(err)
+	}
+	pcfg.ReleaseWinRMCPClient(cpClient)
+}
+
+func TestClientPoolSize(t *testing.T) {
+	pcfg := NewProviderConf(&Settings{WinRMHost: "dc1", WinRMPort: 5985, WinRMProto: "http", MaxPoolSize: 2})
+	ctx := context.Background()
+
+	var clients []*winrm.Client
+	for i := 0; i < 3; i++ {
+		client, err := pcfg.AcquireWinRMClient(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clients = append(clients, client)
+	}
+	for _, client := range clients {
+		pcfg.ReleaseWinRMClient(client)
+	}
+	if len(pcfg.winRMClients) != 2 || len(pcfg.clientHosts) != 2 {
+		t.Errorf("expected 2 pooled clients, got %d (%d tracked)", len(pcfg.winRMClients), len(pcfg.clientHosts))
+	}
+
+	// The client released last is reused, the other one is evicted once it has been idle for too long.
+	pcfg.clientReleased[clients[0]] = time.Now().Add(-2 * clientIdleTimeout)
+	client, err := pcfg.AcquireWinRMClient(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client != clients[1] {
+		t.Errorf("expected the most recently released client to be reused")
+	}
+	if len(pcfg.winRMClients) != 0 || len(pcfg.clientReleased) != 1 {
+		t.Errorf("expected the idle client to be evicted, got %d pooled clients", len(pcfg.winRMClients))
+	}
+	pcfg.ReleaseWinRMClient(client)
+}
+
+func TestClose(t *testing.T) {
+	pcfg := NewProviderConf(&Settings{WinRMHost: "dc1", WinRMPort: 5985, WinRMProto: "http"})
+	pooled, inUse := &PSRPClient{}, &PSRPClient{}
+	pcfg.trackClient(pooled, "dc1")
+	pcfg.trackClient(inUse, "dc1")
+	pcfg.ReleasePSRPClient(pooled)
+
+	pcfg.Close()
+	if len(pcfg.psrpClients) != 0 || len(pcfg.clientHosts) != 1 {
+		t.Errorf("expected the pooled client to be closed, got %d pooled clients (%d tracked)", len(pcfg.psrpClients), len(pcfg.clientHosts))
+	}
+	// Clients in use when the provider stops are closed once they are released.
+	pcfg.ReleasePSRPClient(inUse)
+	if len(pcfg.psrpClients) != 0 || len(pcfg.clientHosts) != 0 {
+		t.Errorf("expected the released client to be closed, got %d pooled clients (%d tracked)", len(pcfg.psrpClients), len(pcfg.clientHosts))
+	}
+}
+
+func TestAcquireLDAPClientContext(t *testing.T) {
+	// The server accepts the connections but never answers the bind.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		var conns []net.Conn
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				for _, conn := range conns {
+					conn.Close()
+				}
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+	port := listener.Addr().(*net.TCPAddr).Port
+	pcfg := NewProviderConf(&Settings{WinRMHost: "127.0.0.1", LDAPPort: port, LDAPProto: "ldap", WinRMUsername: "user", WinRMPassword: "password"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := pcfg.AcquireLDAPClient(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the bind to be interrupted, got %v", err)
+	}
+
+	// Acquired clients are closed when the context is done, and are not pooled anymore then.
+	ldapClient := newTestLDAPClient(t)
+	ctx, cancel = context.WithCancel(context.Background())
+	ldapClient.watch(ctx)
+	cancel()
+	for i := 0; i < 100 && !ldapClient.IsClosing(); i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if !ldapClient.IsClosing() {
+		t.Error("expected the client to be closed once its context is done")
+	}
+	pcfg.ReleaseLDAPClient(ldapClient)
+	if len(pcfg.ldapClients["user"]) != 0 {
+		t.Error("expected the closed client not to be pooled")
+	}
+}
+
+// newTestLDAPClient returns an LDAP client connected to nothing, for the pool tests.
+func newTestLDAPClient(t *testing.T) *LDAPClient {
+	t.Helper()
+	client, server := net.Pipe()
+	t.Cleanup(func() { _ = server.Close() })
+	conn := ldap.NewConn(client, false)
+	conn.Start()
+	t.Cleanup(func() { _ = conn.Close() })
+	return &LDAPClient{Conn: conn}
+}
+
+func TestAcquireLDAPClientLimit(t *testing.T) {
+	pcfg := NewProviderConf(&Settings{WinRMHost: "dc1", WinRMUsername: "user", MaxConcurrentOperations: 1})
+	pooled := newTestLDAPClient(t)
+	pcfg.trackClient(pooled, "dc1")
+	pcfg.keep(pooled, 0)
+	pcfg.ldapClients["user"] = []*LDAPClient{pooled}
+
+	client, err := pcfg.AcquireLDAPClient(context.Background())
+	if err != nil || client != pooled {
+		t.Fatalf("expected the pooled client, got %v, %v", client, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := pcfg.AcquireLDAPClient(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the second client to wait, got %v", err)
+	}
+
+	acquired := make(chan error)
+	go func() {
+		other, err := pcfg.AcquireLDAPClient(context.Background())
+		if err == nil && other != client {
+			err = errors.New("expected the released client to be reused")
+		}
+		if err == nil {
+			// The connection failed, the client is discarded and its slot released.
+			other.Close()
+			pcfg.ReleaseLDAPClient(other)
+		}
+		acquired <- err
+	}()
+	select {
+	case err := <-acquired:
+		t.Fatalf("expected the client to wait for the first one to be released, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	pcfg.ReleaseLDAPClient(client)
+	if err := <-acquired; err != nil {
+		t.Fatal(err)
+	}
+	if len(pcfg.ldapClients["user"]) != 0 || len(pcfg.clientHosts) != 0 {
+		t.Errorf("expected the closed client to be discarded, got %d pooled clients (%d tracked)", len(pcfg.ldapClients["user"]), len(pcfg.clientHosts))
+	}
+	if err := acquireSlot(ctx, pcfg.operations); err != nil {
+		t.Errorf("expected the slot of the discarded client to be released, got %v", err)
+	}
+}
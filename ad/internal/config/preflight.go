@@ -0,0 +1,106 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// PreflightIssue is a problem found by the preflight checks, along with the way to fix it.
+type PreflightIssue struct {
+	Summary string
+	Detail  string
+	// Fatal issues prevent any command from running. The other ones only affect some resources.
+	Fatal bool
+}
+
+// kerberosHints maps the errors returned by the KDC to the way to fix them.
+var kerberosHints = []struct {
+	code string
+	hint string
+}{
+	{"KRB_AP_ERR_SKEW", "The clock of this host and the one of the KDC differ by more than 5 minutes. Synchronize them with NTP."},
+	{"KDC_ERR_PREAUTH_FAILED", "The password of winrm_username is wrong, or krb_keytab does not hold its current keys."},
+	{"KDC_ERR_C_PRINCIPAL_UNKNOWN", "winrm_username does not exist in the realm krb_realm. Check the realm, it must be the uppercase DNS name of the domain."},
+	{"KDC_ERR_CLIENT_REVOKED", "The account of winrm_username is disabled, locked out or expired."},
+	{"KDC_ERR_KEY_EXPIRED", "The password of winrm_username expired."},
+	{"KDC_ERR_S_PRINCIPAL_UNKNOWN", "No account holds the SPN %[1]s. Set krb_spn to the SPN registered for the WinRM service, or set winrm_hostname to the fully qualified name the SPN is registered for (setspn -Q %[1]s)."},
+	{"KDC_ERR_ETYPE_NOSUPP", "The KDC and the krb5 configuration do not share an encryption type. Enable AES for winrm_username or update krb_conf."},
+}
+
+// CheckKerberos gets a ticket for the WinRM service of the host in use, reporting why it failed in a way
+// that can be acted upon. It returns nil if kerberos is not used.
+func (pcfg *ProviderConf) CheckKerberos(ctx context.Context) *PreflightIssue {
+	settings := pcfg.hostSettings(ctx)
+	if settings.KrbRealm == "" || settings.WinRMClientCert != nil || pcfg.IsConnectionTypeSSH() || pcfg.IsConnectionTypeLocal() {
+		return nil
+	}
+	spn := settings.KrbSpn
+	if spn == "" {
+		spn = "HTTP/" + settings.WinRMHost
+	}
+
+	kerberos := settings.kerberos
+	if kerberos == nil {
+		kerberos = NewKerberosClient(settings)
+	}
+	cl, err := kerberos.Client()
+	if err == nil {
+		_, _, err = cl.GetServiceTicket(spn)
+	}
+	if err == nil {
+		log.Printf("[DEBUG] Preflight: got a kerberos ticket for %s", spn)
+		return nil
+	}
+	return &PreflightIssue{
+		Summary: fmt.Sprintf("Kerberos authentication to %s failed", spn),
+		Detail:  fmt.Sprintf("%s\n\n%s", kerberosHint(err, spn), err),
+		Fatal:   true,
+	}
+}
+
+// kerberosHint returns the way to fix the kerberos error err.
+func kerberosHint(err error, spn string) string {
+	msg := err.Error()
+	for _, h := range kerberosHints {
+		if strings.Contains(msg, h.code) {
+			if strings.Contains(h.hint, "%[1]s") {
+				return fmt.Sprintf(h.hint, spn)
+			}
+			return h.hint
+		}
+	}
+	if IsDialError(err) || strings.Contains(msg, "networking") || strings.Contains(msg, "KDC") {
+		return "The KDC could not be reached. Check that the domain controllers of krb_realm resolve and accept connections on port 88, or list them in krb_conf."
+	}
+	if strings.Contains(msg, "kinit") {
+		return "The tickets of krb_ccache expired. Run kinit again."
+	}
+	return "Check krb_realm, krb_conf and the credentials of winrm_username."
+}
+
+// ConnectionHint returns the way to fix err, returned while running a command on the remote host.
+func (pcfg *ProviderConf) ConnectionHint(err error) string {
+	msg := err.Error()
+	var sessionErr *SSHSessionError
+	switch {
+	case IsDialError(err):
+		if pcfg.IsConnectionTypeSSH() {
+			return fmt.Sprintf("%s could not be reached on port %d. Check winrm_hostname, ssh_port and that the OpenSSH server is running.", pcfg.Settings.WinRMHost, pcfg.Settings.SSHPort)
+		}
+		return fmt.Sprintf("%s could not be reached on port %d. Check winrm_hostname, winrm_port and winrm_proto, and that WinRM is enabled (winrm quickconfig).", pcfg.Settings.WinRMHost, pcfg.Settings.WinRMPort)
+	case strings.Contains(msg, "x509") || strings.Contains(msg, "certificate"):
+		return "The certificate of the WinRM service is not trusted. Set winrm_ca_cert to the CA that issued it."
+	case strings.Contains(msg, "knownhosts"):
+		return "The host key of the server is not in ssh_known_hosts. Add it, for instance with ssh-keyscan."
+	case strings.Contains(msg, "unable to authenticate") || strings.Contains(msg, "401"):
+		return "The server rejected the credentials. Check winrm_username and winrm_password, and that the account is allowed to connect (it must be a member of Remote Management Users or Administrators)."
+	case strings.Contains(msg, "403"):
+		return "The account is not allowed to use the WinRM service, or winrm_configuration_name names a session configuration it cannot access."
+	case errors.As(err, &sessionErr):
+		return "The server accepted the connection but did not allow commands to run."
+	}
+	return "Check the connection settings of the provider."
+}
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestKerberosHint(t *testing.T) {
+	spn := "HTTP/dc1.contoso.com"
+	cases := []struct {
+		err      error
+		expected string
+	}{
+		{errors.New("[Root cause: KDC_Error] KRB Error: (37) KRB_AP_ERR_SKEW Clock skew too great"), "more than 5 minutes"},
+		{errors.New("KRB Error: (24) KDC_ERR_PREAUTH_FAILED Pre-authentication information was invalid"), "password of winrm_username is wrong"},
+		{errors.New("KRB Error: (7) KDC_ERR_S_PRINCIPAL_UNKNOWN Server not found in Kerberos database"), "setspn -Q HTTP/dc1.contoso.com"},
+		{errors.New("[Root cause: Networking_Error] AS Exchange Error: failed sending AS_REQ to KDC"), "port 88"},
+		{errors.New("something else"), "Check krb_realm"},
+	}
+	for _, c := range cases {
+		if actual := kerberosHint(c.err, spn); !strings.Contains(actual, c.expected) {
+			t.Errorf("%v: expected a hint containing %q, got %q", c.err, c.expected, actual)
+		}
+	}
+}
+
+func TestConnectionHint(t *testing.T) {
+	winrm := &ProviderConf{Settings: &Settings{WinRMHost: "dc1", WinRMPort: 5986}}
+	ssh := &ProviderConf{Settings: &Settings{WinRMHost: "dc1", SSHPort: 22, ConnectionType: ConnectionTypeSSH}}
+	dialErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	cases := []struct {
+		pcfg     *ProviderConf
+		err      error
+		expected string
+	}{
+		{winrm, dialErr, "port 5986"},
+		{ssh, dialErr, "ssh_port"},
+		{winrm, errors.New("tls: failed to verify certificate: x509: certificate signed by unknown authority"), "winrm_ca_cert"},
+		{ssh, errors.New("ssh: handshake failed: knownhosts: key is unknown"), "ssh_known_hosts"},
+		{winrm, errors.New("http response error: 401 - invalid content type"), "rejected the credentials"},
+		{winrm, errors.New("http error 403: forbidden"), "winrm_configuration_name"},
+		{ssh, &SSHSessionError{err: errors.New("administratively prohibited")}, "did not allow commands"},
+	}
+	for _, c := range cases {
+		if actual := c.pcfg.ConnectionHint(c.err); !strings.Contains(actual, c.expected) {
+			t.Errorf("%v: expected a hint containing %q, got %q", c.err, c.expected, actual)
+		}
+	}
+}
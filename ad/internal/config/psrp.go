@@ -0,0 +1,608 @@
+package config
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/hashicorp/go-uuid"
+	"github.com/masterzen/simplexml/dom"
+	"github.com/masterzen/winrm"
+	"github.com/masterzen/winrm/soap"
+)
+
+const (
+	// TransportWinRS runs every command as a powershell.exe process started through a WinRM shell.
+	TransportWinRS = "winrs"
+	// TransportPSRP runs every command as a pipeline in a remote runspace using the PowerShell Remoting Protocol.
+	TransportPSRP = "psrp"
+)
+
+const (
+	psrpResourceURI       = "http://schemas.microsoft.com/powershell/"
+	psrpConfigurationName = "Microsoft.PowerShell"
+	psrpProtocolVersion   = "2.3"
+	psrpEnvelopeSize      = 153600
+	psrpOperationTimeout  = "PT60S"
+	psrpFragmentHeader    = 21
+	psrpMessageHeader     = 40
+
+	psrpFragmentStart byte = 0x1
+	psrpFragmentEnd   byte = 0x2
+
+	psrpDestinationServer uint32 = 0x2
+)
+
+// PSRP message types, see [MS-PSRP] 2.2.1
+const (
+	psrpMsgSessionCapability uint32 = 0x00010002
+	psrpMsgInitRunspacePool  uint32 = 0x00010004
+	psrpMsgRunspacePoolState uint32 = 0x00021005
+	psrpMsgCreatePipeline    uint32 = 0x00021006
+	psrpMsgPipelineOutput    uint32 = 0x00041004
+	psrpMsgErrorRecord       uint32 = 0x00041005
+	psrpMsgPipelineState     uint32 = 0x00041006
+)
+
+// Runspace pool and pipeline states, see [MS-PSRP] 2.2.3.4 and 2.2.3.5
+const (
+	psrpRunspacePoolOpened = 2
+	psrpRunspacePoolClosed = 3
+	psrpRunspacePoolBroken = 5
+
+	// PSRPPipelineStopped is the state of a pipeline that was stopped before it completed.
+	PSRPPipelineStopped = 3
+	// PSRPPipelineCompleted is the state of a pipeline that ran to completion.
+	PSRPPipelineCompleted = 4
+	// PSRPPipelineFailed is the state of a pipeline that was interrupted by a terminating error.
+	PSRPPipelineFailed = 5
+)
+
+const (
+	wsmanActionCreate  = "http://schemas.xmlsoap.org/ws/2004/09/transfer/Create"
+	wsmanActionDelete  = "http://schemas.xmlsoap.org/ws/2004/09/transfer/Delete"
+	wsmanActionCommand = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Command"
+	wsmanActionSend    = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Send"
+	wsmanActionReceive = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Receive"
+	wsmanActionSignal  = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Signal"
+
+	wsmanSignalTerminate = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/signal/terminate"
+)
+
+const psrpSessionCapability = `<Obj RefId="0"><MS><Version N="protocolversion">2.3</Version><Version N="PSVersion">2.0</Version>` +
+	`<Version N="SerializationVersion">1.1.0.1</Version></MS></Obj>`
+
+const psrpHostInfo = `<Obj N="HostInfo" RefId="%d"><MS><B N="_isHostNull">true</B><B N="_isHostUINull">true</B>` +
+	`<B N="_isHostRawUINull">true</B><B N="_useRunspaceHost">true</B></MS></Obj>`
+
+const psrpInitRunspacePool = `<Obj RefId="0"><MS><I32 N="MinRunspaces">1</I32><I32 N="MaxRunspaces">1</I32>` +
+	`<Obj N="PSThreadOptions" RefId="1"><TN RefId="0"><T>System.Management.Automation.Runspaces.PSThreadOptions</T>` +
+	`<T>System.Enum</T><T>System.ValueType</T><T>System.Object</T></TN><ToString>Default</ToString><I32>0</I32></Obj>` +
+	`<Obj N="ApartmentState" RefId="2"><TN RefId="1"><T>System.Threading.ApartmentState</T><T>System.Enum</T>` +
+	`<T>System.ValueType</T><T>System.Object</T></TN><ToString>Unknown</ToString><I32>2</I32></Obj>` +
+	psrpHostInfo + `<Nil N="ApplicationArguments" /></MS></Obj>`
+
+const psrpCreatePipeline = `<Obj RefId="0"><MS><B N="NoInput">true</B>` +
+	`<Obj N="ApartmentState" RefId="1"><TN RefId="0"><T>System.Threading.ApartmentState</T><T>System.Enum</T>` +
+	`<T>System.ValueType</T><T>System.Object</T></TN><ToString>Unknown</ToString><I32>2</I32></Obj>` +
+	`<Obj N="RemoteStreamOptions" RefId="2"><TN RefId="1"><T>System.Management.Automation.RemoteStreamOptions</T>` +
+	`<T>System.Enum</T><T>System.ValueType</T><T>System.Object</T></TN><ToString>0</ToString><I32>0</I32></Obj>` +
+	`<B N="AddToHistory">false</B>` + psrpHostInfo +
+	`<Obj N="PowerShell" RefId="4"><MS><Obj N="Cmds" RefId="5"><TN RefId="2">` +
+	"<T>System.Collections.Generic.List`1[[System.Management.Automation.PSObject, System.Management.Automation, " +
+	`Version=1.0.0.0, Culture=neutral, PublicKeyToken=31bf3856ad364e35]]</T><T>System.Object</T></TN><LST>` +
+	`<Obj RefId="6"><MS><S N="Cmd">%s</S><B N="IsScript">true</B><B N="UseLocalScope">true</B>` +
+	`<Obj N="MergeMyResult" RefId="7"><TN RefId="3"><T>System.Management.Automation.Runspaces.PipelineResultTypes</T>` +
+	`<T>System.Enum</T><T>System.ValueType</T><T>System.Object</T></TN><ToString>None</ToString><I32>0</I32></Obj>` +
+	`<Obj N="MergeToResult" RefId="8"><TNRef RefId="3" /><ToString>None</ToString><I32>0</I32></Obj>` +
+	`<Obj N="MergePreviousResults" RefId="9"><TNRef RefId="3" /><ToString>None</ToString><I32>0</I32></Obj>` +
+	`<Obj N="MergeError" RefId="10"><TNRef RefId="3" /><ToString>None</ToString><I32>0</I32></Obj>` +
+	`<Obj N="MergeWarning" RefId="11"><TNRef RefId="3" /><ToString>None</ToString><I32>0</I32></Obj>` +
+	`<Obj N="MergeVerbose" RefId="12"><TNRef RefId="3" /><ToString>None</ToString><I32>0</I32></Obj>` +
+	`<Obj N="MergeDebug" RefId="13"><TNRef RefId="3" /><ToString>None</ToString><I32>0</I32></Obj>` +
+	`<Obj N="MergeInformation" RefId="14"><TNRef RefId="3" /><ToString>None</ToString><I32>0</I32></Obj>` +
+	`<Obj N="Args" RefId="15"><TNRef RefId="2" /><LST /></Obj></MS></Obj></LST></Obj>` +
+	`<B N="IsNested">false</B><Nil N="History" /><B N="RedirectShellErrorOutputPipe">true</B></MS></Obj>` +
+	`<B N="IsNested">false</B></MS></Obj>`
+
+// psrpModules is run once in every new runspace so that later commands do not pay for the module imports.
+const psrpModules = `Import-Module ActiveDirectory -ErrorAction SilentlyContinue -WarningAction SilentlyContinue
+Import-Module GroupPolicy -ErrorAction SilentlyContinue -WarningAction SilentlyContinue`
+
+// PSRPCommandError is returned by PSRPClient.Run when the pipeline could not be created. The script did not
+// run, so it is safe to try again with a different runspace pool.
+type PSRPCommandError struct {
+	err error
+}
+
+func (e *PSRPCommandError) Error() string {
+	return fmt.Sprintf("while creating PSRP pipeline: %s", e.err)
+}
+
+// PSRPResult holds the objects a pipeline wrote to its output and error streams.
+type PSRPResult struct {
+	// Output holds the deserialized objects written to the output stream.
+	Output []interface{}
+	// Errors holds the error records written to the error stream, followed by the terminating error
+	// of the pipeline if it failed.
+	Errors []*PSObject
+	// State is the final state of the pipeline.
+	State int
+}
+
+type psrpMessage struct {
+	Destination uint32
+	Type        uint32
+	RPID        []byte
+	PID         []byte
+	Data        []byte
+}
+
+func (m *psrpMessage) bytes() []byte {
+	out := make([]byte, psrpMessageHeader, psrpMessageHeader+3+len(m.Data))
+	binary.LittleEndian.PutUint32(out[0:4], m.Destination)
+	binary.LittleEndian.PutUint32(out[4:8], m.Type)
+	copy(out[8:24], m.RPID)
+	copy(out[24:40], m.PID)
+	out = append(out, 0xef, 0xbb, 0xbf)
+	return append(out, m.Data...)
+}
+
+func parsePSRPMessage(b []byte) (*psrpMessage, error) {
+	if len(b) < psrpMessageHeader {
+		return nil, fmt.Errorf("PSRP message is too short (%d bytes)", len(b))
+	}
+	return &psrpMessage{
+		Destination: binary.LittleEndian.Uint32(b[0:4]),
+		Type:        binary.LittleEndian.Uint32(b[4:8]),
+		RPID:        b[8:24],
+		PID:         b[24:40],
+		Data:        b[40:],
+	}, nil
+}
+
+// psrpFragments splits a message into fragments whose blobs are at most maxBlob bytes long.
+func psrpFragments(objectID uint64, msg []byte, maxBlob int) [][]byte {
+	var out [][]byte
+	for fragmentID := uint64(0); fragmentID == 0 || len(msg) > 0; fragmentID++ {
+		n := len(msg)
+		if n > maxBlob {
+			n = maxBlob
+		}
+		var flags byte
+		if fragmentID == 0 {
+			flags |= psrpFragmentStart
+		}
+		if n == len(msg) {
+			flags |= psrpFragmentEnd
+		}
+
+		fragment := make([]byte, psrpFragmentHeader, psrpFragmentHeader+n)
+		binary.BigEndian.PutUint64(fragment[0:8], objectID)
+		binary.BigEndian.PutUint64(fragment[8:16], fragmentID)
+		fragment[16] = flags
+		binary.BigEndian.PutUint32(fragment[17:21], uint32(n))
+		out = append(out, append(fragment, msg[:n]...))
+		msg = msg[n:]
+	}
+	return out
+}
+
+// psrpDefragmenter reassembles the messages carried by the fragments received from the server.
+type psrpDefragmenter struct {
+	buffers map[uint64][]byte
+}
+
+func newPSRPDefragmenter() *psrpDefragmenter {
+	return &psrpDefragmenter{buffers: map[uint64][]byte{}}
+}
+
+// Feed consumes a sequence of fragments and returns the messages they completed.
+func (d *psrpDefragmenter) Feed(data []byte) ([]*psrpMessage, error) {
+	var out []*psrpMessage
+	for len(data) > 0 {
+		if len(data) < psrpFragmentHeader {
+			return nil, fmt.Errorf("truncated PSRP fragment header")
+		}
+		objectID := binary.BigEndian.Uint64(data[0:8])
+		flags := data[16]
+		length := int(binary.BigEndian.Uint32(data[17:21]))
+		if len(data) < psrpFragmentHeader+length {
+			return nil, fmt.Errorf("truncated PSRP fragment for object %d", objectID)
+		}
+		blob := data[psrpFragmentHeader : psrpFragmentHeader+length]
+		data = data[psrpFragmentHeader+length:]
+
+		if flags&psrpFragmentStart != 0 {
+			d.buffers[objectID] = nil
+		}
+		d.buffers[objectID] = append(d.buffers[objectID], blob...)
+		if flags&psrpFragmentEnd != 0 {
+			msg, err := parsePSRPMessage(d.buffers[objectID])
+			delete(d.buffers, objectID)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, msg)
+		}
+	}
+	return out, nil
+}
+
+// psrpGUID returns the binary representation of a GUID, in the mixed endian layout used by .NET.
+func psrpGUID(id string) ([]byte, error) {
+	b, err := uuid.ParseUUID(id)
+	if err != nil {
+		return nil, err
+	}
+	b[0], b[1], b[2], b[3] = b[3], b[2], b[1], b[0]
+	b[4], b[5] = b[5], b[4]
+	b[6], b[7] = b[7], b[6]
+	return b, nil
+}
+
+func psrpNewID() (string, []byte, error) {
+	id, err := uuid.GenerateUUID()
+	if err != nil {
+		return "", nil, err
+	}
+	id = strings.ToUpper(id)
+	guid, err := psrpGUID(id)
+	return id, guid, err
+}
+
+type psrpReceiveResponse struct {
+	Streams []struct {
+		Name      string `xml:"Name,attr"`
+		CommandID string `xml:"CommandId,attr"`
+		Content   string `xml:",chardata"`
+	} `xml:"Body>ReceiveResponse>Stream"`
+	CommandState struct {
+		State string `xml:"State,attr"`
+	} `xml:"Body>ReceiveResponse>CommandState"`
+}
+
+// PSRPClient is a runspace pool opened on the remote host using the PowerShell Remoting Protocol.
+// Scripts of any size can be sent to it, and it returns the deserialized objects and error records
+// they produce. A PSRPClient runs one pipeline at a time.
+type PSRPClient struct {
+	client      *winrm.Client
+	transporter winrm.Transporter
+	url         string
+	resourceURI string
+	shellID     string
+	rpid        []byte
+	objectID    uint64
+	maxBlob     int
+	defrag      *psrpDefragmenter
+}
+
+// NewPSRPClient opens a runspace pool in the session configuration of settings on the remote host and
+// imports the ActiveDirectory and GroupPolicy modules in it. The modules of restricted session
+// configurations are left to their role capabilities.
+func NewPSRPClient(ctx context.Context, settings *Settings) (*PSRPClient, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	endpoint := newWinRMEndpoint(settings)
+
+	configurationName := settings.WinRMConfigurationName
+	if configurationName == "" {
+		configurationName = psrpConfigurationName
+	}
+	c := &PSRPClient{
+		url:         fmt.Sprintf("%s://%s:%d/wsman", strings.ToLower(settings.WinRMProto), settings.WinRMHost, settings.WinRMPort),
+		resourceURI: psrpResourceURI + configurationName,
+		maxBlob:     (psrpEnvelopeSize-2048)*3/4 - psrpFragmentHeader,
+		defrag:      newPSRPDefragmenter(),
+	}
+
+	// The transporter is kept around so that we can post our own WSMan messages with it.
+	params := winrm.NewParameters(psrpOperationTimeout, "en-US", psrpEnvelopeSize)
+	params.TransportDecorator = func() winrm.Transporter {
+		c.transporter = newWinRMTransporter(settings)
+		return c.transporter
+	}
+	client, err := winrm.NewClientWithParameters(endpoint, settings.WinRMUsername, settings.WinRMPassword, params)
+	if err != nil {
+		return nil, err
+	}
+	c.client = client
+
+	if err := c.open(ctx); err != nil {
+		return nil, err
+	}
+	if settings.WinRMConfigurationName != "" {
+		log.Printf("[DEBUG] Opened PSRP runspace pool %s in %s on %s", c.shellID, configurationName, settings.WinRMHost)
+		return c, nil
+	}
+
+	result, err := c.Run(ctx, psrpModules)
+	if err != nil {
+		_ = c.Close()
+		return nil, fmt.Errorf("while importing modules in PSRP runspace: %s", err)
+	}
+	for _, e := range result.Errors {
+		log.Printf("[DEBUG] error while importing modules in PSRP runspace: %s", e)
+	}
+
+	log.Printf("[DEBUG] Opened PSRP runspace pool %s on %s", c.shellID, settings.WinRMHost)
+	return c, nil
+}
+
+func (c *PSRPClient) nextObjectID() uint64 {
+	c.objectID++
+	return c.objectID
+}
+
+func (c *PSRPClient) newRequest(action string, options ...*soap.HeaderOption) *soap.SoapMessage {
+	message := soap.NewMessage()
+	header := message.Header().
+		To(c.url).
+		ReplyTo("http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous").
+		MaxEnvelopeSize(psrpEnvelopeSize).
+		Id(psrpMessageID()).
+		Locale("en-US").
+		Timeout(psrpOperationTimeout).
+		Action(action).
+		ResourceURI(c.resourceURI)
+	if c.shellID != "" {
+		header.ShellId(c.shellID)
+	}
+	for _, option := range options {
+		header.AddOption(option)
+	}
+	header.Build()
+	return message
+}
+
+func psrpMessageID() string {
+	// GenerateUUID only fails if the system's random number generator does.
+	id, _ := uuid.GenerateUUID()
+	return "uuid:" + strings.ToUpper(id)
+}
+
+func (c *PSRPClient) post(message *soap.SoapMessage) (string, error) {
+	return c.transporter.Post(c.client, message)
+}
+
+// open creates the remote shell holding the runspace pool and waits for the pool to be opened.
+func (c *PSRPClient) open(ctx context.Context) error {
+	shellID, rpid, err := psrpNewID()
+	if err != nil {
+		return err
+	}
+
+	var creation []byte
+	for _, msg := range []*psrpMessage{
+		{Destination: psrpDestinationServer, Type: psrpMsgSessionCapability, RPID: rpid, PID: make([]byte, 16), Data: []byte(psrpSessionCapability)},
+		{Destination: psrpDestinationServer, Type: psrpMsgInitRunspacePool, RPID: rpid, PID: make([]byte, 16), Data: []byte(fmt.Sprintf(psrpInitRunspacePool, 3))},
+	} {
+		for _, fragment := range psrpFragments(c.nextObjectID(), msg.bytes(), c.maxBlob) {
+			creation = append(creation, fragment...)
+		}
+	}
+
+	message := c.newRequest(wsmanActionCreate, soap.NewHeaderOption("protocolversion", psrpProtocolVersion))
+	shell := message.CreateBodyElement("Shell", soap.DOM_NS_WIN_SHELL)
+	shell.SetAttr("ShellId", shellID)
+	message.CreateElement(shell, "InputStreams", soap.DOM_NS_WIN_SHELL).SetContent("stdin pr")
+	message.CreateElement(shell, "OutputStreams", soap.DOM_NS_WIN_SHELL).SetContent("stdout")
+	message.CreateElement(shell, "creationXml", dom.Namespace{Prefix: "ps", Uri: "http://schemas.microsoft.com/powershell"}).
+		SetContent(base64.StdEncoding.EncodeToString(creation))
+
+	if _, err := c.post(message); err != nil {
+		return fmt.Errorf("while creating PSRP runspace pool: %s", err)
+	}
+	c.shellID = shellID
+	c.rpid = rpid
+
+	return c.receive(ctx, "", func(msg *psrpMessage) (bool, error) {
+		if msg.Type != psrpMsgRunspacePoolState {
+			return false, nil
+		}
+		state, stateErr, err := psrpState(msg.Data, "RunspaceState")
+		if err != nil {
+			return true, err
+		}
+		switch state {
+		case psrpRunspacePoolOpened:
+			return true, nil
+		case psrpRunspacePoolClosed, psrpRunspacePoolBroken:
+			_ = c.Close()
+			return true, fmt.Errorf("PSRP runspace pool could not be opened (state %d): %s", state, stateErr)
+		}
+		return false, nil
+	})
+}
+
+// psrpState extracts a state and the error record explaining it, if any, from a state message.
+func psrpState(data []byte, name string) (int, *PSObject, error) {
+	values, err := DecodeCLIXML(data)
+	if err != nil {
+		return 0, nil, err
+	}
+	obj, ok := values[0].(*PSObject)
+	if !ok {
+		return 0, nil, fmt.Errorf("unexpected PSRP state message: %s", data)
+	}
+	state, ok := obj.Property(name).(int64)
+	if !ok {
+		return 0, nil, fmt.Errorf("PSRP state message has no %s: %s", name, data)
+	}
+	return int(state), obj.ObjectProperty("ExceptionAsErrorRecord"), nil
+}
+
+// receive polls the server for output until handle reports that it is done. When commandID is empty the
+// output of the runspace pool itself is received.
+func (c *PSRPClient) receive(ctx context.Context, commandID string, handle func(*psrpMessage) (bool, error)) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		message := c.newRequest(wsmanActionReceive, soap.NewHeaderOption("WSMAN_CMDSHELL_OPTION_KEEPALIVE", "TRUE"))
+		receive := message.CreateBodyElement("Receive", soap.DOM_NS_WIN_SHELL)
+		stream := message.CreateElement(receive, "DesiredStream", soap.DOM_NS_WIN_SHELL)
+		if commandID != "" {
+			stream.SetAttr("CommandId", commandID)
+		}
+		stream.SetContent("stdout")
+
+		response, err := c.post(message)
+		if err != nil {
+			if strings.Contains(err.Error(), "OperationTimeout") {
+				// There was no output before the operation timeout, poll again.
+				continue
+			}
+			return fmt.Errorf("while receiving PSRP output: %s", err)
+		}
+
+		var parsed psrpReceiveResponse
+		if err := xml.Unmarshal([]byte(response), &parsed); err != nil {
+			return fmt.Errorf("while parsing PSRP receive response: %s", err)
+		}
+		for _, s := range parsed.Streams {
+			data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s.Content))
+			if err != nil {
+				return fmt.Errorf("while decoding PSRP stream: %s", err)
+			}
+			msgs, err := c.defrag.Feed(data)
+			if err != nil {
+				return err
+			}
+			for _, msg := range msgs {
+				done, err := handle(msg)
+				if done || err != nil {
+					return err
+				}
+			}
+		}
+		if strings.HasSuffix(parsed.CommandState.State, "/Done") {
+			return fmt.Errorf("PSRP command finished without reporting its state")
+		}
+	}
+}
+
+// Run executes a script in the runspace pool. The script runs in its own scope so variables do not leak
+// between scripts, while imported modules stay loaded. Cancelling ctx stops the pipeline, after which the
+// client should be closed.
+func (c *PSRPClient) Run(ctx context.Context, script string) (*PSRPResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	commandID, pid, err := psrpNewID()
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &psrpMessage{
+		Destination: psrpDestinationServer,
+		Type:        psrpMsgCreatePipeline,
+		RPID:        c.rpid,
+		PID:         pid,
+		Data:        []byte(fmt.Sprintf(psrpCreatePipeline, 3, EncodeCLIXMLString(script))),
+	}
+	fragments := psrpFragments(c.nextObjectID(), msg.bytes(), c.maxBlob)
+
+	message := c.newRequest(wsmanActionCommand)
+	line := message.CreateBodyElement("CommandLine", soap.DOM_NS_WIN_SHELL)
+	line.SetAttr("CommandId", commandID)
+	message.CreateElement(line, "Command", soap.DOM_NS_WIN_SHELL)
+	message.CreateElement(line, "Arguments", soap.DOM_NS_WIN_SHELL).SetContent(base64.StdEncoding.EncodeToString(fragments[0]))
+	if _, err := c.post(message); err != nil {
+		return nil, &PSRPCommandError{err: err}
+	}
+
+	// Large scripts do not fit in a single message, the remaining fragments are sent as input.
+	for _, fragment := range fragments[1:] {
+		message := c.newRequest(wsmanActionSend)
+		send := message.CreateBodyElement("Send", soap.DOM_NS_WIN_SHELL)
+		stream := message.CreateElement(send, "Stream", soap.DOM_NS_WIN_SHELL)
+		stream.SetAttr("Name", "stdin")
+		stream.SetAttr("CommandId", commandID)
+		stream.SetContent(base64.StdEncoding.EncodeToString(fragment))
+		if _, err := c.post(message); err != nil {
+			return nil, fmt.Errorf("while sending PSRP pipeline fragment: %s", err)
+		}
+	}
+
+	// Stopping the pipeline as soon as ctx is cancelled also completes the pending receive request.
+	stop := context.AfterFunc(ctx, func() { c.signal(commandID, wsmanSignalTerminate) })
+	defer stop()
+
+	result := &PSRPResult{}
+	err = c.receive(ctx, commandID, func(msg *psrpMessage) (bool, error) {
+		switch msg.Type {
+		case psrpMsgPipelineOutput:
+			values, err := DecodeCLIXML(msg.Data)
+			if err != nil {
+				return true, err
+			}
+			result.Output = append(result.Output, values...)
+		case psrpMsgErrorRecord:
+			values, err := DecodeCLIXML(msg.Data)
+			if err != nil {
+				return true, err
+			}
+			if obj, ok := values[0].(*PSObject); ok {
+				result.Errors = append(result.Errors, obj)
+			}
+		case psrpMsgPipelineState:
+			state, stateErr, err := psrpState(msg.Data, "PipelineState")
+			if err != nil {
+				return true, err
+			}
+			switch state {
+			case PSRPPipelineStopped, PSRPPipelineCompleted, PSRPPipelineFailed:
+				result.State = state
+				if stateErr != nil {
+					result.Errors = append(result.Errors, stateErr)
+				}
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, fmt.Errorf("PSRP pipeline stopped: %w", ctxErr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Let the server release the resources associated with the pipeline.
+	if stop() {
+		c.signal(commandID, wsmanSignalTerminate)
+	}
+
+	return result, nil
+}
+
+// signal sends a signal to a pipeline.
+func (c *PSRPClient) signal(commandID, code string) {
+	message := c.newRequest(wsmanActionSignal)
+	signal := message.CreateBodyElement("Signal", soap.DOM_NS_WIN_SHELL)
+	signal.SetAttr("CommandId", commandID)
+	message.CreateElement(signal, "Code", soap.DOM_NS_WIN_SHELL).SetContent(code)
+	if _, err := c.post(message); err != nil {
+		log.Printf("[DEBUG] error while signaling PSRP pipeline %s: %s", commandID, err)
+	}
+}
+
+// Close closes the runspace pool and deletes the remote shell holding it.
+func (c *PSRPClient) Close() error {
+	if c.shellID == "" {
+		return nil
+	}
+	message := c.newRequest(wsmanActionDelete)
+	message.NewBody()
+	_, err := c.post(message)
+	c.shellID = ""
+	return err
+}
@@ -0,0 +1,154 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPSRPFragments(t *testing.T) {
+	msg := &psrpMessage{
+		Destination: psrpDestinationServer,
+		Type:        psrpMsgCreatePipeline,
+		RPID:        bytes.Repeat([]byte{1}, 16),
+		PID:         bytes.Repeat([]byte{2}, 16),
+		Data:        []byte(strings.Repeat("x", 1000)),
+	}
+	raw := msg.bytes()
+
+	fragments := psrpFragments(7, raw, 300)
+	if len(fragments) != 4 {
+		t.Fatalf("expected 4 fragments, found %d", len(fragments))
+	}
+	if fragments[0][16] != psrpFragmentStart || fragments[3][16] != psrpFragmentEnd {
+		t.Errorf("unexpected fragment flags %x %x", fragments[0][16], fragments[3][16])
+	}
+
+	// Interleave the fragments with a single fragment message for another object
+	other := psrpFragments(8, (&psrpMessage{Type: psrpMsgPipelineState, RPID: msg.RPID, PID: msg.PID}).bytes(), 300)
+	if len(other) != 1 || other[0][16] != psrpFragmentStart|psrpFragmentEnd {
+		t.Fatalf("unexpected fragments for a small message: %v", other)
+	}
+
+	d := newPSRPDefragmenter()
+	var stream []byte
+	stream = append(stream, fragments[0]...)
+	stream = append(stream, other[0]...)
+	stream = append(stream, fragments[1]...)
+	msgs, err := d.Feed(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].Type != psrpMsgPipelineState {
+		t.Fatalf("expected the small message to be complete, found %v", msgs)
+	}
+
+	msgs, err = d.Feed(append(append([]byte{}, fragments[2]...), fragments[3]...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 {
+		t.Fatalf("expected 1 message, found %d", len(msgs))
+	}
+	got := msgs[0]
+	if got.Destination != msg.Destination || got.Type != msg.Type || !bytes.Equal(got.RPID, msg.RPID) || !bytes.Equal(got.PID, msg.PID) {
+		t.Errorf("message header did not survive a round trip: %+v", got)
+	}
+	if !bytes.Equal(got.Data, append([]byte("\xef\xbb\xbf"), msg.Data...)) {
+		t.Errorf("message data did not survive a round trip")
+	}
+
+	if _, err := d.Feed(fragments[0][:10]); err == nil {
+		t.Errorf("expected an error for a truncated fragment")
+	}
+}
+
+func TestPSRPGUID(t *testing.T) {
+	guid, err := psrpGUID("AB721A53-1E2F-11D0-9819-00AA0040529B")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{0x53, 0x1a, 0x72, 0xab, 0x2f, 0x1e, 0xd0, 0x11, 0x98, 0x19, 0x00, 0xaa, 0x00, 0x40, 0x52, 0x9b}
+	if !bytes.Equal(guid, expected) {
+		t.Errorf("unexpected GUID layout %x", guid)
+	}
+}
+
+func TestPSRPCreatePipeline(t *testing.T) {
+	script := "Get-ADUser -Identity \"$(whoami)\" | ConvertTo-Json\r\n# <&> `backtick` _x0041_"
+	values, err := DecodeCLIXML([]byte(fmt.Sprintf(psrpCreatePipeline, 3, EncodeCLIXMLString(script))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pipeline := values[0].(*PSObject)
+	cmds, _ := pipeline.ObjectProperty("PowerShell").ObjectProperty("Cmds").Value.([]interface{})
+	if len(cmds) != 1 {
+		t.Fatalf("expected 1 command, found %d", len(cmds))
+	}
+	cmd := cmds[0].(*PSObject)
+	if cmd.StringProperty("Cmd") != script {
+		t.Errorf("script did not survive serialization, got %q", cmd.StringProperty("Cmd"))
+	}
+	if cmd.Property("IsScript") != true || cmd.Property("UseLocalScope") != true {
+		t.Errorf("unexpected command flags %v", cmd.Properties)
+	}
+
+	if _, err := DecodeCLIXML([]byte(fmt.Sprintf(psrpInitRunspacePool, 3))); err != nil {
+		t.Errorf("invalid INIT_RUNSPACEPOOL message: %s", err)
+	}
+}
+
+func TestPSRPState(t *testing.T) {
+	state, stateErr, err := psrpState([]byte(`<Obj RefId="0"><MS><I32 N="PipelineState">5</I32>`+
+		`<Obj N="ExceptionAsErrorRecord" RefId="1"><ToString>boom</ToString></Obj></MS></Obj>`), "PipelineState")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state != PSRPPipelineFailed || stateErr == nil || stateErr.String() != "boom" {
+		t.Errorf("unexpected state %d, error %v", state, stateErr)
+	}
+
+	if _, _, err := psrpState([]byte(`<Obj RefId="0"><MS /></Obj>`), "PipelineState"); err == nil {
+		t.Errorf("expected an error for a state message without a state")
+	}
+}
+
+func TestPSRPConfigurationName(t *testing.T) {
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, string(body))
+		http.Error(w, "not a WinRM server", http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	host, port, _ := net.SplitHostPort(strings.TrimPrefix(srv.URL, "http://"))
+	portNumber, _ := strconv.Atoi(port)
+	for configurationName, resourceURI := range map[string]string{
+		"":                    "http://schemas.microsoft.com/powershell/Microsoft.PowerShell",
+		"ContosoADManagement": "http://schemas.microsoft.com/powershell/ContosoADManagement",
+	} {
+		requests = nil
+		settings := &Settings{
+			WinRMHost:              host,
+			WinRMPort:              portNumber,
+			WinRMProto:             "http",
+			WinRMUsername:          "admin",
+			WinRMPassword:          "secret",
+			WinRMConfigurationName: configurationName,
+		}
+		if _, err := NewPSRPClient(context.Background(), settings); err == nil {
+			t.Fatalf("expected the error of the server")
+		}
+		if len(requests) != 1 || !strings.Contains(requests[0], ">"+resourceURI+"</") {
+			t.Errorf("expected the runspace pool to be created with the resource URI %s, got %v", resourceURI, requests)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/masterzen/winrm"
+)
+
+// psSessionLoop is the script run by the long lived powershell.exe process backing a PSSession.
+// Once the modules are loaded it writes the marker followed by "ready". Each line read from stdin then
+// holds a base64 encoded UTF-8 script. The script runs in a child scope so variables do not leak between
+// commands, and a single line holding the marker, the exit code and the base64 encoded stdout and stderr
+// is written back once it is done. As with powershell.exe -Command, the exit code is 1 if the script
+// threw or if its last statement failed ($? is false), and 0 otherwise: errors written by the statements
+// before it are reported in stderr only.
+const psSessionLoop = `Import-Module ActiveDirectory -ErrorAction SilentlyContinue -WarningAction SilentlyContinue
+Import-Module GroupPolicy -ErrorAction SilentlyContinue -WarningAction SilentlyContinue
+[Console]::Out.WriteLine('%[1]s ready')
+[Console]::Out.Flush()
+$utf8 = New-Object System.Text.UTF8Encoding $false
+while ($null -ne ($line = [Console]::In.ReadLine())) {
+  $code = 0
+  $out = @()
+  $global:__tfadSuccess = $true
+  try {
+    $sb = [scriptblock]::Create($utf8.GetString([Convert]::FromBase64String($line)) + "` + "`n" + `" + '$global:__tfadSuccess = $?')
+    $out = @(& $sb 2>&1)
+    if (-not $global:__tfadSuccess) { $code = 1 }
+  } catch {
+    $out += $_
+    $code = 1
+  }
+  $errs = @($out | Where-Object { $_ -is [System.Management.Automation.ErrorRecord] })
+  $res = @($out | Where-Object { $_ -isnot [System.Management.Automation.ErrorRecord] })
+  $stdout = ($res | ForEach-Object { if ($_ -is [string]) { $_ } else { $_ | Out-String -Width 4096 } }) -join "` + "`n" + `"
+  $stderr = ($errs | Out-String -Width 4096)
+  [Console]::Out.WriteLine('%[1]s ' + $code + ' ' + [Convert]::ToBase64String($utf8.GetBytes([string]$stdout)) + ' ' + [Convert]::ToBase64String($utf8.GetBytes([string]$stderr)))
+  [Console]::Out.Flush()
+}
+`
+
+// PSSessionInputError is returned by PSSession.Run when the script could not be delivered to the
+// remote process. The script did not run, so it is safe to try again with a different session.
+type PSSessionInputError struct {
+	err error
+}
+
+func (e *PSSessionInputError) Error() string {
+	return fmt.Sprintf("while sending script to powershell session: %s", e.err)
+}
+
+// PSSession is a powershell.exe process running in a WinRM shell that stays alive between commands.
+// The ActiveDirectory and GroupPolicy modules are imported once when the session starts.
+type PSSession struct {
+	client *winrm.Client
+	shell  *winrm.Shell
+	cmd    *winrm.Command
+	marker string
+	lines  chan string
+	stderr *bytes.Buffer
+	mx     *sync.Mutex
+}
+
+// NewPSSession opens a new WinRM shell and starts a powershell session in it. The session outlives ctx,
+// which only bounds the time spent waiting for it to be ready.
+func NewPSSession(ctx context.Context, settings *Settings) (*PSSession, error) {
+	client, err := GetWinRMConnection(ctx, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return nil, fmt.Errorf("while generating session marker: %s", err)
+	}
+	marker := fmt.Sprintf("##TFAD-%s##", hex.EncodeToString(token))
+
+	shell, err := client.CreateShell()
+	if err != nil {
+		return nil, fmt.Errorf("while creating remote shell: %s", err)
+	}
+
+	loop := winrm.Powershell(fmt.Sprintf(psSessionLoop, marker))
+	loop = strings.Replace(loop, "powershell.exe", "powershell.exe -NoLogo -NoProfile -NonInteractive", 1)
+	cmd, err := shell.ExecuteWithContext(context.Background(), loop)
+	if err != nil {
+		_ = shell.Close()
+		return nil, fmt.Errorf("while starting powershell session: %s", err)
+	}
+
+	session := &PSSession{
+		client: client,
+		shell:  shell,
+		cmd:    cmd,
+		marker: marker,
+		lines:  make(chan string),
+		stderr: &bytes.Buffer{},
+		mx:     &sync.Mutex{},
+	}
+	go session.readStdout()
+	go session.readStderr()
+
+	stop := context.AfterFunc(ctx, func() { _ = session.cmd.Close() })
+	err = session.waitReady()
+	if !stop() && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		_ = session.Close()
+		return nil, err
+	}
+
+	log.Printf("[DEBUG] Started persistent powershell session on %s", settings.WinRMHost)
+	return session, nil
+}
+
+// readStdout feeds the lines written by the remote process to the lines channel. The channel is closed
+// once the process exits or the shell goes away.
+func (s *PSSession) readStdout() {
+	defer close(s.lines)
+	reader := bufio.NewReader(s.cmd.Stdout)
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			s.lines <- strings.TrimRight(line, "\r\n")
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("[DEBUG] powershell session stdout closed: %s", err)
+			}
+			return
+		}
+	}
+}
+
+// waitReady blocks until the remote process has loaded its modules. Anything written to the host while
+// loading them is discarded.
+func (s *PSSession) waitReady() error {
+	for line := range s.lines {
+		if line == s.marker+" ready" {
+			return nil
+		}
+	}
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return fmt.Errorf("powershell session exited during startup with exit code %d, stderr: %s", s.cmd.ExitCode(), s.stderr.String())
+}
+
+// readStderr keeps the remote stderr stream drained so that output polling never blocks.
+func (s *PSSession) readStderr() {
+	buf := make([]byte, 4096)
+	for {
+		n, err := s.cmd.Stderr.Read(buf)
+		if n > 0 {
+			s.mx.Lock()
+			s.stderr.Write(buf[:n])
+			s.mx.Unlock()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Run sends a script to the session and waits for its result. Lines written directly to the host
+// (Write-Host for instance) are returned as part of stdout. When ctx is cancelled the remote process is
+// terminated, and the session cannot be used anymore.
+func (s *PSSession) Run(ctx context.Context, script string) (stdout string, stderr string, exitCode int, err error) {
+	if err := ctx.Err(); err != nil {
+		return "", "", 0, err
+	}
+	stop := context.AfterFunc(ctx, func() { _ = s.cmd.Close() })
+	defer stop()
+
+	s.mx.Lock()
+	s.stderr.Reset()
+	s.mx.Unlock()
+
+	input := base64.StdEncoding.EncodeToString([]byte(script)) + "\n"
+	if _, err := s.cmd.Stdin.Write([]byte(input)); err != nil {
+		return "", "", 0, &PSSessionInputError{err: err}
+	}
+
+	var hostLines []string
+	for line := range s.lines {
+		if !strings.HasPrefix(line, s.marker+" ") {
+			hostLines = append(hostLines, line)
+			continue
+		}
+		stdout, stderr, exitCode, err = parsePSSessionResult(strings.TrimPrefix(line, s.marker+" "))
+		if err != nil {
+			return "", "", 0, err
+		}
+		if len(hostLines) > 0 {
+			stdout = strings.Join(append(hostLines, stdout), "\n")
+		}
+		return stdout, stderr, exitCode, nil
+	}
+
+	if err := ctx.Err(); err != nil {
+		return "", "", 0, fmt.Errorf("powershell session terminated: %w", err)
+	}
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return strings.Join(hostLines, "\n"), s.stderr.String(), s.cmd.ExitCode(), fmt.Errorf("powershell session terminated unexpectedly")
+}
+
+// Close stops the remote process and deletes the WinRM shell.
+func (s *PSSession) Close() error {
+	_ = s.cmd.Close()
+	return s.shell.Close()
+}
+
+// parsePSSessionResult decodes the "<exit code> <base64 stdout> <base64 stderr>" part of a result line.
+func parsePSSessionResult(result string) (string, string, int, error) {
+	fields := strings.Split(result, " ")
+	if len(fields) != 3 {
+		return "", "", 0, fmt.Errorf("malformed powershell session result: %q", result)
+	}
+	exitCode, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return "", "", 0, fmt.Errorf("malformed exit code in powershell session result: %s", err)
+	}
+	stdout, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return "", "", 0, fmt.Errorf("malformed stdout in powershell session result: %s", err)
+	}
+	stderr, err := base64.StdEncoding.DecodeString(fields[2])
+	if err != nil {
+		return "", "", 0, fmt.Errorf("malformed stderr in powershell session result: %s", err)
+	}
+	return string(stdout), string(stderr), exitCode, nil
+}
@@ -0,0 +1,32 @@
+package config
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestParsePSSessionResult(t *testing.T) {
+	out := base64.StdEncoding.EncodeToString([]byte("{\"Name\": \"ünïcode\"}"))
+	stdout, stderr, exitCode, err := parsePSSessionResult("0 " + out + " ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdout != "{\"Name\": \"ünïcode\"}" || stderr != "" || exitCode != 0 {
+		t.Errorf("unexpected result: stdout %q, stderr %q, exit code %d", stdout, stderr, exitCode)
+	}
+
+	errOut := base64.StdEncoding.EncodeToString([]byte("Get-ADUser : Cannot find an object with identity"))
+	_, stderr, exitCode, err = parsePSSessionResult("1  " + errOut)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stderr != "Get-ADUser : Cannot find an object with identity" || exitCode != 1 {
+		t.Errorf("unexpected result: stderr %q, exit code %d", stderr, exitCode)
+	}
+
+	for _, malformed := range []string{"", "0", "x a b", "0 !!! a", "0 a b c"} {
+		if _, _, _, err := parsePSSessionResult(malformed); err == nil {
+			t.Errorf("expected an error while parsing %q", malformed)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+const (
+	// netlogonPort is the port domain controllers answer the CLDAP netlogon pings on.
+	netlogonPort = 389
+	// netlogonNtVersion asks for a NETLOGON_SAM_LOGON_RESPONSE_EX response, which holds the site of the
+	// client (NETLOGON_NT_VERSION_5 | NETLOGON_NT_VERSION_5EX).
+	netlogonNtVersion = `\06\00\00\00`
+	// The opcodes of the NETLOGON_SAM_LOGON_RESPONSE_EX responses, for a known and an unknown user.
+	logonSAMLogonResponseEx = 23
+	logonSAMUserUnknownEx   = 25
+	// maxNetlogonPings is how many domain controllers are pinged before giving up on detecting the site.
+	maxNetlogonPings = 3
+)
+
+// DetectSite returns the Active Directory site of the client, as reported by the domain controller dc
+// of realm to a CLDAP netlogon ping. The domain controller maps the address the ping comes from to a site
+// using the subnets defined in Active Directory, the site is empty if no subnet matches.
+func DetectSite(ctx context.Context, dc, realm string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	request, err := netlogonRequest(realm)
+	if err != nil {
+		return "", err
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", net.JoinHostPort(dc, strconv.Itoa(netlogonPort)))
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if _, err := conn.Write(request); err != nil {
+		return "", fmt.Errorf("while sending the netlogon ping to %s: %s", dc, err)
+	}
+	buf := make([]byte, 64*1024)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return "", fmt.Errorf("while reading the netlogon response of %s: %s", dc, err)
+	}
+	return parseNetlogonResponse(buf[:n])
+}
+
+// netlogonRequest returns a search of the Netlogon attribute of the RootDSE of the domain controllers of
+// realm, the LDAP message of a netlogon ping.
+func netlogonRequest(realm string) ([]byte, error) {
+	filter, err := ldap.CompileFilter(fmt.Sprintf("(&(DnsDomain=%s)(NtVer=%s))", ldap.EscapeFilter(realm), netlogonNtVersion))
+	if err != nil {
+		return nil, err
+	}
+	search := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchRequest, nil, "Search Request")
+	search.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Base DN"))
+	search.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, uint64(ldap.ScopeBaseObject), "Scope"))
+	search.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, uint64(ldap.NeverDerefAliases), "Deref Aliases"))
+	search.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, uint64(0), "Size Limit"))
+	search.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, uint64(0), "Time Limit"))
+	search.AppendChild(ber.NewBoolean(ber.ClassUniversal, ber.TypePrimitive, ber.TagBoolean, false, "Types Only"))
+	search.AppendChild(filter)
+	attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	attributes.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "Netlogon", "Attribute"))
+	search.AppendChild(attributes)
+
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Request")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, uint64(1), "MessageID"))
+	packet.AppendChild(search)
+	return packet.Bytes(), nil
+}
+
+// parseNetlogonResponse returns the site of the client from the search result entry answering a
+// netlogon ping.
+func parseNetlogonResponse(response []byte) (string, error) {
+	packet, err := ber.DecodePacketErr(response)
+	if err != nil {
+		return "", fmt.Errorf("while decoding the netlogon response: %s", err)
+	}
+	if len(packet.Children) < 2 || packet.Children[1].Tag != ldap.ApplicationSearchResultEntry || len(packet.Children[1].Children) < 2 {
+		return "", errors.New("the netlogon response holds no search result entry")
+	}
+	for _, attribute := range packet.Children[1].Children[1].Children {
+		if len(attribute.Children) < 2 || len(attribute.Children[1].Children) == 0 {
+			continue
+		}
+		if name, _ := attribute.Children[0].Value.(string); !strings.EqualFold(name, "Netlogon") {
+			continue
+		}
+		return parseNetlogonSAMLogonResponseEx(attribute.Children[1].Children[0].Data.Bytes())
+	}
+	return "", errors.New("the netlogon response holds no Netlogon attribute")
+}
+
+// parseNetlogonSAMLogonResponseEx returns the ClientSiteName of a NETLOGON_SAM_LOGON_RESPONSE_EX
+// structure, the last of the 8 compressed names following its fixed header of 24 bytes.
+func parseNetlogonSAMLogonResponseEx(data []byte) (string, error) {
+	if len(data) < 24 {
+		return "", fmt.Errorf("the netlogon response is too short (%d bytes)", len(data))
+	}
+	if opcode := int(data[0]) | int(data[1])<<8; opcode != logonSAMLogonResponseEx && opcode != logonSAMUserUnknownEx {
+		return "", fmt.Errorf("unexpected netlogon response opcode %d", opcode)
+	}
+	offset := 24
+	var name string
+	for i := 0; i < 8; i++ {
+		var err error
+		name, offset, err = readCompressedName(data, offset)
+		if err != nil {
+			return "", fmt.Errorf("while reading the netlogon response: %s", err)
+		}
+	}
+	return name, nil
+}
+
+// readCompressedName reads the name compressed as in DNS messages (RFC 1035 section 4.1.4) at offset of
+// data, and returns it along with the offset of the data following it.
+func readCompressedName(data []byte, offset int) (string, int, error) {
+	var labels []string
+	next := -1
+	for jumps := 0; ; {
+		if offset >= len(data) {
+			return "", 0, errors.New("name out of bounds")
+		}
+		length := int(data[offset])
+		switch {
+		case length == 0:
+			if next < 0 {
+				next = offset + 1
+			}
+			return strings.Join(labels, "."), next, nil
+		case length&0xC0 == 0xC0:
+			if offset+1 >= len(data) {
+				return "", 0, errors.New("pointer out of bounds")
+			}
+			if jumps++; jumps > len(data) {
+				return "", 0, errors.New("pointer loop")
+			}
+			if next < 0 {
+				next = offset + 2
+			}
+			offset = (length&0x3F)<<8 | int(data[offset+1])
+		default:
+			if offset+1+length > len(data) {
+				return "", 0, errors.New("label out of bounds")
+			}
+			labels = append(labels, string(data[offset+1:offset+1+length]))
+			offset += 1 + length
+		}
+	}
+}
+
+// detectSite returns the site of the client as reported by the first of hosts answering a netlogon ping,
+// or an empty string if none of the first maxNetlogonPings hosts answers.
+func (pcfg *ProviderConf) detectSite(ctx context.Context, hosts []string, realm string) string {
+	if len(hosts) > maxNetlogonPings {
+		hosts = hosts[:maxNetlogonPings]
+	}
+	for _, host := range hosts {
+		site, err := pcfg.pingNetlogon(ctx, host, realm)
+		if err != nil {
+			log.Printf("[WARN] Netlogon ping of %s failed: %s", host, err)
+			continue
+		}
+		return site
+	}
+	return ""
+}
+
+// discoverDomainControllers discovers the domain controllers of the realm of the provider, preferring the
+// ones of the configured site, or of the site of the client if none is configured.
+func (pcfg *ProviderConf) discoverDomainControllers(ctx context.Context) ([]string, error) {
+	realm, site := pcfg.Settings.KrbRealm, pcfg.Settings.DCSite
+	if site != "" {
+		return DiscoverDomainControllers(ctx, pcfg.resolver, realm, site)
+	}
+	hosts, err := DiscoverDomainControllers(ctx, pcfg.resolver, realm, "")
+	if err != nil {
+		return nil, err
+	}
+	site = pcfg.detectSite(ctx, hosts, realm)
+	if site == "" {
+		return hosts, nil
+	}
+	log.Printf("[DEBUG] Detected site %s", site)
+	return DiscoverDomainControllers(ctx, pcfg.resolver, realm, site)
+}
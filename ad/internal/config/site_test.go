@@ -0,0 +1,115 @@
+package config
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// netlogonResponse returns a search result entry holding a NETLOGON_SAM_LOGON_RESPONSE_EX structure
+// reporting clientSite, with its names compressed the way domain controllers do.
+func netlogonResponse(clientSite string) []byte {
+	data := []byte{23, 0, 0, 0, 0xfd, 0xf3, 0x03, 0x00}
+	data = append(data, make([]byte, 16)...)
+	// DnsForestName, then DnsDomainName and DnsHostName pointing to it.
+	data = append(data, 7, 'c', 'o', 'n', 't', 'o', 's', 'o', 3, 'c', 'o', 'm', 0)
+	data = append(data, 0xc0, 24)
+	data = append(data, 3, 'd', 'c', '1', 0xc0, 24)
+	// NetbiosDomainName, NetbiosComputerName, UserName and DcSiteName.
+	data = append(data, 7, 'C', 'O', 'N', 'T', 'O', 'S', 'O', 0)
+	data = append(data, 3, 'D', 'C', '1', 0)
+	data = append(data, 0)
+	data = append(data, 6, 'L', 'o', 'n', 'd', 'o', 'n', 0)
+	if clientSite == "London" {
+		data = append(data, 0xc0, byte(len(data)-8))
+	} else {
+		data = append(data, byte(len(clientSite)))
+		data = append(data, clientSite...)
+		data = append(data, 0)
+	}
+
+	value := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+	value.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, string(data), "Value"))
+	attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+	attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "netlogon", "Type"))
+	attribute.AppendChild(value)
+	attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	attributes.AppendChild(attribute)
+	entry := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	entry.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Object Name"))
+	entry.AppendChild(attributes)
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, uint64(1), "MessageID"))
+	packet.AppendChild(entry)
+	return packet.Bytes()
+}
+
+func TestParseNetlogonResponse(t *testing.T) {
+	for _, site := range []string{"Paris", "London", ""} {
+		actual, err := parseNetlogonResponse(netlogonResponse(site))
+		if err != nil {
+			t.Errorf("unexpected error for site %q: %s", site, err)
+		} else if actual != site {
+			t.Errorf("expected site %q, got %q", site, actual)
+		}
+	}
+
+	if _, err := parseNetlogonResponse([]byte{0x30, 0x03, 0x02, 0x01, 0x01}); err == nil {
+		t.Errorf("expected an error for a response without entry")
+	}
+	if _, err := parseNetlogonSAMLogonResponseEx(append([]byte{23, 0}, make([]byte, 22)...)); err == nil {
+		t.Errorf("expected an error for a truncated response")
+	}
+	if _, _, err := readCompressedName([]byte{0xc0, 0x00}, 0); err == nil {
+		t.Errorf("expected an error for a pointer loop")
+	}
+
+	request, err := netlogonRequest("contoso.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ntVer := ber.DecodePacket(request).Children[1].Children[6].Children[1]
+	if value := ntVer.Children[1].Data.String(); ntVer.Children[0].Data.String() != "NtVer" || value != "\x06\x00\x00\x00" {
+		t.Errorf("unexpected NtVer %q", value)
+	}
+}
+
+func TestDiscoverDomainControllersDetectedSite(t *testing.T) {
+	server := newFakeDNSServer(t, map[string][]net.SRV{
+		"_ldap._tcp.dc._msdcs.contoso.com": {
+			{Target: "dc1.contoso.com.", Port: 389, Priority: 0, Weight: 100},
+			{Target: "dc2.contoso.com.", Port: 389, Priority: 10, Weight: 100},
+		},
+		"_ldap._tcp.london._sites.dc._msdcs.contoso.com": {
+			{Target: "dc2.contoso.com.", Port: 389, Priority: 0, Weight: 100},
+		},
+	})
+	pcfg := NewProviderConf(&Settings{KrbRealm: "contoso.com", DCDiscovery: true})
+	pcfg.resolver = server.resolver()
+	var pinged []string
+	pcfg.pingNetlogon = func(_ context.Context, dc, realm string) (string, error) {
+		pinged = append(pinged, dc)
+		return "london", nil
+	}
+
+	hosts, err := pcfg.discoverDomainControllers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(hosts, []string{"dc2.contoso.com", "dc1.contoso.com"}) {
+		t.Errorf("expected the domain controllers of the detected site first, got %v", hosts)
+	}
+	if !reflect.DeepEqual(pinged, []string{"dc1.contoso.com"}) {
+		t.Errorf("expected a single netlogon ping, got %v", pinged)
+	}
+
+	pinged = nil
+	pcfg.Settings.DCSite = "london"
+	if _, err := pcfg.discoverDomainControllers(context.Background()); err != nil || len(pinged) != 0 {
+		t.Errorf("expected the configured site to be used without a netlogon ping, got %v, %v", pinged, err)
+	}
+}
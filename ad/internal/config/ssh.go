@@ -0,0 +1,246 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	// ConnectionTypeWinRM runs commands through the WinRM service of the remote host.
+	ConnectionTypeWinRM = "winrm"
+	// ConnectionTypeSSH runs commands through the OpenSSH server of the remote host.
+	ConnectionTypeSSH = "ssh"
+)
+
+// sshHandshakeTimeout bounds the SSH handshake and authentication when the context has no deadline.
+const sshHandshakeTimeout = 30 * time.Second
+
+// FileCopier uploads files to the remote host. It is implemented by winrmcp and SSHClient.
+type FileCopier interface {
+	Write(toPath string, src io.Reader) error
+}
+
+// SSHSessionError is returned by SSHClient.Run when no session could be opened on the connection. The
+// command did not run, so it is safe to try again with a different connection.
+type SSHSessionError struct {
+	err error
+}
+
+func (e *SSHSessionError) Error() string {
+	return fmt.Sprintf("while opening SSH session: %s", e.err)
+}
+
+func (e *SSHSessionError) Unwrap() error {
+	return e.err
+}
+
+// SSHClient is a connection to the OpenSSH server of the remote host. Commands run in sessions of their
+// own, several of them can run at the same time, and files are copied with SFTP.
+type SSHClient struct {
+	client *ssh.Client
+	mx     sync.Mutex
+	sftp   *sftp.Client
+}
+
+// readSSHKey returns the signer of the private key of the option ssh_private_key, which is either the key
+// itself or the path of a file holding it.
+func readSSHKey(key, passphrase string) (ssh.Signer, error) {
+	if key == "" {
+		return nil, nil
+	}
+	data, err := readPEM("ssh_private_key", key)
+	if err != nil {
+		return nil, err
+	}
+	var signer ssh.Signer
+	if passphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(data, []byte(passphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("while parsing ssh_private_key: %s", err)
+	}
+	return signer, nil
+}
+
+// sshHostKeyCallback returns the callback verifying the host key of the server against the known hosts
+// file of settings, or accepting any key if SSHInsecure is set.
+func sshHostKeyCallback(settings *Settings) (ssh.HostKeyCallback, error) {
+	if settings.SSHInsecure {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+	path := settings.SSHKnownHosts
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("while looking for the known hosts file: %s", err)
+		}
+		path = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	callback, err := knownhosts.New(path)
+	if err != nil {
+		return nil, fmt.Errorf("while reading ssh_known_hosts: %s", err)
+	}
+	return callback, nil
+}
+
+// newSSHClientConfig returns the configuration authenticating with the private key and the password of
+// settings, in that order.
+func newSSHClientConfig(settings *Settings) (*ssh.ClientConfig, error) {
+	hostKeyCallback, err := sshHostKeyCallback(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	var auth []ssh.AuthMethod
+	if settings.SSHSigner != nil {
+		auth = append(auth, ssh.PublicKeys(settings.SSHSigner))
+	}
+	if settings.WinRMPassword != "" {
+		password := settings.WinRMPassword
+		auth = append(auth, ssh.Password(password),
+			ssh.KeyboardInteractive(func(_, _ string, questions []string, _ []bool) ([]string, error) {
+				answers := make([]string, len(questions))
+				for i := range answers {
+					answers[i] = password
+				}
+				return answers, nil
+			}))
+	}
+
+	return &ssh.ClientConfig{
+		User:            settings.WinRMUsername,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         sshHandshakeTimeout,
+	}, nil
+}
+
+// NewSSHClient connects to the OpenSSH server of the host settings point to. ctx bounds the connection and
+// the handshake, the client outlives it.
+func NewSSHClient(ctx context.Context, settings *Settings) (*SSHClient, error) {
+	cfg, err := newSSHClientConfig(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	addr := net.JoinHostPort(settings.WinRMHost, strconv.Itoa(settings.SSHPort))
+	conn, err := (&net.Dialer{Timeout: cfg.Timeout}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(cfg.Timeout)
+	}
+	_ = conn.SetDeadline(deadline)
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, cfg)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("while connecting to %s over SSH: %w", addr, err)
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	log.Printf("[DEBUG] Connected to %s over SSH", addr)
+	return &SSHClient{client: ssh.NewClient(sshConn, chans, reqs)}, nil
+}
+
+// Run runs command in a new session and returns its output and exit code. Cancelling ctx kills the
+// command and closes the session.
+func (c *SSHClient) Run(ctx context.Context, command string) (stdout string, stderr string, exitCode int, err error) {
+	if err := ctx.Err(); err != nil {
+		return "", "", 0, err
+	}
+	session, err := c.client.NewSession()
+	if err != nil {
+		return "", "", 0, &SSHSessionError{err: err}
+	}
+	defer session.Close()
+
+	var outBuf, errBuf bytes.Buffer
+	session.Stdout = &outBuf
+	session.Stderr = &errBuf
+	if err := session.Start(command); err != nil {
+		return "", "", 0, &SSHSessionError{err: err}
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Wait()
+	}()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		_ = session.Signal(ssh.SIGKILL)
+		_ = session.Close()
+		<-done
+		return outBuf.String(), errBuf.String(), 0, ctx.Err()
+	}
+
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		return outBuf.String(), errBuf.String(), exitErr.ExitStatus(), nil
+	}
+	return outBuf.String(), errBuf.String(), 0, err
+}
+
+// Write copies src to toPath on the remote host using SFTP. toPath is a Windows path.
+func (c *SSHClient) Write(toPath string, src io.Reader) error {
+	c.mx.Lock()
+	if c.sftp == nil {
+		client, err := sftp.NewClient(c.client)
+		if err != nil {
+			c.mx.Unlock()
+			return fmt.Errorf("while starting the SFTP subsystem: %s", err)
+		}
+		c.sftp = client
+	}
+	client := c.sftp
+	c.mx.Unlock()
+
+	f, err := client.Create(sftpPath(toPath))
+	if err != nil {
+		return fmt.Errorf("while creating %q: %s", toPath, err)
+	}
+	if _, err := f.ReadFrom(src); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("while copying to %q: %s", toPath, err)
+	}
+	return f.Close()
+}
+
+// sftpPath returns the path the OpenSSH SFTP server of Windows hosts expects for path, forward slashes
+// with a leading one before the drive letter.
+func sftpPath(path string) string {
+	path = strings.ReplaceAll(path, `\`, "/")
+	if len(path) >= 2 && path[1] == ':' {
+		path = "/" + path
+	}
+	return path
+}
+
+// Close closes the connection.
+func (c *SSHClient) Close() error {
+	c.mx.Lock()
+	if c.sftp != nil {
+		_ = c.sftp.Close()
+	}
+	c.mx.Unlock()
+	return c.client.Close()
+}
@@ -0,0 +1,262 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func newTestSSHKey(t *testing.T) (ssh.Signer, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+// testSSHServer answers exec requests with the output of run, and serves SFTP from memory.
+type testSSHServer struct {
+	listener net.Listener
+	hostKey  ssh.Signer
+	commands chan string
+	run      func(command string, out, errOut io.Writer) uint32
+}
+
+func newTestSSHServer(t *testing.T, clientKey ssh.PublicKey, password string) *testSSHServer {
+	t.Helper()
+	hostKey, _ := newTestSSHKey(t)
+	cfg := &ssh.ServerConfig{
+		PasswordCallback: func(_ ssh.ConnMetadata, p []byte) (*ssh.Permissions, error) {
+			if password == "" || string(p) != password {
+				return nil, errors.New("wrong password")
+			}
+			return nil, nil
+		},
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if clientKey == nil || !bytes.Equal(key.Marshal(), clientKey.Marshal()) {
+				return nil, errors.New("unknown key")
+			}
+			return nil, nil
+		},
+	}
+	cfg.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	s := &testSSHServer{listener: listener, hostKey: hostKey, commands: make(chan string, 10)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn, cfg)
+		}
+	}()
+	return s
+}
+
+func (s *testSSHServer) serve(conn net.Conn, cfg *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer channel.Close()
+			for req := range requests {
+				switch req.Type {
+				case "exec":
+					_ = req.Reply(true, nil)
+					command := string(req.Payload[4:])
+					s.commands <- command
+					status := s.run(command, channel, channel.Stderr())
+					_, _ = channel.SendRequest("exit-status", false, binary.BigEndian.AppendUint32(nil, status))
+					return
+				case "subsystem":
+					_ = req.Reply(string(req.Payload[4:]) == "sftp", nil)
+					server := sftp.NewRequestServer(channel, sftp.InMemHandler())
+					_ = server.Serve()
+					return
+				default:
+					_ = req.Reply(false, nil)
+				}
+			}
+		}()
+	}
+}
+
+func (s *testSSHServer) settings(t *testing.T) *Settings {
+	t.Helper()
+	host, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	portNumber, _ := strconv.Atoi(port)
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(s.listener.Addr().String())}, s.hostKey.PublicKey())
+	if err := os.WriteFile(knownHosts, []byte(line+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return &Settings{
+		ConnectionType: ConnectionTypeSSH,
+		WinRMHost:      host,
+		SSHPort:        portNumber,
+		SSHKnownHosts:  knownHosts,
+		WinRMUsername:  "svc-terraform",
+	}
+}
+
+func TestSSHClientRun(t *testing.T) {
+	clientKey, clientKeyPEM := newTestSSHKey(t)
+	server := newTestSSHServer(t, clientKey.PublicKey(), "")
+	server.run = func(command string, out, errOut io.Writer) uint32 {
+		if strings.Contains(command, "fail") {
+			_, _ = io.WriteString(errOut, "boom")
+			return 3
+		}
+		_, _ = io.WriteString(out, "dc1")
+		return 0
+	}
+
+	signer, err := readSSHKey(string(clientKeyPEM), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings := server.settings(t)
+	settings.SSHSigner = signer
+	client, err := NewSSHClient(context.Background(), settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	stdout, _, exitCode, err := client.Run(context.Background(), "powershell.exe -EncodedCommand abc")
+	if err != nil || stdout != "dc1" || exitCode != 0 {
+		t.Errorf("unexpected result %q, %d, %v", stdout, exitCode, err)
+	}
+	if command := <-server.commands; command != "powershell.exe -EncodedCommand abc" {
+		t.Errorf("unexpected command %q", command)
+	}
+	_, stderr, exitCode, err := client.Run(context.Background(), "fail")
+	if err != nil || stderr != "boom" || exitCode != 3 {
+		t.Errorf("unexpected result %q, %d, %v", stderr, exitCode, err)
+	}
+
+	// The host key of the server is not in the known hosts file.
+	other := newTestSSHServer(t, clientKey.PublicKey(), "")
+	otherSettings := other.settings(t)
+	settings.SSHPort = otherSettings.SSHPort
+	if _, err := NewSSHClient(context.Background(), settings); err == nil || !strings.Contains(err.Error(), "knownhosts") {
+		t.Errorf("expected an unknown host key, got %v", err)
+	}
+	settings.SSHInsecure = true
+	if _, err := NewSSHClient(context.Background(), settings); err != nil {
+		t.Errorf("expected any host key to be accepted, got %v", err)
+	}
+}
+
+func TestSSHClientCancel(t *testing.T) {
+	server := newTestSSHServer(t, nil, "secret")
+	release := make(chan struct{})
+	server.run = func(string, io.Writer, io.Writer) uint32 {
+		<-release
+		return 0
+	}
+	defer close(release)
+
+	settings := server.settings(t)
+	settings.WinRMPassword = "secret"
+	client, err := NewSSHClient(context.Background(), settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, _, _, err := client.Run(ctx, "Get-ADUser"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the command to be cancelled, got %v", err)
+	}
+
+	settings.WinRMPassword = "wrong"
+	if _, err := NewSSHClient(context.Background(), settings); err == nil {
+		t.Errorf("expected an authentication error")
+	}
+}
+
+func TestSSHClientWrite(t *testing.T) {
+	clientKey, _ := newTestSSHKey(t)
+	server := newTestSSHServer(t, clientKey.PublicKey(), "")
+	settings := server.settings(t)
+	settings.SSHSigner = clientKey
+	client, err := NewSSHClient(context.Background(), settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	content := "[Unicode]\r\nUnicode=yes\r\n"
+	if err := client.Write(`\GptTmpl.inf`, strings.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.sftp.Mkdir("/C:"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Write(`C:\GptTmpl.inf`, strings.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+	f, err := client.sftp.Open("/C:/GptTmpl.inf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	written, _ := io.ReadAll(f)
+	if string(written) != content {
+		t.Errorf("expected %q, got %q", content, written)
+	}
+}
+
+func TestSFTPPath(t *testing.T) {
+	for path, expected := range map[string]string{
+		`C:\Users\svc\AppData\Local\Temp\x.tmp`: "/C:/Users/svc/AppData/Local/Temp/x.tmp",
+		`\\contoso.com\SYSVOL\gpt.ini`:          "//contoso.com/SYSVOL/gpt.ini",
+		"/tmp/x":                                "/tmp/x",
+	} {
+		if actual := sftpPath(path); actual != expected {
+			t.Errorf("expected %s for %s, got %s", expected, path, actual)
+		}
+	}
+}
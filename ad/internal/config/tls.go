@@ -0,0 +1,114 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/masterzen/winrm"
+)
+
+// readPEM returns the PEM data of the option name. value is either the PEM data itself or the path of a
+// file holding it. An empty value returns nil.
+func readPEM(name, value string) ([]byte, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	data := []byte(value)
+	if !strings.HasPrefix(strings.TrimSpace(value), "-----BEGIN") {
+		content, err := os.ReadFile(value)
+		if err != nil {
+			return nil, fmt.Errorf("while reading %s: %s", name, err)
+		}
+		data = content
+	}
+	if block, _ := pem.Decode(data); block == nil {
+		return nil, fmt.Errorf("%s does not hold PEM encoded data", name)
+	}
+	return data, nil
+}
+
+// readTLSSettings reads the CA bundle, client certificate and key used to connect to WinRM over HTTPS.
+// The key pair is checked so that a mismatch is reported when the provider is configured rather than
+// on the first command.
+func readTLSSettings(caCert, clientCert, clientKey string) ([]byte, []byte, []byte, error) {
+	ca, err := readPEM("winrm_ca_cert", caCert)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if ca != nil && !x509.NewCertPool().AppendCertsFromPEM(ca) {
+		return nil, nil, nil, fmt.Errorf("winrm_ca_cert does not hold any PEM encoded certificate")
+	}
+
+	cert, err := readPEM("winrm_client_cert", clientCert)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	key, err := readPEM("winrm_client_key", clientKey)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if (cert == nil) != (key == nil) {
+		return nil, nil, nil, fmt.Errorf("winrm_client_cert and winrm_client_key must be set together")
+	}
+	if cert != nil {
+		if _, err := tls.X509KeyPair(cert, key); err != nil {
+			return nil, nil, nil, fmt.Errorf("while loading the WinRM client certificate: %s", err)
+		}
+	}
+	return ca, cert, key, nil
+}
+
+// newWinRMEndpoint returns the WinRM endpoint of the host settings point to.
+func newWinRMEndpoint(settings *Settings) *winrm.Endpoint {
+	useHTTPS := strings.ToLower(settings.WinRMProto) == "https"
+	return winrm.NewEndpoint(settings.WinRMHost, settings.WinRMPort, useHTTPS,
+		settings.WinRMInsecure, settings.WinRMCACert, settings.WinRMClientCert, settings.WinRMClientKey, 0)
+}
+
+// newWinRMTransporter returns the transporter authenticating WinRM requests. A client certificate takes
+// precedence over kerberos, which takes precedence over NTLM and basic authentication. Messages sent
+// over HTTP are sealed if message encryption is enabled, HTTPS already encrypts them.
+func newWinRMTransporter(settings *Settings) winrm.Transporter {
+	switch {
+	case settings.WinRMClientCert != nil:
+		return winrm.NewClientAuthRequestWithDial(nil)
+	case settings.WinRMMessageEncryption && strings.ToLower(settings.WinRMProto) == "http":
+		return newEncryptedTransporter(settings)
+	case settings.KrbRealm != "":
+		return NewKerberosTransporter(settings)()
+	case settings.WinRMUseNTLM:
+		return &winrm.ClientNTLM{}
+	default:
+		return winrm.NewClientWithDial(nil)
+	}
+}
+
+// certTransporter sets the client certificate on endpoints that are built without it, such as the ones
+// of winrmcp.
+type certTransporter struct {
+	winrm.Transporter
+	cert []byte
+	key  []byte
+}
+
+func (c *certTransporter) Transport(endpoint *winrm.Endpoint) error {
+	endpoint.Cert, endpoint.Key = c.cert, c.key
+	return c.Transporter.Transport(endpoint)
+}
+
+// caCertPool returns the pool of the CA certificates of endpoint, or nil to use the system pool.
+func caCertPool(endpoint *winrm.Endpoint) (*x509.CertPool, error) {
+	if len(endpoint.CACert) == 0 {
+		return nil, nil
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(endpoint.CACert) {
+		return nil, fmt.Errorf("unable to read the CA certificates")
+	}
+	return pool, nil
+}
@@ -0,0 +1,216 @@
+package config
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/masterzen/winrm"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// newTestCert returns a certificate signed by parent, or a self signed CA certificate if parent is nil.
+func newTestCert(t *testing.T, parent *testCert, template *x509.Certificate) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func newTestPKI(t *testing.T) (ca, server, client *testCert) {
+	ca = newTestCert(t, nil, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Contoso Root CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	})
+	server = newTestCert(t, ca, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "dc1.contoso.com"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	client = newTestCert(t, ca, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "svc-terraform"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	return ca, server, client
+}
+
+func TestReadPEM(t *testing.T) {
+	ca, _, _ := newTestPKI(t)
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(path, ca.certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, value := range []string{string(ca.certPEM), "\n" + string(ca.certPEM), path} {
+		data, err := readPEM("winrm_ca_cert", value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.TrimSpace(string(data)) != strings.TrimSpace(string(ca.certPEM)) {
+			t.Errorf("unexpected PEM data %q", data)
+		}
+	}
+
+	if data, err := readPEM("winrm_ca_cert", ""); data != nil || err != nil {
+		t.Errorf("expected nothing for an empty value, got %q, %v", data, err)
+	}
+	if _, err := readPEM("winrm_ca_cert", filepath.Join(t.TempDir(), "missing.pem")); err == nil {
+		t.Errorf("expected an error for a missing file")
+	}
+	notPEM := filepath.Join(t.TempDir(), "ca.der")
+	if err := os.WriteFile(notPEM, ca.cert.Raw, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readPEM("winrm_ca_cert", notPEM); err == nil || !strings.Contains(err.Error(), "PEM") {
+		t.Errorf("expected an error for a file that is not PEM encoded, got %v", err)
+	}
+}
+
+func TestReadTLSSettings(t *testing.T) {
+	ca, server, client := newTestPKI(t)
+
+	if _, _, _, err := readTLSSettings(string(ca.certPEM), string(client.certPEM), string(client.keyPEM)); err != nil {
+		t.Errorf("unexpected error %s", err)
+	}
+	if _, _, _, err := readTLSSettings("", string(client.certPEM), ""); err == nil {
+		t.Errorf("expected an error for a certificate without a key")
+	}
+	if _, _, _, err := readTLSSettings("", string(client.certPEM), string(server.keyPEM)); err == nil {
+		t.Errorf("expected an error for a key that does not match the certificate")
+	}
+	if _, _, _, err := readTLSSettings(string(client.keyPEM), "", ""); err == nil {
+		t.Errorf("expected an error for a CA bundle without certificates")
+	}
+}
+
+func TestClientCertificateAuthentication(t *testing.T) {
+	ca, server, client := newTestPKI(t)
+
+	var authorization string
+	var peers []string
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		for _, cert := range r.TLS.PeerCertificates {
+			peers = append(peers, cert.Subject.CommonName)
+		}
+		http.Error(w, "not a WinRM server", http.StatusInternalServerError)
+	}))
+	serverCert, err := tls.X509KeyPair(server.certPEM, server.keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+	srv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	host, port, _ := net.SplitHostPort(strings.TrimPrefix(srv.URL, "https://"))
+	portNumber, _ := strconv.Atoi(port)
+	settings := &Settings{
+		WinRMHost:       host,
+		WinRMPort:       portNumber,
+		WinRMProto:      "https",
+		WinRMCACert:     ca.certPEM,
+		WinRMClientCert: client.certPEM,
+		WinRMClientKey:  client.keyPEM,
+		KrbRealm:        "CONTOSO.COM",
+	}
+	if _, ok := newWinRMTransporter(settings).(*winrm.ClientAuthRequest); !ok {
+		t.Errorf("the client certificate should take precedence over kerberos")
+	}
+
+	conn, err := GetWinRMConnection(context.Background(), settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.CreateShell(); err == nil || !strings.Contains(err.Error(), "500") {
+		t.Errorf("expected the error of the server, got %v", err)
+	}
+	if authorization != "http://schemas.dmtf.org/wbem/wsman/1/wsman/secprofile/https/mutual" {
+		t.Errorf("unexpected authorization header %q", authorization)
+	}
+	if len(peers) != 1 || peers[0] != "svc-terraform" {
+		t.Errorf("expected the client certificate to be presented, got %v", peers)
+	}
+
+	// The server certificate is not trusted without the CA bundle.
+	settings.WinRMCACert = nil
+	conn, err = GetWinRMConnection(context.Background(), settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.CreateShell(); err == nil || !strings.Contains(err.Error(), "certificate") {
+		t.Errorf("expected a certificate verification error, got %v", err)
+	}
+}
+
+func TestCertTransporter(t *testing.T) {
+	_, _, client := newTestPKI(t)
+	transporter := &certTransporter{
+		Transporter: winrm.NewClientAuthRequestWithDial(nil),
+		cert:        client.certPEM,
+		key:         client.keyPEM,
+	}
+	endpoint := winrm.NewEndpoint("dc1.contoso.com", 5986, true, false, nil, nil, nil, 0)
+	if err := transporter.Transport(endpoint); err != nil {
+		t.Fatal(err)
+	}
+	if string(endpoint.Cert) != string(client.certPEM) || string(endpoint.Key) != string(client.keyPEM) {
+		t.Errorf("the client certificate was not set on the endpoint")
+	}
+}
@@ -0,0 +1,161 @@
+// Package redact keeps track of the secrets handled by the provider, such as passwords and keytabs,
+// and removes them from the text that leaves the provider: log lines, error messages and the output
+// of remote commands.
+package redact
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Placeholder replaces the secrets.
+const Placeholder = "<REDACTED>"
+
+// Registry holds secrets and scrubs them from strings. The zero value is ready to use.
+type Registry struct {
+	mx       sync.RWMutex
+	secrets  map[string]struct{}
+	replacer *strings.Replacer
+}
+
+// Register adds secrets to the registry. The JSON encoded form of each secret is registered as well,
+// since values are also logged as part of JSON documents. Empty strings are ignored.
+func (r *Registry) Register(secrets ...string) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	if r.secrets == nil {
+		r.secrets = map[string]struct{}{}
+	}
+	changed := false
+	for _, secret := range secrets {
+		if secret == "" {
+			continue
+		}
+		for _, form := range []string{secret, jsonEscape(secret)} {
+			if _, ok := r.secrets[form]; !ok {
+				r.secrets[form] = struct{}{}
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		return
+	}
+
+	// strings.Replacer picks the first match at a given position, so longer secrets go first for a
+	// secret containing another one to be scrubbed entirely.
+	sorted := make([]string, 0, len(r.secrets))
+	for secret := range r.secrets {
+		sorted = append(sorted, secret)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if len(sorted[i]) != len(sorted[j]) {
+			return len(sorted[i]) > len(sorted[j])
+		}
+		return sorted[i] < sorted[j]
+	})
+	oldnew := make([]string, 0, 2*len(sorted))
+	for _, secret := range sorted {
+		oldnew = append(oldnew, secret, Placeholder)
+	}
+	r.replacer = strings.NewReplacer(oldnew...)
+}
+
+// String returns s with every registered secret replaced by Placeholder.
+func (r *Registry) String(s string) string {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+
+	if r.replacer == nil {
+		return s
+	}
+	return r.replacer.Replace(s)
+}
+
+// Error returns err with the registered secrets scrubbed from its message. The returned error unwraps
+// to err, so that errors.Is and errors.As keep working.
+func (r *Registry) Error(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	if scrubbed := r.String(msg); scrubbed != msg {
+		return &redactedError{msg: scrubbed, err: err}
+	}
+	return err
+}
+
+// Writer returns a writer that scrubs the registered secrets before writing to w. Each call to Write
+// is scrubbed on its own, which matches the way the log package writes whole lines.
+func (r *Registry) Writer(w io.Writer) io.Writer {
+	return &writer{registry: r, out: w}
+}
+
+type writer struct {
+	registry *Registry
+	out      io.Writer
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.out, w.registry.String(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string {
+	return e.msg
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+func jsonEscape(s string) string {
+	var out strings.Builder
+	enc := json.NewEncoder(&out)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(s); err != nil {
+		return s
+	}
+	escaped := strings.TrimSpace(out.String())
+	return escaped[1 : len(escaped)-1]
+}
+
+var (
+	defaultRegistry Registry
+	filterLogOnce   sync.Once
+)
+
+// Register adds secrets to the registry used by the provider.
+func Register(secrets ...string) {
+	defaultRegistry.Register(secrets...)
+}
+
+// String scrubs the secrets registered with Register from s.
+func String(s string) string {
+	return defaultRegistry.String(s)
+}
+
+// Error scrubs the secrets registered with Register from the message of err.
+func Error(err error) error {
+	return defaultRegistry.Error(err)
+}
+
+// FilterLog scrubs the secrets registered with Register from the output of the standard logger. It must
+// be called after the plugin server set the output of the logger, and only wraps it once.
+func FilterLog() {
+	filterLogOnce.Do(func() {
+		log.SetOutput(defaultRegistry.Writer(log.Writer()))
+	})
+}
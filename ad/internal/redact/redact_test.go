@@ -0,0 +1,65 @@
+package redact
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestRegistryString(t *testing.T) {
+	var r Registry
+	if actual := r.String("nothing to hide"); actual != "nothing to hide" {
+		t.Errorf("unexpected output %q", actual)
+	}
+
+	r.Register("", "P@ss", `P@ss"word\1`, "P@ss")
+	cases := []struct {
+		input    string
+		expected string
+	}{
+		{"password is P@ss", "password is <REDACTED>"},
+		{`$Password = ConvertTo-SecureString -String "P@ss"word\1"`, `$Password = ConvertTo-SecureString -String "<REDACTED>"`},
+		{`{"AccountPassword":"P@ss\"word\\1"}`, `{"AccountPassword":"<REDACTED>"}`},
+		{"no secret here", "no secret here"},
+	}
+	for _, c := range cases {
+		if actual := r.String(c.input); actual != c.expected {
+			t.Errorf("expected %q, got %q", c.expected, actual)
+		}
+	}
+}
+
+func TestRegistryError(t *testing.T) {
+	var r Registry
+	r.Register("hunter2")
+
+	sentinel := errors.New("access denied")
+	err := r.Error(fmt.Errorf("stderr: bad password hunter2: %w", sentinel))
+	if err.Error() != "stderr: bad password <REDACTED>: access denied" {
+		t.Errorf("unexpected error %q", err)
+	}
+	if !errors.Is(err, sentinel) {
+		t.Errorf("the scrubbed error does not unwrap to the original one")
+	}
+
+	if err := r.Error(sentinel); err != sentinel {
+		t.Errorf("errors without secrets should be returned as is, got %#v", err)
+	}
+	if err := r.Error(nil); err != nil {
+		t.Errorf("expected nil, got %s", err)
+	}
+}
+
+func TestRegistryWriter(t *testing.T) {
+	var r Registry
+	var out strings.Builder
+	logger := log.New(r.Writer(&out), "", 0)
+
+	r.Register("s3cr3t")
+	logger.Printf("[DEBUG] Stdout: , Stderr: Set-ADAccountPassword : s3cr3t does not meet the requirements")
+	if expected := "[DEBUG] Stdout: , Stderr: Set-ADAccountPassword : <REDACTED> does not meet the requirements\n"; out.String() != expected {
+		t.Errorf("expected %q, got %q", expected, out.String())
+	}
+}
@@ -0,0 +1,242 @@
+package winrmhelper
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/terraform-provider-ad/ad/internal/config"
+	"github.com/hashicorp/terraform-provider-ad/ad/internal/redact"
+)
+
+// batchItemScript runs a command of a batch in a scope of its own, so that the variables of the commands
+// do not clash, and reports its outcome as a line of JSON. Errors are made terminating for the command to
+// stop at the first one, as it would when run on its own.
+const batchItemScript = `try { $tfOut = & { $ErrorActionPreference = 'Stop'; %[2]s }; ` +
+	`[pscustomobject]@{Index = %[1]d; Output = ($tfOut | Out-String).Trim(); Error = $null} | ConvertTo-Json -Compress } ` +
+	`catch { [pscustomobject]@{Index = %[1]d; Output = $null; Error = ($_ | Out-String)} | ConvertTo-Json -Compress }`
+
+// maxBatchSize is the maximum number of commands run by a batch.
+const maxBatchSize = 50
+
+// maxBatchScriptLength bounds the length of the scripts sent as the argument of powershell.exe. Command
+// lines are limited to 32767 characters, and scripts grow by 8/3 once encoded.
+const maxBatchScriptLength = 8000
+
+// batchItem is a command waiting for its batch to run.
+type batchItem struct {
+	cmd *PSCommand
+	// conf is the ProviderConf of the resource that issued the command.
+	conf   *config.ProviderConf
+	ctx    context.Context
+	length int
+	result *PSCommandResult
+	err    error
+	done   chan struct{}
+}
+
+// batcher collects the commands issued during the batch window, and runs them as a single script. Each
+// provider has its own, shared by its credential profiles: their commands carry their own credentials.
+type batcher struct {
+	mx       sync.Mutex
+	settings *config.Settings
+	pending  []*batchItem
+	length   int
+	// timer ends the batch window of the pending items.
+	timer *time.Timer
+}
+
+// RunBatched runs the command as part of a batch with the other commands issued within batch_window,
+// and returns its own result. Commands are run on their own when batching is disabled, or when they
+// cannot be combined with other ones.
+func (p *PSCommand) RunBatched(ctx context.Context, conf *config.ProviderConf) (*PSCommandResult, error) {
+	if conf.Settings.BatchWindow <= 0 || p.ExecLocally || p.InvokeCommand || conf.IsRestrictedLanguage() {
+		return p.Run(ctx, conf)
+	}
+
+	b := conf.Batcher(func() interface{} { return &batcher{settings: conf.Settings} }).(*batcher)
+	item := &batchItem{cmd: p, conf: conf, ctx: ctx, done: make(chan struct{})}
+	b.add(item)
+
+	select {
+	case <-item.done:
+	case <-ctx.Done():
+		if b.cancel(item) {
+			return nil, ctx.Err()
+		}
+		// The batch already picked the command up, it runs anyway. Its result is waited for, or an object
+		// created by a command that cannot be retried would not be tracked.
+		<-item.done
+	}
+	if item.err == nil && isRetryable(item.result, nil, !p.NonIdempotent) {
+		log.Printf("[DEBUG] Running the command of the batch again on its own: %s", item.result.StdErr)
+		return p.Run(ctx, conf)
+	}
+	return item.result, item.err
+}
+
+// add queues item, starting the batch window if it is the first one. The pending commands are run right
+// away if the batch is full.
+func (b *batcher) add(item *batchItem) {
+	item.length = len(batchItemScript) + len(item.cmd.cmd)
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	if len(b.pending) > 0 && b.length+item.length > b.maxLength() {
+		go b.run(b.take())
+	}
+	b.pending = append(b.pending, item)
+	b.length += item.length
+	switch {
+	case len(b.pending) >= maxBatchSize:
+		go b.run(b.take())
+	case len(b.pending) == 1:
+		b.timer = time.AfterFunc(b.settings.BatchWindow, b.flush)
+	}
+}
+
+// cancel removes item from the queue, unless a batch already took it. It returns true if the item was
+// removed and will not run.
+func (b *batcher) cancel(item *batchItem) bool {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	for i, pending := range b.pending {
+		if pending != item {
+			continue
+		}
+		b.pending = append(b.pending[:i], b.pending[i+1:]...)
+		b.length -= item.length
+		if len(b.pending) == 0 && b.timer != nil {
+			b.timer.Stop()
+			b.timer = nil
+		}
+		return true
+	}
+	return false
+}
+
+// maxLength returns the maximum length of the script of a batch. PSRP and persistent sessions send the
+// script in the body of messages rather than on the command line.
+func (b *batcher) maxLength() int {
+	conf := &config.ProviderConf{Settings: b.settings}
+	if conf.IsTransportPSRP() || conf.IsPersistentSessionEnabled() && !conf.IsConnectionTypeSSH() {
+		return maxBatchScriptLength * maxBatchSize
+	}
+	return maxBatchScriptLength
+}
+
+// take returns the pending items and empties the queue. It must be called with mx held.
+func (b *batcher) take() []*batchItem {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	items := b.pending
+	b.pending, b.length = nil, 0
+	return items
+}
+
+// flush runs the pending items once the batch window is over.
+func (b *batcher) flush() {
+	b.mx.Lock()
+	items := b.take()
+	b.mx.Unlock()
+	b.run(items)
+}
+
+// run runs items as a single script and hands each of them its own result.
+func (b *batcher) run(items []*batchItem) {
+	if len(items) == 0 {
+		return
+	}
+	defer func() {
+		for _, item := range items {
+			close(item.done)
+		}
+	}()
+	if len(items) == 1 {
+		items[0].result, items[0].err = items[0].cmd.Run(items[0].ctx, items[0].conf)
+		return
+	}
+
+	scripts := make([]string, len(items))
+	nonIdempotent := false
+	for i, item := range items {
+		item.cmd.prepare(item.conf)
+		scripts[i] = fmt.Sprintf(batchItemScript, i, item.cmd.cmd)
+		nonIdempotent = nonIdempotent || item.cmd.NonIdempotent
+	}
+	log.Printf("[DEBUG] Running a batch of %d commands", len(items))
+	psCmd := NewPSCommand([]string{strings.Join(scripts, "; ")}, CreatePSCommandOpts{NonIdempotent: nonIdempotent})
+	// The batch runs on behalf of several resources, it is not cancelled when one of them is. The
+	// connection is the same for all of them, only the credentials passed to the cmdlets differ.
+	result, err := psCmd.Run(context.WithoutCancel(items[0].ctx), items[0].conf)
+	if err != nil {
+		for _, item := range items {
+			item.err = err
+		}
+		return
+	}
+
+	results, parseErr := parseBatchResults(result.Stdout, len(items))
+	for i, item := range items {
+		switch {
+		case results[i] != nil:
+			item.result = results[i]
+			if item.cmd.ForceArray && item.result.Stdout != "" && item.result.Stdout[0] != '[' {
+				item.result.Stdout = fmt.Sprintf("[%s]", item.result.Stdout)
+			}
+		case parseErr != nil:
+			item.err = parseErr
+		default:
+			// The batch stopped before the command ran.
+			item.result = result
+			if item.result.ExitCode == 0 {
+				item.err = fmt.Errorf("the batch completed without running the command, stdout: %s", result.Stdout)
+			}
+		}
+	}
+}
+
+// batchResult is the outcome of a command of a batch, as reported by batchItemScript.
+type batchResult struct {
+	Index  int
+	Output *string
+	Error  *string
+}
+
+// parseBatchResults returns the results of the n commands of a batch from its output. The result of the
+// commands that did not report any is nil.
+func parseBatchResults(output string, n int) ([]*PSCommandResult, error) {
+	results := make([]*PSCommandResult, n)
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(nil, 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var r batchResult
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			return results, fmt.Errorf("while decoding the results of the batch: %s", err)
+		}
+		if r.Index < 0 || r.Index >= n {
+			return results, fmt.Errorf("unexpected result for command %d of a batch of %d", r.Index, n)
+		}
+		result := &PSCommandResult{}
+		if r.Output != nil {
+			result.Stdout = strings.TrimSpace(*r.Output)
+		}
+		if r.Error != nil {
+			result.StdErr = redact.String(strings.TrimSpace(*r.Error))
+			result.ExitCode = defaultFailedCode
+			result.ErrorRecords = config.ParseErrorRecords(result.StdErr)
+		}
+		results[r.Index] = result
+	}
+	return results, scanner.Err()
+}
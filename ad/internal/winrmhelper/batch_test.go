@@ -0,0 +1,79 @@
+package winrmhelper
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-provider-ad/ad/internal/config"
+)
+
+func TestParseBatchResults(t *testing.T) {
+	output := "{\"Index\":1,\"Output\":\"\",\"Error\":\"New-ADUser : The specified account already exists\\r\\n\"}\r\n" +
+		"\r\n" +
+		"{\"Index\":0,\"Output\":\"5d2f0b8e-1c9a-4f4b-9c1e-2a6b3f1d7e01\",\"Error\":null}\r\n"
+	results, err := parseBatchResults(output, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0] == nil || results[0].ExitCode != 0 || results[0].Stdout != "5d2f0b8e-1c9a-4f4b-9c1e-2a6b3f1d7e01" {
+		t.Errorf("unexpected result for the first command: %#v", results[0])
+	}
+	if results[1] == nil || results[1].ExitCode == 0 || !strings.Contains(results[1].StdErr, "already exists") {
+		t.Errorf("unexpected result for the second command: %#v", results[1])
+	}
+	if results[2] != nil {
+		t.Errorf("expected no result for the third command, got %#v", results[2])
+	}
+
+	if _, err := parseBatchResults("{\"Index\":2,\"Output\":\"\",\"Error\":null}", 2); err == nil {
+		t.Errorf("expected an error for a result out of the batch")
+	}
+	if _, err := parseBatchResults("The term 'ConvertTo-Json' is not recognized", 2); err == nil {
+		t.Errorf("expected an error for output that is not JSON")
+	}
+}
+
+func TestBatcherAdd(t *testing.T) {
+	// Over PSRP the scripts are not bound by the length of command lines, batches are bound by their size.
+	b := &batcher{settings: &config.Settings{BatchWindow: time.Hour, WinRMTransport: config.TransportPSRP}}
+	for i := 0; i < maxBatchSize-1; i++ {
+		b.add(&batchItem{cmd: NewPSCommand([]string{"Remove-ADUser"}, CreatePSCommandOpts{}), done: make(chan struct{})})
+	}
+	if len(b.pending) != maxBatchSize-1 || b.timer == nil {
+		t.Fatalf("expected %d pending commands waiting for the window to end, got %d", maxBatchSize-1, len(b.pending))
+	}
+	items := b.take()
+	if len(items) != maxBatchSize-1 || len(b.pending) != 0 || b.timer != nil {
+		t.Errorf("expected the pending commands to be taken, %d remain", len(b.pending))
+	}
+
+	b.settings.WinRMTransport = ""
+	if b.maxLength() != maxBatchScriptLength {
+		t.Errorf("expected batches sent on the command line to be bound to %d characters, got %d", maxBatchScriptLength, b.maxLength())
+	}
+}
+
+func TestBatcherCancel(t *testing.T) {
+	b := &batcher{settings: &config.Settings{BatchWindow: time.Hour}}
+	first := &batchItem{cmd: NewPSCommand([]string{"New-ADUser"}, CreatePSCommandOpts{}), done: make(chan struct{})}
+	second := &batchItem{cmd: NewPSCommand([]string{"New-ADGroup"}, CreatePSCommandOpts{}), done: make(chan struct{})}
+	b.add(first)
+	b.add(second)
+
+	if !b.cancel(first) {
+		t.Fatal("expected a pending command to be cancelled")
+	}
+	if len(b.pending) != 1 || b.pending[0] != second || b.length != second.length || b.timer == nil {
+		t.Errorf("expected only the second command to remain pending, got %d commands", len(b.pending))
+	}
+	if !b.cancel(second) || b.timer != nil {
+		t.Errorf("expected the batch window to end with the last pending command")
+	}
+
+	b.add(first)
+	b.take()
+	if b.cancel(first) {
+		t.Errorf("expected a command taken by a batch not to be cancelled")
+	}
+}
@@ -0,0 +1,173 @@
+package winrmhelper
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform-provider-ad/ad/internal/config"
+	"github.com/hashicorp/terraform-provider-ad/ad/internal/redact"
+)
+
+// These errors classify the failures reported by the ActiveDirectory and GroupPolicy modules, and by the
+// LDAP backend. Use errors.Is to test for them instead of matching on the text of an error.
+var (
+	// ErrNotFound is returned when the object a command operates on does not exist.
+	ErrNotFound = errors.New("object not found")
+	// ErrAlreadyExists is returned when an object cannot be created because it already exists.
+	ErrAlreadyExists = errors.New("object already exists")
+	// ErrAccessDenied is returned when the account used by the provider lacks the required permissions.
+	ErrAccessDenied = errors.New("access denied")
+	// ErrServerDown is returned when no domain controller could be contacted.
+	ErrServerDown = errors.New("server down")
+)
+
+// gpoNotFoundRe matches the errors reported by the GroupPolicy module for missing GPOs and links.
+var gpoNotFoundRe = regexp.MustCompile(`^Gpo\w*NotFound\b`)
+
+// errorRecordKind returns the classification of an error record: ErrServerDown, ErrAccessDenied,
+// ErrAlreadyExists, ErrNotFound or nil if the error does not fall in any of these.
+func errorRecordKind(r *config.ErrorRecord) error {
+	ids := strings.ToLower(strings.Join([]string{r.ExceptionType, r.Reason, r.FullyQualifiedErrorID}, " "))
+	exceptions := strings.ToLower(r.ExceptionType + " " + r.Reason)
+	msg := strings.ToLower(r.Message)
+
+	switch {
+	case r.Category == "ResourceUnavailable" || r.Category == "ConnectionError" ||
+		strings.Contains(ids, "adserverdownexception") || strings.Contains(msg, "unable to contact the server"):
+		return ErrServerDown
+	case r.Category == "PermissionDenied" || r.Category == "SecurityError" ||
+		strings.Contains(ids, "unauthorizedaccessexception") || strings.Contains(msg, "access is denied") ||
+		strings.Contains(msg, "insufficient access rights"):
+		return ErrAccessDenied
+	case r.Category == "ResourceExists" || strings.Contains(ids, "alreadyexists") ||
+		strings.Contains(msg, "already exists") || strings.Contains(msg, "already linked"):
+		return ErrAlreadyExists
+	case r.Category == "ObjectNotFound" || strings.Contains(exceptions, "notfound") || gpoNotFoundRe.MatchString(r.FullyQualifiedErrorID) ||
+		strings.Contains(msg, "there is no such object on the server") ||
+		strings.Contains(msg, "cannot find an object with identity"):
+		return ErrNotFound
+	}
+	return nil
+}
+
+// PSCommandError is returned when a powershell command exits with a non-zero exit code. It unwraps to
+// the classification of its first error record, if any.
+type PSCommandError struct {
+	Command      string
+	ExitCode     int
+	StdErr       string
+	Stdout       string
+	ErrorRecords []*config.ErrorRecord
+}
+
+// NewPSCommandError returns the error reported for a failed command.
+func NewPSCommandError(command string, result *PSCommandResult) error {
+	return &PSCommandError{
+		Command:      command,
+		ExitCode:     result.ExitCode,
+		StdErr:       result.StdErr,
+		Stdout:       result.Stdout,
+		ErrorRecords: result.ErrorRecords,
+	}
+}
+
+func (e *PSCommandError) Error() string {
+	return redact.String(fmt.Sprintf("command %s exited with a non-zero exit code(%d), stderr: %s, stdout: %s", e.Command, e.ExitCode, e.StdErr, e.Stdout))
+}
+
+func (e *PSCommandError) Unwrap() error {
+	for _, record := range e.ErrorRecords {
+		if kind := errorRecordKind(record); kind != nil {
+			return kind
+		}
+	}
+	return nil
+}
+
+// shellQuotaErrors are fragments of the faults WinRM returns when a new shell would exceed the quotas
+// of the user. Shells are created before the command is sent, so the command did not run.
+var shellQuotaErrors = []string{"maxshellsperuser", "concurrent shells", "maxshells"}
+
+// operationQuotaErrors are fragments of the faults WinRM returns when the quotas of the user are
+// exceeded by any operation, including the ones that follow the start of the command.
+var operationQuotaErrors = []string{"quotalimit", "maxconcurrentoperationsperuser", "concurrent operations"}
+
+// clockSkewErrors are fragments of the errors reported when the KDC rejects a request because the
+// clocks are out of sync. Authentication fails before the command is sent, and a new ticket is
+// requested when the command is run again.
+var clockSkewErrors = []string{"krb_ap_err_skew", "clock skew"}
+
+// http500Error matches the errors the WinRM clients return for an HTTP 500 response, which WinRM sends
+// along with its faults.
+var http500Error = regexp.MustCompile(`(http error|http response error:|request returned:|winrm request:) 500\b`)
+
+func containsAny(s string, fragments []string) bool {
+	for _, fragment := range fragments {
+		if strings.Contains(s, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+// isRetryable reports whether a command that returned result and err failed because of a transient
+// condition and can be run again. Commands that are not idempotent are only retried when the failure
+// happened before they reached the server, since running them twice could fail or create duplicates.
+func isRetryable(result *PSCommandResult, err error, idempotent bool) bool {
+	if err != nil {
+		msg := strings.ToLower(err.Error())
+		isHTTP500 := http500Error.MatchString(msg)
+		switch {
+		case containsAny(msg, clockSkewErrors):
+			return true
+		case isHTTP500 && containsAny(msg, shellQuotaErrors):
+			return true
+		case !idempotent:
+			return false
+		case isHTTP500 && containsAny(msg, operationQuotaErrors):
+			return true
+		case errors.Is(err, ErrServerDown) || strings.Contains(msg, "unable to contact the server"):
+			return true
+		}
+		return false
+	}
+
+	return idempotent && isServerDown(result, nil)
+}
+
+// isServerDown reports whether a command failed because the domain controller could not be contacted.
+func isServerDown(result *PSCommandResult, err error) bool {
+	if err != nil {
+		return errors.Is(err, ErrServerDown)
+	}
+	if result == nil || result.ExitCode == 0 {
+		return false
+	}
+	for _, record := range result.ErrorRecords {
+		if errorRecordKind(record) == ErrServerDown {
+			return true
+		}
+	}
+	return false
+}
+
+// retryBackoff returns how long to wait before the given retry attempt, starting at 0. The delay
+// doubles with every attempt up to maxBackoff, and up to half of it is random so that resources failing
+// at the same time do not retry in lockstep.
+func retryBackoff(attempt int, minBackoff, maxBackoff time.Duration) time.Duration {
+	backoff := minBackoff
+	for i := 0; i < attempt && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
@@ -0,0 +1,121 @@
+package winrmhelper
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-provider-ad/ad/internal/config"
+	"github.com/hashicorp/terraform-provider-ad/ad/internal/redact"
+)
+
+func TestIsRetryable(t *testing.T) {
+	shellQuota := errors.New("http error 500: <s:Fault>The WS-Management service cannot process the request. This user is allowed a maximum number of 5 concurrent shells, which has been exceeded.</s:Fault>")
+	operationQuota := errors.New("http error 500: <s:Fault><f:WSManFault Code=\"2150859173\"><f:Message>The WS-Management service cannot process the request. This user is allowed a maximum number of 25 concurrent operations, which has been exceeded.</f:Message></f:WSManFault></s:Fault>")
+	clockSkew := errors.New("[Root cause: KRBError] KRB Error: (37) KRB_AP_ERR_SKEW Clock skew too great")
+	serverDown := &PSCommandResult{ExitCode: 1, ErrorRecords: []*config.ErrorRecord{{Category: "ResourceUnavailable", Reason: "ADServerDownException"}}}
+	notFound := &PSCommandResult{ExitCode: 1, ErrorRecords: []*config.ErrorRecord{{Category: "ObjectNotFound", Reason: "ADIdentityNotFoundException"}}}
+
+	cases := []struct {
+		name       string
+		result     *PSCommandResult
+		err        error
+		idempotent bool
+		expected   bool
+	}{
+		{"success", &PSCommandResult{}, nil, true, false},
+		{"shell quota", nil, shellQuota, true, true},
+		{"shell quota on create", nil, shellQuota, false, true},
+		{"operation quota", nil, operationQuota, true, true},
+		{"operation quota on create", nil, operationQuota, false, false},
+		{"clock skew on create", nil, clockSkew, false, true},
+		{"server down", serverDown, nil, true, true},
+		{"server down on create", serverDown, nil, false, false},
+		{"wrapped server down", nil, fmt.Errorf("while reading: %w", ErrServerDown), true, true},
+		{"not found", notFound, nil, true, false},
+		{"other transport error", nil, errors.New("http error 401: unauthorized"), true, false},
+		{"kerberos shell quota", nil, errors.New("http error while making kerberos authenticated winRM request: 500 - 500 Internal Server Error. maximum number of 5 concurrent shells"), false, true},
+		{"quota without http 500", nil, errors.New("Get-ADUser: cannot find user 5000 in the concurrent operations group"), true, false},
+		{"http 5000", nil, errors.New("http error 5000: concurrent shells"), true, false},
+	}
+	for _, c := range cases {
+		if actual := isRetryable(c.result, c.err, c.idempotent); actual != c.expected {
+			t.Errorf("%s: expected %t, got %t", c.name, c.expected, actual)
+		}
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	minBackoff, maxBackoff := time.Second, 10*time.Second
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+	for attempt, backoff := range expected {
+		for i := 0; i < 20; i++ {
+			delay := retryBackoff(attempt, minBackoff, maxBackoff)
+			if delay < backoff/2 || delay > backoff {
+				t.Fatalf("attempt %d: expected a delay between %s and %s, got %s", attempt, backoff/2, backoff, delay)
+			}
+		}
+	}
+
+	if delay := retryBackoff(100, time.Hour, 2*time.Hour); delay > 2*time.Hour || delay < time.Hour {
+		t.Errorf("expected the delay to be capped, got %s", delay)
+	}
+	if delay := retryBackoff(3, 0, 0); delay != 0 {
+		t.Errorf("expected no delay, got %s", delay)
+	}
+}
+
+func TestPSCommandErrorRedaction(t *testing.T) {
+	redact.Register("Sup3rS3cret!")
+	err := NewPSCommandError("Set-ADAccountPassword", &PSCommandResult{
+		ExitCode: 1,
+		StdErr:   "Set-ADAccountPassword : The password Sup3rS3cret! does not meet the length, complexity, or history requirement of the domain.",
+	})
+	if strings.Contains(err.Error(), "Sup3rS3cret!") {
+		t.Errorf("the password was not redacted from %q", err)
+	}
+}
+
+func TestErrorRecordKind(t *testing.T) {
+	cases := []struct {
+		name     string
+		record   config.ErrorRecord
+		expected error
+	}{
+		{"identity not found", config.ErrorRecord{Category: "ObjectNotFound", ExceptionType: "ADIdentityNotFoundException"}, ErrNotFound},
+		{"gpo not found", config.ErrorRecord{FullyQualifiedErrorID: "GpoWithNameNotFound,Microsoft.GroupPolicy.Commands.GetGpoCommand"}, ErrNotFound},
+		{"server down", config.ErrorRecord{Category: "ResourceUnavailable", ExceptionType: "ADServerDownException"}, ErrServerDown},
+		{"access denied", config.ErrorRecord{Message: "Access is denied."}, ErrAccessDenied},
+		{"already exists", config.ErrorRecord{Category: "ResourceExists", ExceptionType: "System.ArgumentException"}, ErrAlreadyExists},
+		{"parameter binding", config.ErrorRecord{Category: "InvalidArgument", ExceptionType: "ParameterBindingException"}, nil},
+	}
+	for _, c := range cases {
+		if kind := errorRecordKind(&c.record); kind != c.expected {
+			t.Errorf("%s: expected %v, got %v", c.name, c.expected, kind)
+		}
+	}
+}
+
+func TestPSCommandError(t *testing.T) {
+	result := &PSCommandResult{
+		StdErr:   "Get-ADUser : Cannot find an object with identity",
+		ExitCode: 1,
+		ErrorRecords: []*config.ErrorRecord{
+			{Category: "ObjectNotFound", ExceptionType: "ADIdentityNotFoundException"},
+			{Category: "ResourceUnavailable", ExceptionType: "ADServerDownException"},
+		},
+	}
+	err := NewPSCommandError("Get-ADUser", result)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected %v to wrap ErrNotFound", err)
+	}
+	if errors.Is(err, ErrAlreadyExists) || errors.Is(err, ErrServerDown) {
+		t.Errorf("error should only be classified after its first record: %v", err)
+	}
+
+	if err := NewPSCommandError("Get-ADUser", &PSCommandResult{ExitCode: 1}); errors.Unwrap(err) != nil {
+		t.Errorf("expected an unclassified error, got %v", errors.Unwrap(err))
+	}
+}
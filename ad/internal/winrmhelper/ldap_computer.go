@@ -0,0 +1,139 @@
+package winrmhelper
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/hashicorp/terraform-provider-ad/ad/internal/config"
+)
+
+const (
+	uacPasswordNotRequired       = 0x00000020
+	ldapComputerClassFilter      = "(objectClass=computer)"
+	ldapComputerDefaultContainer = "CN=Computers"
+)
+
+// ldapComputerFilter returns a filter matching a computer by identity. Like Get-ADComputer, a SAM
+// account name can be given with or without the trailing dollar sign.
+func ldapComputerFilter(identity string) string {
+	filter := ldapIdentityFilter(identity)
+	if strings.HasPrefix(filter, "(sAMAccountName=") && !strings.HasSuffix(identity, "$") {
+		filter = fmt.Sprintf("(sAMAccountName=%s$)", ldap.EscapeFilter(identity))
+	}
+	return fmt.Sprintf("(&%s%s)", ldapComputerClassFilter, filter)
+}
+
+func newComputerFromLDAP(ctx context.Context, conf *config.ProviderConf, identity string) (*Computer, error) {
+	conn, err := conf.AcquireLDAPClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("while acquiring ldap client: %s", err)
+	}
+	defer conf.ReleaseLDAPClient(conn)
+
+	attributes := []string{"objectGUID", "objectSid", "distinguishedName", "cn", "sAMAccountName", "description"}
+	entry, err := ldapSearchOne(conn, identity, ldapComputerFilter(identity), attributes, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Computer{
+		Name:           entry.GetAttributeValue("cn"),
+		GUID:           ldapGUIDToString(entry.GetRawAttributeValue("objectGUID")),
+		DN:             entry.DN,
+		Description:    entry.GetAttributeValue("description"),
+		SAMAccountName: entry.GetAttributeValue("sAMAccountName"),
+		Path:           ldapParentDN(entry.DN),
+		SID:            SID{Value: ldapSIDToString(entry.GetRawAttributeValue("objectSid"))},
+	}, nil
+}
+
+func (m *Computer) createLDAP(ctx context.Context, conf *config.ProviderConf) (string, error) {
+	conn, err := conf.AcquireLDAPClient(ctx)
+	if err != nil {
+		return "", fmt.Errorf("while acquiring ldap client: %s", err)
+	}
+	defer conf.ReleaseLDAPClient(conn)
+
+	name := m.Name
+	path := m.Path
+	if path == "" {
+		path = fmt.Sprintf("%s,%s", ldapComputerDefaultContainer, conn.BaseDN)
+	}
+	samAccountName := m.SAMAccountName
+	if samAccountName == "" {
+		samAccountName = name
+	}
+	if !strings.HasSuffix(samAccountName, "$") {
+		samAccountName = fmt.Sprintf("%s$", samAccountName)
+	}
+
+	dn := fmt.Sprintf("%s,%s", ldapRDN("CN", name), path)
+	req := ldap.NewAddRequest(dn, nil)
+	req.Attribute("objectClass", []string{"top", "person", "organizationalPerson", "user", "computer"})
+	req.Attribute("sAMAccountName", []string{samAccountName})
+	req.Attribute("userAccountControl", []string{strconv.Itoa(uacWorkstationTrust | uacPasswordNotRequired)})
+	if m.Description != "" {
+		req.Attribute("description", []string{m.Description})
+	}
+
+	log.Printf("[DEBUG] Adding computer with DN %q over LDAP", dn)
+	err = conn.Add(req)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultEntryAlreadyExists) {
+			return "", ldapAlreadyExistsError(dn, err)
+		}
+		return "", fmt.Errorf("while adding computer %q: %s", dn, err)
+	}
+
+	entry, err := ldapSearchOne(conn, dn, fmt.Sprintf("(distinguishedName=%s)", ldap.EscapeFilter(dn)), []string{"objectGUID"}, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return ldapGUIDToString(entry.GetRawAttributeValue("objectGUID")), nil
+}
+
+func (m *Computer) updateLDAP(ctx context.Context, conf *config.ProviderConf, changes map[string]interface{}) error {
+	conn, err := conf.AcquireLDAPClient(ctx)
+	if err != nil {
+		return fmt.Errorf("while acquiring ldap client: %s", err)
+	}
+	defer conf.ReleaseLDAPClient(conn)
+
+	entry, err := ldapGetObject(conn, ldapComputerClassFilter, m.GUID, []string{"distinguishedName"}, nil)
+	if err != nil {
+		return err
+	}
+
+	if description, ok := changes["description"]; ok {
+		req := ldap.NewModifyRequest(entry.DN, nil)
+		ldapReplaceOrClear(req, "description", []string{description.(string)})
+		err = conn.Modify(req)
+		if err != nil {
+			return fmt.Errorf("while modifying computer description: %s", err)
+		}
+	}
+
+	if path, ok := changes["container"]; ok {
+		err = ldapMoveAndRename(conn, entry.DN, ldapRDNOf(entry.DN), path.(string))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *Computer) deleteLDAP(ctx context.Context, conf *config.ProviderConf) error {
+	conn, err := conf.AcquireLDAPClient(ctx)
+	if err != nil {
+		return fmt.Errorf("while acquiring ldap client: %s", err)
+	}
+	defer conf.ReleaseLDAPClient(conn)
+
+	return ldapDelete(conn, ldapGUIDDN(m.GUID), false)
+}
@@ -0,0 +1,186 @@
+package winrmhelper
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-provider-ad/ad/internal/config"
+)
+
+const (
+	groupTypeGlobal      = 0x00000002
+	groupTypeDomainLocal = 0x00000004
+	groupTypeUniversal   = 0x00000008
+	groupTypeSecurity    = 0x80000000
+	ldapGroupClassFilter = "(objectClass=group)"
+)
+
+// ldapGroupType returns the value of the groupType attribute for a scope and category.
+func ldapGroupType(scope, category string) (string, error) {
+	var groupType uint32
+	switch scope {
+	case "global":
+		groupType = groupTypeGlobal
+	case "domainlocal":
+		groupType = groupTypeDomainLocal
+	case "universal":
+		groupType = groupTypeUniversal
+	default:
+		return "", fmt.Errorf("invalid group scope %q", scope)
+	}
+	switch category {
+	case "security":
+		groupType |= groupTypeSecurity
+	case "distribution":
+	default:
+		return "", fmt.Errorf("invalid group category %q", category)
+	}
+	return strconv.FormatInt(int64(int32(groupType)), 10), nil
+}
+
+func (g *Group) addGroupLDAP(ctx context.Context, conf *config.ProviderConf) (string, error) {
+	conn, err := conf.AcquireLDAPClient(ctx)
+	if err != nil {
+		return "", fmt.Errorf("while acquiring ldap client: %s", err)
+	}
+	defer conf.ReleaseLDAPClient(conn)
+
+	groupType, err := ldapGroupType(g.Scope, g.Category)
+	if err != nil {
+		return "", err
+	}
+
+	dn := fmt.Sprintf("%s,%s", ldapRDN("CN", g.Name), g.Container)
+	req := ldap.NewAddRequest(dn, nil)
+	req.Attribute("objectClass", []string{"top", "group"})
+	req.Attribute("groupType", []string{groupType})
+	if g.SAMAccountName != "" {
+		req.Attribute("sAMAccountName", []string{g.SAMAccountName})
+	}
+	if g.Description != "" {
+		req.Attribute("description", []string{g.Description})
+	}
+
+	log.Printf("[DEBUG] Adding group with DN %q over LDAP", dn)
+	err = conn.Add(req)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultEntryAlreadyExists) {
+			return "", fmt.Errorf("there is another group named %q: %w", g.Name, ErrAlreadyExists)
+		}
+		return "", fmt.Errorf("while adding group %q: %s", dn, err)
+	}
+
+	entry, err := ldapSearchOne(conn, dn, fmt.Sprintf("(distinguishedName=%s)", ldap.EscapeFilter(dn)), []string{"objectGUID"}, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return ldapGUIDToString(entry.GetRawAttributeValue("objectGUID")), nil
+}
+
+func (g *Group) modifyGroupLDAP(ctx context.Context, d *schema.ResourceData, conf *config.ProviderConf) error {
+	conn, err := conf.AcquireLDAPClient(ctx)
+	if err != nil {
+		return fmt.Errorf("while acquiring ldap client: %s", err)
+	}
+	defer conf.ReleaseLDAPClient(conn)
+
+	entry, err := ldapGetObject(conn, ldapGroupClassFilter, g.GUID, []string{"distinguishedName"}, nil)
+	if err != nil {
+		return err
+	}
+	dn := entry.DN
+
+	req := ldap.NewModifyRequest(dn, nil)
+	if d.HasChange("sam_account_name") {
+		ldapReplaceOrClear(req, "sAMAccountName", []string{d.Get("sam_account_name").(string)})
+	}
+	if d.HasChange("description") {
+		ldapReplaceOrClear(req, "description", []string{d.Get("description").(string)})
+	}
+	if d.HasChanges("scope", "category") {
+		groupType, err := ldapGroupType(d.Get("scope").(string), d.Get("category").(string))
+		if err != nil {
+			return err
+		}
+		req.Replace("groupType", []string{groupType})
+	}
+
+	if len(req.Changes) > 0 {
+		err = conn.Modify(req)
+		if err != nil {
+			return fmt.Errorf("while modifying group %q: %s", dn, err)
+		}
+	}
+
+	if d.HasChanges("name", "container") {
+		err = ldapMoveAndRename(conn, dn, ldapRDN("CN", d.Get("name").(string)), d.Get("container").(string))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (g *Group) deleteGroupLDAP(ctx context.Context, conf *config.ProviderConf) error {
+	conn, err := conf.AcquireLDAPClient(ctx)
+	if err != nil {
+		return fmt.Errorf("while acquiring ldap client: %s", err)
+	}
+	defer conf.ReleaseLDAPClient(conn)
+
+	return ldapDelete(conn, ldapGUIDDN(g.GUID), false)
+}
+
+func getGroupFromLDAP(ctx context.Context, conf *config.ProviderConf, identity string) (*Group, error) {
+	conn, err := conf.AcquireLDAPClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("while acquiring ldap client: %s", err)
+	}
+	defer conf.ReleaseLDAPClient(conn)
+
+	attributes := []string{"objectGUID", "objectSid", "distinguishedName", "sAMAccountName", "cn", "groupType", "description"}
+	entry, err := ldapGetObject(conn, ldapGroupClassFilter, identity, attributes, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	groupType, err := strconv.ParseInt(entry.GetAttributeValue("groupType"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("while parsing groupType of %q: %s", entry.DN, err)
+	}
+
+	g := &Group{
+		GUID:              ldapGUIDToString(entry.GetRawAttributeValue("objectGUID")),
+		SID:               SID{Value: ldapSIDToString(entry.GetRawAttributeValue("objectSid"))},
+		SAMAccountName:    entry.GetAttributeValue("sAMAccountName"),
+		Name:              entry.GetAttributeValue("cn"),
+		DistinguishedName: entry.DN,
+		Container:         ldapParentDN(entry.DN),
+		Description:       entry.GetAttributeValue("description"),
+		Scope:             "global",
+		Category:          "distribution",
+	}
+
+	switch {
+	case groupType&groupTypeDomainLocal != 0:
+		g.ScopeNum = 0
+		g.Scope = "domainlocal"
+	case groupType&groupTypeUniversal != 0:
+		g.ScopeNum = 2
+		g.Scope = "universal"
+	default:
+		g.ScopeNum = 1
+	}
+	if uint32(groupType)&groupTypeSecurity != 0 {
+		g.CategoryNum = 1
+		g.Category = "security"
+	}
+
+	return g, nil
+}
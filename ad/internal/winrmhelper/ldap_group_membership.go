@@ -0,0 +1,219 @@
+package winrmhelper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/hashicorp/terraform-provider-ad/ad/internal/config"
+)
+
+// ldapMemberBatchSize is the number of members resolved by a single search.
+const ldapMemberBatchSize = 50
+
+// ldapGetMemberDNs returns the DNs of all the members of a group. Large groups only return a range
+// of values at a time so the member attribute is read until the last range is reached.
+func ldapGetMemberDNs(conn *config.LDAPClient, groupDN string) ([]string, error) {
+	members := []string{}
+	start := 0
+	for {
+		req := ldap.NewSearchRequest(groupDN, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 1, 0, false,
+			"(objectClass=*)", []string{fmt.Sprintf("member;range=%d-*", start)}, nil)
+		res, err := conn.Search(req)
+		if err != nil {
+			return nil, fmt.Errorf("while reading members of %q: %s", groupDN, err)
+		}
+		if len(res.Entries) == 0 {
+			return members, nil
+		}
+
+		next := -1
+		for _, attr := range res.Entries[0].Attributes {
+			name := strings.ToLower(attr.Name)
+			if name == "member" {
+				return append(members, attr.Values...), nil
+			}
+			if !strings.HasPrefix(name, "member;range=") {
+				continue
+			}
+			members = append(members, attr.Values...)
+			if strings.HasSuffix(name, "-*") {
+				return members, nil
+			}
+			end, err := strconv.Atoi(name[strings.LastIndex(name, "-")+1:])
+			if err != nil {
+				return nil, fmt.Errorf("invalid range %q while reading members of %q", attr.Name, groupDN)
+			}
+			next = end + 1
+		}
+		if next < 0 {
+			return members, nil
+		}
+		start = next
+	}
+}
+
+func ldapEntryToGroupMember(entry *ldap.Entry) *GroupMember {
+	return &GroupMember{
+		SamAccountName: entry.GetAttributeValue("sAMAccountName"),
+		DN:             entry.DN,
+		GUID:           ldapGUIDToString(entry.GetRawAttributeValue("objectGUID")),
+		Name:           entry.GetAttributeValue("name"),
+	}
+}
+
+// ldapResolveMembers looks up the objects matching a list of search filters, a batch at a time.
+func ldapResolveMembers(conn *config.LDAPClient, filters []string) ([]*GroupMember, error) {
+	members := []*GroupMember{}
+	for start := 0; start < len(filters); start += ldapMemberBatchSize {
+		end := start + ldapMemberBatchSize
+		if end > len(filters) {
+			end = len(filters)
+		}
+		filter := fmt.Sprintf("(|%s)", strings.Join(filters[start:end], ""))
+		req := ldap.NewSearchRequest(conn.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+			filter, []string{"objectGUID", "sAMAccountName", "name"}, nil)
+		res, err := conn.SearchWithPaging(req, 500)
+		if err != nil {
+			return nil, fmt.Errorf("while resolving group members: %s", err)
+		}
+		for _, entry := range res.Entries {
+			members = append(members, ldapEntryToGroupMember(entry))
+		}
+	}
+	return members, nil
+}
+
+func (g *GroupMembership) ldapGetGroupDN(conn *config.LDAPClient) (string, error) {
+	entry, err := ldapGetObject(conn, ldapGroupClassFilter, g.GroupGUID, []string{"distinguishedName"}, nil)
+	if err != nil {
+		return "", err
+	}
+	return entry.DN, nil
+}
+
+func (g *GroupMembership) getGroupMembersLDAP(conn *config.LDAPClient, groupDN string) ([]*GroupMember, error) {
+	dns, err := ldapGetMemberDNs(conn, groupDN)
+	if err != nil {
+		return nil, err
+	}
+	filters := make([]string, len(dns))
+	for idx, dn := range dns {
+		filters[idx] = fmt.Sprintf("(distinguishedName=%s)", ldap.EscapeFilter(dn))
+	}
+	return ldapResolveMembers(conn, filters)
+}
+
+// resolveExpectedMembersLDAP looks up the members listed in the resource, which can be identified by
+// any identity accepted by the ActiveDirectory powershell module.
+func (g *GroupMembership) resolveExpectedMembersLDAP(conn *config.LDAPClient, expected []*GroupMember) ([]*GroupMember, error) {
+	resolved := []*GroupMember{}
+	for _, member := range expected {
+		entry, err := ldapSearchOne(conn, member.GUID, ldapIdentityFilter(member.GUID), []string{"objectGUID", "sAMAccountName", "name"}, nil)
+		if err != nil {
+			return nil, err
+		}
+		resolved = append(resolved, ldapEntryToGroupMember(entry))
+	}
+	return resolved, nil
+}
+
+// modifyGroupMembersLDAP adds and removes members of a group in a single modify request.
+func (g *GroupMembership) modifyGroupMembersLDAP(conn *config.LDAPClient, groupDN string, toAdd, toRemove []*GroupMember) error {
+	if len(toAdd) == 0 && len(toRemove) == 0 {
+		return nil
+	}
+	req := ldap.NewModifyRequest(groupDN, nil)
+	if len(toAdd) > 0 {
+		dns := make([]string, len(toAdd))
+		for idx, m := range toAdd {
+			dns[idx] = m.DN
+		}
+		req.Add("member", dns)
+	}
+	if len(toRemove) > 0 {
+		dns := make([]string, len(toRemove))
+		for idx, m := range toRemove {
+			dns[idx] = m.DN
+		}
+		req.Delete("member", dns)
+	}
+	err := conn.Modify(req)
+	if err != nil {
+		return fmt.Errorf("while updating members of group %q: %s", groupDN, err)
+	}
+	return nil
+}
+
+func (g *GroupMembership) updateLDAP(ctx context.Context, conf *config.ProviderConf, expected []*GroupMember, removeUnexpected bool) error {
+	conn, err := conf.AcquireLDAPClient(ctx)
+	if err != nil {
+		return fmt.Errorf("while acquiring ldap client: %s", err)
+	}
+	defer conf.ReleaseLDAPClient(conn)
+
+	groupDN, err := g.ldapGetGroupDN(conn)
+	if err != nil {
+		return err
+	}
+	existing, err := g.getGroupMembersLDAP(conn, groupDN)
+	if err != nil {
+		return err
+	}
+	resolved, err := g.resolveExpectedMembersLDAP(conn, expected)
+	if err != nil {
+		return err
+	}
+
+	toAdd, toRemove := diffGroupMemberLists(resolved, existing)
+	if !removeUnexpected {
+		toRemove = nil
+	}
+	return g.modifyGroupMembersLDAP(conn, groupDN, toAdd, toRemove)
+}
+
+func (g *GroupMembership) deleteLDAP(ctx context.Context, conf *config.ProviderConf) error {
+	conn, err := conf.AcquireLDAPClient(ctx)
+	if err != nil {
+		return fmt.Errorf("while acquiring ldap client: %s", err)
+	}
+	defer conf.ReleaseLDAPClient(conn)
+
+	groupDN, err := g.ldapGetGroupDN(conn)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	existing, err := g.getGroupMembersLDAP(conn, groupDN)
+	if err != nil {
+		return err
+	}
+	return g.modifyGroupMembersLDAP(conn, groupDN, nil, existing)
+}
+
+func newGroupMembershipFromLDAP(ctx context.Context, conf *config.ProviderConf, groupID string) (*GroupMembership, error) {
+	conn, err := conf.AcquireLDAPClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("while acquiring ldap client: %s", err)
+	}
+	defer conf.ReleaseLDAPClient(conn)
+
+	result := &GroupMembership{
+		GroupGUID: groupID,
+	}
+	groupDN, err := result.ldapGetGroupDN(conn)
+	if err != nil {
+		return nil, err
+	}
+	result.GroupMembers, err = result.getGroupMembersLDAP(conn, groupDN)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
@@ -0,0 +1,252 @@
+package winrmhelper
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/hashicorp/terraform-provider-ad/ad/internal/config"
+)
+
+// ldapNotFoundError returns an error wrapping ErrNotFound, so that callers can detect missing objects
+// regardless of backend.
+func ldapNotFoundError(identity string) error {
+	return fmt.Errorf("cannot find an object with identity %q: %w", identity, ErrNotFound)
+}
+
+// ldapAlreadyExistsError returns an error wrapping ErrAlreadyExists.
+func ldapAlreadyExistsError(dn string, err error) error {
+	return fmt.Errorf("an object with DN %q already exists: %s: %w", dn, err, ErrAlreadyExists)
+}
+
+// ldapGUIDToString converts the binary representation of an objectGUID to its string form.
+func ldapGUIDToString(b []byte) string {
+	if len(b) != 16 {
+		return ""
+	}
+	return fmt.Sprintf("%08x-%04x-%04x-%s-%s",
+		binary.LittleEndian.Uint32(b[0:4]),
+		binary.LittleEndian.Uint16(b[4:6]),
+		binary.LittleEndian.Uint16(b[6:8]),
+		hex.EncodeToString(b[8:10]),
+		hex.EncodeToString(b[10:16]))
+}
+
+// ldapGUIDToBytes converts a GUID string to the binary representation used by objectGUID.
+func ldapGUIDToBytes(guid string) ([]byte, error) {
+	guid = strings.Trim(guid, "{}")
+	raw, err := hex.DecodeString(strings.ReplaceAll(guid, "-", ""))
+	if err != nil || len(raw) != 16 || len(guid) != 36 {
+		return nil, fmt.Errorf("%q is not a valid GUID", guid)
+	}
+	out := make([]byte, 16)
+	binary.LittleEndian.PutUint32(out[0:4], binary.BigEndian.Uint32(raw[0:4]))
+	binary.LittleEndian.PutUint16(out[4:6], binary.BigEndian.Uint16(raw[4:6]))
+	binary.LittleEndian.PutUint16(out[6:8], binary.BigEndian.Uint16(raw[6:8]))
+	copy(out[8:], raw[8:])
+	return out, nil
+}
+
+// ldapSIDToString converts the binary representation of a SID to its string form.
+func ldapSIDToString(b []byte) string {
+	if len(b) < 8 || len(b) < 8+4*int(b[1]) {
+		return ""
+	}
+	var authority uint64
+	for _, v := range b[2:8] {
+		authority = authority<<8 | uint64(v)
+	}
+	out := fmt.Sprintf("S-%d-%d", b[0], authority)
+	for i := 0; i < int(b[1]); i++ {
+		out = fmt.Sprintf("%s-%d", out, binary.LittleEndian.Uint32(b[8+4*i:]))
+	}
+	return out
+}
+
+// ldapSIDToBytes converts a SID string to its binary representation.
+func ldapSIDToBytes(sid string) ([]byte, error) {
+	toks := strings.Split(sid, "-")
+	if len(toks) < 3 || strings.ToUpper(toks[0]) != "S" {
+		return nil, fmt.Errorf("%q is not a valid SID", sid)
+	}
+	revision, err := strconv.ParseUint(toks[1], 10, 8)
+	if err != nil {
+		return nil, fmt.Errorf("%q is not a valid SID: %s", sid, err)
+	}
+	authority, err := strconv.ParseUint(toks[2], 10, 48)
+	if err != nil {
+		return nil, fmt.Errorf("%q is not a valid SID: %s", sid, err)
+	}
+	subAuthorities := toks[3:]
+	out := make([]byte, 8+4*len(subAuthorities))
+	out[0] = byte(revision)
+	out[1] = byte(len(subAuthorities))
+	for i := 0; i < 6; i++ {
+		out[7-i] = byte(authority >> (8 * i))
+	}
+	for i, s := range subAuthorities {
+		v, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a valid SID: %s", sid, err)
+		}
+		binary.LittleEndian.PutUint32(out[8+4*i:], uint32(v))
+	}
+	return out, nil
+}
+
+// ldapEscapeBytes escapes every byte of a binary value so it can be used in a search filter.
+func ldapEscapeBytes(b []byte) string {
+	var sb strings.Builder
+	for _, v := range b {
+		fmt.Fprintf(&sb, "\\%02x", v)
+	}
+	return sb.String()
+}
+
+var ldapAttributeTypeRegexp = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9-]*$`)
+
+// ldapIsDN returns true if the identity is a distinguished name.
+func ldapIsDN(identity string) bool {
+	dn, err := ldap.ParseDN(identity)
+	if err != nil || len(dn.RDNs) == 0 {
+		return false
+	}
+	for _, rdn := range dn.RDNs {
+		for _, attr := range rdn.Attributes {
+			if !ldapAttributeTypeRegexp.MatchString(attr.Type) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// ldapIdentityFilter returns a search filter matching an object by the same kinds of identities
+// the ActiveDirectory powershell module accepts: GUID, SID, distinguished name, UPN or SAM account name.
+func ldapIdentityFilter(identity string) string {
+	if guid, err := ldapGUIDToBytes(identity); err == nil {
+		return fmt.Sprintf("(objectGUID=%s)", ldapEscapeBytes(guid))
+	}
+	if strings.HasPrefix(strings.ToUpper(identity), "S-1-") {
+		if sid, err := ldapSIDToBytes(identity); err == nil {
+			return fmt.Sprintf("(objectSid=%s)", ldapEscapeBytes(sid))
+		}
+	}
+	if ldapIsDN(identity) {
+		return fmt.Sprintf("(distinguishedName=%s)", ldap.EscapeFilter(identity))
+	}
+	if strings.Contains(identity, "@") {
+		return fmt.Sprintf("(userPrincipalName=%s)", ldap.EscapeFilter(identity))
+	}
+	return fmt.Sprintf("(sAMAccountName=%s)", ldap.EscapeFilter(identity))
+}
+
+// ldapGUIDDN returns the extended DN form that addresses an object by its GUID.
+func ldapGUIDDN(guid string) string {
+	return fmt.Sprintf("<GUID=%s>", strings.Trim(guid, "{}"))
+}
+
+// ldapSearchOne runs a subtree search under the domain's naming context and returns exactly one entry.
+func ldapSearchOne(conn *config.LDAPClient, identity, filter string, attributes []string, controls []ldap.Control) (*ldap.Entry, error) {
+	req := ldap.NewSearchRequest(conn.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		filter, attributes, controls)
+	log.Printf("[DEBUG] Running LDAP search with filter %s", filter)
+	res, err := conn.Search(req)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return nil, ldapNotFoundError(identity)
+		}
+		return nil, fmt.Errorf("while searching for %q: %s", identity, err)
+	}
+	if len(res.Entries) == 0 {
+		return nil, ldapNotFoundError(identity)
+	}
+	if len(res.Entries) > 1 {
+		return nil, fmt.Errorf("more than one object matched identity %q", identity)
+	}
+	return res.Entries[0], nil
+}
+
+// ldapGetObject looks up an object matching classFilter by identity.
+func ldapGetObject(conn *config.LDAPClient, classFilter, identity string, attributes []string, controls []ldap.Control) (*ldap.Entry, error) {
+	filter := fmt.Sprintf("(&%s%s)", classFilter, ldapIdentityFilter(identity))
+	return ldapSearchOne(conn, identity, filter, attributes, controls)
+}
+
+// ldapParentDN returns the DN of the container of an object.
+func ldapParentDN(dn string) string {
+	escaped := false
+	for idx, c := range dn {
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == ',':
+			return dn[idx+1:]
+		}
+	}
+	return ""
+}
+
+// ldapRDNOf returns the relative distinguished name of an object.
+func ldapRDNOf(dn string) string {
+	parent := ldapParentDN(dn)
+	if parent == "" {
+		return dn
+	}
+	return dn[:len(dn)-len(parent)-1]
+}
+
+// ldapRDN builds a relative distinguished name from an attribute type and a raw value.
+func ldapRDN(attrType, value string) string {
+	return fmt.Sprintf("%s=%s", attrType, ldap.EscapeDN(value))
+}
+
+// ldapMoveAndRename moves an object under a new container and/or gives it a new relative DN.
+func ldapMoveAndRename(conn *config.LDAPClient, dn, newRDN, newContainer string) error {
+	req := ldap.NewModifyDNRequest(dn, newRDN, true, newContainer)
+	err := conn.ModifyDN(req)
+	if err != nil {
+		return fmt.Errorf("while moving object %q: %s", dn, err)
+	}
+	return nil
+}
+
+// ldapDelete removes an object. Missing objects are not considered an error.
+func ldapDelete(conn *config.LDAPClient, dn string, tree bool) error {
+	req := ldap.NewDelRequest(dn, nil)
+	if tree {
+		req.Controls = append(req.Controls, ldap.NewControlSubtreeDelete())
+	}
+	err := conn.Del(req)
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return fmt.Errorf("while removing object %q: %s", dn, err)
+	}
+	return nil
+}
+
+// ldapReplaceOrClear adds a replace or a delete operation to a modify request depending on whether a value is set.
+func ldapReplaceOrClear(req *ldap.ModifyRequest, attribute string, values []string) {
+	if len(values) == 0 || (len(values) == 1 && values[0] == "") {
+		req.Replace(attribute, []string{})
+		return
+	}
+	req.Replace(attribute, values)
+}
+
+// ldapEncodePassword returns the value of the unicodePwd attribute for a given password.
+func ldapEncodePassword(password string) string {
+	encoded := utf16.Encode([]rune(`"` + password + `"`))
+	out := make([]byte, 2*len(encoded))
+	for i, v := range encoded {
+		binary.LittleEndian.PutUint16(out[2*i:], v)
+	}
+	return string(out)
+}
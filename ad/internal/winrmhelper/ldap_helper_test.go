@@ -0,0 +1,76 @@
+package winrmhelper
+
+import (
+	"testing"
+)
+
+func TestLDAPGUIDConversion(t *testing.T) {
+	guid := "ab721a53-1e2f-11d0-9819-00aa0040529b"
+	raw, err := ldapGUIDToBytes(guid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `\53\1a\72\ab\2f\1e\d0\11\98\19\00\aa\00\40\52\9b`
+	if ldapEscapeBytes(raw) != expected {
+		t.Errorf("unexpected binary GUID. Expected %s got %s", expected, ldapEscapeBytes(raw))
+	}
+	if ldapGUIDToString(raw) != guid {
+		t.Errorf("GUID did not survive a round trip. Expected %s got %s", guid, ldapGUIDToString(raw))
+	}
+
+	if _, err := ldapGUIDToBytes("not-a-guid"); err == nil {
+		t.Errorf("expected an error while parsing an invalid GUID")
+	}
+}
+
+func TestLDAPSIDConversion(t *testing.T) {
+	for _, sid := range []string{"S-1-1-0", "S-1-5-10", "S-1-5-21-3623811015-3361044348-30300820-1013"} {
+		raw, err := ldapSIDToBytes(sid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ldapSIDToString(raw) != sid {
+			t.Errorf("SID did not survive a round trip. Expected %s got %s", sid, ldapSIDToString(raw))
+		}
+	}
+
+	raw, _ := ldapSIDToBytes("S-1-5-10")
+	expected := `\01\01\00\00\00\00\00\05\0a\00\00\00`
+	if ldapEscapeBytes(raw) != expected {
+		t.Errorf("unexpected binary SID. Expected %s got %s", expected, ldapEscapeBytes(raw))
+	}
+}
+
+func TestLDAPIdentityFilter(t *testing.T) {
+	cases := map[string]string{
+		"ab721a53-1e2f-11d0-9819-00aa0040529b":       `(objectGUID=\53\1a\72\ab\2f\1e\d0\11\98\19\00\aa\00\40\52\9b)`,
+		"S-1-5-10":                                   `(objectSid=\01\01\00\00\00\00\00\05\0a\00\00\00)`,
+		"CN=Test User,CN=Users,DC=contoso,DC=com":    `(distinguishedName=CN=Test User,CN=Users,DC=contoso,DC=com)`,
+		"testuser@contoso.com":                       `(userPrincipalName=testuser@contoso.com)`,
+		"testuser":                                   `(sAMAccountName=testuser)`,
+		"test*)(objectClass=*":                       `(sAMAccountName=test\2a\29\28objectClass=\2a)`,
+		`CN=Smith\, John,OU=Sales,DC=contoso,DC=com`: `(distinguishedName=CN=Smith\5c, John,OU=Sales,DC=contoso,DC=com)`,
+	}
+	for identity, expected := range cases {
+		if filter := ldapIdentityFilter(identity); filter != expected {
+			t.Errorf("unexpected filter for %q. Expected %s got %s", identity, expected, filter)
+		}
+	}
+}
+
+func TestLDAPParentDN(t *testing.T) {
+	dn := `CN=Smith\, John,OU=Sales,DC=contoso,DC=com`
+	if parent := ldapParentDN(dn); parent != "OU=Sales,DC=contoso,DC=com" {
+		t.Errorf("unexpected parent DN %q", parent)
+	}
+	if rdn := ldapRDNOf(dn); rdn != `CN=Smith\, John` {
+		t.Errorf("unexpected RDN %q", rdn)
+	}
+}
+
+func TestLDAPEncodePassword(t *testing.T) {
+	expected := "\"\x00p\x00w\x00\"\x00"
+	if out := ldapEncodePassword("pw"); out != expected {
+		t.Errorf("unexpected encoded password %q", out)
+	}
+}
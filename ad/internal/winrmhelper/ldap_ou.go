@@ -0,0 +1,178 @@
+package winrmhelper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/hashicorp/terraform-provider-ad/ad/internal/config"
+)
+
+const ldapOUClassFilter = "(objectClass=organizationalUnit)"
+
+// ldapSetOUProtection adds or removes the entries that protect an OU from accidental deletion. Like
+// the ActiveDirectory powershell module, protecting an OU also denies deleting children of its parent
+// but unprotecting it leaves the parent untouched.
+func ldapSetOUProtection(conn *config.LDAPClient, dn string, protected bool) error {
+	err := ldapUpdateDeny(conn, dn, protected, sidEveryone, accessMaskDelete, "")
+	if err != nil {
+		return err
+	}
+	if protected {
+		return ldapUpdateDeny(conn, ldapParentDN(dn), true, sidEveryone, accessMaskDeleteChild, "")
+	}
+	return nil
+}
+
+func newOrgUnitFromLDAP(ctx context.Context, conf *config.ProviderConf, identity, name, path string) (*OrgUnit, error) {
+	conn, err := conf.AcquireLDAPClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("while acquiring ldap client: %s", err)
+	}
+	defer conf.ReleaseLDAPClient(conn)
+
+	if identity == "" {
+		identity = fmt.Sprintf("%s,%s", ldapRDN("OU", name), path)
+	}
+
+	attributes := []string{"objectGUID", "distinguishedName", "ou", "description"}
+	entry, err := ldapGetObject(conn, ldapOUClassFilter, identity, attributes, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	sd, err := ldapGetSecurityDescriptor(conn, entry.DN)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OrgUnit{
+		Name:              entry.GetAttributeValue("ou"),
+		Description:       entry.GetAttributeValue("description"),
+		Path:              ldapParentDN(entry.DN),
+		Protected:         sd.HasDeny(sidEveryone, accessMaskDelete, ""),
+		DistinguishedName: entry.DN,
+		GUID:              ldapGUIDToString(entry.GetRawAttributeValue("objectGUID")),
+	}, nil
+}
+
+func (o *OrgUnit) createLDAP(ctx context.Context, conf *config.ProviderConf) (string, error) {
+	conn, err := conf.AcquireLDAPClient(ctx)
+	if err != nil {
+		return "", fmt.Errorf("while acquiring ldap client: %s", err)
+	}
+	defer conf.ReleaseLDAPClient(conn)
+
+	path := o.Path
+	if path == "" {
+		path = conn.BaseDN
+	}
+	dn := fmt.Sprintf("%s,%s", ldapRDN("OU", o.Name), path)
+
+	req := ldap.NewAddRequest(dn, nil)
+	req.Attribute("objectClass", []string{"top", "organizationalUnit"})
+	if o.Description != "" {
+		req.Attribute("description", []string{o.Description})
+	}
+
+	log.Printf("[DEBUG] Adding OU with DN %q over LDAP", dn)
+	err = conn.Add(req)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultEntryAlreadyExists) {
+			return "", ldapAlreadyExistsError(dn, err)
+		}
+		return "", fmt.Errorf("while adding OU %q: %s", dn, err)
+	}
+
+	if o.Protected {
+		err = ldapSetOUProtection(conn, dn, true)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	entry, err := ldapSearchOne(conn, dn, fmt.Sprintf("(distinguishedName=%s)", ldap.EscapeFilter(dn)), []string{"objectGUID"}, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return ldapGUIDToString(entry.GetRawAttributeValue("objectGUID")), nil
+}
+
+func (o *OrgUnit) updateLDAP(ctx context.Context, conf *config.ProviderConf, changes map[string]interface{}) error {
+	conn, err := conf.AcquireLDAPClient(ctx)
+	if err != nil {
+		return fmt.Errorf("while acquiring ldap client: %s", err)
+	}
+	defer conf.ReleaseLDAPClient(conn)
+
+	entry, err := ldapGetObject(conn, ldapOUClassFilter, o.GUID, []string{"distinguishedName"}, nil)
+	if err != nil {
+		return err
+	}
+	dn := entry.DN
+
+	if description, ok := changes["description"]; ok {
+		req := ldap.NewModifyRequest(dn, nil)
+		ldapReplaceOrClear(req, "description", []string{description.(string)})
+		err = conn.Modify(req)
+		if err != nil {
+			return fmt.Errorf("while modifying OU %q: %s", dn, err)
+		}
+	}
+
+	_, nameChanged := changes["name"]
+	_, pathChanged := changes["path"]
+	_, protectedChanged := changes["protected"]
+
+	if nameChanged || pathChanged {
+		// A protected OU can't be moved or renamed, so protection is lifted and restored below
+		err = ldapSetOUProtection(conn, dn, false)
+		if err != nil {
+			return err
+		}
+
+		rdn := ldapRDNOf(dn)
+		if name, ok := changes["name"]; ok {
+			rdn = ldapRDN("OU", name.(string))
+		}
+		container := ldapParentDN(dn)
+		if path, ok := changes["path"]; ok {
+			container = path.(string)
+		}
+		err = ldapMoveAndRename(conn, dn, rdn, container)
+		if err != nil {
+			return err
+		}
+		dn = fmt.Sprintf("%s,%s", rdn, container)
+	}
+
+	if nameChanged || pathChanged || protectedChanged {
+		err = ldapSetOUProtection(conn, dn, o.Protected)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (o *OrgUnit) deleteLDAP(ctx context.Context, conf *config.ProviderConf) error {
+	conn, err := conf.AcquireLDAPClient(ctx)
+	if err != nil {
+		return fmt.Errorf("while acquiring ldap client: %s", err)
+	}
+	defer conf.ReleaseLDAPClient(conn)
+
+	dn := o.DistinguishedName
+	err = ldapSetOUProtection(conn, dn, false)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	return ldapDelete(conn, dn, false)
+}
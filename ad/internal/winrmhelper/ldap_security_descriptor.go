@@ -0,0 +1,266 @@
+package winrmhelper
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/hashicorp/terraform-provider-ad/ad/internal/config"
+)
+
+const (
+	// ldapSDFlagsOID is the OID of the LDAP_SERVER_SD_FLAGS control.
+	ldapSDFlagsOID = "1.2.840.113556.1.4.801"
+	// ldapSDFlagsDACL is the BER encoded value of the SD flags control requesting only the DACL.
+	ldapSDFlagsDACL = "\x30\x03\x02\x01\x04"
+
+	aceTypeAccessDenied       = 0x01
+	aceTypeAccessDeniedObject = 0x06
+
+	aceObjectTypePresent = 0x1
+
+	sdControlDACLPresent  = 0x0004
+	sdControlSelfRelative = 0x8000
+
+	sidEveryone = "S-1-1-0"
+	sidSelf     = "S-1-5-10"
+
+	// accessMaskDelete combines DELETE and DELETE_TREE, which is what "protect from accidental deletion" denies.
+	accessMaskDelete      = 0x00010040
+	accessMaskDeleteChild = 0x00000002
+	accessMaskControl     = 0x00000100
+
+	// guidChangePassword is the User-Change-Password control access right.
+	guidChangePassword = "ab721a53-1e2f-11d0-9819-00aa0040529b"
+)
+
+// ldapSDFlagsControl returns a control that limits nTSecurityDescriptor reads and writes to the DACL.
+func ldapSDFlagsControl() ldap.Control {
+	return ldap.NewControlString(ldapSDFlagsOID, true, ldapSDFlagsDACL)
+}
+
+// ace is an access control entry. Only the fields needed to recognise the entries we manage are parsed,
+// every other entry is kept as is.
+type ace struct {
+	Type       byte
+	Flags      byte
+	Mask       uint32
+	ObjectType string
+	SID        string
+	raw        []byte
+}
+
+// securityDescriptor is a self relative security descriptor which only carries a DACL.
+type securityDescriptor struct {
+	Control uint16
+	DACL    []*ace
+}
+
+// parseSecurityDescriptor parses the DACL out of a binary self relative security descriptor.
+func parseSecurityDescriptor(b []byte) (*securityDescriptor, error) {
+	if len(b) < 20 {
+		return nil, fmt.Errorf("security descriptor is too short (%d bytes)", len(b))
+	}
+	sd := &securityDescriptor{
+		Control: binary.LittleEndian.Uint16(b[2:4]),
+		DACL:    []*ace{},
+	}
+	daclOffset := int(binary.LittleEndian.Uint32(b[16:20]))
+	if sd.Control&sdControlDACLPresent == 0 || daclOffset == 0 {
+		return sd, nil
+	}
+	if daclOffset+8 > len(b) {
+		return nil, fmt.Errorf("invalid DACL offset %d", daclOffset)
+	}
+	aceCount := int(binary.LittleEndian.Uint16(b[daclOffset+4 : daclOffset+6]))
+	pos := daclOffset + 8
+	for i := 0; i < aceCount; i++ {
+		if pos+4 > len(b) {
+			return nil, fmt.Errorf("ACE %d is out of bounds", i)
+		}
+		size := int(binary.LittleEndian.Uint16(b[pos+2 : pos+4]))
+		if size < 8 || pos+size > len(b) {
+			return nil, fmt.Errorf("ACE %d has an invalid size of %d", i, size)
+		}
+		entry, err := parseACE(b[pos : pos+size])
+		if err != nil {
+			return nil, err
+		}
+		sd.DACL = append(sd.DACL, entry)
+		pos += size
+	}
+	return sd, nil
+}
+
+func parseACE(b []byte) (*ace, error) {
+	entry := &ace{
+		Type:  b[0],
+		Flags: b[1],
+		Mask:  binary.LittleEndian.Uint32(b[4:8]),
+		raw:   append([]byte{}, b...),
+	}
+	switch entry.Type {
+	case aceTypeAccessDenied:
+		entry.SID = ldapSIDToString(b[8:])
+	case aceTypeAccessDeniedObject:
+		if len(b) < 12 {
+			return nil, fmt.Errorf("object ACE is too short")
+		}
+		flags := binary.LittleEndian.Uint32(b[8:12])
+		pos := 12
+		if flags&aceObjectTypePresent != 0 {
+			if len(b) < pos+16 {
+				return nil, fmt.Errorf("object ACE is too short")
+			}
+			entry.ObjectType = ldapGUIDToString(b[pos : pos+16])
+			pos += 16
+		}
+		if flags&0x2 != 0 {
+			pos += 16
+		}
+		if len(b) < pos {
+			return nil, fmt.Errorf("object ACE is too short")
+		}
+		entry.SID = ldapSIDToString(b[pos:])
+	}
+	return entry, nil
+}
+
+// newDenyACE builds an explicit deny entry. If objectType is set an object entry is built.
+func newDenyACE(sid string, mask uint32, objectType string) (*ace, error) {
+	sidBytes, err := ldapSIDToBytes(sid)
+	if err != nil {
+		return nil, err
+	}
+	body := &bytes.Buffer{}
+	_ = binary.Write(body, binary.LittleEndian, mask)
+	aceType := byte(aceTypeAccessDenied)
+	if objectType != "" {
+		aceType = aceTypeAccessDeniedObject
+		guid, err := ldapGUIDToBytes(objectType)
+		if err != nil {
+			return nil, err
+		}
+		_ = binary.Write(body, binary.LittleEndian, uint32(aceObjectTypePresent))
+		body.Write(guid)
+	}
+	body.Write(sidBytes)
+
+	raw := make([]byte, 4, 4+body.Len())
+	raw[0] = aceType
+	binary.LittleEndian.PutUint16(raw[2:4], uint16(4+body.Len()))
+	raw = append(raw, body.Bytes()...)
+	return &ace{Type: aceType, Mask: mask, ObjectType: objectType, SID: sid, raw: raw}, nil
+}
+
+func (a *ace) matches(sid string, mask uint32, objectType string) bool {
+	if a.SID != sid || a.Mask&mask != mask || a.ObjectType != objectType {
+		return false
+	}
+	return a.Type == aceTypeAccessDenied || a.Type == aceTypeAccessDeniedObject
+}
+
+// HasDeny returns true if an explicit deny entry for the given trustee and rights exists.
+func (sd *securityDescriptor) HasDeny(sid string, mask uint32, objectType string) bool {
+	for _, entry := range sd.DACL {
+		// 0x10 is INHERITED_ACE, inherited entries are not ours to manage
+		if entry.Flags&0x10 == 0 && entry.matches(sid, mask, objectType) {
+			return true
+		}
+	}
+	return false
+}
+
+// AddDeny adds an explicit deny entry unless one already exists. Explicit deny entries come first in
+// a canonical DACL so the new entry is prepended.
+func (sd *securityDescriptor) AddDeny(sid string, mask uint32, objectType string) error {
+	if sd.HasDeny(sid, mask, objectType) {
+		return nil
+	}
+	entry, err := newDenyACE(sid, mask, objectType)
+	if err != nil {
+		return err
+	}
+	sd.DACL = append([]*ace{entry}, sd.DACL...)
+	sd.Control |= sdControlDACLPresent
+	return nil
+}
+
+// RemoveDeny removes the explicit deny entries for the given trustee and rights.
+func (sd *securityDescriptor) RemoveDeny(sid string, mask uint32, objectType string) {
+	out := []*ace{}
+	for _, entry := range sd.DACL {
+		if entry.Flags&0x10 == 0 && entry.matches(sid, mask, objectType) {
+			continue
+		}
+		out = append(out, entry)
+	}
+	sd.DACL = out
+}
+
+// Bytes returns the binary self relative form of the security descriptor.
+func (sd *securityDescriptor) Bytes() []byte {
+	acl := &bytes.Buffer{}
+	for _, entry := range sd.DACL {
+		acl.Write(entry.raw)
+	}
+
+	out := make([]byte, 28, 28+acl.Len())
+	out[0] = 1
+	binary.LittleEndian.PutUint16(out[2:4], sd.Control|sdControlSelfRelative|sdControlDACLPresent)
+	binary.LittleEndian.PutUint32(out[16:20], 20)
+	// ACL header, revision 4 is required for object entries
+	out[20] = 4
+	binary.LittleEndian.PutUint16(out[22:24], uint16(8+acl.Len()))
+	binary.LittleEndian.PutUint16(out[24:26], uint16(len(sd.DACL)))
+	return append(out, acl.Bytes()...)
+}
+
+// ldapGetSecurityDescriptor reads the DACL of an object.
+func ldapGetSecurityDescriptor(conn *config.LDAPClient, dn string) (*securityDescriptor, error) {
+	req := ldap.NewSearchRequest(dn, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 1, 0, false,
+		"(objectClass=*)", []string{"nTSecurityDescriptor"}, []ldap.Control{ldapSDFlagsControl()})
+	res, err := conn.Search(req)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return nil, ldapNotFoundError(dn)
+		}
+		return nil, fmt.Errorf("while reading security descriptor of %q: %s", dn, err)
+	}
+	if len(res.Entries) == 0 {
+		return nil, ldapNotFoundError(dn)
+	}
+	return parseSecurityDescriptor(res.Entries[0].GetRawAttributeValue("nTSecurityDescriptor"))
+}
+
+// ldapSetSecurityDescriptor writes the DACL of an object.
+func ldapSetSecurityDescriptor(conn *config.LDAPClient, dn string, sd *securityDescriptor) error {
+	req := ldap.NewModifyRequest(dn, []ldap.Control{ldapSDFlagsControl()})
+	req.Replace("nTSecurityDescriptor", []string{string(sd.Bytes())})
+	err := conn.Modify(req)
+	if err != nil {
+		return fmt.Errorf("while updating security descriptor of %q: %s", dn, err)
+	}
+	return nil
+}
+
+// ldapUpdateDeny adds or removes an explicit deny entry on an object.
+func ldapUpdateDeny(conn *config.LDAPClient, dn string, deny bool, sid string, mask uint32, objectType string) error {
+	sd, err := ldapGetSecurityDescriptor(conn, dn)
+	if err != nil {
+		return err
+	}
+	if sd.HasDeny(sid, mask, objectType) == deny {
+		return nil
+	}
+	if deny {
+		err = sd.AddDeny(sid, mask, objectType)
+		if err != nil {
+			return err
+		}
+	} else {
+		sd.RemoveDeny(sid, mask, objectType)
+	}
+	return ldapSetSecurityDescriptor(conn, dn, sd)
+}
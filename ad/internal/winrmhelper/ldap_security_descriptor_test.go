@@ -0,0 +1,60 @@
+package winrmhelper
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSecurityDescriptorRoundTrip(t *testing.T) {
+	sd := &securityDescriptor{}
+	err := sd.AddDeny(sidEveryone, accessMaskDelete, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sd.AddDeny(sidSelf, accessMaskControl, guidChangePassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := parseSecurityDescriptor(sd.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed.DACL) != 2 {
+		t.Fatalf("expected 2 ACEs, found %d", len(parsed.DACL))
+	}
+	if !parsed.HasDeny(sidEveryone, accessMaskDelete, "") {
+		t.Errorf("deny delete ACE for everyone is missing")
+	}
+	if !parsed.HasDeny(sidSelf, accessMaskControl, guidChangePassword) {
+		t.Errorf("deny change password ACE for self is missing")
+	}
+	if parsed.HasDeny(sidEveryone, accessMaskControl, guidChangePassword) {
+		t.Errorf("unexpected deny change password ACE for everyone")
+	}
+	if !bytes.Equal(parsed.Bytes(), sd.Bytes()) {
+		t.Errorf("security descriptor did not survive a round trip")
+	}
+}
+
+func TestSecurityDescriptorAddRemove(t *testing.T) {
+	sd := &securityDescriptor{}
+	_ = sd.AddDeny(sidEveryone, accessMaskDelete, "")
+	_ = sd.AddDeny(sidEveryone, accessMaskDelete, "")
+	if len(sd.DACL) != 1 {
+		t.Errorf("adding the same ACE twice should be a no-op, found %d ACEs", len(sd.DACL))
+	}
+
+	inherited, _ := newDenyACE(sidEveryone, accessMaskDelete, "")
+	inherited.Flags = 0x10
+	inherited.raw[1] = 0x10
+	sd.DACL = append(sd.DACL, inherited)
+
+	sd.RemoveDeny(sidEveryone, accessMaskDelete, "")
+	if len(sd.DACL) != 1 || sd.DACL[0].Flags != 0x10 {
+		t.Errorf("only the explicit ACE should have been removed")
+	}
+	if sd.HasDeny(sidEveryone, accessMaskDelete, "") {
+		t.Errorf("inherited ACEs should not be reported as explicit deny entries")
+	}
+}
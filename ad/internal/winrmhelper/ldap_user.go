@@ -0,0 +1,349 @@
+package winrmhelper
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/structure"
+	"github.com/hashicorp/terraform-provider-ad/ad/internal/config"
+)
+
+const (
+	uacAccountDisable        = 0x00000002
+	uacNormalAccount         = 0x00000200
+	uacWorkstationTrust      = 0x00001000
+	uacDontExpirePassword    = 0x00010000
+	uacSmartcardRequired     = 0x00040000
+	uacTrustedForDelegation  = 0x00080000
+	ldapUserClassFilter      = "(&(objectCategory=person)(objectClass=user))"
+	ldapUserDefaultContainer = "CN=Users"
+)
+
+// ldapUserAttribute maps a resource field to the LDAP attribute that backs it.
+type ldapUserAttribute struct {
+	key       string
+	attribute string
+	field     func(u *User) *string
+}
+
+var ldapUserAttributes = []ldapUserAttribute{
+	{"sam_account_name", "sAMAccountName", func(u *User) *string { return &u.SAMAccountName }},
+	{"principal_name", "userPrincipalName", func(u *User) *string { return &u.PrincipalName }},
+	{"display_name", "displayName", func(u *User) *string { return &u.DisplayName }},
+	{"city", "l", func(u *User) *string { return &u.City }},
+	{"company", "company", func(u *User) *string { return &u.Company }},
+	{"country", "c", func(u *User) *string { return &u.Country }},
+	{"department", "department", func(u *User) *string { return &u.Department }},
+	{"description", "description", func(u *User) *string { return &u.Description }},
+	{"division", "division", func(u *User) *string { return &u.Division }},
+	{"email_address", "mail", func(u *User) *string { return &u.EmailAddress }},
+	{"employee_id", "employeeID", func(u *User) *string { return &u.EmployeeID }},
+	{"employee_number", "employeeNumber", func(u *User) *string { return &u.EmployeeNumber }},
+	{"fax", "facsimileTelephoneNumber", func(u *User) *string { return &u.Fax }},
+	{"given_name", "givenName", func(u *User) *string { return &u.GivenName }},
+	{"home_directory", "homeDirectory", func(u *User) *string { return &u.HomeDirectory }},
+	{"home_drive", "homeDrive", func(u *User) *string { return &u.HomeDrive }},
+	{"home_phone", "homePhone", func(u *User) *string { return &u.HomePhone }},
+	{"home_page", "wWWHomePage", func(u *User) *string { return &u.HomePage }},
+	{"initials", "initials", func(u *User) *string { return &u.Initials }},
+	{"mobile_phone", "mobile", func(u *User) *string { return &u.MobilePhone }},
+	{"office", "physicalDeliveryOfficeName", func(u *User) *string { return &u.Office }},
+	{"office_phone", "telephoneNumber", func(u *User) *string { return &u.OfficePhone }},
+	{"organization", "o", func(u *User) *string { return &u.Organization }},
+	{"other_name", "middleName", func(u *User) *string { return &u.OtherName }},
+	{"po_box", "postOfficeBox", func(u *User) *string { return &u.POBox }},
+	{"postal_code", "postalCode", func(u *User) *string { return &u.PostalCode }},
+	{"state", "st", func(u *User) *string { return &u.State }},
+	{"street_address", "streetAddress", func(u *User) *string { return &u.StreetAddress }},
+	{"surname", "sn", func(u *User) *string { return &u.Surname }},
+	{"title", "title", func(u *User) *string { return &u.Title }},
+}
+
+// userAccountControlFlags returns the userAccountControl bits managed through boolean resource fields.
+func (u *User) userAccountControlFlags() int64 {
+	var uac int64
+	if !u.Enabled {
+		uac |= uacAccountDisable
+	}
+	if u.PasswordNeverExpires {
+		uac |= uacDontExpirePassword
+	}
+	if u.SmartcardLogonRequired {
+		uac |= uacSmartcardRequired
+	}
+	if u.TrustedForDelegation {
+		uac |= uacTrustedForDelegation
+	}
+	return uac
+}
+
+// ldapCustomAttributeValues flattens a custom attribute value from the resource's JSON document.
+func ldapCustomAttributeValues(v interface{}) []string {
+	if reflect.ValueOf(v).Kind() == reflect.Slice {
+		out := []string{}
+		for _, item := range v.([]interface{}) {
+			out = append(out, fmt.Sprintf("%v", item))
+		}
+		return out
+	}
+	return []string{fmt.Sprintf("%v", v)}
+}
+
+// ldapSetCannotChangePassword adds or removes the entries that stop a user from changing their password.
+func ldapSetCannotChangePassword(conn *config.LDAPClient, dn string, cannotChange bool) error {
+	for _, sid := range []string{sidEveryone, sidSelf} {
+		err := ldapUpdateDeny(conn, dn, cannotChange, sid, accessMaskControl, guidChangePassword)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (u *User) newUserLDAP(ctx context.Context, conf *config.ProviderConf) (string, error) {
+	conn, err := conf.AcquireLDAPClient(ctx)
+	if err != nil {
+		return "", fmt.Errorf("while acquiring ldap client: %s", err)
+	}
+	defer conf.ReleaseLDAPClient(conn)
+
+	container := u.Container
+	if container == "" {
+		container = fmt.Sprintf("%s,%s", ldapUserDefaultContainer, conn.BaseDN)
+	}
+	dn := fmt.Sprintf("%s,%s", ldapRDN("CN", u.Username), container)
+
+	// The account is created disabled and only enabled once a password has been set, otherwise
+	// password policies would prevent the creation of the object.
+	req := ldap.NewAddRequest(dn, nil)
+	req.Attribute("objectClass", []string{"top", "person", "organizationalPerson", "user"})
+	req.Attribute("userAccountControl", []string{strconv.FormatInt(uacNormalAccount|uacAccountDisable|u.userAccountControlFlags(), 10)})
+	for _, attr := range ldapUserAttributes {
+		value := *attr.field(u)
+		if value == "" {
+			continue
+		}
+		if attr.key == "country" {
+			value = strings.ToUpper(value)
+		}
+		req.Attribute(attr.attribute, []string{value})
+	}
+	for k, v := range u.CustomAttributes {
+		req.Attribute(k, ldapCustomAttributeValues(v))
+	}
+
+	log.Printf("[DEBUG] Adding user with DN %q over LDAP", dn)
+	err = conn.Add(req)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultEntryAlreadyExists) {
+			return "", fmt.Errorf("there is another User named %q: %w", u.PrincipalName, ErrAlreadyExists)
+		}
+		return "", fmt.Errorf("while adding user %q: %s", dn, err)
+	}
+
+	if u.Password != "" {
+		req := ldap.NewModifyRequest(dn, nil)
+		req.Replace("unicodePwd", []string{ldapEncodePassword(u.Password)})
+		err = conn.Modify(req)
+		if err != nil {
+			return "", fmt.Errorf("while setting password for user %q: %s", dn, err)
+		}
+	}
+
+	if u.Enabled {
+		req := ldap.NewModifyRequest(dn, nil)
+		req.Replace("userAccountControl", []string{strconv.FormatInt(uacNormalAccount|u.userAccountControlFlags(), 10)})
+		err = conn.Modify(req)
+		if err != nil {
+			return "", fmt.Errorf("while enabling user %q: %s", dn, err)
+		}
+	}
+
+	if u.CannotChangePassword {
+		err = ldapSetCannotChangePassword(conn, dn, true)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	entry, err := ldapSearchOne(conn, dn, fmt.Sprintf("(distinguishedName=%s)", ldap.EscapeFilter(dn)), []string{"objectGUID"}, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return ldapGUIDToString(entry.GetRawAttributeValue("objectGUID")), nil
+}
+
+func (u *User) modifyUserLDAP(ctx context.Context, d *schema.ResourceData, conf *config.ProviderConf) error {
+	conn, err := conf.AcquireLDAPClient(ctx)
+	if err != nil {
+		return fmt.Errorf("while acquiring ldap client: %s", err)
+	}
+	defer conf.ReleaseLDAPClient(conn)
+
+	entry, err := ldapGetObject(conn, ldapUserClassFilter, u.GUID, []string{"distinguishedName", "userAccountControl"}, nil)
+	if err != nil {
+		return err
+	}
+	dn := entry.DN
+
+	req := ldap.NewModifyRequest(dn, nil)
+	for _, attr := range ldapUserAttributes {
+		if d.HasChange(attr.key) {
+			ldapReplaceOrClear(req, attr.attribute, []string{d.Get(attr.key).(string)})
+		}
+	}
+
+	if d.HasChanges("enabled", "password_never_expires", "smart_card_logon_required", "trusted_for_delegation") {
+		uac, err := strconv.ParseInt(entry.GetAttributeValue("userAccountControl"), 10, 64)
+		if err != nil {
+			return fmt.Errorf("while parsing userAccountControl of %q: %s", dn, err)
+		}
+		managed := int64(uacAccountDisable | uacDontExpirePassword | uacSmartcardRequired | uacTrustedForDelegation)
+		uac = uac&^managed | u.userAccountControlFlags()
+		req.Replace("userAccountControl", []string{strconv.FormatInt(uac, 10)})
+	}
+
+	if d.HasChange("custom_attributes") {
+		oldValue, newValue := d.GetChange("custom_attributes")
+		newMap, err := structure.ExpandJsonFromString(newValue.(string))
+		if err != nil {
+			return err
+		}
+		oldMap := map[string]interface{}{}
+		if oldValue.(string) != "" {
+			oldMap, err = structure.ExpandJsonFromString(oldValue.(string))
+			if err != nil {
+				return fmt.Errorf("while expanding CA json string %s: %s", oldValue.(string), err)
+			}
+		}
+		for k := range oldMap {
+			if _, ok := newMap[k]; !ok {
+				req.Replace(k, []string{})
+			}
+		}
+		for k, v := range newMap {
+			if oldVal, ok := oldMap[k]; !ok || !reflect.DeepEqual(oldVal, v) {
+				req.Replace(k, ldapCustomAttributeValues(v))
+			}
+		}
+	}
+
+	if d.HasChange("initial_password") {
+		req.Replace("unicodePwd", []string{ldapEncodePassword(d.Get("initial_password").(string))})
+	}
+
+	if len(req.Changes) > 0 {
+		err = conn.Modify(req)
+		if err != nil {
+			return fmt.Errorf("while modifying user %q: %s", dn, err)
+		}
+	}
+
+	if d.HasChange("cannot_change_password") {
+		err = ldapSetCannotChangePassword(conn, dn, d.Get("cannot_change_password").(bool))
+		if err != nil {
+			return err
+		}
+	}
+
+	if d.HasChange("container") {
+		err = ldapMoveAndRename(conn, dn, ldapRDNOf(dn), d.Get("container").(string))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (u *User) deleteUserLDAP(ctx context.Context, conf *config.ProviderConf) error {
+	conn, err := conf.AcquireLDAPClient(ctx)
+	if err != nil {
+		return fmt.Errorf("while acquiring ldap client: %s", err)
+	}
+	defer conf.ReleaseLDAPClient(conn)
+
+	return ldapDelete(conn, ldapGUIDDN(u.GUID), false)
+}
+
+func getUserFromLDAP(ctx context.Context, conf *config.ProviderConf, identity string, customAttributes []string) (*User, error) {
+	conn, err := conf.AcquireLDAPClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("while acquiring ldap client: %s", err)
+	}
+	defer conf.ReleaseLDAPClient(conn)
+
+	attributes := []string{"objectGUID", "objectSid", "distinguishedName", "userAccountControl"}
+	for _, attr := range ldapUserAttributes {
+		attributes = append(attributes, attr.attribute)
+	}
+	attributes = append(attributes, customAttributes...)
+
+	entry, err := ldapGetObject(conn, ldapUserClassFilter, identity, attributes, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &User{
+		GUID:              ldapGUIDToString(entry.GetRawAttributeValue("objectGUID")),
+		SID:               SID{Value: ldapSIDToString(entry.GetRawAttributeValue("objectSid"))},
+		DistinguishedName: entry.DN,
+		Container:         ldapParentDN(entry.DN),
+	}
+	for _, attr := range ldapUserAttributes {
+		*attr.field(user) = entry.GetEqualFoldAttributeValue(attr.attribute)
+	}
+	if user.PrincipalName != "" {
+		tokens := strings.Split(user.PrincipalName, "@")
+		user.Username = tokens[0]
+		if len(tokens) > 1 {
+			user.Domain = tokens[1]
+		}
+	}
+
+	user.UserAccountControl, err = strconv.ParseInt(entry.GetAttributeValue("userAccountControl"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("while parsing userAccountControl of %q: %s", entry.DN, err)
+	}
+	user.Enabled = user.UserAccountControl&uacAccountDisable == 0
+	user.PasswordNeverExpires = user.UserAccountControl&uacDontExpirePassword != 0
+	user.SmartcardLogonRequired = user.UserAccountControl&uacSmartcardRequired != 0
+	user.TrustedForDelegation = user.UserAccountControl&uacTrustedForDelegation != 0
+
+	sd, err := ldapGetSecurityDescriptor(conn, entry.DN)
+	if err != nil {
+		return nil, err
+	}
+	user.CannotChangePassword = sd.HasDeny(sidEveryone, accessMaskControl, guidChangePassword) &&
+		sd.HasDeny(sidSelf, accessMaskControl, guidChangePassword)
+
+	if customAttributes == nil {
+		return user, nil
+	}
+
+	user.CustomAttributes = make(map[string]interface{})
+	for _, property := range customAttributes {
+		values := entry.GetEqualFoldAttributeValues(property)
+		switch len(values) {
+		case 0:
+			continue
+		case 1:
+			user.CustomAttributes[property] = values[0]
+		default:
+			multi := make([]interface{}, len(values))
+			for idx, v := range values {
+				multi[idx] = v
+			}
+			user.CustomAttributes[property] = multi
+		}
+	}
+
+	return user, nil
+}
@@ -1,56 +1,93 @@
 package winrmhelper
 
 import (
-	"encoding/xml"
+	"context"
 	"fmt"
 	"log"
 	"strings"
+	"time"
 
 	"github.com/hashicorp/terraform-provider-ad/ad/internal/config"
+	"github.com/hashicorp/terraform-provider-ad/ad/internal/redact"
 
 	"github.com/masterzen/winrm"
 )
 
+// defaultJSONDepth is the depth of the JSON documents commands return when json_depth is not set. The
+// default depth of ConvertTo-Json, 2, turns the values of multi-valued binary attributes and the
+// sub-objects of SIDs into the name of their type.
+const defaultJSONDepth = 5
+
 type CreatePSCommandOpts struct {
-	ExecLocally     bool
-	ForceArray      bool
-	InvokeCommand   bool
-	JSONOutput      bool
+	ExecLocally   bool
+	ForceArray    bool
+	InvokeCommand bool
+	JSONOutput    bool
+	// JSONProperties projects the output on these properties before it is converted to JSON, for the
+	// documents to only hold the properties that are decoded, whatever their depth.
+	JSONProperties []string
+	// NonIdempotent marks commands, such as the ones creating objects, that must not run twice. They
+	// are only retried when they failed before reaching the server.
+	NonIdempotent   bool
 	PassCredentials bool
 	Password        string
 	Server          string
-	SkipCredPrefix  bool
 	SkipCredSuffix  bool
 	Username        string
 }
 
 type PSCommand struct {
 	CreatePSCommandOpts
-	cmd string
+	cmds []string
+	cmd  string
+	// host is the WinRM host the last attempt was sent to.
+	host string
+	// restricted is set once cmd was built for a session configuration in restricted language mode.
+	restricted bool
+	// depth is the depth of the JSON output cmd was built for.
+	depth int
 }
 
 func NewPSCommand(cmds []string, opts CreatePSCommandOpts) *PSCommand {
+	res := PSCommand{
+		CreatePSCommandOpts: opts,
+		cmds:                append([]string(nil), cmds...),
+		cmd:                 buildPSCommand(cmds, opts, false, defaultJSONDepth),
+		depth:               defaultJSONDepth,
+	}
+
+	return &res
+}
+
+// buildPSCommand appends the credentials, server and output conversion required by opts to cmds.
+// Restricted commands can be run by the session configurations of Just Enough Administration endpoints:
+// parameters are passed as literals, and no variable is assigned. JSON output is serialized up to depth
+// levels.
+func buildPSCommand(cmds []string, opts CreatePSCommandOpts, restricted bool, depth int) string {
+	if restricted {
+		cmds = append([]string(nil), cmds...)
+		for i := range cmds {
+			cmds[i] = restrictedScript(cmds[i])
+		}
+		if opts.InvokeCommand {
+			// Invoke-Command needs a script block, the commands run with the account of the session
+			// configuration instead.
+			opts.InvokeCommand, opts.PassCredentials = false, false
+		}
+	}
+
 	if opts.InvokeCommand && opts.PassCredentials {
 		invokeCmds := []string{"Invoke-Command -Authentication Kerberos"}
 		if opts.JSONOutput {
-			cmds = append(cmds, "| ConvertTo-Json")
+			cmds = append(cmds, jsonConversion(opts.JSONProperties, depth))
 		}
 
 		invokeCmds = append(invokeCmds, fmt.Sprintf("-ScriptBlock {%s}", strings.Join(cmds, " ")))
 		cmds = invokeCmds
 	}
 
-	if opts.PassCredentials {
-		if !opts.SkipCredPrefix {
-			cmdUsername := fmt.Sprintf("$User = \"%s\"\n", opts.Username)
-			cmdPassword := fmt.Sprintf("$Password = ConvertTo-SecureString -String \"%s\" -AsPlainText -Force\n", opts.Password)
-			cmds = append([]string{"$Credential = New-Object -TypeName System.Management.Automation.PSCredential -ArgumentList $User, $Password\n"}, cmds...)
-			cmds = append([]string{cmdUsername}, cmds...)
-			cmds = append([]string{cmdPassword}, cmds...)
-		}
-		if !opts.SkipCredSuffix {
-			cmds = append(cmds, "-Credential $Credential")
-		}
+	if opts.PassCredentials && !opts.SkipCredSuffix {
+		cmds = append(cmds, "-Credential "+psCredential(opts.Username, opts.Password))
 	}
 
 	if opts.PassCredentials && opts.Server != "" {
@@ -63,74 +100,166 @@ func NewPSCommand(cmds []string, opts CreatePSCommandOpts) *PSCommand {
 	}
 
 	if !opts.InvokeCommand && opts.JSONOutput {
-		cmds = append(cmds, "| ConvertTo-Json")
+		cmds = append(cmds, jsonConversion(opts.JSONProperties, depth))
 	}
 
 	cmd := strings.Join(cmds, " ")
 
-	logStr := cmd
 	if opts.PassCredentials {
-		logStr = strings.ReplaceAll(cmd, opts.Password, "<REDACTED>")
+		redact.Register(opts.Password)
 	}
-	log.Printf("[DEBUG] Constructing powerrshell command: %s ", logStr)
+	log.Printf("[DEBUG] Constructing powerrshell command: %s ", redact.String(cmd))
+	return cmd
+}
 
-	res := PSCommand{
-		CreatePSCommandOpts: opts,
-		cmd:                 cmd,
+// Run will run a powershell command and return the stdout and stderr
+// The output is converted to JSON if the json parameter is set to true.
+// Commands that fail because of a transient error are retried up to conf.Settings.MaxRetries times.
+// When the WinRM host or the domain controller cannot be reached, the next attempts use another one.
+// Cancelling ctx aborts the remote command.
+func (p *PSCommand) Run(ctx context.Context, conf *config.ProviderConf) (*PSCommandResult, error) {
+	p.prepare(conf)
+	for attempt := 0; ; attempt++ {
+		result, err := p.run(ctx, conf)
+		resend := p.failover(ctx, conf, result, err)
+		if attempt >= conf.Settings.MaxRetries || !resend && !isRetryable(result, err, !p.NonIdempotent) {
+			return result, err
+		}
+
+		delay := retryBackoff(attempt, conf.Settings.RetryMinBackoff, conf.Settings.RetryMaxBackoff)
+		if err == nil {
+			err = NewPSCommandError("powershell", result)
+		}
+		log.Printf("[WARN] Powershell command failed with a transient error, retrying in %s (attempt %d of %d): %s", delay, attempt+1, conf.Settings.MaxRetries, err)
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("while waiting to retry powershell command: %w", ctx.Err())
+		case <-time.After(delay):
+		}
 	}
+}
 
-	return &res
+// prepare builds the command again if it must be run with another language mode or output depth than the
+// ones it was built for.
+func (p *PSCommand) prepare(conf *config.ProviderConf) {
+	restricted := !p.ExecLocally && conf.IsRestrictedLanguage()
+	depth := jsonDepth(conf)
+	if restricted != p.restricted || depth != p.depth {
+		p.restricted, p.depth = restricted, depth
+		p.cmd = buildPSCommand(p.cmds, p.CreatePSCommandOpts, restricted, depth)
+	}
 }
 
-// Run will run a powershell command and return the stdout and stderr
-// The output is converted to JSON if the json parameter is set to true.
-func (p *PSCommand) Run(conf *config.ProviderConf) (*PSCommandResult, error) {
+// jsonDepth returns the depth of the JSON documents commands return.
+func jsonDepth(conf *config.ProviderConf) int {
+	if conf.Settings.JSONDepth > 0 {
+		return conf.Settings.JSONDepth
+	}
+	return defaultJSONDepth
+}
+
+// jsonConversion returns the pipeline converting the output of a command to JSON documents of depth
+// levels, projected on properties if any.
+func jsonConversion(properties []string, depth int) string {
+	conversion := fmt.Sprintf("| ConvertTo-Json -Depth %d", depth)
+	if len(properties) == 0 {
+		return conversion
+	}
+	quoted := make([]string, len(properties))
+	for i, property := range properties {
+		quoted[i] = psQuote(property)
+	}
+	return fmt.Sprintf("| Select-Object -Property %s %s", strings.Join(quoted, ","), conversion)
+}
+
+// failover marks the WinRM host or the domain controller used by the last attempt down if it could not be
+// reached, and points the command at the next one. It returns true if the command did not reach the
+// WinRM host and can be sent to another one, even if it is not idempotent.
+func (p *PSCommand) failover(ctx context.Context, conf *config.ProviderConf, result *PSCommandResult, err error) bool {
+	if p.ExecLocally {
+		return false
+	}
+	if config.IsDialError(err) {
+		return p.host != "" && conf.MarkWinRMHostDown(p.host)
+	}
+
+	// The domain controller is only passed to the cmdlets along with the credentials.
+	if !p.PassCredentials || p.Server == "" || !isServerDown(result, err) {
+		return false
+	}
+	if conf.MarkDomainControllerDown(p.Server) {
+		p.Server = conf.IdentifyDomainController(ctx)
+		p.cmd = buildPSCommand(p.cmds, p.CreatePSCommandOpts, p.restricted, p.depth)
+	}
+	return false
+}
+
+func (p *PSCommand) run(ctx context.Context, conf *config.ProviderConf) (*PSCommandResult, error) {
+	if !p.ExecLocally && conf.IsTransportPSRP() {
+		log.Printf("[DEBUG] Executing command using PSRP")
+		return p.runPSRP(ctx, conf)
+	}
+
 	var (
 		stdout string
 		stderr string
 		res    int
 		err    error
 	)
-	conn, err := conf.AcquireWinRMClient()
-	if err != nil {
-		return nil, fmt.Errorf("while acquiring winrm client: %s", err)
-	}
-	defer conf.ReleaseWinRMClient(conn)
-
 	encodedCmd := winrm.Powershell(p.cmd)
 
-	if !p.ExecLocally && conn != nil {
+	if !p.ExecLocally && conf.IsConnectionTypeSSH() {
+		log.Printf("[DEBUG] Executing command over SSH")
+		stdout, stderr, res, err = conf.RunSSH(ctx, encodedCmd)
+	} else if !p.ExecLocally && conf.IsPersistentSessionEnabled() {
+		log.Printf("[DEBUG] Executing command in persistent powershell session")
+		stdout, stderr, res, err = conf.RunInPSSession(ctx, p.cmd)
+	} else if !p.ExecLocally {
+		p.host = conf.WinRMHost(ctx)
+		conn, connErr := conf.AcquireWinRMClient(ctx)
+		if connErr != nil {
+			return nil, fmt.Errorf("while acquiring winrm client: %s", connErr)
+		}
+		defer conf.ReleaseWinRMClient(conn)
+
 		log.Printf("[DEBUG] Executing command on remote host")
-		stdout, stderr, res, err = conn.RunWithString(encodedCmd, "")
-		log.Printf("[DEBUG] Powershell command exited with code %d", res)
+		stdout, stderr, res, err = conn.RunWithContextWithString(ctx, encodedCmd, "")
 	} else {
 		log.Printf("[DEBUG] Creating local shell")
 		localShell := NewLocalPSSession()
 		log.Printf("[DEBUG] Executing command on local host")
-		stdout, stderr, res, err = localShell.ExecutePScmd(encodedCmd)
+		stdout, stderr, res, err = localShell.ExecutePScmd(ctx, encodedCmd)
 	}
 
 	if err != nil {
+		err = redact.Error(fmt.Errorf("powershell command failed with exit code %d\nstdout: %s\nstderr: %s\nerror: %w", res, stdout, stderr, err))
 		log.Printf("[DEBUG] run error : %s", err)
-		return nil, fmt.Errorf("powershell command failed with exit code %d\nstdout: %s\nstderr: %s\nerror: %s", res, stdout, stderr, err)
+		return nil, err
 	}
 
 	log.Printf("[DEBUG] Powershell command exited with code %d", res)
 	if res != 0 {
-		log.Printf("[DEBUG] Stdout: %s, Stderr: %s", stdout, stderr)
+		log.Printf("[DEBUG] Stdout: %s, Stderr: %s", redact.String(stdout), redact.String(stderr))
 	}
 
 	// Decode stderr here for the error to be human readable if we need to return early
-	stderr, xmlErr := decodeXMLCli(stderr)
-	if xmlErr != nil {
-		log.Printf("[DEBUG] stderr was not serialised as CLIXML, passing back as is")
+	errorText := stderr
+	if msg, raw, xmlErr := config.DecodeCLIXMLStderr(stderr); xmlErr != nil {
+		log.Printf("[DEBUG] stderr was not serialised as CLIXML, passing back as is: %s", xmlErr)
+	} else {
+		stderr, errorText = msg, raw
 	}
+	// Commands can echo their arguments to stderr, including the secrets, and stderr ends up in errors.
+	stderr, errorText = redact.String(stderr), redact.String(errorText)
 
 	result := &PSCommandResult{
 		Stdout:   strings.TrimSpace(stdout),
 		StdErr:   stderr,
 		ExitCode: res,
 	}
+	if res != 0 {
+		result.ErrorRecords = config.ParseErrorRecords(errorText)
+	}
 
 	if p.ForceArray && result.Stdout != "" && string(result.Stdout[0]) != "[" {
 		result.Stdout = fmt.Sprintf("[%s]", result.Stdout)
@@ -139,64 +268,65 @@ func (p *PSCommand) Run(conf *config.ProviderConf) (*PSCommandResult, error) {
 	return result, nil
 }
 
-func (p *PSCommand) String() string {
-	return p.cmd
-}
-
-// PSCommandResult holds the stdout, stderr and exit code of a powershell command
-type PSCommandResult struct {
-	Stdout   string
-	StdErr   string
-	ExitCode int
-}
+// runPSRP runs the command in a remote runspace. Output objects are kept in their deserialized form, and
+// also rendered as text so that callers can handle the result the same way regardless of the transport.
+func (p *PSCommand) runPSRP(ctx context.Context, conf *config.ProviderConf) (*PSCommandResult, error) {
+	psrpResult, err := conf.RunPSRP(ctx, p.cmd)
+	if err != nil {
+		err = redact.Error(err)
+		log.Printf("[DEBUG] run error : %s", err)
+		return nil, fmt.Errorf("powershell command failed\nerror: %w", err)
+	}
 
-type psString string
+	stdout := make([]string, 0, len(psrpResult.Output))
+	for _, value := range psrpResult.Output {
+		stdout = append(stdout, config.CLIXMLString(value))
+	}
+	errorRecords := make([]*config.ErrorRecord, 0, len(psrpResult.Errors))
+	stderr := make([]string, 0, len(psrpResult.Errors))
+	for _, obj := range psrpResult.Errors {
+		errorRecord := config.NewErrorRecord(obj)
+		errorRecords = append(errorRecords, errorRecord)
+		stderr = append(stderr, redact.String(errorRecord.String()))
+	}
 
-func (s *psString) UnmarshalText(text []byte) error {
-	str := string(text)
-	str = strings.TrimSpace(str)
-	if str[0] == '+' && len(str) > 2 {
-		*s = psString(fmt.Sprintf("\n%s", str[2:]))
-	} else {
-		*s = psString(str)
+	result := &PSCommandResult{
+		Stdout:       strings.TrimSpace(strings.Join(stdout, "\n")),
+		StdErr:       strings.Join(stderr, "\n"),
+		Objects:      psrpResult.Output,
+		ErrorRecords: errorRecords,
+	}
+	if psrpResult.State != config.PSRPPipelineCompleted || len(psrpResult.Errors) > 0 {
+		result.ExitCode = defaultFailedCode
 	}
+	log.Printf("[DEBUG] Powershell command exited with code %d", result.ExitCode)
 
-	return nil
-}
+	if p.ForceArray && result.Stdout != "" && string(result.Stdout[0]) != "[" {
+		result.Stdout = fmt.Sprintf("[%s]", result.Stdout)
+	}
 
-// PSOutput is used to unmarshall CLIXML output
-// Right now we are only using this to extract error messages, but it can be extended
-// to unpack more elements if required.
-type PSOutput struct {
-	PSStrings []psString `xml:"S"`
+	return result, nil
 }
 
-func (p *PSOutput) stringSlice() []string {
-	out := make([]string, len(p.PSStrings))
-	for idx, v := range p.PSStrings {
-		out[idx] = string(v)
-	}
-	return out
+// psCredential returns the expression creating the credential of username. It is passed inline, restricted
+// language does not allow it to be assigned to a variable.
+func psCredential(username, password string) string {
+	return fmt.Sprintf("(New-Object -TypeName System.Management.Automation.PSCredential -ArgumentList %s, %s)",
+		psQuote(username), psSecureString(password))
 }
 
-// String() return a string containing the error message that was serialised in a CLIXML message
-func (p *PSOutput) String() string {
-	str := strings.Join(p.stringSlice(), "")
-	replacer := strings.NewReplacer("_x000D_", "", "_x000A_", "")
-	str = replacer.Replace(str)
-	return str
+func (p *PSCommand) String() string {
+	return p.cmd
 }
 
-func decodeXMLCli(xmlDoc string) (string, error) {
-	// If stderr is formatted in CLIXML try to extract the error message
-	if strings.Contains(xmlDoc, "#< CLIXML") {
-		xmlDoc = strings.Replace(xmlDoc, "#< CLIXML", "", -1)
-		var v PSOutput
-		err := xml.Unmarshal([]byte(xmlDoc), &v)
-		if err != nil {
-			return "", fmt.Errorf("while unmarshalling CLIXML document: %s", err)
-		}
-		xmlDoc = strings.TrimSpace(v.String())
-	}
-	return xmlDoc, nil
+// PSCommandResult holds the stdout, stderr and exit code of a powershell command
+type PSCommandResult struct {
+	Stdout   string
+	StdErr   string
+	ExitCode int
+	// Objects holds the deserialized output objects. It is only populated by the psrp transport.
+	Objects []interface{}
+	// ErrorRecords holds the error records of a failed command. They are deserialized when using the
+	// psrp transport and parsed from stderr otherwise.
+	ErrorRecords []*config.ErrorRecord
 }
@@ -0,0 +1,35 @@
+package winrmhelper
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-provider-ad/ad/internal/config"
+)
+
+func TestJSONConversion(t *testing.T) {
+	if conversion := jsonConversion(nil, 3); conversion != "| ConvertTo-Json -Depth 3" {
+		t.Errorf("unexpected conversion %s", conversion)
+	}
+	conversion := jsonConversion([]string{"ObjectGUID", "SID", "o'Brien"}, 5)
+	expected := "| Select-Object -Property 'ObjectGUID','SID','o''Brien' | ConvertTo-Json -Depth 5"
+	if conversion != expected {
+		t.Errorf("unexpected conversion:\nactual: %s\nexpected: %s", conversion, expected)
+	}
+}
+
+func TestPSCommandJSONDepth(t *testing.T) {
+	getUser := NewPSCommandBuilder("Get-ADUser").AddParam("Identity", "jdoe").String()
+	cmd := NewPSCommand([]string{getUser}, CreatePSCommandOpts{JSONOutput: true, JSONProperties: userProperties})
+	if !strings.HasSuffix(cmd.String(), " | ConvertTo-Json -Depth 5") {
+		t.Errorf("expected the default depth, got %s", cmd.String())
+	}
+
+	cmd.prepare(&config.ProviderConf{Settings: &config.Settings{JSONDepth: 10}})
+	if !strings.HasSuffix(cmd.String(), " | ConvertTo-Json -Depth 10") {
+		t.Errorf("expected the depth of the settings, got %s", cmd.String())
+	}
+	if !strings.Contains(cmd.String(), "| Select-Object -Property 'ObjectGUID','SamAccountName',") {
+		t.Errorf("expected the output to be projected, got %s", cmd.String())
+	}
+}
@@ -0,0 +1,240 @@
+package winrmhelper
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform-provider-ad/ad/internal/redact"
+)
+
+// psParamsDecoder turns the payload back into a hashtable that is splatted on the cmdlet. ConvertFrom-Json
+// returns PSCustomObjects on Windows PowerShell 5.1, which cannot be splatted, so parameters and hashtable
+// values such as the ones passed to -Replace are copied into hashtables. Parameters listed in Secure are
+// converted to SecureStrings.
+const psParamsDecoder = `$tfPayload = [System.Text.Encoding]::UTF8.GetString([System.Convert]::FromBase64String('%s')) | ConvertFrom-Json; ` +
+	`$tfParams = @{}; ` +
+	`foreach ($tfParam in $tfPayload.Params.PSObject.Properties) { ` +
+	`$tfValue = $tfParam.Value; ` +
+	`if ($tfValue -is [System.Management.Automation.PSCustomObject]) { $tfTable = @{}; foreach ($tfEntry in $tfValue.PSObject.Properties) { $tfTable[$tfEntry.Name] = $tfEntry.Value }; $tfValue = $tfTable }; ` +
+	`$tfParams[$tfParam.Name] = $tfValue }; ` +
+	`foreach ($tfName in $tfPayload.Secure) { $tfParams[$tfName] = ConvertTo-SecureString -String $tfParams[$tfName] -AsPlainText -Force }; ` +
+	`%s @tfParams`
+
+// psParamsDecoderRe matches the scripts built from psParamsDecoder, capturing the payload and the cmdlet.
+var psParamsDecoderRe = func() *regexp.Regexp {
+	parts := strings.Split(psParamsDecoder, "%s")
+	return regexp.MustCompile(regexp.QuoteMeta(parts[0]) + `([A-Za-z0-9+/=]*)` +
+		regexp.QuoteMeta(parts[1]) + `([A-Za-z0-9-]+)` + regexp.QuoteMeta(parts[2]))
+}()
+
+// psQuoteReplacer doubles the single quotes, including the typographic ones powershell also accepts.
+var psQuoteReplacer = strings.NewReplacer("'", "''", "\u2018", "\u2018\u2018", "\u2019", "\u2019\u2019",
+	"\u201a", "\u201a\u201a", "\u201b", "\u201b\u201b")
+
+// psPayload is the document sent to the remote host.
+type psPayload struct {
+	Params map[string]interface{}
+	Secure []string `json:",omitempty"`
+}
+
+// PSCommandBuilder builds the invocation of a cmdlet without interpolating any value in the script.
+// The parameters are serialized as JSON and sent base64 encoded, and the remote side splats them on
+// the cmdlet after decoding them. Values are passed as is, they must not be escaped with SanitiseString.
+//
+// The output of String can be followed by more parameters and a pipeline, the same way as a cmdlet
+// name, for NewPSCommand to append -Credential, -Server and ConvertTo-Json.
+type PSCommandBuilder struct {
+	cmdlet string
+	params map[string]interface{}
+	secure []string
+}
+
+// NewPSCommandBuilder returns a builder for cmdlet, which must be a constant.
+func NewPSCommandBuilder(cmdlet string) *PSCommandBuilder {
+	return &PSCommandBuilder{
+		cmdlet: cmdlet,
+		params: map[string]interface{}{},
+	}
+}
+
+// AddParam sets a parameter of the cmdlet. Booleans can be used for switches, nil passes $null, and
+// maps are converted to hashtables.
+func (b *PSCommandBuilder) AddParam(name string, value interface{}) *PSCommandBuilder {
+	b.params[name] = value
+	return b
+}
+
+// AddParamIfNotEmpty sets a parameter of the cmdlet unless its value is empty.
+func (b *PSCommandBuilder) AddParamIfNotEmpty(name, value string) *PSCommandBuilder {
+	if value != "" {
+		b.params[name] = value
+	}
+	return b
+}
+
+// AddParamOrNull sets a parameter of the cmdlet, passing $null if its value is empty. Set-* cmdlets
+// clear attributes set to $null.
+func (b *PSCommandBuilder) AddParamOrNull(name, value string) *PSCommandBuilder {
+	if value == "" {
+		b.params[name] = nil
+	} else {
+		b.params[name] = value
+	}
+	return b
+}
+
+// AddSecureParam sets a parameter that is converted to a SecureString on the remote side, such as the
+// password of an account. The value is registered for redaction.
+func (b *PSCommandBuilder) AddSecureParam(name, value string) *PSCommandBuilder {
+	redact.Register(value)
+	b.params[name] = value
+	b.secure = append(b.secure, name)
+	return b
+}
+
+// Len returns the number of parameters set.
+func (b *PSCommandBuilder) Len() int {
+	return len(b.params)
+}
+
+func (b *PSCommandBuilder) payload() ([]byte, error) {
+	return json.Marshal(psPayload{Params: b.params, Secure: b.secure})
+}
+
+// String returns the script invoking the cmdlet.
+func (b *PSCommandBuilder) String() string {
+	payload, err := b.payload()
+	if err != nil {
+		// The parameters are built from strings, booleans, numbers and maps and slices of those.
+		panic(fmt.Sprintf("cannot serialize the parameters of %s: %s", b.cmdlet, err))
+	}
+	encoded := base64.StdEncoding.EncodeToString(payload)
+	if len(b.secure) > 0 {
+		// The secure values cannot be scrubbed from the encoded payload, which is logged with the command.
+		redact.Register(encoded)
+	}
+	log.Printf("[DEBUG] Parameters of %s: %s", b.cmdlet, b.redacted())
+	return fmt.Sprintf(psParamsDecoder, encoded, b.cmdlet)
+}
+
+// redacted returns the parameter names and values for logging, without the secure ones.
+func (b *PSCommandBuilder) redacted() string {
+	params := make(map[string]interface{}, len(b.params))
+	for name, value := range b.params {
+		params[name] = value
+	}
+	for _, name := range b.secure {
+		params[name] = redact.Placeholder
+	}
+	var out strings.Builder
+	enc := json.NewEncoder(&out)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(params); err != nil {
+		return err.Error()
+	}
+	return strings.TrimSpace(out.String())
+}
+
+// restrictedScript replaces the scripts built by PSCommandBuilder in script with invocations passing the
+// parameters as literals. The payload is decoded with variables, method calls and loops, which restricted
+// language does not allow.
+func restrictedScript(script string) string {
+	return psParamsDecoderRe.ReplaceAllStringFunc(script, func(match string) string {
+		m := psParamsDecoderRe.FindStringSubmatch(match)
+		raw, err := base64.StdEncoding.DecodeString(m[1])
+		if err != nil {
+			log.Printf("[DEBUG] cannot decode the parameters of %s: %s", m[2], err)
+			return match
+		}
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.UseNumber()
+		var payload psPayload
+		if err := dec.Decode(&payload); err != nil {
+			log.Printf("[DEBUG] cannot decode the parameters of %s: %s", m[2], err)
+			return match
+		}
+		return payload.literal(m[2])
+	})
+}
+
+// literal returns the invocation of cmdlet with the parameters of the payload passed as literals.
+func (p psPayload) literal(cmdlet string) string {
+	names := make([]string, 0, len(p.Params))
+	for name := range p.Params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	secure := make(map[string]bool, len(p.Secure))
+	for _, name := range p.Secure {
+		secure[name] = true
+	}
+
+	invocation := []string{cmdlet}
+	for _, name := range names {
+		value := psLiteral(p.Params[name])
+		if s, ok := p.Params[name].(string); ok && secure[name] {
+			value = psSecureString(s)
+		}
+		invocation = append(invocation, fmt.Sprintf("-%s:%s", name, value))
+	}
+	return strings.Join(invocation, " ")
+}
+
+// psLiteral returns value, decoded from JSON, as a powershell literal. Arrays are passed as comma
+// separated lists and objects as hashtables.
+func psLiteral(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "$null"
+	case bool:
+		if v {
+			return "$true"
+		}
+		return "$false"
+	case json.Number:
+		return v.String()
+	case string:
+		return psQuote(v)
+	case []interface{}:
+		if len(v) == 0 {
+			return "@()"
+		}
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			items = append(items, psLiteral(item))
+		}
+		return strings.Join(items, ",")
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		entries := make([]string, 0, len(v))
+		for _, key := range keys {
+			entries = append(entries, psQuote(key)+"="+psLiteral(v[key]))
+		}
+		return "@{" + strings.Join(entries, "; ") + "}"
+	}
+	// The payload only holds JSON values.
+	panic(fmt.Sprintf("unexpected parameter value %T", value))
+}
+
+// psQuote returns value as a single quoted string, in which powershell does not expand anything.
+func psQuote(value string) string {
+	return "'" + psQuoteReplacer.Replace(value) + "'"
+}
+
+// psSecureString returns the expression converting value to a SecureString. The value is registered for
+// redaction.
+func psSecureString(value string) string {
+	redact.Register(value, psQuoteReplacer.Replace(value))
+	return fmt.Sprintf("(ConvertTo-SecureString -String %s -AsPlainText -Force)", psQuote(value))
+}
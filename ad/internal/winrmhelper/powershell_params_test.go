@@ -0,0 +1,216 @@
+package winrmhelper
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-provider-ad/ad/internal/redact"
+)
+
+var adversarialNames = []string{
+	`$(Remove-ADUser -Identity Administrator -Confirm:$false)`,
+	"back`ticks`n and `$escaped",
+	`quote" ; Remove-ADGroup -Identity "Domain Admins`,
+	`single' ; Remove-ADGroup -Identity 'Domain Admins`,
+	"“unicode” ‘quotes’; $(whoami)",
+	"new\r\nline",
+	`@{hash=table}`,
+}
+
+var payloadRe = regexp.MustCompile(`FromBase64String\('([A-Za-z0-9+/=]*)'\)`)
+
+func decodePSPayload(t *testing.T, script string) psPayload {
+	t.Helper()
+	m := payloadRe.FindAllStringSubmatch(script, -1)
+	if len(m) != 1 {
+		t.Fatalf("expected a single payload in %q, found %d", script, len(m))
+	}
+	raw, err := base64.StdEncoding.DecodeString(m[0][1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var payload psPayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		t.Fatal(err)
+	}
+	return payload
+}
+
+func TestPSCommandBuilderAdversarialValues(t *testing.T) {
+	for _, name := range adversarialNames {
+		script := NewPSCommandBuilder("New-ADGroup").
+			AddParam("Name", name).
+			AddParam("Path", "OU="+name+",DC=contoso,DC=com").
+			AddParam("PassThru", true).
+			String()
+
+		for _, fragment := range []string{name, "$(", "`", "“", "’", "'Domain", "\r"} {
+			if strings.Contains(script, fragment) {
+				t.Errorf("value %q leaked into the script: %s", fragment, script)
+			}
+		}
+		if !strings.HasSuffix(script, "; New-ADGroup @tfParams") {
+			t.Errorf("unexpected invocation in %s", script)
+		}
+
+		payload := decodePSPayload(t, script)
+		expected := map[string]interface{}{
+			"Name":     name,
+			"Path":     "OU=" + name + ",DC=contoso,DC=com",
+			"PassThru": true,
+		}
+		if !reflect.DeepEqual(payload.Params, expected) {
+			t.Errorf("parameters did not survive a round trip:\nactual: %#v\nexpected: %#v", payload.Params, expected)
+		}
+	}
+}
+
+func TestPSCommandBuilderParams(t *testing.T) {
+	cmd := NewPSCommandBuilder("Set-ADUser").
+		AddParam("Identity", "d5b5a5b4-6e3c-4f6b-9d1e-1d2a3b4c5d6e").
+		AddParamIfNotEmpty("Title", "").
+		AddParamIfNotEmpty("Office", adversarialNames[4]).
+		AddParamOrNull("Description", "").
+		AddParam("Clear", []string{"extensionAttribute1"}).
+		AddParam("Replace", map[string]interface{}{"otherTelephone": []string{"1", adversarialNames[0]}}).
+		AddSecureParam("NewPassword", "P@ss`word$(1)")
+
+	if cmd.Len() != 6 {
+		t.Errorf("expected 6 parameters, found %d", cmd.Len())
+	}
+
+	payload := decodePSPayload(t, cmd.String())
+	expected := map[string]interface{}{
+		"Identity":    "d5b5a5b4-6e3c-4f6b-9d1e-1d2a3b4c5d6e",
+		"Office":      adversarialNames[4],
+		"Description": nil,
+		"Clear":       []interface{}{"extensionAttribute1"},
+		"Replace":     map[string]interface{}{"otherTelephone": []interface{}{"1", adversarialNames[0]}},
+		"NewPassword": "P@ss`word$(1)",
+	}
+	if !reflect.DeepEqual(payload.Params, expected) {
+		t.Errorf("unexpected parameters:\nactual: %#v\nexpected: %#v", payload.Params, expected)
+	}
+	if !reflect.DeepEqual(payload.Secure, []string{"NewPassword"}) {
+		t.Errorf("unexpected secure parameters %v", payload.Secure)
+	}
+
+	if redacted := cmd.redacted(); strings.Contains(redacted, "P@ss") || !strings.Contains(redacted, "<REDACTED>") {
+		t.Errorf("secure parameter was not redacted: %s", redacted)
+	}
+	if logged := redact.String(cmd.String()); payloadRe.MatchString(logged) {
+		t.Errorf("the payload holding a secure parameter was not redacted: %s", logged)
+	}
+}
+
+func TestPSCommandBuilderWithCredentials(t *testing.T) {
+	builder := NewPSCommandBuilder("Get-ADUser").AddParam("Identity", adversarialNames[0])
+	cmd := NewPSCommand([]string{builder.String()}, CreatePSCommandOpts{
+		JSONOutput:      true,
+		PassCredentials: true,
+		Username:        "CONTOSO\\admin",
+		Password:        "secret",
+		Server:          "dc1.contoso.com",
+	})
+
+	credential := "(New-Object -TypeName System.Management.Automation.PSCredential -ArgumentList 'CONTOSO\\admin', " +
+		"(ConvertTo-SecureString -String 'secret' -AsPlainText -Force))"
+	if !strings.HasSuffix(cmd.String(), "Get-ADUser @tfParams -Credential "+credential+" -Server dc1.contoso.com | ConvertTo-Json -Depth 5") {
+		t.Errorf("unexpected command %s", cmd.String())
+	}
+	if strings.Contains(cmd.String(), adversarialNames[0]) {
+		t.Errorf("value leaked into the command %s", cmd.String())
+	}
+	if logged := redact.String(cmd.String()); strings.Contains(logged, "secret") {
+		t.Errorf("the password was not redacted from %s", logged)
+	}
+}
+
+// unquotePS returns the value of a single quoted powershell string, or false if quoted is not one.
+func unquotePS(quoted string) (string, bool) {
+	runes := []rune(quoted)
+	isQuote := func(r rune) bool { return strings.ContainsRune("'\u2018\u2019\u201a\u201b", r) }
+	if len(runes) < 2 || !isQuote(runes[0]) || !isQuote(runes[len(runes)-1]) {
+		return "", false
+	}
+	var value []rune
+	for i := 1; i < len(runes)-1; i++ {
+		if isQuote(runes[i]) {
+			// A quote inside the string must be escaped by another one.
+			if i+1 >= len(runes)-1 || !isQuote(runes[i+1]) {
+				return "", false
+			}
+			i++
+		}
+		value = append(value, runes[i])
+	}
+	return string(value), true
+}
+
+func TestPSQuote(t *testing.T) {
+	for _, name := range adversarialNames {
+		value, ok := unquotePS(psQuote(name))
+		if !ok || value != name {
+			t.Errorf("%q does not quote %q, got %q", psQuote(name), name, value)
+		}
+	}
+}
+
+func TestRestrictedScript(t *testing.T) {
+	script := NewPSCommandBuilder("Set-ADUser").
+		AddParam("Identity", adversarialNames[3]).
+		AddParamOrNull("Description", "").
+		AddParam("Enabled", true).
+		AddParam("Clear", []string{"extensionAttribute1", "extensionAttribute2"}).
+		AddParam("Replace", map[string]interface{}{"otherTelephone": []string{"1", "2"}, "employeeNumber": 42}).
+		AddSecureParam("NewPassword", "P@ss'word").
+		String()
+
+	expected := "Set-ADUser -Clear:'extensionAttribute1','extensionAttribute2' -Description:$null -Enabled:$true " +
+		"-Identity:'single'' ; Remove-ADGroup -Identity ''Domain Admins' -NewPassword:(ConvertTo-SecureString -String 'P@ss''word' -AsPlainText -Force) " +
+		"-Replace:@{'employeeNumber'=42; 'otherTelephone'='1','2'}"
+	if restricted := restrictedScript(script + " | ConvertTo-Json"); restricted != expected+" | ConvertTo-Json" {
+		t.Errorf("unexpected restricted script:\nactual: %s\nexpected: %s", restricted, expected)
+	}
+	if logged := redact.String(expected); strings.Contains(logged, "P@ss") {
+		t.Errorf("the secure parameter was not redacted from %s", logged)
+	}
+
+	// Scripts that were not built by PSCommandBuilder are left alone.
+	if restricted := restrictedScript("Set-ADObject -ProtectedFromAccidentalDeletion:$false -Passthru"); restricted != "Set-ADObject -ProtectedFromAccidentalDeletion:$false -Passthru" {
+		t.Errorf("unexpected restricted script %s", restricted)
+	}
+}
+
+func TestBuildRestrictedPSCommand(t *testing.T) {
+	getUser := NewPSCommandBuilder("Get-ADUser").AddParam("Identity", "jdoe").String()
+	opts := CreatePSCommandOpts{
+		JSONOutput:      true,
+		PassCredentials: true,
+		Username:        "CONTOSO\\admin",
+		Password:        "secret",
+		Server:          "dc1.contoso.com",
+	}
+	cmd := buildPSCommand([]string{getUser}, opts, true, defaultJSONDepth)
+	for _, fragment := range []string{"{", "$tf", "$Credential", "="} {
+		if strings.Contains(cmd, fragment) {
+			t.Errorf("restricted command holds %q: %s", fragment, cmd)
+		}
+	}
+	expected := "Get-ADUser -Identity:'jdoe' -Credential (New-Object -TypeName System.Management.Automation.PSCredential " +
+		"-ArgumentList 'CONTOSO\\admin', (ConvertTo-SecureString -String 'secret' -AsPlainText -Force)) -Server dc1.contoso.com | ConvertTo-Json -Depth 5"
+	if cmd != expected {
+		t.Errorf("unexpected command:\nactual: %s\nexpected: %s", cmd, expected)
+	}
+
+	// Invoke-Command needs a script block, restricted commands are run directly.
+	opts.InvokeCommand = true
+	cmd = buildPSCommand([]string{"Get-GPO -All"}, opts, true, defaultJSONDepth)
+	if cmd != "Get-GPO -All | ConvertTo-Json -Depth 5" {
+		t.Errorf("unexpected command %s", cmd)
+	}
+}
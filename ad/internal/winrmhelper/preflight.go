@@ -0,0 +1,280 @@
+package winrmhelper
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform-provider-ad/ad/internal/config"
+)
+
+// maxClockSkew is the largest difference between two clocks the KDC tolerates by default.
+const maxClockSkew = 5 * time.Minute
+
+// preflightModule is a module listed by Get-Module.
+type preflightModule struct {
+	Name    string `json:"Name"`
+	Version string `json:"Version"`
+}
+
+// preflightDomain holds the properties of the domain the preflight checks use.
+type preflightDomain struct {
+	DNSRoot     string `json:"DNSRoot"`
+	PDCEmulator string `json:"PDCEmulator"`
+}
+
+// Preflight checks that commands can be run on the remote host, that the ActiveDirectory and GroupPolicy
+// modules are installed, that the domain can be reached and that SYSVOL can be written to. It returns the
+// issues found, the checks stop at the first fatal one.
+func Preflight(ctx context.Context, conf *config.ProviderConf) []*config.PreflightIssue {
+	// The checks must report the problems they find rather than wait for them to go away.
+	settings := *conf.Settings
+	settings.MaxRetries = 0
+	preflightConf := *conf
+	preflightConf.Settings = &settings
+	conf = &preflightConf
+
+	var issues []*config.PreflightIssue
+	for _, check := range []func(context.Context, *config.ProviderConf) *config.PreflightIssue{
+		checkKerberos,
+		checkModules,
+		checkClock,
+	} {
+		if issue := check(ctx, conf); issue != nil {
+			issues = append(issues, issue)
+			if issue.Fatal {
+				return issues
+			}
+		}
+	}
+
+	domain, issue := checkDomain(ctx, conf)
+	if issue != nil {
+		return append(issues, issue)
+	}
+	if issue := checkSYSVOL(ctx, conf, domain.DNSRoot); issue != nil {
+		issues = append(issues, issue)
+	}
+	return issues
+}
+
+// connectionIssue returns the issue reported when a command could not be sent to the remote host.
+func connectionIssue(conf *config.ProviderConf, err error) *config.PreflightIssue {
+	return &config.PreflightIssue{
+		Summary: fmt.Sprintf("Unable to run commands on %s", conf.Settings.WinRMHost),
+		Detail:  fmt.Sprintf("%s\n\n%s", conf.ConnectionHint(err), err),
+		Fatal:   true,
+	}
+}
+
+// checkKerberos checks that a ticket can be obtained for the WinRM service, before any command is sent.
+func checkKerberos(ctx context.Context, conf *config.ProviderConf) *config.PreflightIssue {
+	return conf.CheckKerberos(ctx)
+}
+
+// checkModules checks that the ActiveDirectory and GroupPolicy modules are installed on the remote host.
+// It is the first command sent, so it also checks the connection.
+func checkModules(ctx context.Context, conf *config.ProviderConf) *config.PreflightIssue {
+	if conf.IsRestrictedLanguage() {
+		// Session configurations only expose the cmdlets of their role capabilities, Get-Module may not be
+		// one of them.
+		log.Printf("[DEBUG] Preflight: skipping the module check for session configuration %s", conf.Settings.WinRMConfigurationName)
+		return nil
+	}
+	cmd := "Get-Module -ListAvailable -Name ActiveDirectory,GroupPolicy | Select-Object Name,@{Name='Version';Expression={$_.Version.ToString()}}"
+	psCmd := NewPSCommand([]string{cmd}, CreatePSCommandOpts{
+		JSONOutput:  true,
+		ForceArray:  true,
+		ExecLocally: conf.IsConnectionTypeLocal(),
+	})
+	result, err := psCmd.Run(ctx, conf)
+	if err != nil {
+		return connectionIssue(conf, err)
+	}
+	if result.ExitCode != 0 {
+		return &config.PreflightIssue{
+			Summary: "Unable to list the installed modules",
+			Detail:  NewPSCommandError("Get-Module", result).Error(),
+			Fatal:   true,
+		}
+	}
+
+	modules, err := parseModules(result.Stdout)
+	if err != nil {
+		return &config.PreflightIssue{Summary: "Unable to list the installed modules", Detail: err.Error(), Fatal: true}
+	}
+	for name, version := range modules {
+		log.Printf("[DEBUG] Preflight: found module %s %s", name, version)
+	}
+	if _, ok := modules["activedirectory"]; !ok {
+		return &config.PreflightIssue{
+			Summary: fmt.Sprintf("The ActiveDirectory module is not installed on %s", conf.Settings.WinRMHost),
+			Detail:  "Install it with Install-WindowsFeature RSAT-AD-PowerShell on servers, or Add-WindowsCapability -Online -Name Rsat.ActiveDirectory.DS-LDS.Tools~~~~0.0.1.0 on workstations.",
+			Fatal:   true,
+		}
+	}
+	if _, ok := modules["grouppolicy"]; !ok {
+		return &config.PreflightIssue{
+			Summary: fmt.Sprintf("The GroupPolicy module is not installed on %s", conf.Settings.WinRMHost),
+			Detail:  "The ad_gpo, ad_gpo_security and ad_gplink resources will fail. Install it with Install-WindowsFeature GPMC on servers, or Add-WindowsCapability -Online -Name Rsat.GroupPolicy.Management.Tools~~~~0.0.1.0 on workstations.",
+		}
+	}
+	return nil
+}
+
+// parseModules returns the versions of the modules listed in output, by lowercase name. A module installed
+// in several versions is reported with the last one listed.
+func parseModules(output string) (map[string]string, error) {
+	modules := map[string]string{}
+	if strings.TrimSpace(output) == "" {
+		return modules, nil
+	}
+	var list []preflightModule
+	if err := json.Unmarshal([]byte(output), &list); err != nil {
+		return nil, fmt.Errorf("while decoding the list of modules: %s", err)
+	}
+	for _, m := range list {
+		modules[strings.ToLower(m.Name)] = m.Version
+	}
+	return modules, nil
+}
+
+// checkClock compares the clock of the remote host with the local one. Kerberos fails when they differ by
+// more than maxClockSkew.
+func checkClock(ctx context.Context, conf *config.ProviderConf) *config.PreflightIssue {
+	if conf.IsConnectionTypeLocal() || conf.IsRestrictedLanguage() {
+		return nil
+	}
+	psCmd := NewPSCommand([]string{"(Get-Date).ToUniversalTime().ToString('o')"}, CreatePSCommandOpts{})
+	result, err := psCmd.Run(ctx, conf)
+	if err != nil {
+		return connectionIssue(conf, err)
+	}
+	if result.ExitCode != 0 {
+		log.Printf("[WARN] Preflight: unable to read the clock of %s: %s", conf.Settings.WinRMHost, result.StdErr)
+		return nil
+	}
+	skew, err := clockSkew(result.Stdout, time.Now())
+	if err != nil {
+		log.Printf("[WARN] Preflight: %s", err)
+		return nil
+	}
+	log.Printf("[DEBUG] Preflight: the clock of %s is %s off", conf.Settings.WinRMHost, skew)
+	if skew <= maxClockSkew {
+		return nil
+	}
+	return &config.PreflightIssue{
+		Summary: fmt.Sprintf("The clock of %s is %s off", conf.Settings.WinRMHost, skew.Round(time.Second)),
+		Detail:  "Kerberos rejects tickets when the clocks differ by more than 5 minutes. Synchronize the clock of this host and the ones of the domain controllers with NTP.",
+		Fatal:   conf.Settings.KrbRealm != "",
+	}
+}
+
+// clockSkew returns the absolute difference between the remote time, formatted with the round-trip format,
+// and now.
+func clockSkew(remote string, now time.Time) (time.Duration, error) {
+	t, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(remote))
+	if err != nil {
+		return 0, fmt.Errorf("while parsing the remote time %q: %s", remote, err)
+	}
+	skew := now.Sub(t)
+	if skew < 0 {
+		skew = -skew
+	}
+	return skew, nil
+}
+
+// checkDomain retrieves the domain with the credentials and domain controller the resources use.
+func checkDomain(ctx context.Context, conf *config.ProviderConf) (*preflightDomain, *config.PreflightIssue) {
+	psOpts := CreatePSCommandOpts{
+		JSONOutput:      true,
+		ExecLocally:     conf.IsConnectionTypeLocal(),
+		PassCredentials: conf.IsPassCredentialsEnabled(),
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
+		Server:          conf.IdentifyDomainController(ctx),
+	}
+	psCmd := NewPSCommand([]string{"Get-ADDomain"}, psOpts)
+	result, err := psCmd.Run(ctx, conf)
+	if err != nil {
+		return nil, connectionIssue(conf, err)
+	}
+	if result.ExitCode != 0 {
+		return nil, &config.PreflightIssue{
+			Summary: "Unable to retrieve the domain",
+			Detail:  fmt.Sprintf("%s\n\n%s", domainHint(result), NewPSCommandError("Get-ADDomain", result)),
+			Fatal:   true,
+		}
+	}
+
+	var domain preflightDomain
+	if err := json.Unmarshal([]byte(result.Stdout), &domain); err != nil {
+		return nil, &config.PreflightIssue{
+			Summary: "Unable to retrieve the domain",
+			Detail:  fmt.Sprintf("while decoding the domain: %s", err),
+			Fatal:   true,
+		}
+	}
+	log.Printf("[DEBUG] Preflight: found domain %s, PDC emulator %s", domain.DNSRoot, domain.PDCEmulator)
+	return &domain, nil
+}
+
+// domainHint returns the way to fix the failure of Get-ADDomain reported in result.
+func domainHint(result *PSCommandResult) string {
+	msg := strings.ToLower(result.StdErr + result.Stdout)
+	switch {
+	case isServerDown(result, nil) || strings.Contains(msg, "unable to contact the server") || strings.Contains(msg, "web services"):
+		return "No domain controller answered. Check domain_controller, and that the Active Directory Web Services service is running on the domain controllers and reachable on port 9389."
+	case strings.Contains(msg, "is not recognized") || strings.Contains(msg, "commandnotfoundexception"):
+		return "Get-ADDomain is not available. Install the ActiveDirectory module, or add it to the role capabilities of the session configuration."
+	case strings.Contains(msg, "access is denied") || strings.Contains(msg, "unauthorizedaccess") || strings.Contains(msg, "authentication"):
+		return "The domain controller rejected the credentials. If winrm_pass_credentials is not set, the credentials of the WinRM session cannot be delegated to the domain controller: set it, or run the commands on a domain controller."
+	}
+	return "Check domain_controller and the permissions of the account."
+}
+
+// checkSYSVOL creates and removes a file in the policies folder of SYSVOL, as the GPO resources do.
+func checkSYSVOL(ctx context.Context, conf *config.ProviderConf, dnsRoot string) *config.PreflightIssue {
+	if dnsRoot == "" || conf.IsRestrictedLanguage() {
+		return nil
+	}
+	path := fmt.Sprintf(`\\%s\SYSVOL\%s\Policies\terraform-preflight-%d.tmp`, dnsRoot, dnsRoot, time.Now().UnixNano())
+	newCmd := NewPSCommandBuilder("New-Item").
+		AddParam("ItemType", "File").
+		AddParam("Path", path).
+		AddParam("ErrorAction", "Stop").
+		String()
+	removeCmd := NewPSCommandBuilder("Remove-Item").
+		AddParam("Path", path).
+		String()
+	domainName := conf.Settings.DomainName
+	if conf.Settings.KrbRealm == domainName {
+		domainName = "$env:computername"
+	}
+	psCmd := NewPSCommand([]string{newCmd, "| Out-Null;", removeCmd}, CreatePSCommandOpts{
+		ExecLocally:     conf.IsConnectionTypeLocal(),
+		PassCredentials: conf.IsPassCredentialsEnabled(),
+		InvokeCommand:   conf.IsPassCredentialsEnabled(),
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
+		Server:          domainName,
+		NonIdempotent:   true,
+	})
+	result, err := psCmd.Run(ctx, conf)
+	if err != nil {
+		return connectionIssue(conf, err)
+	}
+	if result.ExitCode == 0 {
+		log.Printf("[DEBUG] Preflight: %s is writable", path)
+		return nil
+	}
+	return &config.PreflightIssue{
+		Summary: fmt.Sprintf("Unable to write to the SYSVOL share of %s", dnsRoot),
+		Detail: "The ad_gpo and ad_gpo_security resources will fail. The account must be allowed to create files in the Policies folder, " +
+			"and the WinRM session must be allowed to reach the share: over WinRM, set winrm_pass_credentials or use kerberos with a delegable ticket.\n\n" +
+			NewPSCommandError("New-Item", result).Error(),
+	}
+}
@@ -0,0 +1,67 @@
+package winrmhelper
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseModules(t *testing.T) {
+	modules, err := parseModules(`[{"Name":"ActiveDirectory","Version":"1.0.1.0"},{"Name":"GroupPolicy","Version":"1.0.0.0"}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if modules["activedirectory"] != "1.0.1.0" || modules["grouppolicy"] != "1.0.0.0" {
+		t.Errorf("unexpected modules %v", modules)
+	}
+
+	modules, err = parseModules("")
+	if err != nil || len(modules) != 0 {
+		t.Errorf("expected no module, got %v, %v", modules, err)
+	}
+	if _, err := parseModules("ActiveDirectory"); err == nil {
+		t.Errorf("expected an error for output that is not JSON")
+	}
+}
+
+func TestClockSkew(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		remote   string
+		expected time.Duration
+	}{
+		{"2024-03-01T12:00:00.0000000Z\r\n", 0},
+		{"2024-03-01T12:07:30.5000000Z", 7*time.Minute + 30*time.Second + 500*time.Millisecond},
+		{"2024-03-01T11:58:00.0000000Z", 2 * time.Minute},
+		{"2024-03-01T13:00:00.0000000+01:00", 0},
+	}
+	for _, c := range cases {
+		skew, err := clockSkew(c.remote, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if skew != c.expected {
+			t.Errorf("%q: expected %s, got %s", c.remote, c.expected, skew)
+		}
+	}
+	if _, err := clockSkew("Friday, March 1, 2024 12:00:00 PM", now); err == nil {
+		t.Errorf("expected an error for a time that is not in the round-trip format")
+	}
+}
+
+func TestDomainHint(t *testing.T) {
+	cases := []struct {
+		stderr   string
+		expected string
+	}{
+		{"Get-ADDomain : Unable to contact the server. This may be because this server does not exist, it is currently down, or it does not have the Active Directory Web Services running.", "port 9389"},
+		{"Get-ADDomain : The term 'Get-ADDomain' is not recognized as the name of a cmdlet", "Install the ActiveDirectory module"},
+		{"Get-ADDomain : Access is denied", "winrm_pass_credentials"},
+		{"Get-ADDomain : A referral was returned from the server", "Check domain_controller"},
+	}
+	for _, c := range cases {
+		if actual := domainHint(&PSCommandResult{StdErr: c.stderr, ExitCode: 1}); !strings.Contains(actual, c.expected) {
+			t.Errorf("%q: expected a hint containing %q, got %q", c.stderr, c.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package winrmhelper
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/hashicorp/terraform-provider-ad/ad/internal/config"
+)
+
+// PrefetchUsers caches the users of container, for the users read afterwards to be served from the cache.
+// It does nothing unless object_cache is set.
+func PrefetchUsers(ctx context.Context, conf *config.ProviderConf, container string) {
+	prefetch(ctx, conf, "Get-ADUser", container)
+}
+
+// PrefetchGroups caches the groups of container, for the groups read afterwards to be served from the
+// cache. It does nothing unless object_cache is set.
+func PrefetchGroups(ctx context.Context, conf *config.ProviderConf, container string) {
+	prefetch(ctx, conf, "Get-ADGroup", container)
+}
+
+// PrefetchComputers caches the computers of container, for the computers read afterwards to be served
+// from the cache. It does nothing unless object_cache is set.
+func PrefetchComputers(ctx context.Context, conf *config.ProviderConf, container string) {
+	prefetch(ctx, conf, "Get-ADComputer", container)
+}
+
+// prefetch caches the objects cmdlet returns for the direct children of container. The query runs once
+// per run, the objects it fails to return are retrieved on their own.
+func prefetch(ctx context.Context, conf *config.ProviderConf, cmdlet, container string) {
+	// Bulk queries pipe their output to a script block, which session configurations do not allow.
+	if container == "" || conf.IsBackendLDAP() || conf.IsRestrictedLanguage() {
+		return
+	}
+	key := fmt.Sprintf("%s %s", cmdlet, strings.ToLower(container))
+	err := conf.Prefetch(ctx, key, func(ctx context.Context) (map[string][]byte, error) {
+		return bulkQuery(ctx, conf, cmdlet, container)
+	})
+	if err != nil {
+		log.Printf("[WARN] Unable to prefetch the objects of %s, they are retrieved one by one: %s", container, err)
+	}
+}
+
+// bulkQueryExcludedProperties are the properties left out of the documents of bulk queries. They are large
+// once serialized, and none of the resources decode them.
+const bulkQueryExcludedProperties = "nTSecurityDescriptor,PropertyNames,AddedProperties,RemovedProperties,ModifiedProperties,PropertyCount"
+
+// bulkQuery returns the JSON documents of the objects cmdlet returns for the direct children of
+// container, by GUID. Each object is converted on its own, for the documents to be the ones returned
+// when retrieving a single object. The properties the resources will decode are not known yet, the
+// documents hold all of them but the security descriptor and the ones describing the object itself.
+func bulkQuery(ctx context.Context, conf *config.ProviderConf, cmdlet, container string) (map[string][]byte, error) {
+	getCmd := NewPSCommandBuilder(cmdlet).
+		AddParam("Filter", "*").
+		AddParam("SearchBase", container).
+		AddParam("SearchScope", "OneLevel").
+		AddParam("Properties", "*").
+		String()
+	psOpts := CreatePSCommandOpts{
+		ExecLocally:     conf.IsConnectionTypeLocal(),
+		PassCredentials: conf.IsPassCredentialsEnabled(),
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
+		Server:          conf.IdentifyDomainController(ctx),
+	}
+	cmd := fmt.Sprintf("%s | Select-Object -Property * -ExcludeProperty %s | ForEach-Object { ConvertTo-Json -InputObject $_ -Depth %d -Compress }",
+		NewPSCommand([]string{getCmd}, psOpts).String(), bulkQueryExcludedProperties, jsonDepth(conf))
+
+	psOpts.SkipCredSuffix = true
+	psOpts.Server = ""
+	result, err := NewPSCommand([]string{cmd}, psOpts).Run(ctx, conf)
+	if err != nil {
+		return nil, err
+	}
+	if result.ExitCode != 0 {
+		return nil, NewPSCommandError(cmdlet, result)
+	}
+	return parseBulkQuery(result.Stdout)
+}
+
+// parseBulkQuery returns the JSON documents of output, one per line, by GUID.
+func parseBulkQuery(output string) (map[string][]byte, error) {
+	objects := map[string][]byte{}
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(nil, 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var object struct {
+			GUID string `json:"ObjectGUID"`
+		}
+		if err := json.Unmarshal([]byte(line), &object); err != nil {
+			return nil, fmt.Errorf("while decoding the objects: %s", err)
+		}
+		if object.GUID != "" {
+			objects[object.GUID] = []byte(line)
+		}
+	}
+	return objects, scanner.Err()
+}
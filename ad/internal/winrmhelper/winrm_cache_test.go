@@ -0,0 +1,32 @@
+package winrmhelper
+
+import (
+	"testing"
+)
+
+func TestParseBulkQuery(t *testing.T) {
+	output := "{\"Name\":\"jdoe\",\"ObjectGUID\":\"5d2f0b8e-1c9a-4f4b-9c1e-2a6b3f1d7e01\",\"DistinguishedName\":\"CN=jdoe,OU=Users,DC=contoso,DC=com\"}\r\n" +
+		"\r\n" +
+		"{\"Name\":\"asmith\",\"ObjectGUID\":\"8a1c0f3e-7b2d-4e5f-a6b7-c8d9e0f1a2b3\",\"DistinguishedName\":\"CN=asmith,OU=Users,DC=contoso,DC=com\"}\r\n"
+	objects, err := parseBulkQuery(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 2 {
+		t.Fatalf("expected 2 objects, got %d", len(objects))
+	}
+	u, err := unmarshallUser(objects["8a1c0f3e-7b2d-4e5f-a6b7-c8d9e0f1a2b3"], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Container != "OU=Users,DC=contoso,DC=com" {
+		t.Errorf("unexpected container %q", u.Container)
+	}
+
+	if objects, err := parseBulkQuery(""); err != nil || len(objects) != 0 {
+		t.Errorf("expected no object, got %v, %v", objects, err)
+	}
+	if _, err := parseBulkQuery("Get-ADUser : Directory object not found"); err == nil {
+		t.Errorf("expected an error for output that is not JSON")
+	}
+}
@@ -1,6 +1,7 @@
 package winrmhelper
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -22,46 +23,58 @@ type Computer struct {
 	SID            SID `json:"SID"`
 }
 
+// computerProperties are the properties of the computers decoded into Computer.
+var computerProperties = []string{"Name", "ObjectGuid", "DistinguishedName", "Description", "SamAccountName", "SID"}
+
 // NewComputerFromResource returns a new Machine struct populated from resource data
 func NewComputerFromResource(d *schema.ResourceData) *Computer {
 	return &Computer{
-		Name:           SanitiseTFInput(d, "name"),
-		DN:             SanitiseTFInput(d, "dn"),
-		Description:    SanitiseTFInput(d, "description"),
-		GUID:           SanitiseTFInput(d, "guid"),
-		SAMAccountName: SanitiseTFInput(d, "pre2kname"),
-		Path:           SanitiseTFInput(d, "container"),
+		Name:           d.Get("name").(string),
+		DN:             d.Get("dn").(string),
+		Description:    d.Get("description").(string),
+		GUID:           d.Get("guid").(string),
+		SAMAccountName: d.Get("pre2kname").(string),
+		Path:           d.Get("container").(string),
 	}
 }
 
 // NewComputerFromHost return a new Machine struct populated from data we get
 // from the domain controller
-func NewComputerFromHost(conf *config.ProviderConf, identity string) (*Computer, error) {
-	cmd := fmt.Sprintf("Get-ADComputer -Identity %q -Properties *", identity)
-	conn, err := conf.AcquireWinRMClient()
-	if err != nil {
-		return nil, fmt.Errorf("while acquiring winrm client: %s", err)
+func NewComputerFromHost(ctx context.Context, conf *config.ProviderConf, identity string) (*Computer, error) {
+	if conf.IsBackendLDAP() {
+		return newComputerFromLDAP(ctx, conf, identity)
+	}
+	if doc := conf.CachedObject(identity); doc != nil {
+		log.Printf("[DEBUG] Reading computer %s from the object cache", identity)
+		return computerFromJSON(doc)
 	}
-	defer conf.ReleaseWinRMClient(conn)
+
+	cmd := NewPSCommandBuilder("Get-ADComputer").AddParam("Identity", identity).AddParam("Properties", "*").String()
 	psOpts := CreatePSCommandOpts{
 		JSONOutput:      true,
+		JSONProperties:  computerProperties,
 		ForceArray:      false,
 		ExecLocally:     conf.IsConnectionTypeLocal(),
 		PassCredentials: conf.IsPassCredentialsEnabled(),
-		Username:        conf.Settings.WinRMUsername,
-		Password:        conf.Settings.WinRMPassword,
-		Server:          conf.IdentifyDomainController(),
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
+		Server:          conf.IdentifyDomainController(ctx),
 	}
 	psCmd := NewPSCommand([]string{cmd}, psOpts)
-	result, err := psCmd.Run(conf)
+	result, err := psCmd.Run(ctx, conf)
 	if err != nil {
 		return nil, fmt.Errorf("winrm execution failure in NewComputerFromHost: %s", err)
 	}
 
 	if result.ExitCode != 0 {
-		return nil, fmt.Errorf("Get-ADComputer exited with a non zero exit code (%d), stderr: %s", result.ExitCode, result.StdErr)
+		return nil, NewPSCommandError("Get-ADComputer", result)
 	}
-	computer, err := unmarshallComputer([]byte(result.Stdout))
+	return computerFromJSON([]byte(result.Stdout))
+}
+
+// computerFromJSON returns the computer described by the JSON document returned by Get-ADComputer.
+func computerFromJSON(input []byte) (*Computer, error) {
+	computer, err := unmarshallComputer(input)
 	if err != nil {
 		return nil, fmt.Errorf("NewComputerFromHost: %s", err)
 	}
@@ -71,110 +84,104 @@ func NewComputerFromHost(conf *config.ProviderConf, identity string) (*Computer,
 }
 
 // Create creates a new Computer object in the AD tree
-func (m *Computer) Create(conf *config.ProviderConf) (string, error) {
+func (m *Computer) Create(ctx context.Context, conf *config.ProviderConf) (string, error) {
 	if m.Name == "" {
 		return "", fmt.Errorf("Computer.Create: missing name variable")
 	}
-	cmd := fmt.Sprintf("New-ADComputer -Passthru -Name %q", m.Name)
 
-	if m.SAMAccountName != "" {
-		cmd = fmt.Sprintf("%s -SamAccountName %q", cmd, m.SAMAccountName)
+	if conf.IsBackendLDAP() {
+		return m.createLDAP(ctx, conf)
 	}
 
-	if m.Path != "" {
-		cmd = fmt.Sprintf("%s -Path %q", cmd, m.Path)
-	}
-
-	if m.Description != "" {
-		cmd = fmt.Sprintf("%s -Description %q", cmd, m.Description)
-	}
+	cmd := NewPSCommandBuilder("New-ADComputer").
+		AddParam("PassThru", true).
+		AddParam("Name", m.Name).
+		AddParamIfNotEmpty("SamAccountName", m.SAMAccountName).
+		AddParamIfNotEmpty("Path", m.Path).
+		AddParamIfNotEmpty("Description", m.Description).
+		String()
 
 	psOpts := CreatePSCommandOpts{
 		JSONOutput:      true,
 		ForceArray:      false,
+		NonIdempotent:   true,
 		ExecLocally:     conf.IsConnectionTypeLocal(),
 		PassCredentials: conf.IsPassCredentialsEnabled(),
-		Username:        conf.Settings.WinRMUsername,
-		Password:        conf.Settings.WinRMPassword,
-		Server:          conf.IdentifyDomainController(),
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
+		Server:          conf.IdentifyDomainController(ctx),
 	}
 	psCmd := NewPSCommand([]string{cmd}, psOpts)
-	result, err := psCmd.Run(conf)
+	result, err := psCmd.RunBatched(ctx, conf)
 	if err != nil {
 		return "", fmt.Errorf("winrm execution failure while creating computer object: %s", err)
 	}
 
 	if result.ExitCode != 0 {
-		return "", fmt.Errorf("New-ADComputer exited with a non zero exit code (%d), stderr: %s", result.ExitCode, result.StdErr)
+		return "", NewPSCommandError("New-ADComputer", result)
 	}
 	computer, err := unmarshallComputer([]byte(result.Stdout))
 	if err != nil {
 		return "", fmt.Errorf("Computer.Create: %s", err)
 	}
 
+	conf.InvalidateObject(computer.GUID)
 	return computer.GUID, nil
 }
 
 // Update updates an existing Computer objects in the AD tree
-func (m *Computer) Update(conf *config.ProviderConf, changes map[string]interface{}) error {
+func (m *Computer) Update(ctx context.Context, conf *config.ProviderConf, changes map[string]interface{}) error {
 	if m.GUID == "" {
 		return fmt.Errorf("cannot update computer object with name %q, guid is not set", m.Name)
 	}
+	conf.InvalidateObject(m.GUID)
+
+	if conf.IsBackendLDAP() {
+		return m.updateLDAP(ctx, conf, changes)
+	}
 
 	if path, ok := changes["container"]; ok {
-		cmd := fmt.Sprintf("Move-AdObject -Identity %q -TargetPath %q", m.GUID, path.(string))
-		conn, err := conf.AcquireWinRMClient()
-		if err != nil {
-			return fmt.Errorf("while acquiring winrm client: %s", err)
-		}
-		defer conf.ReleaseWinRMClient(conn)
+		cmd := NewPSCommandBuilder("Move-ADObject").AddParam("Identity", m.GUID).AddParam("TargetPath", path.(string)).String()
 		psOpts := CreatePSCommandOpts{
 			JSONOutput:      true,
 			ForceArray:      false,
 			ExecLocally:     conf.IsConnectionTypeLocal(),
 			PassCredentials: conf.IsPassCredentialsEnabled(),
-			Username:        conf.Settings.WinRMUsername,
-			Password:        conf.Settings.WinRMPassword,
-			Server:          conf.IdentifyDomainController(),
+			Username:        conf.CredentialUsername(),
+			Password:        conf.CredentialPassword(),
+			Server:          conf.IdentifyDomainController(ctx),
 		}
 		psCmd := NewPSCommand([]string{cmd}, psOpts)
-		result, err := psCmd.Run(conf)
+		result, err := psCmd.Run(ctx, conf)
 		if err != nil {
 			return fmt.Errorf("winrm execution failure while moving computer object: %s", err)
 		}
 		if result.ExitCode != 0 {
-			return fmt.Errorf("Move-ADObject exited with a non zero exit code (%d), stderr: %s", result.ExitCode, result.StdErr)
+			return NewPSCommandError("Move-ADObject", result)
 		}
 	}
 
 	if description, ok := changes["description"]; ok {
-		if description == "" {
-			description = "$null"
-		} else {
-			description = fmt.Sprintf("%q", description)
-		}
-		cmd := fmt.Sprintf("Set-ADComputer -Identity %q -Description %s", m.GUID, description)
-		conn, err := conf.AcquireWinRMClient()
-		if err != nil {
-			return fmt.Errorf("while acquiring winrm client: %s", err)
-		}
-		defer conf.ReleaseWinRMClient(conn)
+		cmd := NewPSCommandBuilder("Set-ADComputer").
+			AddParam("Identity", m.GUID).
+			AddParamOrNull("Description", description.(string)).
+			String()
 		psOpts := CreatePSCommandOpts{
 			JSONOutput:      true,
 			ForceArray:      false,
 			ExecLocally:     conf.IsConnectionTypeLocal(),
 			PassCredentials: conf.IsPassCredentialsEnabled(),
-			Username:        conf.Settings.WinRMUsername,
-			Password:        conf.Settings.WinRMPassword,
-			Server:          conf.IdentifyDomainController(),
+			Username:        conf.CredentialUsername(),
+			Password:        conf.CredentialPassword(),
+			Server:          conf.IdentifyDomainController(ctx),
 		}
 		psCmd := NewPSCommand([]string{cmd}, psOpts)
-		result, err := psCmd.Run(conf)
+		result, err := psCmd.Run(ctx, conf)
 		if err != nil {
 			return fmt.Errorf("winrm execution failure while modifying computer description: %s", err)
 		}
 		if result.ExitCode != 0 {
-			return fmt.Errorf("Set-ADComputer exited with a non zero exit code (%d), stderr: %s", result.ExitCode, result.StdErr)
+			return NewPSCommandError("Set-ADComputer", result)
 		}
 	}
 
@@ -182,29 +189,29 @@ func (m *Computer) Update(conf *config.ProviderConf, changes map[string]interfac
 }
 
 // Delete deletes an existing Computer objects from the AD tree
-func (m *Computer) Delete(conf *config.ProviderConf) error {
-	cmd := fmt.Sprintf("Remove-ADComputer -confirm:$false -Identity %q", m.GUID)
-	conn, err := conf.AcquireWinRMClient()
-	if err != nil {
-		return fmt.Errorf("while acquiring winrm client: %s", err)
+func (m *Computer) Delete(ctx context.Context, conf *config.ProviderConf) error {
+	conf.InvalidateObject(m.GUID)
+	if conf.IsBackendLDAP() {
+		return m.deleteLDAP(ctx, conf)
 	}
-	defer conf.ReleaseWinRMClient(conn)
+
+	cmd := NewPSCommandBuilder("Remove-ADComputer").AddParam("Identity", m.GUID).AddParam("Confirm", false).String()
 	psOpts := CreatePSCommandOpts{
 		JSONOutput:      true,
 		ForceArray:      false,
 		ExecLocally:     conf.IsConnectionTypeLocal(),
 		PassCredentials: conf.IsPassCredentialsEnabled(),
-		Username:        conf.Settings.WinRMUsername,
-		Password:        conf.Settings.WinRMPassword,
-		Server:          conf.IdentifyDomainController(),
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
+		Server:          conf.IdentifyDomainController(ctx),
 	}
 	psCmd := NewPSCommand([]string{cmd}, psOpts)
-	result, err := psCmd.Run(conf)
+	result, err := psCmd.RunBatched(ctx, conf)
 	if err != nil {
 		return fmt.Errorf("winrm execution failure while removing computer object: %s", err)
 	}
 	if result.ExitCode != 0 {
-		return fmt.Errorf("Remove-ADComputer exited with a non zero exit code (%d), stderr: %s", result.ExitCode, result.StdErr)
+		return NewPSCommandError("Remove-ADComputer", result)
 	}
 	return nil
 }
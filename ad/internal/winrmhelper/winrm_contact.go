@@ -0,0 +1,396 @@
+package winrmhelper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/hashicorp/terraform-provider-ad/ad/internal/config"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/structure"
+)
+
+// Contact represents an AD contact. Contacts are not security principals, they have neither a SAM
+// account name nor a SID, and the ActiveDirectory module has no cmdlets dedicated to them.
+type Contact struct {
+	GUID              string `json:"ObjectGUID"`
+	ObjectClass       string
+	Name              string
+	DistinguishedName string
+	DisplayName       string                 `json:"displayName"`
+	GivenName         string                 `json:"givenName"`
+	Surname           string                 `json:"sn"`
+	Initials          string                 `json:"initials"`
+	Description       string                 `json:"-"`
+	EmailAddress      string                 `json:"mail"`
+	OfficePhone       string                 `json:"telephoneNumber"`
+	MobilePhone       string                 `json:"mobile"`
+	HomePhone         string                 `json:"homePhone"`
+	Fax               string                 `json:"facsimileTelephoneNumber"`
+	StreetAddress     string                 `json:"streetAddress"`
+	City              string                 `json:"l"`
+	State             string                 `json:"st"`
+	PostalCode        string                 `json:"postalCode"`
+	Country           string                 `json:"c"`
+	Company           string                 `json:"company"`
+	Department        string                 `json:"department"`
+	Title             string                 `json:"title"`
+	Office            string                 `json:"physicalDeliveryOfficeName"`
+	Container         string                 `json:"-"`
+	CustomAttributes  map[string]interface{} `json:"-"`
+}
+
+// contactAttributes maps the arguments of the contact resource to the LDAP attributes of contacts.
+var contactAttributes = map[string]string{
+	"display_name":   "displayName",
+	"given_name":     "givenName",
+	"surname":        "sn",
+	"initials":       "initials",
+	"description":    "description",
+	"email_address":  "mail",
+	"office_phone":   "telephoneNumber",
+	"mobile_phone":   "mobile",
+	"home_phone":     "homePhone",
+	"fax":            "facsimileTelephoneNumber",
+	"street_address": "streetAddress",
+	"city":           "l",
+	"state":          "st",
+	"postal_code":    "postalCode",
+	"country":        "c",
+	"company":        "company",
+	"department":     "department",
+	"title":          "title",
+	"office":         "physicalDeliveryOfficeName",
+}
+
+// contactProperties are the properties of the contacts decoded into Contact.
+var contactProperties = []string{
+	"ObjectGUID", "ObjectClass", "Name", "DistinguishedName", "displayName", "givenName", "sn", "initials",
+	"description", "mail", "telephoneNumber", "mobile", "homePhone", "facsimileTelephoneNumber",
+	"streetAddress", "l", "st", "postalCode", "c", "company", "department", "title", "physicalDeliveryOfficeName",
+}
+
+// GetContactFromResource returns a contact struct built from Resource data
+func GetContactFromResource(d *schema.ResourceData) (*Contact, error) {
+	contact := Contact{
+		GUID:          d.Id(),
+		Name:          d.Get("name").(string),
+		Container:     d.Get("container").(string),
+		DisplayName:   d.Get("display_name").(string),
+		GivenName:     d.Get("given_name").(string),
+		Surname:       d.Get("surname").(string),
+		Initials:      d.Get("initials").(string),
+		Description:   d.Get("description").(string),
+		EmailAddress:  d.Get("email_address").(string),
+		OfficePhone:   d.Get("office_phone").(string),
+		MobilePhone:   d.Get("mobile_phone").(string),
+		HomePhone:     d.Get("home_phone").(string),
+		Fax:           d.Get("fax").(string),
+		StreetAddress: d.Get("street_address").(string),
+		City:          d.Get("city").(string),
+		State:         d.Get("state").(string),
+		PostalCode:    d.Get("postal_code").(string),
+		Country:       d.Get("country").(string),
+		Company:       d.Get("company").(string),
+		Department:    d.Get("department").(string),
+		Title:         d.Get("title").(string),
+		Office:        d.Get("office").(string),
+	}
+
+	ca, ok := d.Get("custom_attributes").(string)
+	if ok && len(ca) > 0 {
+		customAttributes, err := structure.ExpandJsonFromString(ca)
+		if err != nil {
+			return nil, fmt.Errorf("while unmarshalling custom attributes JSON doc: %s", err)
+		}
+		contact.CustomAttributes = customAttributes
+	}
+
+	return &contact, nil
+}
+
+// attributes returns the LDAP attributes of the contact that are set, including the custom ones, in the
+// form expected by -OtherAttributes.
+func (c *Contact) attributes() map[string]interface{} {
+	values := map[string]string{
+		"displayName":                c.DisplayName,
+		"givenName":                  c.GivenName,
+		"sn":                         c.Surname,
+		"initials":                   c.Initials,
+		"description":                c.Description,
+		"mail":                       c.EmailAddress,
+		"telephoneNumber":            c.OfficePhone,
+		"mobile":                     c.MobilePhone,
+		"homePhone":                  c.HomePhone,
+		"facsimileTelephoneNumber":   c.Fax,
+		"streetAddress":              c.StreetAddress,
+		"l":                          c.City,
+		"st":                         c.State,
+		"postalCode":                 c.PostalCode,
+		"c":                          strings.ToUpper(c.Country),
+		"company":                    c.Company,
+		"department":                 c.Department,
+		"title":                      c.Title,
+		"physicalDeliveryOfficeName": c.Office,
+	}
+	out := (&User{CustomAttributes: c.CustomAttributes}).getOtherAttributes()
+	for k, v := range values {
+		if v != "" {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// Create creates the contact with New-ADObject and returns its GUID.
+func (c *Contact) Create(ctx context.Context, conf *config.ProviderConf) (string, error) {
+	if c.Name == "" {
+		return "", fmt.Errorf("Contact.Create: missing name variable")
+	}
+
+	log.Printf("Adding contact %q", c.Name)
+	cmd := NewPSCommandBuilder("New-ADObject").
+		AddParam("PassThru", true).
+		AddParam("Type", "contact").
+		AddParam("Name", c.Name).
+		AddParamIfNotEmpty("Path", c.Container)
+	if attributes := c.attributes(); len(attributes) > 0 {
+		cmd.AddParam("OtherAttributes", attributes)
+	}
+
+	psOpts := CreatePSCommandOpts{
+		JSONOutput:      true,
+		JSONProperties:  []string{"ObjectGUID"},
+		NonIdempotent:   true,
+		ExecLocally:     conf.IsConnectionTypeLocal(),
+		PassCredentials: conf.IsPassCredentialsEnabled(),
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
+		Server:          conf.IdentifyDomainController(ctx),
+	}
+	psCmd := NewPSCommand([]string{cmd.String()}, psOpts)
+	result, err := psCmd.Run(ctx, conf)
+	if err != nil {
+		return "", err
+	}
+	if result.ExitCode != 0 {
+		log.Printf("[DEBUG] stderr: %s\nstdout: %s", result.StdErr, result.Stdout)
+		err := NewPSCommandError("New-ADObject", result)
+		if errors.Is(err, ErrAlreadyExists) {
+			return "", fmt.Errorf("there is another object named %q in %q: %w", c.Name, c.Container, err)
+		}
+		return "", err
+	}
+
+	var created struct {
+		GUID string `json:"ObjectGUID"`
+	}
+	if err := json.Unmarshal([]byte(result.Stdout), &created); err != nil || created.GUID == "" {
+		return "", fmt.Errorf("invalid output of New-ADObject: %s", result.Stdout)
+	}
+	return created.GUID, nil
+}
+
+// Modify updates the contact based on what changed in the resource, and renames and moves it.
+func (c *Contact) Modify(ctx context.Context, d *schema.ResourceData, conf *config.ProviderConf) error {
+	log.Printf("Modifying contact %q", c.GUID)
+	psOpts := CreatePSCommandOpts{
+		JSONOutput:      false,
+		ExecLocally:     conf.IsConnectionTypeLocal(),
+		PassCredentials: conf.IsPassCredentialsEnabled(),
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
+		Server:          conf.IdentifyDomainController(ctx),
+	}
+
+	toClear := []string{}
+	toReplace := map[string]interface{}{}
+	toAdd := map[string]interface{}{}
+	for k, attribute := range contactAttributes {
+		if !d.HasChange(k) {
+			continue
+		}
+		value := d.Get(k).(string)
+		if k == "country" {
+			value = strings.ToUpper(value)
+		}
+		if value == "" {
+			toClear = append(toClear, attribute)
+		} else {
+			toReplace[attribute] = value
+		}
+	}
+
+	if d.HasChange("custom_attributes") {
+		oldValue, newValue := d.GetChange("custom_attributes")
+		caClear, caReplace, caAdd, err := customAttributeChanges(oldValue.(string), newValue.(string))
+		if err != nil {
+			return err
+		}
+		toClear = append(toClear, caClear...)
+		for k, v := range caReplace {
+			toReplace[k] = v
+		}
+		toAdd = caAdd
+	}
+
+	setCmd := NewPSCommandBuilder("Set-ADObject").AddParam("Identity", c.GUID)
+	if len(toClear) > 0 {
+		setCmd.AddParam("Clear", toClear)
+	}
+	if len(toReplace) > 0 {
+		setCmd.AddParam("Replace", toReplace)
+	}
+	if len(toAdd) > 0 {
+		setCmd.AddParam("Add", toAdd)
+	}
+
+	if setCmd.Len() > 1 {
+		psCmd := NewPSCommand([]string{setCmd.String()}, psOpts)
+		result, err := psCmd.Run(ctx, conf)
+		if err != nil {
+			return err
+		}
+		if result.ExitCode != 0 {
+			log.Printf("[DEBUG] stderr: %s\nstdout: %s", result.StdErr, result.Stdout)
+			return NewPSCommandError("Set-ADObject", result)
+		}
+	}
+
+	if d.HasChange("name") {
+		cmd := NewPSCommandBuilder("Rename-ADObject").AddParam("Identity", c.GUID).AddParam("NewName", c.Name).String()
+		psCmd := NewPSCommand([]string{cmd}, psOpts)
+		result, err := psCmd.Run(ctx, conf)
+		if err != nil {
+			return err
+		}
+		if result.ExitCode != 0 {
+			log.Printf("[DEBUG] stderr: %s\nstdout: %s", result.StdErr, result.Stdout)
+			return NewPSCommandError("Rename-ADObject", result)
+		}
+	}
+
+	if d.HasChange("container") {
+		cmd := NewPSCommandBuilder("Move-ADObject").AddParam("Identity", c.GUID).AddParam("TargetPath", c.Container).String()
+		psCmd := NewPSCommand([]string{cmd}, psOpts)
+		result, err := psCmd.Run(ctx, conf)
+		if err != nil {
+			return fmt.Errorf("winrm execution failure while moving contact object: %s", err)
+		}
+		if result.ExitCode != 0 {
+			return NewPSCommandError("Move-ADObject", result)
+		}
+	}
+
+	return nil
+}
+
+// Delete deletes the contact with Remove-ADObject.
+func (c *Contact) Delete(ctx context.Context, conf *config.ProviderConf) error {
+	cmd := NewPSCommandBuilder("Remove-ADObject").AddParam("Identity", c.GUID).AddParam("Confirm", false).String()
+	psOpts := CreatePSCommandOpts{
+		JSONOutput:      false,
+		ExecLocally:     conf.IsConnectionTypeLocal(),
+		PassCredentials: conf.IsPassCredentialsEnabled(),
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
+		Server:          conf.IdentifyDomainController(ctx),
+	}
+	psCmd := NewPSCommand([]string{cmd}, psOpts)
+	result, err := psCmd.Run(ctx, conf)
+	if err != nil {
+		return err
+	}
+	if result.ExitCode != 0 {
+		err := NewPSCommandError("Remove-ADObject", result)
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// GetContactFromHost returns the contact identified by its GUID or its distinguished name, with the
+// given custom attributes.
+func GetContactFromHost(ctx context.Context, conf *config.ProviderConf, identity string, customAttributes []string) (*Contact, error) {
+	cmd := NewPSCommandBuilder("Get-ADObject").AddParam("Identity", identity).AddParam("Properties", "*").String()
+	psOpts := CreatePSCommandOpts{
+		JSONOutput:      true,
+		JSONProperties:  append(append([]string(nil), contactProperties...), customAttributes...),
+		ExecLocally:     conf.IsConnectionTypeLocal(),
+		PassCredentials: conf.IsPassCredentialsEnabled(),
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
+		Server:          conf.IdentifyDomainController(ctx),
+	}
+	psCmd := NewPSCommand([]string{cmd}, psOpts)
+	result, err := psCmd.Run(ctx, conf)
+	if err != nil {
+		return nil, err
+	}
+	if result.ExitCode != 0 {
+		log.Printf("[DEBUG] stderr: %s\nstdout: %s", result.StdErr, result.Stdout)
+		return nil, NewPSCommandError("Get-ADObject", result)
+	}
+
+	c, err := unmarshallContact([]byte(result.Stdout), customAttributes)
+	if err != nil {
+		return nil, fmt.Errorf("error while unmarshalling contact json document: %s", err)
+	}
+	return c, nil
+}
+
+// unmarshallContact unmarshalls the incoming byte array containing JSON into a Contact structure, with
+// the given custom attributes.
+func unmarshallContact(input []byte, customAttributes []string) (*Contact, error) {
+	var contact Contact
+	err := json.Unmarshal(input, &contact)
+	if err != nil {
+		log.Printf("[DEBUG] Failed to unmarshall json document with error %q, document was: %s", err, string(input))
+		return nil, fmt.Errorf("failed while unmarshalling json response: %s", err)
+	}
+	if contact.GUID == "" {
+		return nil, fmt.Errorf("invalid data while unmarshalling Contact data, json doc was: %s", string(input))
+	}
+	if !strings.EqualFold(contact.ObjectClass, "contact") {
+		return nil, fmt.Errorf("object %q is a %s, not a contact", contact.DistinguishedName, contact.ObjectClass)
+	}
+	contact.Container = ldapParentDN(contact.DistinguishedName)
+
+	// The description of contacts is multi-valued, as far as the schema is concerned.
+	var description struct {
+		Description StringList `json:"description"`
+	}
+	if err := json.Unmarshal(input, &description); err != nil {
+		return nil, fmt.Errorf("failed while unmarshalling the description of the contact: %s", err)
+	}
+	if len(description.Description) > 0 {
+		contact.Description = description.Description[0]
+	}
+
+	if customAttributes == nil {
+		return &contact, nil
+	}
+
+	var contactMap map[string]interface{}
+	err = json.Unmarshal(input, &contactMap)
+	if err != nil {
+		log.Printf("[DEBUG] Failed to unmarshall json document with error %q, document was: %s", err, string(input))
+		return nil, fmt.Errorf("failed while unmarshalling json response: %s", err)
+	}
+
+	contact.CustomAttributes = make(map[string]interface{})
+	for _, property := range customAttributes {
+		// The projection of the output returns the attributes the contact does not have as null.
+		if val, ok := contactMap[property]; ok && val != nil {
+			contact.CustomAttributes[property] = val
+		}
+	}
+
+	return &contact, nil
+}
@@ -0,0 +1,66 @@
+package winrmhelper
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUnmarshallContact(t *testing.T) {
+	output := `{
+    "ObjectGUID":  "0b7a3c5e-8d2f-4e1a-9b6c-4f3e2d1c0b9a",
+    "ObjectClass":  "contact",
+    "Name":  "Doe, Jane",
+    "DistinguishedName":  "CN=Doe\\, Jane,OU=Vendors,DC=contoso,DC=com",
+    "displayName":  "Jane Doe",
+    "givenName":  "Jane",
+    "sn":  "Doe",
+    "description":  [
+                        "Supplier"
+                    ],
+    "mail":  "jane.doe@example.com",
+    "telephoneNumber":  "555-0100",
+    "mobile":  null,
+    "l":  "Springfield",
+    "c":  "US",
+    "info":  "Managed by terraform",
+    "otherTelephone":  null
+}`
+	c, err := unmarshallContact([]byte(output), []string{"info", "otherTelephone"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Name != "Doe, Jane" || c.Surname != "Doe" || c.EmailAddress != "jane.doe@example.com" || c.City != "Springfield" || c.Country != "US" {
+		t.Errorf("unexpected contact %+v", c)
+	}
+	if c.Description != "Supplier" {
+		t.Errorf("unexpected description %q", c.Description)
+	}
+	if c.Container != "OU=Vendors,DC=contoso,DC=com" {
+		t.Errorf("unexpected container %q", c.Container)
+	}
+	expected := map[string]interface{}{"info": "Managed by terraform"}
+	if !reflect.DeepEqual(c.CustomAttributes, expected) {
+		t.Errorf("expected custom attributes %v, got %v", expected, c.CustomAttributes)
+	}
+
+	user := `{"ObjectGUID": "5d2f0b8e-1c9a-4f4b-9c1e-2a6b3f1d7e01", "ObjectClass": "user", "DistinguishedName": "CN=jdoe,DC=contoso,DC=com"}`
+	if _, err := unmarshallContact([]byte(user), nil); err == nil {
+		t.Error("expected an error for an object which is not a contact")
+	}
+}
+
+func TestContactAttributes(t *testing.T) {
+	c := &Contact{
+		DisplayName:      "Jane Doe",
+		Country:          "us",
+		CustomAttributes: map[string]interface{}{"otherTelephone": []interface{}{"555-0100", "555-0101"}},
+	}
+	expected := map[string]interface{}{
+		"displayName":    "Jane Doe",
+		"c":              "US",
+		"otherTelephone": []string{"555-0100", "555-0101"},
+	}
+	if attributes := c.attributes(); !reflect.DeepEqual(attributes, expected) {
+		t.Errorf("expected %v, got %v", expected, attributes)
+	}
+}
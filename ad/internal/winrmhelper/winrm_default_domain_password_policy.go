@@ -0,0 +1,192 @@
+package winrmhelper
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-provider-ad/ad/internal/config"
+	"github.com/hashicorp/terraform-provider-ad/ad/internal/gposec"
+)
+
+// defaultDomainPolicyGUID is the GUID of the Default Domain Policy GPO, which is the same in every domain.
+const defaultDomainPolicyGUID = "31B2F340-016D-11D2-945F-00C04FB984F9"
+
+// DefaultDomainPasswordPolicy is the password policy set on the head of a domain, which applies to the
+// users no fine grained password policy applies to.
+type DefaultDomainPasswordPolicy struct {
+	PasswordPolicy
+	DistinguishedName string
+}
+
+// GetDefaultDomainPasswordPolicy returns the password policy of the domain identity, a DN or a DNS name,
+// or of the domain of the domain controller if it is empty, with Get-ADDefaultDomainPasswordPolicy. The
+// values are the ones of the domain head, which the Default Domain Policy GPO overwrites when it is
+// applied to the domain controllers.
+func GetDefaultDomainPasswordPolicy(ctx context.Context, conf *config.ProviderConf, identity string) (*DefaultDomainPasswordPolicy, error) {
+	cmd := NewPSCommandBuilder("Get-ADDefaultDomainPasswordPolicy").AddParamIfNotEmpty("Identity", identity).String()
+	psOpts := CreatePSCommandOpts{
+		JSONOutput:      true,
+		JSONProperties:  append([]string{"DistinguishedName"}, passwordPolicyProperties...),
+		ExecLocally:     conf.IsConnectionTypeLocal(),
+		PassCredentials: conf.IsPassCredentialsEnabled(),
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
+		Server:          conf.IdentifyDomainController(ctx),
+	}
+	psCmd := NewPSCommand([]string{cmd}, psOpts)
+	result, err := psCmd.Run(ctx, conf)
+	if err != nil {
+		return nil, err
+	}
+	if result.ExitCode != 0 {
+		log.Printf("[DEBUG] stderr: %s\nstdout: %s", result.StdErr, result.Stdout)
+		return nil, NewPSCommandError("Get-ADDefaultDomainPasswordPolicy", result)
+	}
+	return unmarshallDefaultDomainPasswordPolicy([]byte(result.Stdout))
+}
+
+// unmarshallDefaultDomainPasswordPolicy unmarshalls the incoming byte array containing JSON into a
+// DefaultDomainPasswordPolicy structure.
+func unmarshallDefaultDomainPasswordPolicy(input []byte) (*DefaultDomainPasswordPolicy, error) {
+	var p DefaultDomainPasswordPolicy
+	err := json.Unmarshal(input, &p)
+	if err != nil {
+		log.Printf("[DEBUG] Failed to unmarshall json document with error %q, document was: %s", err, string(input))
+		return nil, fmt.Errorf("failed while unmarshalling json response: %s", err)
+	}
+	if p.DistinguishedName == "" {
+		return nil, fmt.Errorf("invalid data while unmarshalling DefaultDomainPasswordPolicy data, json doc was: %s", string(input))
+	}
+	return &p, nil
+}
+
+// SetDefaultDomainPasswordPolicy sets the password policy of the domain identity with
+// Set-ADDefaultDomainPasswordPolicy, from the settings present in the configuration of d, or only from
+// the ones that changed if onlyChanges is set. The other settings are left as they are.
+func SetDefaultDomainPasswordPolicy(ctx context.Context, conf *config.ProviderConf, identity string, d *schema.ResourceData, onlyChanges bool) error {
+	cmd := NewPSCommandBuilder("Set-ADDefaultDomainPasswordPolicy").AddParam("Identity", identity)
+	rawConfig := d.GetRawConfig()
+	for k, param := range passwordPolicyParams {
+		if onlyChanges && !d.HasChange(k) {
+			continue
+		}
+		if !onlyChanges && (rawConfig.IsNull() || rawConfig.GetAttr(k).IsNull()) {
+			continue
+		}
+		cmd.AddParam(param, d.Get(k))
+	}
+	if cmd.Len() == 1 {
+		return nil
+	}
+
+	psOpts := CreatePSCommandOpts{
+		JSONOutput:      false,
+		ExecLocally:     conf.IsConnectionTypeLocal(),
+		PassCredentials: conf.IsPassCredentialsEnabled(),
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
+		Server:          conf.IdentifyDomainController(ctx),
+	}
+	psCmd := NewPSCommand([]string{cmd.String()}, psOpts)
+	result, err := psCmd.Run(ctx, conf)
+	if err != nil {
+		return err
+	}
+	if result.ExitCode != 0 {
+		log.Printf("[DEBUG] stderr: %s\nstdout: %s", result.StdErr, result.Stdout)
+		return NewPSCommandError("Set-ADDefaultDomainPasswordPolicy", result)
+	}
+	return nil
+}
+
+// GetDefaultDomainPolicyGPOSettings returns the password and lockout settings defined in the Default
+// Domain Policy GPO of the domain of the provider, formatted as PasswordPolicy.Settings formats them.
+// The GPO overwrites the policy of the domain head when it is applied to the domain controllers.
+func GetDefaultDomainPolicyGPOSettings(ctx context.Context, conf *config.ProviderConf) (map[string]string, error) {
+	gpo, err := GetGPOFromHost(ctx, conf, "", defaultDomainPolicyGUID)
+	if err != nil {
+		return nil, fmt.Errorf("while reading the Default Domain Policy GPO: %s", err)
+	}
+	sec, err := GetSecIniFromHost(ctx, conf, gpo)
+	if err != nil {
+		return nil, fmt.Errorf("while reading the security settings of the Default Domain Policy GPO: %s", err)
+	}
+	return gpoPasswordPolicySettings(sec), nil
+}
+
+// gpoPasswordPolicySettings returns the password and lockout settings of sec by the name of the arguments
+// of the password policy resources. The settings sec does not define are omitted. Ages are set in days
+// and lockout durations in minutes in the GPO, negative values meaning forever.
+func gpoPasswordPolicySettings(sec *gposec.SecuritySettings) map[string]string {
+	settings := map[string]string{}
+	if sec.SystemAccess == nil {
+		return settings
+	}
+	pp, al := sec.SystemAccess.PasswordPolicies, sec.SystemAccess.AccountLockout
+	if pp == nil {
+		pp = &gposec.PasswordPolicies{}
+	}
+	if al == nil {
+		al = &gposec.AccountLockout{}
+	}
+
+	setInt := func(k, v string) (int, bool) {
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return 0, false
+		}
+		settings[k] = strconv.Itoa(n)
+		return n, true
+	}
+	setBool := func(k, v string) {
+		if n, ok := setInt(k, v); ok {
+			settings[k] = strconv.FormatBool(n != 0)
+		}
+	}
+	setTimeSpan := func(k, v string, unit time.Duration) {
+		if n, ok := setInt(k, v); ok {
+			if n < 0 {
+				delete(settings, k)
+				return
+			}
+			settings[k] = FormatTimeSpan(time.Duration(n) * unit)
+		}
+	}
+
+	setBool("complexity_enabled", pp.PasswordComplexity)
+	setBool("reversible_encryption_enabled", pp.ClearTextPassword)
+	setInt("min_password_length", pp.MinimumPasswordLength)
+	setInt("password_history_count", pp.PasswordHistorySize)
+	setInt("lockout_threshold", al.LockoutBadCount)
+	setTimeSpan("lockout_duration", al.LockoutDuration, time.Minute)
+	setTimeSpan("lockout_observation_window", al.ResetLockoutCount, time.Minute)
+	setTimeSpan("min_password_age", pp.MinimumPasswordAge, 24*time.Hour)
+	// Passwords never expire when the maximum age is 0 in the policy of the domain head, -1 in the GPO.
+	maxPasswordAge := pp.MaximumPasswordAge
+	if n, err := strconv.Atoi(strings.TrimSpace(maxPasswordAge)); err == nil && n < 0 {
+		maxPasswordAge = "0"
+	}
+	setTimeSpan("max_password_age", maxPasswordAge, 24*time.Hour)
+	return settings
+}
+
+// Settings returns the settings of p by the name of the arguments of the password policy resources.
+func (p *PasswordPolicy) Settings() map[string]string {
+	return map[string]string{
+		"complexity_enabled":            strconv.FormatBool(p.ComplexityEnabled),
+		"reversible_encryption_enabled": strconv.FormatBool(p.ReversibleEncryptionEnabled),
+		"min_password_length":           strconv.Itoa(p.MinPasswordLength),
+		"password_history_count":        strconv.Itoa(p.PasswordHistoryCount),
+		"lockout_threshold":             strconv.Itoa(p.LockoutThreshold),
+		"lockout_duration":              FormatTimeSpan(p.LockoutDuration.Duration),
+		"lockout_observation_window":    FormatTimeSpan(p.LockoutObservationWindow.Duration),
+		"min_password_age":              FormatTimeSpan(p.MinPasswordAge.Duration),
+		"max_password_age":              FormatTimeSpan(p.MaxPasswordAge.Duration),
+	}
+}
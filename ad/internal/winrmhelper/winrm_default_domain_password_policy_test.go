@@ -0,0 +1,80 @@
+package winrmhelper
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-provider-ad/ad/internal/gposec"
+)
+
+func TestUnmarshallDefaultDomainPasswordPolicy(t *testing.T) {
+	output := `{
+    "DistinguishedName":  "DC=contoso,DC=com",
+    "ComplexityEnabled":  true,
+    "ReversibleEncryptionEnabled":  false,
+    "MinPasswordLength":  7,
+    "PasswordHistoryCount":  24,
+    "LockoutThreshold":  0,
+    "LockoutDuration":  {"Ticks": 18000000000},
+    "LockoutObservationWindow":  {"Ticks": 18000000000},
+    "MinPasswordAge":  {"Ticks": 864000000000},
+    "MaxPasswordAge":  {"Ticks": 36288000000000}
+}`
+	p, err := unmarshallDefaultDomainPasswordPolicy([]byte(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.DistinguishedName != "DC=contoso,DC=com" || p.MinPasswordLength != 7 || p.PasswordHistoryCount != 24 {
+		t.Errorf("unexpected policy %+v", p)
+	}
+	if FormatTimeSpan(p.MaxPasswordAge.Duration) != "42.00:00:00" || p.LockoutDuration.Duration != 30*time.Minute {
+		t.Errorf("unexpected time spans %s, %s", p.MaxPasswordAge, p.LockoutDuration)
+	}
+
+	if _, err := unmarshallDefaultDomainPasswordPolicy([]byte(`{"MinPasswordLength": 7}`)); err == nil {
+		t.Error("expected an error for a policy without DN")
+	}
+}
+
+func TestGPOPasswordPolicySettings(t *testing.T) {
+	ini := `[Unicode]
+Unicode=yes
+[System Access]
+MinimumPasswordAge = 1
+MaximumPasswordAge = -1
+MinimumPasswordLength = 12
+PasswordComplexity = 1
+PasswordHistorySize = 24
+LockoutBadCount = 5
+ResetLockoutCount = 15
+LockoutDuration = -1
+[Version]
+signature="$CHICAGO$"
+Revision=1
+`
+	sec, err := gposec.ParseIniFile([]byte(ini), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"complexity_enabled":         "true",
+		"min_password_length":        "12",
+		"password_history_count":     "24",
+		"lockout_threshold":          "5",
+		"lockout_observation_window": "00:15:00",
+		"min_password_age":           "1.00:00:00",
+		"max_password_age":           "00:00:00",
+	}
+	if actual := gpoPasswordPolicySettings(sec); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if actual := gpoPasswordPolicySettings(&gposec.SecuritySettings{}); len(actual) != 0 {
+		t.Errorf("expected no settings without a System Access section, got %v", actual)
+	}
+
+	p := PasswordPolicy{ComplexityEnabled: true, MinPasswordLength: 12, MinPasswordAge: PSTimeSpan{24 * time.Hour}}
+	if settings := p.Settings(); settings["complexity_enabled"] != "true" || settings["min_password_length"] != "12" || settings["min_password_age"] != "1.00:00:00" || settings["max_password_age"] != "00:00:00" {
+		t.Errorf("unexpected settings %v", settings)
+	}
+}
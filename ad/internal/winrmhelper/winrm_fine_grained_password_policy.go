@@ -0,0 +1,386 @@
+package winrmhelper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-provider-ad/ad/internal/config"
+)
+
+// PasswordPolicy holds the settings shared by the default password policy of a domain and the fine
+// grained password policies.
+type PasswordPolicy struct {
+	ComplexityEnabled           bool
+	ReversibleEncryptionEnabled bool
+	MinPasswordLength           int
+	PasswordHistoryCount        int
+	LockoutThreshold            int
+	LockoutDuration             PSTimeSpan
+	LockoutObservationWindow    PSTimeSpan
+	MinPasswordAge              PSTimeSpan
+	MaxPasswordAge              PSTimeSpan
+}
+
+// passwordPolicyParams maps the arguments of the password policy resources to the parameters of the
+// cmdlets. The time spans are passed in the [d.]hh:mm:ss format, which powershell converts.
+var passwordPolicyParams = map[string]string{
+	"complexity_enabled":            "ComplexityEnabled",
+	"reversible_encryption_enabled": "ReversibleEncryptionEnabled",
+	"min_password_length":           "MinPasswordLength",
+	"password_history_count":        "PasswordHistoryCount",
+	"lockout_threshold":             "LockoutThreshold",
+	"lockout_duration":              "LockoutDuration",
+	"lockout_observation_window":    "LockoutObservationWindow",
+	"min_password_age":              "MinPasswordAge",
+	"max_password_age":              "MaxPasswordAge",
+}
+
+// passwordPolicyProperties are the properties decoded into PasswordPolicy.
+var passwordPolicyProperties = []string{
+	"ComplexityEnabled", "ReversibleEncryptionEnabled", "MinPasswordLength", "PasswordHistoryCount",
+	"LockoutThreshold", "LockoutDuration", "LockoutObservationWindow", "MinPasswordAge", "MaxPasswordAge",
+}
+
+// addPasswordPolicyParams sets the parameters of the password policy arguments of d, or only the ones
+// that changed if onlyChanges is set.
+func addPasswordPolicyParams(cmd *PSCommandBuilder, d *schema.ResourceData, onlyChanges bool) {
+	for k, param := range passwordPolicyParams {
+		if onlyChanges && !d.HasChange(k) {
+			continue
+		}
+		cmd.AddParam(param, d.Get(k))
+	}
+}
+
+// FineGrainedPasswordPolicy is a Password Settings Object, and the users and groups it applies to.
+type FineGrainedPasswordPolicy struct {
+	PasswordPolicy
+	GUID              string `json:"ObjectGUID"`
+	Name              string
+	DisplayName       string
+	Description       string
+	DistinguishedName string
+	Precedence        int
+	Protected         bool `json:"ProtectedFromAccidentalDeletion"`
+	// Subjects holds the GUIDs of the users and groups the policy applies to.
+	Subjects []string `json:"-"`
+}
+
+// fineGrainedPasswordPolicyProperties are the properties of the policies decoded into
+// FineGrainedPasswordPolicy.
+var fineGrainedPasswordPolicyProperties = append([]string{
+	"ObjectGUID", "Name", "DisplayName", "Description", "DistinguishedName", "Precedence", "ProtectedFromAccidentalDeletion",
+}, passwordPolicyProperties...)
+
+// GetFineGrainedPasswordPolicyFromResource returns a policy struct built from Resource data
+func GetFineGrainedPasswordPolicyFromResource(d *schema.ResourceData) *FineGrainedPasswordPolicy {
+	return &FineGrainedPasswordPolicy{
+		GUID:        d.Id(),
+		Name:        d.Get("name").(string),
+		DisplayName: d.Get("display_name").(string),
+		Description: d.Get("description").(string),
+		Precedence:  d.Get("precedence").(int),
+		Protected:   d.Get("protected").(bool),
+		Subjects:    setToStringList(d.Get("subjects").(*schema.Set)),
+	}
+}
+
+// GetFineGrainedPasswordPolicyFromHost retrieves the policy with the given GUID, and the GUIDs of its
+// subjects.
+func GetFineGrainedPasswordPolicyFromHost(ctx context.Context, conf *config.ProviderConf, guid string) (*FineGrainedPasswordPolicy, error) {
+	cmd := NewPSCommandBuilder("Get-ADFineGrainedPasswordPolicy").AddParam("Identity", guid).AddParam("Properties", "*").String()
+	psOpts := CreatePSCommandOpts{
+		JSONOutput:      true,
+		JSONProperties:  fineGrainedPasswordPolicyProperties,
+		ExecLocally:     conf.IsConnectionTypeLocal(),
+		PassCredentials: conf.IsPassCredentialsEnabled(),
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
+		Server:          conf.IdentifyDomainController(ctx),
+	}
+	psCmd := NewPSCommand([]string{cmd}, psOpts)
+	result, err := psCmd.Run(ctx, conf)
+	if err != nil {
+		return nil, err
+	}
+	if result.ExitCode != 0 {
+		log.Printf("[DEBUG] stderr: %s\nstdout: %s", result.StdErr, result.Stdout)
+		return nil, NewPSCommandError("Get-ADFineGrainedPasswordPolicy", result)
+	}
+
+	p, err := unmarshallFineGrainedPasswordPolicy([]byte(result.Stdout))
+	if err != nil {
+		return nil, fmt.Errorf("error while unmarshalling password policy json document: %s", err)
+	}
+	p.Subjects, err = p.getSubjects(ctx, conf)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// unmarshallFineGrainedPasswordPolicy unmarshalls the incoming byte array containing JSON into a
+// FineGrainedPasswordPolicy structure.
+func unmarshallFineGrainedPasswordPolicy(input []byte) (*FineGrainedPasswordPolicy, error) {
+	var p FineGrainedPasswordPolicy
+	err := json.Unmarshal(input, &p)
+	if err != nil {
+		log.Printf("[DEBUG] Failed to unmarshall json document with error %q, document was: %s", err, string(input))
+		return nil, fmt.Errorf("failed while unmarshalling json response: %s", err)
+	}
+	if p.GUID == "" {
+		return nil, fmt.Errorf("invalid data while unmarshalling FineGrainedPasswordPolicy data, json doc was: %s", string(input))
+	}
+	return &p, nil
+}
+
+// getSubjects returns the GUIDs of the users and groups the policy applies to, sorted.
+func (p *FineGrainedPasswordPolicy) getSubjects(ctx context.Context, conf *config.ProviderConf) ([]string, error) {
+	cmd := NewPSCommandBuilder("Get-ADFineGrainedPasswordPolicySubject").AddParam("Identity", p.GUID).String()
+	psOpts := CreatePSCommandOpts{
+		JSONOutput:      true,
+		JSONProperties:  []string{"ObjectGUID"},
+		ForceArray:      true,
+		ExecLocally:     conf.IsConnectionTypeLocal(),
+		PassCredentials: conf.IsPassCredentialsEnabled(),
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
+		Server:          conf.IdentifyDomainController(ctx),
+	}
+	psCmd := NewPSCommand([]string{cmd}, psOpts)
+	result, err := psCmd.Run(ctx, conf)
+	if err != nil {
+		return nil, fmt.Errorf("while running Get-ADFineGrainedPasswordPolicySubject: %s", err)
+	} else if result.ExitCode != 0 {
+		return nil, NewPSCommandError("Get-ADFineGrainedPasswordPolicySubject", result)
+	}
+	if strings.TrimSpace(result.Stdout) == "" {
+		return []string{}, nil
+	}
+
+	var subjects []struct {
+		GUID string `json:"ObjectGUID"`
+	}
+	if err := json.Unmarshal([]byte(result.Stdout), &subjects); err != nil {
+		return nil, fmt.Errorf("while unmarshalling the subjects of the password policy: %s", err)
+	}
+	out := make([]string, 0, len(subjects))
+	for _, s := range subjects {
+		out = append(out, s.GUID)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// diffStringLists returns the items of expected missing from existing, and the items of existing
+// missing from expected. Items are compared case insensitively.
+func diffStringLists(expected, existing []string) ([]string, []string) {
+	contains := func(list []string, item string) bool {
+		for _, i := range list {
+			if strings.EqualFold(i, item) {
+				return true
+			}
+		}
+		return false
+	}
+	var toAdd, toRemove []string
+	for _, item := range expected {
+		if !contains(existing, item) {
+			toAdd = append(toAdd, item)
+		}
+	}
+	for _, item := range existing {
+		if !contains(expected, item) {
+			toRemove = append(toRemove, item)
+		}
+	}
+	return toAdd, toRemove
+}
+
+// bulkSubjectsOp runs operation, Add-ADFineGrainedPasswordPolicySubject or
+// Remove-ADFineGrainedPasswordPolicySubject, for subjects.
+func (p *FineGrainedPasswordPolicy) bulkSubjectsOp(ctx context.Context, conf *config.ProviderConf, operation string, subjects []string) error {
+	if len(subjects) == 0 {
+		return nil
+	}
+
+	cmd := NewPSCommandBuilder(operation).
+		AddParam("Identity", p.GUID).
+		AddParam("Subjects", subjects).
+		AddParam("Confirm", false).
+		String()
+	psOpts := CreatePSCommandOpts{
+		JSONOutput:      false,
+		ExecLocally:     conf.IsConnectionTypeLocal(),
+		PassCredentials: conf.IsPassCredentialsEnabled(),
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
+		Server:          conf.IdentifyDomainController(ctx),
+	}
+	psCmd := NewPSCommand([]string{cmd}, psOpts)
+	result, err := psCmd.Run(ctx, conf)
+	if err != nil {
+		return fmt.Errorf("while running %s: %s", operation, err)
+	} else if result.ExitCode != 0 {
+		return NewPSCommandError(operation, result)
+	}
+	return nil
+}
+
+// UpdateSubjects applies the policy to the subjects of p, and only to them.
+func (p *FineGrainedPasswordPolicy) UpdateSubjects(ctx context.Context, conf *config.ProviderConf) error {
+	existing, err := p.getSubjects(ctx, conf)
+	if err != nil {
+		return err
+	}
+
+	toAdd, toRemove := diffStringLists(p.Subjects, existing)
+	err = p.bulkSubjectsOp(ctx, conf, "Add-ADFineGrainedPasswordPolicySubject", toAdd)
+	if err != nil {
+		return err
+	}
+	return p.bulkSubjectsOp(ctx, conf, "Remove-ADFineGrainedPasswordPolicySubject", toRemove)
+}
+
+// Create creates the policy with New-ADFineGrainedPasswordPolicy, with the settings of d, and returns its
+// GUID. The subjects are applied with UpdateSubjects.
+func (p *FineGrainedPasswordPolicy) Create(ctx context.Context, d *schema.ResourceData, conf *config.ProviderConf) (string, error) {
+	if p.Name == "" {
+		return "", fmt.Errorf("FineGrainedPasswordPolicy.Create: missing name variable")
+	}
+
+	log.Printf("[DEBUG] Adding password policy %q", p.Name)
+	cmd := NewPSCommandBuilder("New-ADFineGrainedPasswordPolicy").
+		AddParam("PassThru", true).
+		AddParam("Name", p.Name).
+		AddParam("Precedence", p.Precedence).
+		AddParam("ProtectedFromAccidentalDeletion", p.Protected).
+		AddParamIfNotEmpty("DisplayName", p.DisplayName).
+		AddParamIfNotEmpty("Description", p.Description)
+	addPasswordPolicyParams(cmd, d, false)
+
+	psOpts := CreatePSCommandOpts{
+		JSONOutput:      true,
+		JSONProperties:  []string{"ObjectGUID"},
+		NonIdempotent:   true,
+		ExecLocally:     conf.IsConnectionTypeLocal(),
+		PassCredentials: conf.IsPassCredentialsEnabled(),
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
+		Server:          conf.IdentifyDomainController(ctx),
+	}
+	psCmd := NewPSCommand([]string{cmd.String()}, psOpts)
+	result, err := psCmd.Run(ctx, conf)
+	if err != nil {
+		return "", err
+	}
+	if result.ExitCode != 0 {
+		log.Printf("[DEBUG] stderr: %s\nstdout: %s", result.StdErr, result.Stdout)
+		err := NewPSCommandError("New-ADFineGrainedPasswordPolicy", result)
+		if errors.Is(err, ErrAlreadyExists) {
+			return "", fmt.Errorf("there is another password policy named %q: %w", p.Name, err)
+		}
+		return "", err
+	}
+
+	var created struct {
+		GUID string `json:"ObjectGUID"`
+	}
+	if err := json.Unmarshal([]byte(result.Stdout), &created); err != nil || created.GUID == "" {
+		return "", fmt.Errorf("invalid output of New-ADFineGrainedPasswordPolicy: %s", result.Stdout)
+	}
+	return created.GUID, nil
+}
+
+// Modify updates the settings of the policy that changed in d.
+func (p *FineGrainedPasswordPolicy) Modify(ctx context.Context, d *schema.ResourceData, conf *config.ProviderConf) error {
+	log.Printf("[DEBUG] Modifying password policy %q", p.Name)
+	setCmd := NewPSCommandBuilder("Set-ADFineGrainedPasswordPolicy").AddParam("Identity", p.GUID)
+	if d.HasChange("display_name") {
+		setCmd.AddParamOrNull("DisplayName", p.DisplayName)
+	}
+	if d.HasChange("description") {
+		setCmd.AddParamOrNull("Description", p.Description)
+	}
+	if d.HasChange("precedence") {
+		setCmd.AddParam("Precedence", p.Precedence)
+	}
+	if d.HasChange("protected") {
+		setCmd.AddParam("ProtectedFromAccidentalDeletion", p.Protected)
+	}
+	addPasswordPolicyParams(setCmd, d, true)
+
+	if setCmd.Len() > 1 {
+		if err := p.set(ctx, conf, setCmd); err != nil {
+			return err
+		}
+	}
+	if d.HasChange("subjects") {
+		return p.UpdateSubjects(ctx, conf)
+	}
+	return nil
+}
+
+// set runs setCmd, a Set-ADFineGrainedPasswordPolicy command.
+func (p *FineGrainedPasswordPolicy) set(ctx context.Context, conf *config.ProviderConf, setCmd *PSCommandBuilder) error {
+	psOpts := CreatePSCommandOpts{
+		JSONOutput:      false,
+		ExecLocally:     conf.IsConnectionTypeLocal(),
+		PassCredentials: conf.IsPassCredentialsEnabled(),
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
+		Server:          conf.IdentifyDomainController(ctx),
+	}
+	psCmd := NewPSCommand([]string{setCmd.String()}, psOpts)
+	result, err := psCmd.Run(ctx, conf)
+	if err != nil {
+		return err
+	}
+	if result.ExitCode != 0 {
+		log.Printf("[DEBUG] stderr: %s\nstdout: %s", result.StdErr, result.Stdout)
+		return NewPSCommandError("Set-ADFineGrainedPasswordPolicy", result)
+	}
+	return nil
+}
+
+// Delete removes the protection from accidental deletion of the policy, and deletes it.
+func (p *FineGrainedPasswordPolicy) Delete(ctx context.Context, conf *config.ProviderConf) error {
+	setCmd := NewPSCommandBuilder("Set-ADFineGrainedPasswordPolicy").
+		AddParam("Identity", p.GUID).
+		AddParam("ProtectedFromAccidentalDeletion", false)
+	if err := p.set(ctx, conf, setCmd); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	cmd := NewPSCommandBuilder("Remove-ADFineGrainedPasswordPolicy").AddParam("Identity", p.GUID).AddParam("Confirm", false).String()
+	psOpts := CreatePSCommandOpts{
+		JSONOutput:      false,
+		ExecLocally:     conf.IsConnectionTypeLocal(),
+		PassCredentials: conf.IsPassCredentialsEnabled(),
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
+		Server:          conf.IdentifyDomainController(ctx),
+	}
+	psCmd := NewPSCommand([]string{cmd}, psOpts)
+	result, err := psCmd.Run(ctx, conf)
+	if err != nil {
+		return err
+	}
+	if result.ExitCode != 0 {
+		err := NewPSCommandError("Remove-ADFineGrainedPasswordPolicy", result)
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package winrmhelper
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestUnmarshallFineGrainedPasswordPolicy(t *testing.T) {
+	output := `{
+    "ObjectGUID":  "6f2b8c1e-4d3a-4b5c-9e8f-7a6b5c4d3e2f",
+    "Name":  "admins",
+    "DisplayName":  null,
+    "Description":  "Policy of the administrators",
+    "DistinguishedName":  "CN=admins,CN=Password Settings Container,CN=System,DC=contoso,DC=com",
+    "Precedence":  10,
+    "ProtectedFromAccidentalDeletion":  true,
+    "ComplexityEnabled":  true,
+    "ReversibleEncryptionEnabled":  false,
+    "MinPasswordLength":  14,
+    "PasswordHistoryCount":  24,
+    "LockoutThreshold":  5,
+    "LockoutDuration":  {"Ticks": 18000000000, "Days": 0, "Hours": 0, "Minutes": 30},
+    "LockoutObservationWindow":  {"Ticks": 9000000000, "Days": 0, "Hours": 0, "Minutes": 15},
+    "MinPasswordAge":  {"Ticks": 864000000000, "Days": 1},
+    "MaxPasswordAge":  {"Ticks": 0}
+}`
+	p, err := unmarshallFineGrainedPasswordPolicy([]byte(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "admins" || p.Precedence != 10 || !p.Protected || p.DisplayName != "" {
+		t.Errorf("unexpected policy %+v", p)
+	}
+	if p.MinPasswordLength != 14 || p.LockoutThreshold != 5 || !p.ComplexityEnabled || p.ReversibleEncryptionEnabled {
+		t.Errorf("unexpected settings %+v", p.PasswordPolicy)
+	}
+	if p.LockoutDuration.Duration != 30*time.Minute || p.LockoutObservationWindow.Duration != 15*time.Minute {
+		t.Errorf("unexpected lockout durations %s, %s", p.LockoutDuration, p.LockoutObservationWindow)
+	}
+	if p.MinPasswordAge.Duration != 24*time.Hour || p.MaxPasswordAge.Duration != 0 {
+		t.Errorf("unexpected password ages %s, %s", p.MinPasswordAge, p.MaxPasswordAge)
+	}
+
+	if _, err := unmarshallFineGrainedPasswordPolicy([]byte(`{"Name": "admins"}`)); err == nil {
+		t.Error("expected an error for a policy without GUID")
+	}
+}
+
+func TestTimeSpan(t *testing.T) {
+	cases := []struct {
+		input    string
+		duration time.Duration
+		format   string
+	}{
+		{"00:30:00", 30 * time.Minute, "00:30:00"},
+		{"1.00:00:00", 24 * time.Hour, "1.00:00:00"},
+		{"1.0:00:00", 24 * time.Hour, "1.00:00:00"},
+		{"24:00:00", 0, ""},
+		{"42.00:00:00", 42 * 24 * time.Hour, "42.00:00:00"},
+		{"1:02:03", time.Hour + 2*time.Minute + 3*time.Second, "01:02:03"},
+		{"00:00:00", 0, "00:00:00"},
+		{"30", 0, ""},
+		{"00:60:00", 0, ""},
+		{"-1.00:00:00", 0, ""},
+	}
+	for _, c := range cases {
+		d, err := ParseTimeSpan(c.input)
+		if c.format == "" {
+			if err == nil {
+				t.Errorf("expected an error for %q, got %s", c.input, d)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error for %q: %s", c.input, err)
+			continue
+		}
+		if d != c.duration {
+			t.Errorf("expected %s for %q, got %s", c.duration, c.input, d)
+		}
+		if f := FormatTimeSpan(d); f != c.format {
+			t.Errorf("expected %q for %q, got %q", c.format, c.input, f)
+		}
+	}
+}
+
+func TestDiffStringLists(t *testing.T) {
+	toAdd, toRemove := diffStringLists([]string{"A", "b", "c"}, []string{"a", "d"})
+	if !reflect.DeepEqual(toAdd, []string{"b", "c"}) {
+		t.Errorf("unexpected items to add %v", toAdd)
+	}
+	if !reflect.DeepEqual(toRemove, []string{"d"}) {
+		t.Errorf("unexpected items to remove %v", toRemove)
+	}
+}
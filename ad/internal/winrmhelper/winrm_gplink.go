@@ -1,7 +1,9 @@
 package winrmhelper
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"regexp"
@@ -24,7 +26,7 @@ type GPLink struct {
 }
 
 // NewGPLink creates a link between a GPO and an AD object
-func (g *GPLink) NewGPLink(conf *config.ProviderConf) (string, error) {
+func (g *GPLink) NewGPLink(ctx context.Context, conf *config.ProviderConf) (string, error) {
 	log.Printf("[DEBUG] Creating new user")
 	enforced := "No"
 	if g.Enforced {
@@ -36,10 +38,14 @@ func (g *GPLink) NewGPLink(conf *config.ProviderConf) (string, error) {
 		enabled = "Yes"
 	}
 
-	cmds := []string{fmt.Sprintf("New-GPLink -Guid %q -Target %q -LinkEnabled %q -Enforced %q", g.GPOGuid, g.Target, enabled, enforced)}
+	cmd := NewPSCommandBuilder("New-GPLink").
+		AddParam("Guid", g.GPOGuid).
+		AddParam("Target", g.Target).
+		AddParam("LinkEnabled", enabled).
+		AddParam("Enforced", enforced)
 
 	if g.Order > 0 {
-		cmds = append(cmds, fmt.Sprintf("-Order %d", g.Order))
+		cmd.AddParam("Order", g.Order)
 	}
 	domainName := conf.Settings.DomainName
 	if conf.Settings.KrbRealm == domainName {
@@ -48,25 +54,27 @@ func (g *GPLink) NewGPLink(conf *config.ProviderConf) (string, error) {
 	psOpts := CreatePSCommandOpts{
 		JSONOutput:      true,
 		ForceArray:      false,
+		NonIdempotent:   true,
 		ExecLocally:     conf.IsConnectionTypeLocal(),
 		PassCredentials: conf.IsPassCredentialsEnabled(),
-		Username:        conf.Settings.WinRMUsername,
-		Password:        conf.Settings.WinRMPassword,
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
 		Server:          domainName,
 		InvokeCommand:   conf.IsPassCredentialsEnabled(),
 	}
-	psCmd := NewPSCommand(cmds, psOpts)
-	result, err := psCmd.Run(conf)
+	psCmd := NewPSCommand([]string{cmd.String()}, psOpts)
+	result, err := psCmd.Run(ctx, conf)
 	if err != nil {
 		return "", err
 	}
 
 	if result.ExitCode != 0 {
 		log.Printf("[DEBUG] stderr: %s\nstdout: %s", result.StdErr, result.Stdout)
-		if strings.Contains(result.StdErr, "is already linked") {
-			return "", fmt.Errorf("there is another link between GPO %q and target %q", g.GPOGuid, g.Target)
+		err := NewPSCommandError("New-GPLink", result)
+		if errors.Is(err, ErrAlreadyExists) {
+			return "", fmt.Errorf("there is another link between GPO %q and target %q: %w", g.GPOGuid, g.Target, err)
 		}
-		return "", fmt.Errorf("command New-GPLink exited with a non-zero exit code %d, stderr: %s", result.ExitCode, result.StdErr)
+		return "", err
 	}
 
 	gplink, err := unmarshallNewGPLink([]byte(result.Stdout))
@@ -74,7 +82,7 @@ func (g *GPLink) NewGPLink(conf *config.ProviderConf) (string, error) {
 		return "", fmt.Errorf("error while unmarshalling gplink json document: %s", err)
 	}
 
-	ou, err := NewOrgUnitFromHost(conf, gplink.Target, "", "")
+	ou, err := NewOrgUnitFromHost(ctx, conf, gplink.Target, "", "")
 	if err != nil {
 		return "", fmt.Errorf("failed to retrieve details for OU %q: %s", gplink.Target, err)
 	}
@@ -86,8 +94,8 @@ func (g *GPLink) NewGPLink(conf *config.ProviderConf) (string, error) {
 }
 
 // ModifyGPLink changes a GPO link
-func (g *GPLink) ModifyGPLink(conf *config.ProviderConf, changes map[string]interface{}) error {
-	cmds := []string{fmt.Sprintf("Set-GPLink -guid %q -target %q", g.GPOGuid, g.Target)}
+func (g *GPLink) ModifyGPLink(ctx context.Context, conf *config.ProviderConf, changes map[string]interface{}) error {
+	cmd := NewPSCommandBuilder("Set-GPLink").AddParam("Guid", g.GPOGuid).AddParam("Target", g.Target)
 	keyMap := map[string]string{
 		"enforced": "Enforced",
 		"enabled":  "LinkEnabled",
@@ -99,15 +107,15 @@ func (g *GPLink) ModifyGPLink(conf *config.ProviderConf, changes map[string]inte
 			if v.(bool) {
 				value = "Yes"
 			}
-			cmds = append(cmds, fmt.Sprintf("-%s %q", paramName, value))
+			cmd.AddParam(paramName, value)
 		}
 	}
 
 	if order, ok := changes["order"]; ok {
-		cmds = append(cmds, fmt.Sprintf("-Order %d", order.(int)))
+		cmd.AddParam("Order", order.(int))
 	}
 
-	if len(cmds) == 1 {
+	if cmd.Len() == 2 {
 		return nil
 	}
 	domainName := conf.Settings.DomainName
@@ -119,27 +127,27 @@ func (g *GPLink) ModifyGPLink(conf *config.ProviderConf, changes map[string]inte
 		ForceArray:      false,
 		ExecLocally:     conf.IsConnectionTypeLocal(),
 		PassCredentials: conf.IsPassCredentialsEnabled(),
-		Username:        conf.Settings.WinRMUsername,
-		Password:        conf.Settings.WinRMPassword,
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
 		Server:          domainName,
 		InvokeCommand:   conf.IsPassCredentialsEnabled(),
 	}
-	psCmd := NewPSCommand(cmds, psOpts)
-	result, err := psCmd.Run(conf)
+	psCmd := NewPSCommand([]string{cmd.String()}, psOpts)
+	result, err := psCmd.Run(ctx, conf)
 	if err != nil {
 		return fmt.Errorf("error while running Set-GPLink: %s", err)
 	}
 
 	if result.ExitCode != 0 {
-		return fmt.Errorf("Set-GPLink exited with a non-zero exit code %d, stderr :%s", result.ExitCode, result.StdErr)
+		return NewPSCommandError("Set-GPLink", result)
 	}
 
 	return nil
 }
 
 // RemoveGPLink deletes a link between a GPO and an AD object
-func (g *GPLink) RemoveGPLink(conf *config.ProviderConf) error {
-	cmd := fmt.Sprintf("Remove-GPlink -Guid %q -Target %q", g.GPOGuid, g.Target)
+func (g *GPLink) RemoveGPLink(ctx context.Context, conf *config.ProviderConf) error {
+	cmd := NewPSCommandBuilder("Remove-GPLink").AddParam("Guid", g.GPOGuid).AddParam("Target", g.Target).String()
 	domainName := conf.Settings.DomainName
 	if conf.Settings.KrbRealm == domainName {
 		domainName = "$env:computername"
@@ -149,22 +157,22 @@ func (g *GPLink) RemoveGPLink(conf *config.ProviderConf) error {
 		ForceArray:      false,
 		ExecLocally:     conf.IsConnectionTypeLocal(),
 		PassCredentials: conf.IsPassCredentialsEnabled(),
-		Username:        conf.Settings.WinRMUsername,
-		Password:        conf.Settings.WinRMPassword,
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
 		Server:          domainName,
 		InvokeCommand:   conf.IsPassCredentialsEnabled(),
 	}
 	psCmd := NewPSCommand([]string{cmd}, psOpts)
-	result, err := psCmd.Run(conf)
+	result, err := psCmd.Run(ctx, conf)
 	if err != nil {
 		return fmt.Errorf("while removing GPLink: %s", err)
 	} else if result.ExitCode != 0 {
-		stderr := result.StdErr
-		if strings.Contains(stderr, "GpoLinkNotFound") || strings.Contains(stderr, "GpoWithIdNotFound") || strings.Contains(stderr, "There is no such object on the server") {
+		err := NewPSCommandError("Remove-GPLink", result)
+		if errors.Is(err, ErrNotFound) {
 			// Check if the resource is already deleted
 			return nil
 		}
-		return fmt.Errorf("while removing GPLink: %s", stderr)
+		return fmt.Errorf("while removing GPLink: %w", err)
 	}
 	return nil
 }
@@ -173,8 +181,8 @@ func (g *GPLink) RemoveGPLink(conf *config.ProviderConf) error {
 // resource's configuration
 func GetGPLinkFromResource(d *schema.ResourceData) *GPLink {
 	gplink := GPLink{
-		GPOGuid:  SanitiseTFInput(d, "gpo_guid"),
-		Target:   SanitiseTFInput(d, "target_dn"),
+		GPOGuid:  d.Get("gpo_guid").(string),
+		Target:   d.Get("target_dn").(string),
 		Enabled:  d.Get("enabled").(bool),
 		Enforced: d.Get("enforced").(bool),
 		Order:    d.Get("order").(int),
@@ -184,30 +192,30 @@ func GetGPLinkFromResource(d *schema.ResourceData) *GPLink {
 
 // GetGPLinkFromHost returns a GPLink struct populated with data retrieved from the
 // Domain Controller
-func GetGPLinkFromHost(conf *config.ProviderConf, gpoGUID, containerGUID string) (*GPLink, error) {
-	cmds := []string{fmt.Sprintf("Get-ADObject -filter {ObjectGUID -eq %q} -properties gplink", containerGUID)}
+func GetGPLinkFromHost(ctx context.Context, conf *config.ProviderConf, gpoGUID, containerGUID string) (*GPLink, error) {
+	cmd := NewPSCommandBuilder("Get-ADObject").AddParam("Identity", containerGUID).AddParam("Properties", "gPLink").String()
 	psOpts := CreatePSCommandOpts{
 		JSONOutput:      true,
 		ForceArray:      false,
 		ExecLocally:     conf.IsConnectionTypeLocal(),
 		PassCredentials: conf.IsPassCredentialsEnabled(),
-		Username:        conf.Settings.WinRMUsername,
-		Password:        conf.Settings.WinRMPassword,
-		Server:          conf.IdentifyDomainController(),
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
+		Server:          conf.IdentifyDomainController(ctx),
 	}
-	psCmd := NewPSCommand(cmds, psOpts)
-	result, err := psCmd.Run(conf)
+	psCmd := NewPSCommand([]string{cmd}, psOpts)
+	result, err := psCmd.Run(ctx, conf)
 	if err != nil {
 		return nil, fmt.Errorf("while running Get-ADObject: %s", err)
 	}
 
 	if result.ExitCode != 0 {
 		log.Printf("[DEBUG] stderr: %s\nstdout: %s", result.StdErr, result.Stdout)
-		return nil, fmt.Errorf("command New-GPLink exited with a non-zero exit code %d, stderr: %s", result.ExitCode, result.StdErr)
+		return nil, NewPSCommandError("Get-ADObject", result)
 	}
 
 	if result.Stdout == "" {
-		return nil, fmt.Errorf("did not find a container with DN %q", containerGUID)
+		return nil, fmt.Errorf("did not find a container with DN %q: %w", containerGUID, ErrNotFound)
 	}
 
 	gplinks, err := getGPLinksFromADObject([]byte(result.Stdout))
@@ -216,7 +224,7 @@ func GetGPLinkFromHost(conf *config.ProviderConf, gpoGUID, containerGUID string)
 	}
 
 	if len(gplinks) == 0 {
-		return nil, fmt.Errorf("did not find any GPOs linked to GPO %q", containerGUID)
+		return nil, fmt.Errorf("did not find any GPOs linked to GPO %q: %w", containerGUID, ErrNotFound)
 	}
 	gpoFound := false
 	gpoOrder := -1
@@ -251,7 +259,7 @@ func GetGPLinkFromHost(conf *config.ProviderConf, gpoGUID, containerGUID string)
 	}
 
 	if !gpoFound {
-		return nil, fmt.Errorf("did not find any GPOs with ID %q attached to container %q", gpoGUID, containerGUID)
+		return nil, fmt.Errorf("did not find any GPOs with ID %q attached to container %q: %w", gpoGUID, containerGUID, ErrNotFound)
 	}
 
 	gpo := &GPLink{
@@ -2,8 +2,10 @@ package winrmhelper
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"strconv"
@@ -12,8 +14,8 @@ import (
 
 	"github.com/hashicorp/terraform-provider-ad/ad/internal/config"
 
+	"github.com/go-ldap/ldap/v3"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
-	"github.com/packer-community/winrmcp/winrmcp"
 	"gopkg.in/ini.v1"
 )
 
@@ -33,11 +35,17 @@ type GPO struct {
 }
 
 func getGPOCmdByName(name string) string {
-	return fmt.Sprintf("Get-GPO -Name %s", name)
+	return NewPSCommandBuilder("Get-GPO").AddParam("Name", name).String()
 }
 
 func getGPOCmdByGUID(guid string) string {
-	return fmt.Sprintf("Get-GPO -Guid %s", guid)
+	return NewPSCommandBuilder("Get-GPO").AddParam("Guid", guid).String()
+}
+
+// getGPOContainerCmd returns the command retrieving the groupPolicyContainer object of a GPO.
+func getGPOContainerCmd(guid string, properties ...string) string {
+	filter := fmt.Sprintf("(&(objectClass=groupPolicyContainer)(cn={%s}))", ldap.EscapeFilter(guid))
+	return NewPSCommandBuilder("Get-ADObject").AddParam("LDAPFilter", filter).AddParam("Properties", properties).String()
 }
 
 // GPOStatusMap is used to translate the GPO status from a numeric format the json output returns
@@ -49,6 +57,16 @@ var GPOStatusMap = map[int]string{
 	3: "AllSettingsEnabled",
 }
 
+// isGPOStatus reports whether status is one of the values of GPOStatusMap.
+func isGPOStatus(status string) bool {
+	for _, s := range GPOStatusMap {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
 // unmarshallGPO unmarshalls the incoming byte array containing JSON
 // into a GPO structure.
 func unmarshallGPO(input []byte) (*GPO, error) {
@@ -70,7 +88,7 @@ func unmarshallGPO(input []byte) (*GPO, error) {
 }
 
 // GetGPOFromHost returns a GPO structure populated by data from the DC server
-func GetGPOFromHost(conf *config.ProviderConf, name, guid string) (*GPO, error) {
+func GetGPOFromHost(ctx context.Context, conf *config.ProviderConf, name, guid string) (*GPO, error) {
 	start := time.Now().Unix()
 	var cmd string
 	if name != "" {
@@ -87,31 +105,31 @@ func GetGPOFromHost(conf *config.ProviderConf, name, guid string) (*GPO, error)
 		ForceArray:      false,
 		ExecLocally:     conf.IsConnectionTypeLocal(),
 		PassCredentials: conf.IsPassCredentialsEnabled(),
-		Username:        conf.Settings.WinRMUsername,
-		Password:        conf.Settings.WinRMPassword,
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
 		Server:          domainName,
 		InvokeCommand:   conf.IsPassCredentialsEnabled(),
 	}
 	psCmd := NewPSCommand([]string{cmd}, psOpts)
-	result, err := psCmd.Run(conf)
+	result, err := psCmd.Run(ctx, conf)
 	if err != nil {
 		return nil, err
 	}
 	if result.ExitCode != 0 {
-		return nil, fmt.Errorf("command exited with a non-zero exit code %d, stderr: %s", result.ExitCode, result.StdErr)
+		return nil, NewPSCommandError("Get-GPO", result)
 	}
 	gpo, err := unmarshallGPO([]byte(result.Stdout))
 	if err != nil {
 		return nil, err
 	}
 
-	basePath, err := gpo.getGPOFilePath(conf)
+	basePath, err := gpo.getGPOFilePath(ctx, conf)
 	if err != nil {
 		return nil, err
 	}
 	gpo.basePath = basePath
 
-	err = gpo.loadGPTIni(conf)
+	err = gpo.loadGPTIni(ctx, conf)
 	if err != nil {
 		return nil, err
 	}
@@ -129,26 +147,25 @@ func GetGPOFromHost(conf *config.ProviderConf, name, guid string) (*GPO, error)
 // GetGPOFromResource returns a GPO structure popuplated by data from TF
 func GetGPOFromResource(d *schema.ResourceData) *GPO {
 	g := GPO{
-		Name:        SanitiseTFInput(d, "name"),
-		Domain:      SanitiseTFInput(d, "domain"),
-		Description: SanitiseTFInput(d, "description"),
-		Status:      SanitiseTFInput(d, "status"),
+		Name:        d.Get("name").(string),
+		Domain:      d.Get("domain").(string),
+		Description: d.Get("description").(string),
+		Status:      d.Get("status").(string),
 		ID:          d.Id(),
 	}
 	return &g
 }
 
 // Rename renames a GPO to the given name
-func (g *GPO) Rename(conf *config.ProviderConf, target string) error {
+func (g *GPO) Rename(ctx context.Context, conf *config.ProviderConf, target string) error {
 	if g.ID == "" {
 		return fmt.Errorf("gpo guid required")
 	}
-	cmds := []string{}
-	cmds = append(cmds, fmt.Sprintf("Rename-GPO -Guid %s -TargetName %s", g.ID, target))
-
-	if g.Domain != "" {
-		cmds = append(cmds, fmt.Sprintf("-Domain %s", g.Domain))
-	}
+	cmd := NewPSCommandBuilder("Rename-GPO").
+		AddParam("Guid", g.ID).
+		AddParam("TargetName", target).
+		AddParamIfNotEmpty("Domain", g.Domain).
+		String()
 
 	domainName := conf.Settings.DomainName
 	if conf.Settings.KrbRealm == domainName {
@@ -159,13 +176,13 @@ func (g *GPO) Rename(conf *config.ProviderConf, target string) error {
 		ForceArray:      false,
 		ExecLocally:     conf.IsConnectionTypeLocal(),
 		PassCredentials: conf.IsPassCredentialsEnabled(),
-		Username:        conf.Settings.WinRMUsername,
-		Password:        conf.Settings.WinRMPassword,
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
 		Server:          domainName,
 		InvokeCommand:   conf.IsPassCredentialsEnabled(),
 	}
-	psCmd := NewPSCommand(cmds, psOpts)
-	result, err := psCmd.Run(conf)
+	psCmd := NewPSCommand([]string{cmd}, psOpts)
+	result, err := psCmd.Run(ctx, conf)
 	if err != nil {
 		return fmt.Errorf("while renaming GPO: %s", err)
 	} else if result != nil && result.ExitCode != 0 {
@@ -175,8 +192,12 @@ func (g *GPO) Rename(conf *config.ProviderConf, target string) error {
 }
 
 // ChangeStatus Changes the status of a GPO
-func (g *GPO) ChangeStatus(conf *config.ProviderConf, status string) error {
-	cmd := fmt.Sprintf(`(%s).GpoStatus = "%s"`, getGPOCmdByGUID(g.ID), status)
+func (g *GPO) ChangeStatus(ctx context.Context, conf *config.ProviderConf, status string) error {
+	if !isGPOStatus(status) {
+		return fmt.Errorf("unknown GPO status %q", status)
+	}
+	// status is one of the values of GPOStatusMap and can be part of the script.
+	cmd := fmt.Sprintf("%s | ForEach-Object { $_.GpoStatus = '%s' }", getGPOCmdByGUID(g.ID), status)
 
 	domainName := conf.Settings.DomainName
 	if conf.Settings.KrbRealm == domainName {
@@ -187,40 +208,34 @@ func (g *GPO) ChangeStatus(conf *config.ProviderConf, status string) error {
 		ForceArray:      false,
 		ExecLocally:     conf.IsConnectionTypeLocal(),
 		PassCredentials: conf.IsPassCredentialsEnabled(),
-		Username:        conf.Settings.WinRMUsername,
-		Password:        conf.Settings.WinRMPassword,
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
 		Server:          domainName,
 		InvokeCommand:   conf.IsPassCredentialsEnabled(),
 	}
 	psCmd := NewPSCommand([]string{cmd}, psOpts)
-	result, err := psCmd.Run(conf)
+	result, err := psCmd.Run(ctx, conf)
 	if err != nil {
 		return err
 	}
 	if result.ExitCode != 0 {
-		return fmt.Errorf("status update failed with a non zero exit code (%d) stdout: %s stderr:%s",
-			result.ExitCode, result.Stdout, result.StdErr)
+		return fmt.Errorf("status update failed: %w", NewPSCommandError("Set-GPO", result))
 	}
 
 	return nil
 }
 
 // NewGPO uses Powershell over WinRM to create a script
-func (g *GPO) NewGPO(conf *config.ProviderConf) (string, error) {
+func (g *GPO) NewGPO(ctx context.Context, conf *config.ProviderConf) (string, error) {
 
 	if g.Name == "" {
 		return "", fmt.Errorf("gpo name required")
 	}
-	cmds := []string{}
-	cmds = append(cmds, fmt.Sprintf("New-GPO -Name %q", g.Name))
-
-	if g.Domain != "" {
-		cmds = append(cmds, fmt.Sprintf("-Domain %q", g.Domain))
-	}
-
-	if g.Description != "" {
-		cmds = append(cmds, fmt.Sprintf("-Comment %q", g.Description))
-	}
+	cmd := NewPSCommandBuilder("New-GPO").
+		AddParam("Name", g.Name).
+		AddParamIfNotEmpty("Domain", g.Domain).
+		AddParamIfNotEmpty("Comment", g.Description).
+		String()
 
 	domainName := conf.Settings.DomainName
 	if conf.Settings.KrbRealm == domainName {
@@ -229,24 +244,26 @@ func (g *GPO) NewGPO(conf *config.ProviderConf) (string, error) {
 	psOpts := CreatePSCommandOpts{
 		JSONOutput:      true,
 		ForceArray:      false,
+		NonIdempotent:   true,
 		ExecLocally:     conf.IsConnectionTypeLocal(),
 		PassCredentials: conf.IsPassCredentialsEnabled(),
-		Username:        conf.Settings.WinRMUsername,
-		Password:        conf.Settings.WinRMPassword,
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
 		Server:          domainName,
 		InvokeCommand:   conf.IsPassCredentialsEnabled(),
 	}
-	psCmd := NewPSCommand(cmds, psOpts)
-	result, err := psCmd.Run(conf)
+	psCmd := NewPSCommand([]string{cmd}, psOpts)
+	result, err := psCmd.Run(ctx, conf)
 	if err != nil {
 		return "", err
 	}
 	if result.ExitCode != 0 {
 		log.Printf("[DEBUG] stderr: %s\nstdout: %s", result.StdErr, result.Stdout)
-		if strings.Contains(result.StdErr, "GpoWithNameAlreadyExists") {
-			return "", fmt.Errorf("there is another GPO named %q", g.Name)
+		err := NewPSCommandError("New-GPO", result)
+		if errors.Is(err, ErrAlreadyExists) {
+			return "", fmt.Errorf("there is another GPO named %q: %w", g.Name, err)
 		}
-		return "", fmt.Errorf("command exited with a non-zero exit code %d, stderr: %s", result.ExitCode, result.StdErr)
+		return "", err
 	}
 	gpo, err := unmarshallGPO([]byte(result.Stdout))
 	if err != nil {
@@ -256,8 +273,8 @@ func (g *GPO) NewGPO(conf *config.ProviderConf) (string, error) {
 }
 
 // DeleteGPO delete the GPO container
-func (g *GPO) DeleteGPO(conf *config.ProviderConf) error {
-	cmd := fmt.Sprintf("Remove-GPO -Name %s -Domain %s", g.Name, g.Domain)
+func (g *GPO) DeleteGPO(ctx context.Context, conf *config.ProviderConf) error {
+	cmd := NewPSCommandBuilder("Remove-GPO").AddParam("Name", g.Name).AddParamIfNotEmpty("Domain", g.Domain).String()
 	domainName := conf.Settings.DomainName
 	if conf.Settings.KrbRealm == domainName {
 		domainName = "$env:computername"
@@ -267,16 +284,20 @@ func (g *GPO) DeleteGPO(conf *config.ProviderConf) error {
 		ForceArray:      false,
 		ExecLocally:     conf.IsConnectionTypeLocal(),
 		PassCredentials: conf.IsPassCredentialsEnabled(),
-		Username:        conf.Settings.WinRMUsername,
-		Password:        conf.Settings.WinRMPassword,
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
 		Server:          domainName,
 		InvokeCommand:   conf.IsPassCredentialsEnabled(),
 	}
 	psCmd := NewPSCommand([]string{cmd}, psOpts)
-	_, err := psCmd.Run(conf)
+	result, err := psCmd.Run(ctx, conf)
 	if err != nil {
+		return err
+	}
+	if result.ExitCode != 0 {
+		err := NewPSCommandError("Remove-GPO", result)
 		// Check if the resource is already deleted
-		if strings.Contains(err.Error(), "GpoWithNameNotFound") {
+		if errors.Is(err, ErrNotFound) {
 			return nil
 		}
 		return err
@@ -285,16 +306,16 @@ func (g *GPO) DeleteGPO(conf *config.ProviderConf) error {
 }
 
 // UpdateGPO updates the GPO container
-func (g *GPO) UpdateGPO(config *config.ProviderConf, d *schema.ResourceData) (string, error) {
+func (g *GPO) UpdateGPO(ctx context.Context, config *config.ProviderConf, d *schema.ResourceData) (string, error) {
 	if d.HasChange("name") {
-		err := g.Rename(config, SanitiseTFInput(d, "name"))
+		err := g.Rename(ctx, config, d.Get("name").(string))
 		if err != nil {
 			return "", err
 		}
 	}
 
 	if d.HasChange("status") {
-		err := g.ChangeStatus(config, SanitiseTFInput(d, "status"))
+		err := g.ChangeStatus(ctx, config, d.Get("status").(string))
 		if err != nil {
 			return "", err
 		}
@@ -305,8 +326,8 @@ func (g *GPO) UpdateGPO(config *config.ProviderConf, d *schema.ResourceData) (st
 // getGPOFilePath retrieves the AD Object of a GPO via powershell and returns the gPCFileSysPath
 // property. This property points at the UNC that the GPO stores its configuration. We use the output
 // of this function as well as GetsysVolPath to construct the GPO path on the DC's filesystem.
-func (g *GPO) getGPOFilePath(conf *config.ProviderConf) (string, error) {
-	cmd := fmt.Sprintf("(Get-ADObject  -LDAPFilter '(&(objectClass=groupPolicyContainer)(cn={%s}))' -Properties gPCFilesysPath).gPCFilesysPath", g.ID)
+func (g *GPO) getGPOFilePath(ctx context.Context, conf *config.ProviderConf) (string, error) {
+	cmd := fmt.Sprintf("%s | Select-Object -ExpandProperty gPCFileSysPath", getGPOContainerCmd(g.ID, "gPCFileSysPath"))
 	domainName := conf.Settings.DomainName
 	if conf.Settings.KrbRealm == domainName {
 		domainName = "$env:computername"
@@ -316,13 +337,13 @@ func (g *GPO) getGPOFilePath(conf *config.ProviderConf) (string, error) {
 		ForceArray:      false,
 		ExecLocally:     conf.IsConnectionTypeLocal(),
 		PassCredentials: conf.IsPassCredentialsEnabled(),
-		Username:        conf.Settings.WinRMUsername,
-		Password:        conf.Settings.WinRMPassword,
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
 		Server:          domainName,
 		InvokeCommand:   conf.IsPassCredentialsEnabled(),
 	}
 	psCmd := NewPSCommand([]string{cmd}, psOpts)
-	result, err := psCmd.Run(conf)
+	result, err := psCmd.Run(ctx, conf)
 	if err != nil {
 		return "", fmt.Errorf("error while retrieving GPO with %q path: %s", g.ID, err)
 	}
@@ -334,7 +355,7 @@ func (g *GPO) getGPOFilePath(conf *config.ProviderConf) (string, error) {
 
 // getSysVolPath returns the local path for the SYSVOL share on a Domain Controller. The combination of this
 // and the value we get from getGPOFilePath is used to construct the GPO path on the DC's filesystem.
-func getSysVolPath(conf *config.ProviderConf) (string, error) {
+func getSysVolPath(ctx context.Context, conf *config.ProviderConf) (string, error) {
 	cmd := "(Get-SmbShare sysvol).path"
 	domainName := conf.Settings.DomainName
 	if conf.Settings.KrbRealm == domainName {
@@ -345,13 +366,13 @@ func getSysVolPath(conf *config.ProviderConf) (string, error) {
 		ForceArray:      false,
 		ExecLocally:     conf.IsConnectionTypeLocal(),
 		PassCredentials: conf.IsPassCredentialsEnabled(),
-		Username:        conf.Settings.WinRMUsername,
-		Password:        conf.Settings.WinRMPassword,
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
 		Server:          domainName,
 		InvokeCommand:   conf.IsPassCredentialsEnabled(),
 	}
 	psCmd := NewPSCommand([]string{cmd}, psOpts)
-	result, err := psCmd.Run(conf)
+	result, err := psCmd.Run(ctx, conf)
 	if err != nil {
 		return "", fmt.Errorf("error while retrieving SYSVOL path")
 	}
@@ -379,38 +400,36 @@ func (g *GPO) loadGPOVersions() error {
 }
 
 // SetADGPOVersions updates AD with the given versions for a GPO
-func (g *GPO) SetADGPOVersions(conf *config.ProviderConf, gpoVersion uint32) error {
+func (g *GPO) SetADGPOVersions(ctx context.Context, conf *config.ProviderConf, gpoVersion uint32) error {
 
 	psOpts := CreatePSCommandOpts{
 		JSONOutput:      false,
 		ForceArray:      false,
 		ExecLocally:     conf.IsConnectionTypeLocal(),
 		PassCredentials: conf.IsPassCredentialsEnabled(),
-		Username:        conf.Settings.WinRMUsername,
-		Password:        conf.Settings.WinRMPassword,
-		Server:          conf.IdentifyDomainController(),
-		SkipCredPrefix:  true,
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
+		Server:          conf.IdentifyDomainController(ctx),
 	}
 
-	tmpCmd := fmt.Sprintf("Get-ADObject  -LDAPFilter '(&(objectClass=groupPolicyContainer)(cn={%s}))' -Properties *", g.ID)
 	cmds := []string{
-		fmt.Sprintf("$o=(%s)", NewPSCommand([]string{tmpCmd}, psOpts).String()),
-		NewPSCommand([]string{fmt.Sprintf("$o.VersionNumber=%d;Set-AdObject -Instance $o", gpoVersion)}, psOpts).String(),
+		NewPSCommand([]string{getGPOContainerCmd(g.ID, "versionNumber")}, psOpts).String(),
+		NewPSCommand([]string{fmt.Sprintf("Set-ADObject -Replace @{versionNumber=%d}", gpoVersion)}, psOpts).String(),
 	}
 
-	cmd := strings.Join(cmds, ";")
+	cmd := strings.Join(cmds, "|")
 	psOpts = CreatePSCommandOpts{
 		JSONOutput:      false,
 		ForceArray:      false,
 		ExecLocally:     conf.IsConnectionTypeLocal(),
 		PassCredentials: conf.IsPassCredentialsEnabled(),
-		Username:        conf.Settings.WinRMUsername,
-		Password:        conf.Settings.WinRMPassword,
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
 		Server:          "",
 		SkipCredSuffix:  true,
 	}
 	psCmd := NewPSCommand([]string{cmd}, psOpts)
-	result, err := psCmd.Run(conf)
+	result, err := psCmd.Run(ctx, conf)
 	if err != nil {
 		return fmt.Errorf("error while setting new version in AD for GPO %q: %s", g.ID, err)
 	}
@@ -421,7 +440,7 @@ func (g *GPO) SetADGPOVersions(conf *config.ProviderConf, gpoVersion uint32) err
 }
 
 // SetINIGPOVersions update gpt.ini with the new version
-func (g *GPO) SetINIGPOVersions(conf *config.ProviderConf, cpConn *winrmcp.Winrmcp, gpoVersion uint32) error {
+func (g *GPO) SetINIGPOVersions(ctx context.Context, conf *config.ProviderConf, cpConn config.FileCopier, gpoVersion uint32) error {
 	gpoVersionString, err := g.gptIni.Section("General").GetKey("Version")
 	if err != nil {
 		return fmt.Errorf("error while setting new GPT version to %d", gpoVersion)
@@ -435,7 +454,7 @@ func (g *GPO) SetINIGPOVersions(conf *config.ProviderConf, cpConn *winrmcp.Winrm
 	}
 
 	gptPath := fmt.Sprintf("%s\\gpt.ini", g.basePath)
-	err = UploadFiletoSYSVOL(conf, cpConn, buf, gptPath)
+	err = UploadFiletoSYSVOL(ctx, conf, cpConn, buf, gptPath)
 	if err != nil {
 		return fmt.Errorf("error while writing ini file to %q: %s", gptPath, err)
 	}
@@ -444,28 +463,28 @@ func (g *GPO) SetINIGPOVersions(conf *config.ProviderConf, cpConn *winrmcp.Winrm
 }
 
 // SetGPOVersions updates gpt.ini on the DC with the given values for user and computer version of a GPO.
-func (g *GPO) SetGPOVersions(conf *config.ProviderConf, cpConn *winrmcp.Winrmcp, userVersion, computerVersion uint16) error {
+func (g *GPO) SetGPOVersions(ctx context.Context, conf *config.ProviderConf, cpConn config.FileCopier, userVersion, computerVersion uint16) error {
 	outBuf := make([]byte, 4)
 	binary.LittleEndian.PutUint16(outBuf[:2], computerVersion)
 	binary.LittleEndian.PutUint16(outBuf[2:], userVersion)
 	newVersion := binary.LittleEndian.Uint32(outBuf)
 
-	err := g.SetINIGPOVersions(conf, cpConn, newVersion)
+	err := g.SetINIGPOVersions(ctx, conf, cpConn, newVersion)
 	if err != nil {
 		return err
 	}
 
-	err = g.SetADGPOVersions(conf, newVersion)
+	err = g.SetADGPOVersions(ctx, conf, newVersion)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (g *GPO) loadGPTIni(conf *config.ProviderConf) error {
+func (g *GPO) loadGPTIni(ctx context.Context, conf *config.ProviderConf) error {
 	gptPath := fmt.Sprintf("%s\\gpt.ini", g.basePath)
 	log.Printf("[DEBUG] Getting GPT ini from %s", gptPath)
-	cmd := fmt.Sprintf(`Get-Content "%s"`, gptPath)
+	cmd := NewPSCommandBuilder("Get-Content").AddParam("LiteralPath", gptPath).String()
 	domainName := conf.Settings.DomainName
 	if conf.Settings.KrbRealm == domainName {
 		domainName = "$env:computername"
@@ -475,13 +494,13 @@ func (g *GPO) loadGPTIni(conf *config.ProviderConf) error {
 		ForceArray:      false,
 		ExecLocally:     conf.IsConnectionTypeLocal(),
 		PassCredentials: conf.IsPassCredentialsEnabled(),
-		Username:        conf.Settings.WinRMUsername,
-		Password:        conf.Settings.WinRMPassword,
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
 		Server:          domainName,
 		InvokeCommand:   conf.IsPassCredentialsEnabled(),
 	}
 	psCmd := NewPSCommand([]string{cmd}, psOpts)
-	result, err := psCmd.Run(conf)
+	result, err := psCmd.Run(ctx, conf)
 	if err != nil {
 		return fmt.Errorf("error while retrieving contents of %q: %s", gptPath, err)
 	}
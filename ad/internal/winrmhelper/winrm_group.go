@@ -1,7 +1,9 @@
 package winrmhelper
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
@@ -26,39 +28,49 @@ type Group struct {
 	SID               SID `json:"SID"`
 }
 
+// groupProperties are the properties of the groups decoded into Group.
+var groupProperties = []string{
+	"ObjectGUID", "SamAccountName", "Name", "GroupScope", "GroupCategory", "DistinguishedName", "Description", "SID",
+}
+
 // AddGroup creates a new group
-func (g *Group) AddGroup(conf *config.ProviderConf) (string, error) {
+func (g *Group) AddGroup(ctx context.Context, conf *config.ProviderConf) (string, error) {
 	log.Printf("[DEBUG] Adding group with name %q", g.Name)
-	cmds := []string{fmt.Sprintf("New-ADGroup -Passthru -Name %q -GroupScope %q -GroupCategory %q -Path %q", g.Name, g.Scope, g.Category, g.Container)}
-
-	if g.SAMAccountName != "" {
-		cmds = append(cmds, fmt.Sprintf("-SamAccountName %q", g.SAMAccountName))
+	if conf.IsBackendLDAP() {
+		return g.addGroupLDAP(ctx, conf)
 	}
 
-	if g.Description != "" {
-		cmds = append(cmds, fmt.Sprintf("-Description %q", g.Description))
-	}
+	cmd := NewPSCommandBuilder("New-ADGroup").
+		AddParam("PassThru", true).
+		AddParam("Name", g.Name).
+		AddParam("GroupScope", g.Scope).
+		AddParam("GroupCategory", g.Category).
+		AddParam("Path", g.Container).
+		AddParamIfNotEmpty("SamAccountName", g.SAMAccountName).
+		AddParamIfNotEmpty("Description", g.Description)
 	psOpts := CreatePSCommandOpts{
 		JSONOutput:      true,
 		ForceArray:      false,
+		NonIdempotent:   true,
 		ExecLocally:     conf.IsConnectionTypeLocal(),
 		PassCredentials: conf.IsPassCredentialsEnabled(),
-		Username:        conf.Settings.WinRMUsername,
-		Password:        conf.Settings.WinRMPassword,
-		Server:          conf.IdentifyDomainController(),
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
+		Server:          conf.IdentifyDomainController(ctx),
 	}
-	psCmd := NewPSCommand(cmds, psOpts)
-	result, err := psCmd.Run(conf)
+	psCmd := NewPSCommand([]string{cmd.String()}, psOpts)
+	result, err := psCmd.RunBatched(ctx, conf)
 	if err != nil {
 		return "", err
 	}
 
 	if result.ExitCode != 0 {
 		log.Printf("[DEBUG] stderr: %s\nstdout: %s", result.StdErr, result.Stdout)
-		if strings.Contains(result.StdErr, "already exists") {
-			return "", fmt.Errorf("there is another group named %q", g.Name)
+		err := NewPSCommandError("New-ADGroup", result)
+		if errors.Is(err, ErrAlreadyExists) {
+			return "", fmt.Errorf("there is another group named %q: %w", g.Name, err)
 		}
-		return "", fmt.Errorf("command New-ADGroup exited with a non-zero exit code %d, stderr: %s", result.ExitCode, result.StdErr)
+		return "", err
 	}
 
 	group, err := unmarshallGroup([]byte(result.Stdout))
@@ -66,11 +78,17 @@ func (g *Group) AddGroup(conf *config.ProviderConf) (string, error) {
 		return "", fmt.Errorf("error while unmarshalling group json document: %s", err)
 	}
 
+	conf.InvalidateObject(group.GUID)
 	return group.GUID, nil
 }
 
 // ModifyGroup updates an existing group
-func (g *Group) ModifyGroup(d *schema.ResourceData, conf *config.ProviderConf) error {
+func (g *Group) ModifyGroup(ctx context.Context, d *schema.ResourceData, conf *config.ProviderConf) error {
+	conf.InvalidateObject(g.GUID)
+	if conf.IsBackendLDAP() {
+		return g.modifyGroupLDAP(ctx, d, conf)
+	}
+
 	KeyMap := map[string]string{
 		"sam_account_name": "SamAccountName",
 		"scope":            "GroupScope",
@@ -78,81 +96,75 @@ func (g *Group) ModifyGroup(d *schema.ResourceData, conf *config.ProviderConf) e
 		"description":      "Description",
 	}
 
-	cmds := []string{fmt.Sprintf("Set-ADGroup -Identity %q", g.GUID)}
+	setCmd := NewPSCommandBuilder("Set-ADGroup").AddParam("Identity", g.GUID)
 
 	for k, param := range KeyMap {
 		if d.HasChange(k) {
-			value := SanitiseTFInput(d, k)
-			if value == "" {
-				value = "$null"
-			} else {
-				value = fmt.Sprintf(`"%s"`, value)
-			}
-			cmds = append(cmds, fmt.Sprintf(`-%s %s`, param, value))
+			setCmd.AddParamOrNull(param, d.Get(k).(string))
 		}
 	}
 
-	if len(cmds) > 1 {
+	if setCmd.Len() > 1 {
 		psOpts := CreatePSCommandOpts{
 			JSONOutput:      false,
 			ForceArray:      false,
 			ExecLocally:     conf.IsConnectionTypeLocal(),
 			PassCredentials: conf.IsPassCredentialsEnabled(),
-			Username:        conf.Settings.WinRMUsername,
-			Password:        conf.Settings.WinRMPassword,
-			Server:          conf.IdentifyDomainController(),
+			Username:        conf.CredentialUsername(),
+			Password:        conf.CredentialPassword(),
+			Server:          conf.IdentifyDomainController(ctx),
 		}
-		psCmd := NewPSCommand(cmds, psOpts)
-		result, err := psCmd.Run(conf)
+		psCmd := NewPSCommand([]string{setCmd.String()}, psOpts)
+		result, err := psCmd.Run(ctx, conf)
 		if err != nil {
 			return err
 		}
 		if result.ExitCode != 0 {
 			log.Printf("[DEBUG] stderr: %s\nstdout: %s", result.StdErr, result.Stdout)
-			return fmt.Errorf("command Set-ADGroup exited with a non-zero exit code %d, stderr: %s", result.ExitCode, result.StdErr)
+			return NewPSCommandError("Set-ADGroup", result)
 		}
 	}
 
 	if d.HasChange("name") {
-		cmd := fmt.Sprintf("Rename-ADObject -Identity %q -NewName %q", g.GUID, d.Get("name").(string))
+		cmd := NewPSCommandBuilder("Rename-ADObject").AddParam("Identity", g.GUID).AddParam("NewName", d.Get("name").(string)).String()
 		psOpts := CreatePSCommandOpts{
 			JSONOutput:      false,
 			ForceArray:      false,
 			ExecLocally:     conf.IsConnectionTypeLocal(),
 			PassCredentials: conf.IsPassCredentialsEnabled(),
-			Username:        conf.Settings.WinRMUsername,
-			Password:        conf.Settings.WinRMPassword,
-			Server:          conf.IdentifyDomainController(),
+			Username:        conf.CredentialUsername(),
+			Password:        conf.CredentialPassword(),
+			Server:          conf.IdentifyDomainController(ctx),
 		}
 		psCmd := NewPSCommand([]string{cmd}, psOpts)
-		result, err := psCmd.Run(conf)
+		result, err := psCmd.Run(ctx, conf)
 		if err != nil {
 			return err
 		}
 		if result.ExitCode != 0 {
 			log.Printf("[DEBUG] stderr: %s\nstdout: %s", result.StdErr, result.Stdout)
-			return fmt.Errorf("command Rename-ADObject exited with a non-zero exit code %d, stderr: %s", result.ExitCode, result.StdErr)
+			return NewPSCommandError("Rename-ADObject", result)
 		}
 	}
 
 	if d.HasChange("container") {
-		cmd := fmt.Sprintf("Move-ADObject -Identity %q -TargetPath %q", g.GUID, d.Get("container").(string))
+		cmd := NewPSCommandBuilder("Move-ADObject").AddParam("Identity", g.GUID).AddParam("TargetPath", d.Get("container").(string)).String()
 		psOpts := CreatePSCommandOpts{
 			JSONOutput:      false,
 			ForceArray:      false,
 			ExecLocally:     conf.IsConnectionTypeLocal(),
 			PassCredentials: conf.IsPassCredentialsEnabled(),
-			Username:        conf.Settings.WinRMUsername,
-			Password:        conf.Settings.WinRMPassword,
-			Server:          conf.IdentifyDomainController(),
+			Username:        conf.CredentialUsername(),
+			Password:        conf.CredentialPassword(),
+			Server:          conf.IdentifyDomainController(ctx),
 		}
 		psCmd := NewPSCommand([]string{cmd}, psOpts)
-		result, err := psCmd.Run(conf)
+		result, err := psCmd.Run(ctx, conf)
 		if err != nil {
 			return fmt.Errorf("winrm execution failure while moving group object: %s", err)
 		}
 		if result.ExitCode != 0 {
-			return fmt.Errorf("Move-ADObject exited with a non zero exit code (%d), stderr: %s", result.ExitCode, result.StdErr)
+			return NewPSCommandError("Move-ADObject", result)
 		}
 	}
 
@@ -160,27 +172,33 @@ func (g *Group) ModifyGroup(d *schema.ResourceData, conf *config.ProviderConf) e
 }
 
 // DeleteGroup removes a group
-func (g *Group) DeleteGroup(conf *config.ProviderConf) error {
-	cmd := fmt.Sprintf("Remove-ADGroup -Identity %s -Confirm:$false", g.GUID)
+func (g *Group) DeleteGroup(ctx context.Context, conf *config.ProviderConf) error {
+	conf.InvalidateObject(g.GUID)
+	if conf.IsBackendLDAP() {
+		return g.deleteGroupLDAP(ctx, conf)
+	}
+
+	cmd := NewPSCommandBuilder("Remove-ADGroup").AddParam("Identity", g.GUID).AddParam("Confirm", false).String()
 	psOpts := CreatePSCommandOpts{
 		JSONOutput:      false,
 		ForceArray:      false,
 		ExecLocally:     conf.IsConnectionTypeLocal(),
 		PassCredentials: conf.IsPassCredentialsEnabled(),
-		Username:        conf.Settings.WinRMUsername,
-		Password:        conf.Settings.WinRMPassword,
-		Server:          conf.IdentifyDomainController(),
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
+		Server:          conf.IdentifyDomainController(ctx),
 	}
 	psCmd := NewPSCommand([]string{cmd}, psOpts)
-	result, err := psCmd.Run(conf)
+	result, err := psCmd.RunBatched(ctx, conf)
 	if err != nil {
+		return err
+	} else if result.ExitCode != 0 {
+		err := NewPSCommandError("Remove-ADGroup", result)
 		// Check if the resource is already deleted
-		if strings.Contains(err.Error(), "ADIdentityNotFoundException") {
+		if errors.Is(err, ErrNotFound) {
 			return nil
 		}
-		return err
-	} else if result.ExitCode != 0 {
-		return fmt.Errorf("while removing group: stderr: %s", result.StdErr)
+		return fmt.Errorf("while removing group: %w", err)
 	}
 	return nil
 }
@@ -188,13 +206,13 @@ func (g *Group) DeleteGroup(conf *config.ProviderConf) error {
 // GetGroupFromResource returns a Group struct built from Resource data
 func GetGroupFromResource(d *schema.ResourceData) *Group {
 	g := Group{
-		Name:           SanitiseTFInput(d, "name"),
-		SAMAccountName: SanitiseTFInput(d, "sam_account_name"),
-		Container:      SanitiseTFInput(d, "container"),
-		Scope:          SanitiseTFInput(d, "scope"),
-		Category:       SanitiseTFInput(d, "category"),
-		GUID:           SanitiseString(d.Id()),
-		Description:    SanitiseTFInput(d, "description"),
+		Name:           d.Get("name").(string),
+		SAMAccountName: d.Get("sam_account_name").(string),
+		Container:      d.Get("container").(string),
+		Scope:          d.Get("scope").(string),
+		Category:       d.Get("category").(string),
+		GUID:           d.Id(),
+		Description:    d.Get("description").(string),
 	}
 
 	return &g
@@ -202,19 +220,32 @@ func GetGroupFromResource(d *schema.ResourceData) *Group {
 
 // GetGroupFromHost returns a Group struct based on data
 // retrieved from the AD Controller.
-func GetGroupFromHost(conf *config.ProviderConf, guid string) (*Group, error) {
-	cmd := fmt.Sprintf("Get-ADGroup -identity %q -properties *", guid)
+func GetGroupFromHost(ctx context.Context, conf *config.ProviderConf, guid string) (*Group, error) {
+	if conf.IsBackendLDAP() {
+		return getGroupFromLDAP(ctx, conf, guid)
+	}
+	if doc := conf.CachedObject(guid); doc != nil {
+		log.Printf("[DEBUG] Reading group %s from the object cache", guid)
+		g, err := unmarshallGroup(doc)
+		if err != nil {
+			return nil, fmt.Errorf("error while unmarshalling group json document: %s", err)
+		}
+		return g, nil
+	}
+
+	cmd := NewPSCommandBuilder("Get-ADGroup").AddParam("Identity", guid).AddParam("Properties", "*").String()
 	psOpts := CreatePSCommandOpts{
 		JSONOutput:      true,
+		JSONProperties:  groupProperties,
 		ForceArray:      false,
 		ExecLocally:     conf.IsConnectionTypeLocal(),
 		PassCredentials: conf.IsPassCredentialsEnabled(),
-		Username:        conf.Settings.WinRMUsername,
-		Password:        conf.Settings.WinRMPassword,
-		Server:          conf.IdentifyDomainController(),
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
+		Server:          conf.IdentifyDomainController(ctx),
 	}
 	psCmd := NewPSCommand([]string{cmd}, psOpts)
-	result, err := psCmd.Run(conf)
+	result, err := psCmd.Run(ctx, conf)
 
 	if err != nil {
 		return nil, err
@@ -222,7 +253,7 @@ func GetGroupFromHost(conf *config.ProviderConf, guid string) (*Group, error) {
 
 	if result.ExitCode != 0 {
 		log.Printf("[DEBUG] stderr: %s\nstdout: %s", result.StdErr, result.Stdout)
-		return nil, fmt.Errorf("command Get-ADGroup exited with a non-zero exit code %d, stderr: %s", result.ExitCode, result.StdErr)
+		return nil, NewPSCommandError("Get-ADGroup", result)
 	}
 
 	g, err := unmarshallGroup([]byte(result.Stdout))
@@ -1,6 +1,7 @@
 package winrmhelper
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"strings"
@@ -15,11 +16,19 @@ type GroupMembership struct {
 	GroupMembers []*GroupMember
 }
 
+// GroupMember is a member of a group. Members are security principals, or contacts, which have no SAM
+// account name and which the cmdlets managing the members of groups reject.
 type GroupMember struct {
 	SamAccountName string `json:"SamAccountName"`
 	DN             string `json:"DistinguishedName"`
 	GUID           string `json:"ObjectGUID"`
 	Name           string `json:"Name"`
+	ObjectClass    string `json:"ObjectClass"`
+}
+
+// isContact returns true if the member is a contact.
+func (m *GroupMember) isContact() bool {
+	return strings.EqualFold(m.ObjectClass, "contact")
 }
 
 func groupExistsInList(g *GroupMember, memberList []*GroupMember) bool {
@@ -60,168 +69,317 @@ func unmarshalGroupMembership(input []byte) ([]*GroupMember, error) {
 	return gm, nil
 }
 
-func getMembershipList(g []*GroupMember) string {
+func getMembershipList(g []*GroupMember) []string {
 	out := []string{}
 	for _, member := range g {
 		out = append(out, member.GUID)
 	}
 
-	return strings.Join(out, ",")
+	return out
 }
 
-func (g *GroupMembership) getGroupMembers(conf *config.ProviderConf) ([]*GroupMember, error) {
-	cmd := fmt.Sprintf("Get-ADGroupMember -Identity %q", g.GroupGUID)
+func (g *GroupMembership) getGroupMembers(ctx context.Context, conf *config.ProviderConf) ([]*GroupMember, error) {
+	cmd := NewPSCommandBuilder("Get-ADGroupMember").AddParam("Identity", g.GroupGUID).String()
 	psOpts := CreatePSCommandOpts{
 		JSONOutput:      true,
 		ForceArray:      true,
 		ExecLocally:     conf.IsConnectionTypeLocal(),
 		PassCredentials: conf.IsPassCredentialsEnabled(),
-		Username:        conf.Settings.WinRMUsername,
-		Password:        conf.Settings.WinRMPassword,
-		Server:          conf.IdentifyDomainController(),
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
+		Server:          conf.IdentifyDomainController(ctx),
 	}
 	psCmd := NewPSCommand([]string{cmd}, psOpts)
-	result, err := psCmd.Run(conf)
+	result, err := psCmd.Run(ctx, conf)
 	if err != nil {
 		return nil, fmt.Errorf("while running Get-ADGroupMember: %s", err)
 	} else if result.ExitCode != 0 {
-		return nil, fmt.Errorf("command Get-ADGroupMember exited with a non-zero exit code(%d), stderr: %s, stdout: %s", result.ExitCode, result.StdErr, result.Stdout)
+		return nil, NewPSCommandError("Get-ADGroupMember", result)
 	}
 
-	if strings.TrimSpace(result.Stdout) == "" {
-		return []*GroupMember{}, nil
+	gm := []*GroupMember{}
+	if strings.TrimSpace(result.Stdout) != "" {
+		gm, err = unmarshalGroupMembership([]byte(result.Stdout))
+		if err != nil {
+			return nil, fmt.Errorf("while unmarshalling group membership response: %s", err)
+		}
 	}
 
-	gm, err := unmarshalGroupMembership([]byte(result.Stdout))
+	contacts, err := g.getGroupContacts(ctx, conf, gm)
 	if err != nil {
-		return nil, fmt.Errorf("while unmarshalling group membership response: %s", err)
+		return nil, err
 	}
-
-	return gm, nil
+	return append(gm, contacts...), nil
 }
 
-func (g *GroupMembership) bulkGroupMembersOp(conf *config.ProviderConf, operation string, members []*GroupMember) error {
-	if len(members) == 0 {
-		return nil
-	}
-
-	memberList := getMembershipList(members)
-	cmd := fmt.Sprintf("%s -Identity %q %s -Confirm:$false", operation, g.GroupGUID, memberList)
+// getGroupContacts returns the contacts among the members of the group, which Get-ADGroupMember does not
+// return. principals are the members it returned.
+func (g *GroupMembership) getGroupContacts(ctx context.Context, conf *config.ProviderConf, principals []*GroupMember) ([]*GroupMember, error) {
+	cmd := NewPSCommandBuilder("Get-ADGroup").AddParam("Identity", g.GroupGUID).AddParam("Properties", "Member").String()
 	psOpts := CreatePSCommandOpts{
-		JSONOutput:      false,
-		ForceArray:      false,
+		JSONOutput:      true,
+		JSONProperties:  []string{"Member"},
 		ExecLocally:     conf.IsConnectionTypeLocal(),
 		PassCredentials: conf.IsPassCredentialsEnabled(),
-		Username:        conf.Settings.WinRMUsername,
-		Password:        conf.Settings.WinRMPassword,
-		Server:          conf.IdentifyDomainController(),
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
+		Server:          conf.IdentifyDomainController(ctx),
 	}
 	psCmd := NewPSCommand([]string{cmd}, psOpts)
-	result, err := psCmd.Run(conf)
-
+	result, err := psCmd.Run(ctx, conf)
 	if err != nil {
-		return fmt.Errorf("while running %s: %s", operation, err)
+		return nil, fmt.Errorf("while running Get-ADGroup: %s", err)
 	} else if result.ExitCode != 0 {
-		return fmt.Errorf("command %s exited with a non-zero exit code(%d), stderr: %s, stdout: %s", operation, result.ExitCode, result.StdErr, result.Stdout)
+		return nil, NewPSCommandError("Get-ADGroup", result)
 	}
 
-	return nil
-}
+	var group struct {
+		Member StringList
+	}
+	if err := json.Unmarshal([]byte(result.Stdout), &group); err != nil {
+		return nil, fmt.Errorf("while unmarshalling the members of the group: %s", err)
+	}
 
-func (g *GroupMembership) addGroupMembers(conf *config.ProviderConf, members []*GroupMember) error {
-	return g.bulkGroupMembersOp(conf, "Add-ADGroupMember", members)
+	filters := []string{}
+	for _, dn := range group.Member {
+		found := false
+		for _, p := range principals {
+			if strings.EqualFold(p.DN, dn) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			filters = append(filters, ldapIdentityFilter(dn))
+		}
+	}
+	return searchContacts(ctx, conf, filters)
 }
 
-func (g *GroupMembership) removeGroupMembers(conf *config.ProviderConf, members []*GroupMember) error {
-	return g.bulkGroupMembersOp(conf, "Remove-ADGroupMember", members)
-}
+// searchContacts returns the contacts matching any of the LDAP filters.
+func searchContacts(ctx context.Context, conf *config.ProviderConf, filters []string) ([]*GroupMember, error) {
+	if len(filters) == 0 {
+		return []*GroupMember{}, nil
+	}
 
-func (g *GroupMembership) Update(conf *config.ProviderConf, expected []*GroupMember) error {
-	existing, err := g.getGroupMembers(conf)
+	filter := fmt.Sprintf("(&(objectClass=contact)(|%s))", strings.Join(filters, ""))
+	cmd := NewPSCommandBuilder("Get-ADObject").AddParam("LDAPFilter", filter).String()
+	psOpts := CreatePSCommandOpts{
+		JSONOutput:      true,
+		JSONProperties:  []string{"DistinguishedName", "ObjectGUID", "Name", "ObjectClass"},
+		ForceArray:      true,
+		ExecLocally:     conf.IsConnectionTypeLocal(),
+		PassCredentials: conf.IsPassCredentialsEnabled(),
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
+		Server:          conf.IdentifyDomainController(ctx),
+	}
+	psCmd := NewPSCommand([]string{cmd}, psOpts)
+	result, err := psCmd.Run(ctx, conf)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("while running Get-ADObject: %s", err)
+	} else if result.ExitCode != 0 {
+		return nil, NewPSCommandError("Get-ADObject", result)
+	}
+	if strings.TrimSpace(result.Stdout) == "" {
+		return []*GroupMember{}, nil
 	}
 
-	toAdd, toRemove := diffGroupMemberLists(expected, existing)
-	err = g.addGroupMembers(conf, toAdd)
+	contacts, err := unmarshalGroupMembership([]byte(result.Stdout))
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("while unmarshalling contacts: %s", err)
 	}
+	return contacts, nil
+}
 
-	err = g.removeGroupMembers(conf, toRemove)
+// resolveContacts marks the contacts among members, which are identified by their GUID or their
+// distinguished name, and sets their distinguished name and GUID. The other members are left as is.
+func resolveContacts(ctx context.Context, conf *config.ProviderConf, members []*GroupMember) error {
+	filters := []string{}
+	for _, m := range members {
+		if m.ObjectClass == "" {
+			filters = append(filters, ldapIdentityFilter(m.GUID))
+		}
+	}
+	contacts, err := searchContacts(ctx, conf, filters)
 	if err != nil {
 		return err
 	}
-
+	setContacts(members, contacts)
 	return nil
 }
 
-func (g *GroupMembership) Create(conf *config.ProviderConf) error {
-	if len(g.GroupMembers) == 0 {
+// setContacts copies the distinguished name, GUID and class of the contacts to the members they identify,
+// so that members given by distinguished name can be compared to the existing members of a group.
+func setContacts(members, contacts []*GroupMember) {
+	for _, m := range members {
+		for _, c := range contacts {
+			if strings.EqualFold(m.GUID, c.GUID) || strings.EqualFold(m.GUID, c.DN) {
+				m.DN = c.DN
+				m.GUID = c.GUID
+				m.ObjectClass = c.ObjectClass
+			}
+		}
+	}
+}
+
+func (g *GroupMembership) bulkGroupMembersOp(ctx context.Context, conf *config.ProviderConf, operation string, members []*GroupMember) error {
+	var principals, contacts []*GroupMember
+	for _, m := range members {
+		if m.isContact() {
+			contacts = append(contacts, m)
+		} else {
+			principals = append(principals, m)
+		}
+	}
+	if err := g.bulkGroupContactsOp(ctx, conf, operation, contacts); err != nil {
+		return err
+	}
+	if len(principals) == 0 {
 		return nil
 	}
 
-	memberList := getMembershipList(g.GroupMembers)
-	cmds := []string{fmt.Sprintf("Add-ADGroupMember -Identity %q -Members %s", g.GroupGUID, memberList)}
+	cmd := NewPSCommandBuilder(operation).
+		AddParam("Identity", g.GroupGUID).
+		AddParam("Members", getMembershipList(principals)).
+		AddParam("Confirm", false).
+		String()
 	psOpts := CreatePSCommandOpts{
 		JSONOutput:      false,
 		ForceArray:      false,
 		ExecLocally:     conf.IsConnectionTypeLocal(),
 		PassCredentials: conf.IsPassCredentialsEnabled(),
-		Username:        conf.Settings.WinRMUsername,
-		Password:        conf.Settings.WinRMPassword,
-		Server:          conf.IdentifyDomainController(),
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
+		Server:          conf.IdentifyDomainController(ctx),
 	}
-	psCmd := NewPSCommand(cmds, psOpts)
-	result, err := psCmd.Run(conf)
+	psCmd := NewPSCommand([]string{cmd}, psOpts)
+	result, err := psCmd.Run(ctx, conf)
+
 	if err != nil {
-		return fmt.Errorf("while running Add-ADGroupMember: %s", err)
+		return fmt.Errorf("while running %s: %s", operation, err)
 	} else if result.ExitCode != 0 {
-		return fmt.Errorf("command Add-ADGroupMember exited with a non-zero exit code(%d), stderr: %s, stdout: %s", result.ExitCode, result.StdErr, result.Stdout)
+		return NewPSCommandError(operation, result)
 	}
 
 	return nil
 }
 
-func (g *GroupMembership) Delete(conf *config.ProviderConf) error {
-	subCmdOpt := CreatePSCommandOpts{
-		JSONOutput:      false,
-		ForceArray:      false,
-		ExecLocally:     conf.IsConnectionTypeLocal(),
-		PassCredentials: conf.IsPassCredentialsEnabled(),
-		Username:        conf.Settings.WinRMUsername,
-		Password:        conf.Settings.WinRMPassword,
-		Server:          conf.IdentifyDomainController(),
-		SkipCredPrefix:  true,
+// bulkGroupContactsOp adds contacts to the member attribute of the group, or removes them from it, as
+// operation, Add-ADGroupMember or Remove-ADGroupMember, would for principals.
+func (g *GroupMembership) bulkGroupContactsOp(ctx context.Context, conf *config.ProviderConf, operation string, contacts []*GroupMember) error {
+	if len(contacts) == 0 {
+		return nil
 	}
-	subcmd := NewPSCommand([]string{fmt.Sprintf("Get-AdGroupMember %q", g.GroupGUID)}, subCmdOpt)
-	cmd := fmt.Sprintf("Remove-ADGroupMember %q -Members (%s) -Confirm:$false", g.GroupGUID, subcmd.String())
 
+	dns := make([]string, len(contacts))
+	for idx, c := range contacts {
+		dns[idx] = c.DN
+	}
+	change := "Add"
+	if operation == "Remove-ADGroupMember" {
+		change = "Remove"
+	}
+	cmd := NewPSCommandBuilder("Set-ADGroup").
+		AddParam("Identity", g.GroupGUID).
+		AddParam(change, map[string]interface{}{"member": dns}).
+		String()
 	psOpts := CreatePSCommandOpts{
 		JSONOutput:      false,
-		ForceArray:      false,
 		ExecLocally:     conf.IsConnectionTypeLocal(),
 		PassCredentials: conf.IsPassCredentialsEnabled(),
-		Username:        conf.Settings.WinRMUsername,
-		Password:        conf.Settings.WinRMPassword,
-		Server:          conf.IdentifyDomainController(),
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
+		Server:          conf.IdentifyDomainController(ctx),
 	}
 	psCmd := NewPSCommand([]string{cmd}, psOpts)
-	result, err := psCmd.Run(conf)
+	result, err := psCmd.Run(ctx, conf)
 	if err != nil {
-		return fmt.Errorf("while running Remove-ADGroupMember: %s", err)
-	} else if result.ExitCode != 0 && !strings.Contains(result.StdErr, "InvalidData") {
-		return fmt.Errorf("command Remove-ADGroupMember exited with a non-zero exit code(%d), stderr: %s, stdout: %s", result.ExitCode, result.StdErr, result.Stdout)
+		return fmt.Errorf("while running Set-ADGroup: %s", err)
+	} else if result.ExitCode != 0 {
+		return NewPSCommandError("Set-ADGroup", result)
 	}
 	return nil
 }
 
-func NewGroupMembershipFromHost(conf *config.ProviderConf, groupID string) (*GroupMembership, error) {
+func (g *GroupMembership) addGroupMembers(ctx context.Context, conf *config.ProviderConf, members []*GroupMember) error {
+	if len(members) == 0 {
+		return nil
+	}
+	if err := resolveContacts(ctx, conf, members); err != nil {
+		return err
+	}
+	return g.bulkGroupMembersOp(ctx, conf, "Add-ADGroupMember", members)
+}
+
+func (g *GroupMembership) removeGroupMembers(ctx context.Context, conf *config.ProviderConf, members []*GroupMember) error {
+	return g.bulkGroupMembersOp(ctx, conf, "Remove-ADGroupMember", members)
+}
+
+func (g *GroupMembership) Update(ctx context.Context, conf *config.ProviderConf, expected []*GroupMember) error {
+	if conf.IsBackendLDAP() {
+		return g.updateLDAP(ctx, conf, expected, true)
+	}
+
+	existing, err := g.getGroupMembers(ctx, conf)
+	if err != nil {
+		return err
+	}
+
+	// Contacts may be given by distinguished name, they are compared to the existing members by GUID.
+	if err := resolveContacts(ctx, conf, expected); err != nil {
+		return err
+	}
+	toAdd, toRemove := diffGroupMemberLists(expected, existing)
+	if len(toAdd) > 0 {
+		err = g.bulkGroupMembersOp(ctx, conf, "Add-ADGroupMember", toAdd)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = g.removeGroupMembers(ctx, conf, toRemove)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (g *GroupMembership) Create(ctx context.Context, conf *config.ProviderConf) error {
+	if len(g.GroupMembers) == 0 {
+		return nil
+	}
+
+	if conf.IsBackendLDAP() {
+		return g.updateLDAP(ctx, conf, g.GroupMembers, false)
+	}
+
+	return g.addGroupMembers(ctx, conf, g.GroupMembers)
+}
+
+func (g *GroupMembership) Delete(ctx context.Context, conf *config.ProviderConf) error {
+	if conf.IsBackendLDAP() {
+		return g.deleteLDAP(ctx, conf)
+	}
+
+	members, err := g.getGroupMembers(ctx, conf)
+	if err != nil {
+		return err
+	}
+	return g.removeGroupMembers(ctx, conf, members)
+}
+
+func NewGroupMembershipFromHost(ctx context.Context, conf *config.ProviderConf, groupID string) (*GroupMembership, error) {
+	if conf.IsBackendLDAP() {
+		return newGroupMembershipFromLDAP(ctx, conf, groupID)
+	}
+
 	result := &GroupMembership{
 		GroupGUID: groupID,
 	}
 
-	gm, err := result.getGroupMembers(conf)
+	gm, err := result.getGroupMembers(ctx, conf)
 	if err != nil {
 		return nil, err
 	}
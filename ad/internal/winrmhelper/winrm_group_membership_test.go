@@ -0,0 +1,31 @@
+package winrmhelper
+
+import "testing"
+
+func TestDiffGroupMemberListsContactByDN(t *testing.T) {
+	contact := &GroupMember{
+		DN:          "CN=Jane Doe,OU=Contacts,DC=contoso,DC=com",
+		GUID:        "4d1a6c1e-2b3f-4c5d-8e9f-0a1b2c3d4e5f",
+		Name:        "Jane Doe",
+		ObjectClass: "contact",
+	}
+	user := &GroupMember{DN: "CN=John Doe,OU=Users,DC=contoso,DC=com", GUID: "9b8a7c6d-5e4f-4a3b-2c1d-0e9f8a7b6c5d", ObjectClass: "user"}
+	existing := []*GroupMember{contact, user}
+
+	expected := []*GroupMember{
+		{GUID: "cn=jane doe,ou=contacts,dc=contoso,dc=com"},
+		{GUID: user.GUID},
+	}
+	setContacts(expected, []*GroupMember{contact})
+	if !expected[0].isContact() || expected[0].GUID != contact.GUID || expected[0].DN != contact.DN {
+		t.Errorf("expected the contact given by DN to be resolved, got %+v", expected[0])
+	}
+	if expected[1].isContact() || expected[1].DN != "" {
+		t.Errorf("expected the other members to be left as is, got %+v", expected[1])
+	}
+
+	toAdd, toRemove := diffGroupMemberLists(expected, existing)
+	if len(toAdd) != 0 || len(toRemove) != 0 {
+		t.Errorf("expected no change, got %d members to add and %d to remove", len(toAdd), len(toRemove))
+	}
+}
@@ -2,19 +2,20 @@ package winrmhelper
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"os/exec"
 	"reflect"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
-	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"github.com/hashicorp/terraform-provider-ad/ad/internal/config"
-	"github.com/packer-community/winrmcp/winrmcp"
 )
 
 // SID is a common structure by all "security principals". This means domains, users, computers, and groups.
@@ -23,6 +24,124 @@ type SID struct {
 	Value string `json:"Value"`
 }
 
+// StringList decodes the values of a multi-valued property, which ConvertTo-Json returns as null when
+// the property is empty and as a string when it holds a single value.
+type StringList []string
+
+func (l *StringList) UnmarshalJSON(b []byte) error {
+	var values []string
+	if err := json.Unmarshal(b, &values); err == nil {
+		*l = values
+		return nil
+	}
+	var value *string
+	if err := json.Unmarshal(b, &value); err != nil {
+		return err
+	}
+	*l = nil
+	if value != nil {
+		*l = StringList{*value}
+	}
+	return nil
+}
+
+// PSDateTime decodes a DateTime serialized by ConvertTo-Json. Windows PowerShell serializes dates as
+// "/Date(<milliseconds since the epoch>)/", and as an object holding the date in value when it carries
+// extended properties. Powershell 6 and later use the ISO 8601 format.
+type PSDateTime struct {
+	time.Time
+}
+
+func (t *PSDateTime) UnmarshalJSON(b []byte) error {
+	var wrapped struct {
+		Value json.RawMessage `json:"value"`
+	}
+	if len(b) > 0 && b[0] == '{' {
+		if err := json.Unmarshal(b, &wrapped); err != nil {
+			return err
+		}
+		b = wrapped.Value
+	}
+	var value *string
+	if err := json.Unmarshal(b, &value); err != nil {
+		return err
+	}
+	t.Time = time.Time{}
+	if value == nil || *value == "" {
+		return nil
+	}
+	if strings.HasPrefix(*value, "/Date(") && strings.HasSuffix(*value, ")/") {
+		ms := strings.TrimSuffix(strings.TrimPrefix(*value, "/Date("), ")/")
+		// The offset of local dates follows the milliseconds, which are always UTC.
+		if i := strings.LastIndexAny(ms, "+-"); i > 0 {
+			ms = ms[:i]
+		}
+		n, err := strconv.ParseInt(ms, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid date %q: %s", *value, err)
+		}
+		t.Time = time.UnixMilli(n).UTC()
+		return nil
+	}
+	parsed, err := time.Parse(time.RFC3339Nano, *value)
+	if err != nil {
+		return fmt.Errorf("invalid date %q: %s", *value, err)
+	}
+	t.Time = parsed.UTC()
+	return nil
+}
+
+// PSTimeSpan decodes a TimeSpan serialized by ConvertTo-Json, which is an object holding its properties.
+type PSTimeSpan struct {
+	time.Duration
+}
+
+func (t *PSTimeSpan) UnmarshalJSON(b []byte) error {
+	var span *struct {
+		Ticks int64
+	}
+	if err := json.Unmarshal(b, &span); err != nil {
+		return err
+	}
+	t.Duration = 0
+	if span != nil {
+		// A tick is 100 nanoseconds.
+		t.Duration = time.Duration(span.Ticks) * 100
+	}
+	return nil
+}
+
+// timeSpanFormat matches the constant format of TimeSpan, [d.]hh:mm:ss, which powershell converts to a
+// TimeSpan.
+var timeSpanFormat = regexp.MustCompile(`^(?:(\d+)\.)?(\d{1,2}):(\d{2}):(\d{2})$`)
+
+// ParseTimeSpan parses a TimeSpan in the [d.]hh:mm:ss format.
+func ParseTimeSpan(s string) (time.Duration, error) {
+	m := timeSpanFormat.FindStringSubmatch(s)
+	if m == nil {
+		return 0, fmt.Errorf("%q is not a time span in the [d.]hh:mm:ss format", s)
+	}
+	days, _ := strconv.Atoi("0" + m[1])
+	hours, _ := strconv.Atoi(m[2])
+	minutes, _ := strconv.Atoi(m[3])
+	seconds, _ := strconv.Atoi(m[4])
+	if hours > 23 || minutes > 59 || seconds > 59 {
+		return 0, fmt.Errorf("%q is not a valid time span", s)
+	}
+	return time.Duration(days)*24*time.Hour + time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute + time.Duration(seconds)*time.Second, nil
+}
+
+// FormatTimeSpan formats d in the [d.]hh:mm:ss format, truncated to the second.
+func FormatTimeSpan(d time.Duration) string {
+	seconds := int64(d / time.Second)
+	days, seconds := seconds/86400, seconds%86400
+	out := fmt.Sprintf("%02d:%02d:%02d", seconds/3600, seconds%3600/60, seconds%60)
+	if days > 0 {
+		out = fmt.Sprintf("%d.%s", days, out)
+	}
+	return out
+}
+
 // LocalPSSession struct
 type LocalPSSession struct {
 	powerShell string
@@ -38,10 +157,10 @@ func NewLocalPSSession() *LocalPSSession {
 
 const defaultFailedCode = 1
 
-// ExecutePScmd will execute the powershell command using exec
-func (l *LocalPSSession) ExecutePScmd(args ...string) (stdout string, stderr string, exitCode int, err error) {
+// ExecutePScmd will execute the powershell command using exec. The process is killed if ctx is cancelled.
+func (l *LocalPSSession) ExecutePScmd(ctx context.Context, args ...string) (stdout string, stderr string, exitCode int, err error) {
 	var outbuf, errbuf bytes.Buffer
-	cmd := exec.Command(l.powerShell, args...)
+	cmd := exec.CommandContext(ctx, l.powerShell, args...)
 	cmd.Stdout = &outbuf
 	cmd.Stderr = &errbuf
 
@@ -67,14 +186,8 @@ func (l *LocalPSSession) ExecutePScmd(args ...string) (stdout string, stderr str
 	return
 }
 
-// SanitiseTFInput returns the value of a resource field after passing it through SanitiseString
-func SanitiseTFInput(d *schema.ResourceData, key string) string {
-	return SanitiseString(d.Get(key).(string))
-
-}
-
-// SanitiseString returns the value of a string after some basic sanitisation checks
-// to protect ourselves from command injection
+// SanitiseString escapes the characters powershell interprets in double quoted strings. Values passed
+// to commands must go through PSCommandBuilder instead.
 func SanitiseString(key string) string {
 	cleanupReplacer := strings.NewReplacer(
 		"`", "``",
@@ -90,26 +203,27 @@ func SanitiseString(key string) string {
 		"\t", "`t",
 		"\v", "`v",
 	)
-	out := cleanupReplacer.Replace(key)
-	log.Printf("[DEBUG] sanitising key %q to: %s", key, out)
-	return out
+	return cleanupReplacer.Replace(key)
 }
 
 // SetMachineExtensionNames will add the necessary GUIDs to the GPO's gPCMachineExtensionNames attribute.
 // These are required for the security settings part of a GPO to work.
-func SetMachineExtensionNames(conf *config.ProviderConf, gpoDN, value string) error {
-	cmd := fmt.Sprintf(`Set-ADObject -Identity "%s" -Replace @{gPCMachineExtensionNames="%s"}`, gpoDN, value)
+func SetMachineExtensionNames(ctx context.Context, conf *config.ProviderConf, gpoDN, value string) error {
+	cmd := NewPSCommandBuilder("Set-ADObject").
+		AddParam("Identity", gpoDN).
+		AddParam("Replace", map[string]interface{}{"gPCMachineExtensionNames": value}).
+		String()
 	psOpts := CreatePSCommandOpts{
 		JSONOutput:      false,
 		ForceArray:      false,
 		ExecLocally:     conf.IsConnectionTypeLocal(),
 		PassCredentials: conf.IsPassCredentialsEnabled(),
-		Username:        conf.Settings.WinRMUsername,
-		Password:        conf.Settings.WinRMPassword,
-		Server:          conf.IdentifyDomainController(),
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
+		Server:          conf.IdentifyDomainController(ctx),
 	}
 	psCmd := NewPSCommand([]string{cmd}, psOpts)
-	result, err := psCmd.Run(conf)
+	result, err := psCmd.Run(ctx, conf)
 	if err != nil {
 		return fmt.Errorf("error while setting machine extension names for GPO %q: %s", gpoDN, err)
 	}
@@ -156,16 +270,30 @@ func SortInnerSlice(m map[string]interface{}) map[string]interface{} {
 	return m
 }
 
-func UploadFiletoSYSVOL(conf *config.ProviderConf, cpClient *winrmcp.Winrmcp, buf io.Reader, destPath string) error {
+// contextReader fails reads once its context is cancelled. winrmcp uploads the content it reads in
+// chunks, so this aborts an upload between two chunks.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// UploadFiletoSYSVOL uploads the content of buf to destPath using winrmcp or SFTP.
+func UploadFiletoSYSVOL(ctx context.Context, conf *config.ProviderConf, cpClient config.FileCopier, buf io.Reader, destPath string) error {
 	tmpPathCmd := NewPSCommand([]string{"$randompath=[System.IO.Path]::GetRandomFileName(); echo $env:TMP\\$randompath"}, CreatePSCommandOpts{
 		ForceArray:      false,
 		JSONOutput:      false,
 		ExecLocally:     conf.IsConnectionTypeLocal(),
 		PassCredentials: false,
-		SkipCredPrefix:  true,
 		SkipCredSuffix:  true,
 	})
-	tmpPathResult, err := tmpPathCmd.Run(conf)
+	tmpPathResult, err := tmpPathCmd.Run(ctx, conf)
 	if err != nil {
 		return fmt.Errorf("while renaming GPO: %s", err)
 	} else if tmpPathResult != nil && tmpPathResult.ExitCode != 0 {
@@ -173,7 +301,7 @@ func UploadFiletoSYSVOL(conf *config.ProviderConf, cpClient *winrmcp.Winrmcp, bu
 	}
 	tmpPath := tmpPathResult.Stdout
 
-	err = cpClient.Write(tmpPath, buf)
+	err = cpClient.Write(tmpPath, &contextReader{ctx: ctx, r: buf})
 	if err != nil {
 		return fmt.Errorf("error while writing ini file to %q: %s", destPath, err)
 	}
@@ -181,7 +309,11 @@ func UploadFiletoSYSVOL(conf *config.ProviderConf, cpClient *winrmcp.Winrmcp, bu
 	toks := strings.Split(destPath, `\`)
 	x := toks[:len(toks)-1]
 	destDir := strings.Join(x, `\`)
-	mdCmd := fmt.Sprintf(`$check=Test-Path "%s"; if (!$check)  {md "%s"}`, destDir, destDir)
+	mdCmd := NewPSCommandBuilder("New-Item").
+		AddParam("ItemType", "Directory").
+		AddParam("Path", destDir).
+		AddParam("Force", true).
+		String()
 	domainName := conf.Settings.DomainName
 	if conf.Settings.KrbRealm == domainName {
 		domainName = "$env:computername"
@@ -192,29 +324,32 @@ func UploadFiletoSYSVOL(conf *config.ProviderConf, cpClient *winrmcp.Winrmcp, bu
 		ForceArray:      false,
 		PassCredentials: conf.IsPassCredentialsEnabled(),
 		InvokeCommand:   conf.IsPassCredentialsEnabled(),
-		Username:        conf.Settings.WinRMUsername,
-		Password:        conf.Settings.WinRMPassword,
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
 		Server:          domainName,
 	})
-	mdOutput, err := mdPSComamnd.Run(conf)
+	mdOutput, err := mdPSComamnd.Run(ctx, conf)
 	if err != nil {
 		return fmt.Errorf("while renaming GPO: %s", err)
 	} else if mdOutput != nil && mdOutput.ExitCode != 0 {
 		return fmt.Errorf("while renaming GPO stderr: %s", mdOutput.StdErr)
 	}
 
-	cpCmd := fmt.Sprintf(`Copy-Item "%s" "%s"; Remove-Item "%s"`, tmpPath, destPath, tmpPath)
+	cpCmd := strings.Join([]string{
+		NewPSCommandBuilder("Copy-Item").AddParam("LiteralPath", tmpPath).AddParam("Destination", destPath).String(),
+		NewPSCommandBuilder("Remove-Item").AddParam("LiteralPath", tmpPath).String(),
+	}, "; ")
 	cpPSComamnd := NewPSCommand([]string{cpCmd}, CreatePSCommandOpts{
 		ExecLocally:     conf.IsConnectionTypeLocal(),
 		JSONOutput:      false,
 		ForceArray:      false,
 		PassCredentials: conf.IsPassCredentialsEnabled(),
 		InvokeCommand:   conf.IsPassCredentialsEnabled(),
-		Username:        conf.Settings.WinRMUsername,
-		Password:        conf.Settings.WinRMPassword,
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
 		Server:          domainName,
 	})
-	cpOutput, err := cpPSComamnd.Run(conf)
+	cpOutput, err := cpPSComamnd.Run(ctx, conf)
 	if err != nil {
 		return fmt.Errorf("while renaming GPO: %s", err)
 	} else if cpOutput != nil && cpOutput.ExitCode != 0 {
@@ -0,0 +1,117 @@
+package winrmhelper
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform-provider-ad/ad/internal/config"
+)
+
+// KdsRootKey is a root key of the Group Key Distribution Service, from which the domain controllers
+// derive the passwords of group managed service accounts.
+type KdsRootKey struct {
+	KeyID         string `json:"KeyId"`
+	EffectiveTime PSDateTime
+	CreationTime  PSDateTime
+}
+
+// kdsRootKeyProperties are the properties of the root keys decoded into KdsRootKey. The other ones hold
+// the key itself.
+var kdsRootKeyProperties = []string{"KeyId", "EffectiveTime", "CreationTime"}
+
+// kdsPSCommandOpts returns the options of the commands of the Kds module. Its cmdlets accept neither
+// credentials nor a server, so they are run through Invoke-Command when the credentials are passed.
+func kdsPSCommandOpts(conf *config.ProviderConf) CreatePSCommandOpts {
+	domainName := conf.Settings.DomainName
+	if conf.Settings.KrbRealm == domainName {
+		domainName = "$env:computername"
+	}
+	return CreatePSCommandOpts{
+		ExecLocally:     conf.IsConnectionTypeLocal(),
+		PassCredentials: conf.IsPassCredentialsEnabled(),
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
+		Server:          domainName,
+		InvokeCommand:   conf.IsPassCredentialsEnabled(),
+	}
+}
+
+// AddKdsRootKey creates a root key with Add-KdsRootKey and returns its ID. The key is effective right away
+// if effectiveImmediately is set, otherwise at effectiveTime, or 10 hours from now if it is zero. Domain
+// controllers only use a key 10 hours after it became effective, unless its effective time is older.
+func AddKdsRootKey(ctx context.Context, conf *config.ProviderConf, effectiveImmediately bool, effectiveTime time.Time) (string, error) {
+	cmd := NewPSCommandBuilder("Add-KdsRootKey")
+	switch {
+	case effectiveImmediately:
+		cmd.AddParam("EffectiveImmediately", true)
+	case !effectiveTime.IsZero():
+		cmd.AddParam("EffectiveTime", effectiveTime.UTC().Format(time.RFC3339))
+	}
+
+	psOpts := kdsPSCommandOpts(conf)
+	psOpts.JSONOutput = true
+	psOpts.JSONProperties = []string{"Guid"}
+	psOpts.NonIdempotent = true
+	psCmd := NewPSCommand([]string{cmd.String()}, psOpts)
+	result, err := psCmd.Run(ctx, conf)
+	if err != nil {
+		return "", err
+	}
+	if result.ExitCode != 0 {
+		log.Printf("[DEBUG] stderr: %s\nstdout: %s", result.StdErr, result.Stdout)
+		return "", NewPSCommandError("Add-KdsRootKey", result)
+	}
+
+	var created struct {
+		GUID string `json:"Guid"`
+	}
+	if err := json.Unmarshal([]byte(result.Stdout), &created); err != nil || created.GUID == "" {
+		return "", fmt.Errorf("invalid output of Add-KdsRootKey: %s", result.Stdout)
+	}
+	return created.GUID, nil
+}
+
+// GetKdsRootKey returns the root key keyID with Get-KdsRootKey, or ErrNotFound if there is none.
+func GetKdsRootKey(ctx context.Context, conf *config.ProviderConf, keyID string) (*KdsRootKey, error) {
+	psOpts := kdsPSCommandOpts(conf)
+	psOpts.JSONOutput = true
+	psOpts.JSONProperties = kdsRootKeyProperties
+	psOpts.ForceArray = true
+	psCmd := NewPSCommand([]string{NewPSCommandBuilder("Get-KdsRootKey").String()}, psOpts)
+	result, err := psCmd.Run(ctx, conf)
+	if err != nil {
+		return nil, err
+	}
+	if result.ExitCode != 0 {
+		log.Printf("[DEBUG] stderr: %s\nstdout: %s", result.StdErr, result.Stdout)
+		return nil, NewPSCommandError("Get-KdsRootKey", result)
+	}
+
+	keys, err := unmarshallKdsRootKeys([]byte(result.Stdout))
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		if strings.EqualFold(key.KeyID, keyID) {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("KDS root key %q: %w", keyID, ErrNotFound)
+}
+
+// unmarshallKdsRootKeys decodes the root keys listed by Get-KdsRootKey.
+func unmarshallKdsRootKeys(input []byte) ([]*KdsRootKey, error) {
+	if strings.TrimSpace(string(input)) == "" {
+		return nil, nil
+	}
+	var keys []*KdsRootKey
+	if err := json.Unmarshal(input, &keys); err != nil {
+		log.Printf("[DEBUG] Failed to unmarshall json document with error %q, document was: %s", err, string(input))
+		return nil, fmt.Errorf("failed while unmarshalling json response: %s", err)
+	}
+	return keys, nil
+}
@@ -0,0 +1,55 @@
+package winrmhelper
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestUnmarshallKdsRootKeys(t *testing.T) {
+	output := `[
+    {
+        "KeyId":  "9c4e1d2a-7b3f-4a8e-b1c2-d3e4f5a6b7c8",
+        "EffectiveTime":  "\/Date(1704067200000)\/",
+        "CreationTime":  "\/Date(1704103200000)\/"
+    },
+    {
+        "KeyId":  "2e5f8a1b-3c4d-4e6f-8a9b-0c1d2e3f4a5b",
+        "EffectiveTime":  "2024-03-01T12:00:00Z",
+        "CreationTime":  {"value": "\/Date(1709258400000+0100)\/", "DisplayHint": 2}
+    }
+]`
+	keys, err := unmarshallKdsRootKeys([]byte(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("expected 2 keys, got %d", len(keys))
+	}
+	if !keys[0].EffectiveTime.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected effective time %s", keys[0].EffectiveTime)
+	}
+	if !keys[1].EffectiveTime.Equal(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected effective time %s", keys[1].EffectiveTime)
+	}
+	if !keys[1].CreationTime.Equal(time.Date(2024, 3, 1, 2, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected creation time %s", keys[1].CreationTime)
+	}
+
+	if keys, err := unmarshallKdsRootKeys([]byte("")); err != nil || len(keys) != 0 {
+		t.Errorf("expected no key, got %v, %v", keys, err)
+	}
+}
+
+func TestPSDateTimeInvalid(t *testing.T) {
+	for _, doc := range []string{`"\/Date(abc)\/"`, `"yesterday"`, `42`} {
+		var d PSDateTime
+		if err := json.Unmarshal([]byte(doc), &d); err == nil {
+			t.Errorf("expected an error for %s, got %s", doc, d)
+		}
+	}
+	var d PSDateTime
+	if err := json.Unmarshal([]byte("null"), &d); err != nil || !d.IsZero() {
+		t.Errorf("expected a zero time for null, got %s, %v", d, err)
+	}
+}
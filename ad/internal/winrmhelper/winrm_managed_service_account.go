@@ -0,0 +1,394 @@
+package winrmhelper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-provider-ad/ad/internal/config"
+)
+
+// msaObjectClass is the object class of standalone managed service accounts, group managed service
+// accounts are msDS-GroupManagedServiceAccount objects.
+const msaObjectClass = "msDS-ManagedServiceAccount"
+
+// kerberosEncryptionTypes maps the encryption types accepted by -KerberosEncryptionType to their bits in
+// msDS-SupportedEncryptionTypes.
+var kerberosEncryptionTypes = map[string]int64{
+	"DES":    0x03,
+	"RC4":    0x04,
+	"AES128": 0x08,
+	"AES256": 0x10,
+}
+
+// ManagedServiceAccount is the structure used to store the details of standalone and group managed
+// service accounts.
+type ManagedServiceAccount struct {
+	GUID                  string `json:"ObjectGUID"`
+	Name                  string
+	SAMAccountName        string `json:"SamAccountName"`
+	DistinguishedName     string
+	Description           string
+	DisplayName           string
+	DNSHostName           string
+	Enabled               bool
+	ObjectClass           string
+	ServicePrincipalNames StringList
+	// PrincipalsAllowedToRetrieveManagedPassword holds distinguished names when read from the host, and
+	// identities, usually GUIDs, when built from the resource.
+	PrincipalsAllowedToRetrieveManagedPassword StringList
+	SupportedEncryptionTypes                   int64 `json:"msDS-SupportedEncryptionTypes"`
+	ManagedPasswordInterval                    int   `json:"msDS-ManagedPasswordInterval"`
+	SID                                        SID   `json:"SID"`
+	Standalone                                 bool  `json:"-"`
+	Container                                  string
+	KerberosEncryptionTypes                    []string
+}
+
+// managedServiceAccountProperties are the properties of the service accounts decoded into
+// ManagedServiceAccount.
+var managedServiceAccountProperties = []string{
+	"ObjectGUID", "Name", "SamAccountName", "DistinguishedName", "Description", "DisplayName", "DNSHostName",
+	"Enabled", "ObjectClass", "ServicePrincipalNames", "PrincipalsAllowedToRetrieveManagedPassword",
+	"msDS-SupportedEncryptionTypes", "msDS-ManagedPasswordInterval", "SID",
+}
+
+// GetManagedServiceAccountFromResource returns a service account struct built from Resource data
+func GetManagedServiceAccountFromResource(d *schema.ResourceData) *ManagedServiceAccount {
+	return &ManagedServiceAccount{
+		GUID:        d.Id(),
+		Name:        d.Get("name").(string),
+		Description: d.Get("description").(string),
+		DisplayName: d.Get("display_name").(string),
+		DNSHostName: d.Get("dns_host_name").(string),
+		Enabled:     d.Get("enabled").(bool),
+		Standalone:  d.Get("standalone").(bool),
+		Container:   d.Get("container").(string),
+		PrincipalsAllowedToRetrieveManagedPassword: setToStringList(d.Get("principals_allowed_to_retrieve_password").(*schema.Set)),
+		ServicePrincipalNames:                      setToStringList(d.Get("service_principal_names").(*schema.Set)),
+		KerberosEncryptionTypes:                    setToStringList(d.Get("kerberos_encryption_types").(*schema.Set)),
+		ManagedPasswordInterval:                    d.Get("password_change_interval").(int),
+	}
+}
+
+// setToStringList returns the strings of a set, sorted.
+func setToStringList(set *schema.Set) []string {
+	out := make([]string, 0, set.Len())
+	for _, v := range set.List() {
+		out = append(out, v.(string))
+	}
+	sort.Strings(out)
+	return out
+}
+
+// GetManagedServiceAccountFromHost retrieves the service account with the given GUID with
+// Get-ADServiceAccount. The principals allowed to retrieve its password are returned as GUIDs.
+func GetManagedServiceAccountFromHost(ctx context.Context, conf *config.ProviderConf, guid string) (*ManagedServiceAccount, error) {
+	cmd := NewPSCommandBuilder("Get-ADServiceAccount").AddParam("Identity", guid).AddParam("Properties", "*").String()
+	psOpts := CreatePSCommandOpts{
+		JSONOutput:      true,
+		JSONProperties:  managedServiceAccountProperties,
+		ExecLocally:     conf.IsConnectionTypeLocal(),
+		PassCredentials: conf.IsPassCredentialsEnabled(),
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
+		Server:          conf.IdentifyDomainController(ctx),
+	}
+	psCmd := NewPSCommand([]string{cmd}, psOpts)
+	result, err := psCmd.Run(ctx, conf)
+	if err != nil {
+		return nil, err
+	}
+	if result.ExitCode != 0 {
+		log.Printf("[DEBUG] stderr: %s\nstdout: %s", result.StdErr, result.Stdout)
+		return nil, NewPSCommandError("Get-ADServiceAccount", result)
+	}
+
+	m, err := unmarshallManagedServiceAccount([]byte(result.Stdout))
+	if err != nil {
+		return nil, fmt.Errorf("error while unmarshalling service account json document: %s", err)
+	}
+	principals, err := getObjectGUIDs(ctx, conf, m.PrincipalsAllowedToRetrieveManagedPassword)
+	if err != nil {
+		return nil, fmt.Errorf("while retrieving the principals allowed to retrieve the password of %q: %s", m.Name, err)
+	}
+	m.PrincipalsAllowedToRetrieveManagedPassword = principals
+	return m, nil
+}
+
+// unmarshallManagedServiceAccount unmarshalls the incoming byte array containing JSON into a
+// ManagedServiceAccount structure and populates the fields derived from the attributes.
+func unmarshallManagedServiceAccount(input []byte) (*ManagedServiceAccount, error) {
+	var m ManagedServiceAccount
+	err := json.Unmarshal(input, &m)
+	if err != nil {
+		log.Printf("[DEBUG] Failed to unmarshall json document with error %q, document was: %s", err, string(input))
+		return nil, fmt.Errorf("failed while unmarshalling json response: %s", err)
+	}
+	if m.GUID == "" {
+		return nil, fmt.Errorf("invalid data while unmarshalling ManagedServiceAccount data, json doc was: %s", string(input))
+	}
+
+	m.Standalone = strings.EqualFold(m.ObjectClass, msaObjectClass)
+	m.Container = ldapParentDN(m.DistinguishedName)
+	m.KerberosEncryptionTypes = decodeKerberosEncryptionTypes(m.SupportedEncryptionTypes)
+	sort.Strings(m.ServicePrincipalNames)
+	return &m, nil
+}
+
+// decodeKerberosEncryptionTypes returns the names of the encryption types set in
+// msDS-SupportedEncryptionTypes, sorted.
+func decodeKerberosEncryptionTypes(bits int64) []string {
+	out := []string{}
+	for name, mask := range kerberosEncryptionTypes {
+		if bits&mask != 0 {
+			out = append(out, name)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// kerberosEncryptionTypeParam returns the value of -KerberosEncryptionType for types.
+func kerberosEncryptionTypeParam(types []string) string {
+	if len(types) == 0 {
+		return "None"
+	}
+	return strings.Join(types, ",")
+}
+
+// getObjectGUIDs returns the GUIDs of the objects with the given distinguished names, sorted.
+func getObjectGUIDs(ctx context.Context, conf *config.ProviderConf, dns []string) ([]string, error) {
+	if len(dns) == 0 {
+		return []string{}, nil
+	}
+	var filter strings.Builder
+	filter.WriteString("(|")
+	for _, dn := range dns {
+		fmt.Fprintf(&filter, "(distinguishedName=%s)", ldap.EscapeFilter(dn))
+	}
+	filter.WriteString(")")
+
+	cmd := NewPSCommandBuilder("Get-ADObject").AddParam("LDAPFilter", filter.String()).String()
+	psOpts := CreatePSCommandOpts{
+		JSONOutput:      true,
+		JSONProperties:  []string{"ObjectGUID"},
+		ForceArray:      true,
+		ExecLocally:     conf.IsConnectionTypeLocal(),
+		PassCredentials: conf.IsPassCredentialsEnabled(),
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
+		Server:          conf.IdentifyDomainController(ctx),
+	}
+	psCmd := NewPSCommand([]string{cmd}, psOpts)
+	result, err := psCmd.Run(ctx, conf)
+	if err != nil {
+		return nil, err
+	}
+	if result.ExitCode != 0 {
+		return nil, NewPSCommandError("Get-ADObject", result)
+	}
+	if strings.TrimSpace(result.Stdout) == "" {
+		return []string{}, nil
+	}
+
+	var objects []struct {
+		GUID string `json:"ObjectGUID"`
+	}
+	if err := json.Unmarshal([]byte(result.Stdout), &objects); err != nil {
+		return nil, fmt.Errorf("while unmarshalling the objects: %s", err)
+	}
+	out := make([]string, 0, len(objects))
+	for _, o := range objects {
+		out = append(out, o.GUID)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// Create creates the service account by running New-ADServiceAccount, and returns its GUID.
+func (m *ManagedServiceAccount) Create(ctx context.Context, conf *config.ProviderConf) (string, error) {
+	if m.Name == "" {
+		return "", fmt.Errorf("ManagedServiceAccount.Create: missing name variable")
+	}
+	if m.Standalone && (len(m.PrincipalsAllowedToRetrieveManagedPassword) > 0 || m.ManagedPasswordInterval > 0) {
+		return "", fmt.Errorf("the principals allowed to retrieve the password and the password change interval can only be set on group managed service accounts")
+	}
+
+	log.Printf("[DEBUG] Adding service account %q", m.Name)
+	cmd := NewPSCommandBuilder("New-ADServiceAccount").
+		AddParam("PassThru", true).
+		AddParam("Name", m.Name).
+		AddParam("Enabled", m.Enabled).
+		AddParamIfNotEmpty("Path", m.Container).
+		AddParamIfNotEmpty("Description", m.Description).
+		AddParamIfNotEmpty("DisplayName", m.DisplayName).
+		AddParamIfNotEmpty("DNSHostName", m.DNSHostName)
+
+	if m.Standalone {
+		cmd.AddParam("RestrictToSingleComputer", true)
+	}
+	if len(m.PrincipalsAllowedToRetrieveManagedPassword) > 0 {
+		cmd.AddParam("PrincipalsAllowedToRetrieveManagedPassword", []string(m.PrincipalsAllowedToRetrieveManagedPassword))
+	}
+	if len(m.ServicePrincipalNames) > 0 {
+		cmd.AddParam("ServicePrincipalNames", []string(m.ServicePrincipalNames))
+	}
+	if m.ManagedPasswordInterval > 0 {
+		cmd.AddParam("ManagedPasswordIntervalInDays", m.ManagedPasswordInterval)
+	}
+	// The domain would set its default encryption types if none was given, None sets no encryption type
+	// when the configuration does not.
+	cmd.AddParam("KerberosEncryptionType", kerberosEncryptionTypeParam(m.KerberosEncryptionTypes))
+
+	psOpts := CreatePSCommandOpts{
+		JSONOutput:      true,
+		JSONProperties:  []string{"ObjectGUID"},
+		NonIdempotent:   true,
+		ExecLocally:     conf.IsConnectionTypeLocal(),
+		PassCredentials: conf.IsPassCredentialsEnabled(),
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
+		Server:          conf.IdentifyDomainController(ctx),
+	}
+	psCmd := NewPSCommand([]string{cmd.String()}, psOpts)
+	result, err := psCmd.Run(ctx, conf)
+	if err != nil {
+		return "", err
+	}
+	if result.ExitCode != 0 {
+		log.Printf("[DEBUG] stderr: %s\nstdout: %s", result.StdErr, result.Stdout)
+		err := NewPSCommandError("New-ADServiceAccount", result)
+		if errors.Is(err, ErrAlreadyExists) {
+			return "", fmt.Errorf("there is another service account named %q: %w", m.Name, err)
+		}
+		return "", err
+	}
+
+	var created struct {
+		GUID string `json:"ObjectGUID"`
+	}
+	if err := json.Unmarshal([]byte(result.Stdout), &created); err != nil || created.GUID == "" {
+		return "", fmt.Errorf("invalid output of New-ADServiceAccount: %s", result.Stdout)
+	}
+	return created.GUID, nil
+}
+
+// Modify updates the service account based on what changed in the resource.
+func (m *ManagedServiceAccount) Modify(ctx context.Context, d *schema.ResourceData, conf *config.ProviderConf) error {
+	log.Printf("[DEBUG] Modifying service account %q", m.Name)
+	setCmd := NewPSCommandBuilder("Set-ADServiceAccount").AddParam("Identity", m.GUID)
+
+	strKeyMap := map[string]string{
+		"description":   "Description",
+		"display_name":  "DisplayName",
+		"dns_host_name": "DNSHostName",
+	}
+	for k, param := range strKeyMap {
+		if d.HasChange(k) {
+			setCmd.AddParamOrNull(param, d.Get(k).(string))
+		}
+	}
+	if d.HasChange("enabled") {
+		setCmd.AddParam("Enabled", m.Enabled)
+	}
+	var clear []string
+	if d.HasChange("kerberos_encryption_types") {
+		if len(m.KerberosEncryptionTypes) > 0 {
+			setCmd.AddParam("KerberosEncryptionType", kerberosEncryptionTypeParam(m.KerberosEncryptionTypes))
+		} else {
+			clear = append(clear, "msDS-SupportedEncryptionTypes")
+		}
+	}
+	if d.HasChange("principals_allowed_to_retrieve_password") {
+		if len(m.PrincipalsAllowedToRetrieveManagedPassword) > 0 {
+			setCmd.AddParam("PrincipalsAllowedToRetrieveManagedPassword", []string(m.PrincipalsAllowedToRetrieveManagedPassword))
+		} else {
+			setCmd.AddParam("PrincipalsAllowedToRetrieveManagedPassword", nil)
+		}
+	}
+	if d.HasChange("service_principal_names") {
+		if len(m.ServicePrincipalNames) > 0 {
+			setCmd.AddParam("ServicePrincipalNames", map[string]interface{}{"Replace": []string(m.ServicePrincipalNames)})
+		} else {
+			clear = append(clear, "servicePrincipalName")
+		}
+	}
+	if len(clear) > 0 {
+		setCmd.AddParam("Clear", clear)
+	}
+
+	if setCmd.Len() > 1 {
+		psOpts := CreatePSCommandOpts{
+			JSONOutput:      false,
+			ExecLocally:     conf.IsConnectionTypeLocal(),
+			PassCredentials: conf.IsPassCredentialsEnabled(),
+			Username:        conf.CredentialUsername(),
+			Password:        conf.CredentialPassword(),
+			Server:          conf.IdentifyDomainController(ctx),
+		}
+		psCmd := NewPSCommand([]string{setCmd.String()}, psOpts)
+		result, err := psCmd.Run(ctx, conf)
+		if err != nil {
+			return err
+		}
+		if result.ExitCode != 0 {
+			log.Printf("[DEBUG] stderr: %s\nstdout: %s", result.StdErr, result.Stdout)
+			return NewPSCommandError("Set-ADServiceAccount", result)
+		}
+	}
+
+	if d.HasChange("container") {
+		cmd := NewPSCommandBuilder("Move-ADObject").AddParam("Identity", m.GUID).AddParam("TargetPath", m.Container).String()
+		psOpts := CreatePSCommandOpts{
+			JSONOutput:      false,
+			ExecLocally:     conf.IsConnectionTypeLocal(),
+			PassCredentials: conf.IsPassCredentialsEnabled(),
+			Username:        conf.CredentialUsername(),
+			Password:        conf.CredentialPassword(),
+			Server:          conf.IdentifyDomainController(ctx),
+		}
+		psCmd := NewPSCommand([]string{cmd}, psOpts)
+		result, err := psCmd.Run(ctx, conf)
+		if err != nil {
+			return fmt.Errorf("winrm execution failure while moving service account object: %s", err)
+		}
+		if result.ExitCode != 0 {
+			return NewPSCommandError("Move-ADObject", result)
+		}
+	}
+
+	return nil
+}
+
+// Delete deletes the service account by running Remove-ADServiceAccount.
+func (m *ManagedServiceAccount) Delete(ctx context.Context, conf *config.ProviderConf) error {
+	cmd := NewPSCommandBuilder("Remove-ADServiceAccount").AddParam("Identity", m.GUID).AddParam("Confirm", false).String()
+	psOpts := CreatePSCommandOpts{
+		JSONOutput:      false,
+		ExecLocally:     conf.IsConnectionTypeLocal(),
+		PassCredentials: conf.IsPassCredentialsEnabled(),
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
+		Server:          conf.IdentifyDomainController(ctx),
+	}
+	psCmd := NewPSCommand([]string{cmd}, psOpts)
+	result, err := psCmd.Run(ctx, conf)
+	if err != nil {
+		return err
+	}
+	if result.ExitCode != 0 {
+		err := NewPSCommandError("Remove-ADServiceAccount", result)
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
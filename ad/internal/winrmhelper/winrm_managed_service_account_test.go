@@ -0,0 +1,67 @@
+package winrmhelper
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUnmarshallManagedServiceAccount(t *testing.T) {
+	doc := `{
+    "ObjectGUID":  "3f1c8e2a-9b4d-4c6e-8a1f-2d3e4f5a6b7c",
+    "Name":  "svc-web",
+    "SamAccountName":  "svc-web$",
+    "DistinguishedName":  "CN=svc-web,CN=Managed Service Accounts,DC=contoso,DC=com",
+    "DNSHostName":  "web.contoso.com",
+    "Enabled":  true,
+    "ObjectClass":  "msDS-GroupManagedServiceAccount",
+    "ServicePrincipalNames":  "HTTP/web.contoso.com",
+    "PrincipalsAllowedToRetrieveManagedPassword":  [
+                                                       "CN=web-servers,OU=Groups,DC=contoso,DC=com",
+                                                       "CN=WEB01,OU=Servers,DC=contoso,DC=com"
+                                                   ],
+    "msDS-SupportedEncryptionTypes":  28,
+    "msDS-ManagedPasswordInterval":  30,
+    "SID":  {
+                "Value":  "S-1-5-21-3623811015-3361044348-30300820-1112"
+            }
+}`
+	m, err := unmarshallManagedServiceAccount([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Standalone || m.Container != "CN=Managed Service Accounts,DC=contoso,DC=com" || m.ManagedPasswordInterval != 30 {
+		t.Errorf("unexpected service account %#v", m)
+	}
+	if !reflect.DeepEqual([]string(m.ServicePrincipalNames), []string{"HTTP/web.contoso.com"}) {
+		t.Errorf("unexpected service principal names %v", m.ServicePrincipalNames)
+	}
+	if len(m.PrincipalsAllowedToRetrieveManagedPassword) != 2 {
+		t.Errorf("unexpected principals %v", m.PrincipalsAllowedToRetrieveManagedPassword)
+	}
+	if !reflect.DeepEqual(m.KerberosEncryptionTypes, []string{"AES128", "AES256", "RC4"}) {
+		t.Errorf("unexpected encryption types %v", m.KerberosEncryptionTypes)
+	}
+
+	doc = `{"ObjectGUID": "8b2d9f3b-0c5e-4d7f-9b2a-3e4f5a6b7c8d", "Name": "svc-sql", "ObjectClass": "msDS-ManagedServiceAccount",
+		"DistinguishedName": "CN=svc-sql,CN=Managed Service Accounts,DC=contoso,DC=com",
+		"ServicePrincipalNames": null, "PrincipalsAllowedToRetrieveManagedPassword": [], "msDS-SupportedEncryptionTypes": 3}`
+	m, err = unmarshallManagedServiceAccount([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Standalone || len(m.ServicePrincipalNames) != 0 || len(m.PrincipalsAllowedToRetrieveManagedPassword) != 0 {
+		t.Errorf("unexpected standalone service account %#v", m)
+	}
+	if !reflect.DeepEqual(m.KerberosEncryptionTypes, []string{"DES"}) {
+		t.Errorf("unexpected encryption types %v", m.KerberosEncryptionTypes)
+	}
+}
+
+func TestKerberosEncryptionTypeParam(t *testing.T) {
+	if p := kerberosEncryptionTypeParam(nil); p != "None" {
+		t.Errorf("expected None, got %q", p)
+	}
+	if p := kerberosEncryptionTypeParam([]string{"AES128", "AES256"}); p != "AES128,AES256" {
+		t.Errorf("unexpected value %q", p)
+	}
+}
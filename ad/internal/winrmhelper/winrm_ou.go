@@ -1,6 +1,7 @@
 package winrmhelper
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -21,14 +22,17 @@ type OrgUnit struct {
 	GUID              string `json:"ObjectGuid"`
 }
 
+// orgUnitProperties are the properties of the organizational units decoded into OrgUnit.
+var orgUnitProperties = []string{"Name", "Description", "ProtectedFromAccidentalDeletion", "DistinguishedName", "ObjectGuid"}
+
 // NewOrgUnitFromResource returns a new OrgUnit struct populated from resource data
 func NewOrgUnitFromResource(d *schema.ResourceData) *OrgUnit {
 	ou := OrgUnit{
-		Description:       SanitiseTFInput(d, "description"),
-		Name:              SanitiseTFInput(d, "name"),
-		Path:              SanitiseTFInput(d, "path"),
-		DistinguishedName: SanitiseTFInput(d, "dn"),
-		GUID:              SanitiseTFInput(d, "guid"),
+		Description:       d.Get("description").(string),
+		Name:              d.Get("name").(string),
+		Path:              d.Get("path").(string),
+		DistinguishedName: d.Get("dn").(string),
+		GUID:              d.Get("guid").(string),
 	}
 	protected := d.Get("protected").(bool)
 	ou.Protected = protected
@@ -37,31 +41,39 @@ func NewOrgUnitFromResource(d *schema.ResourceData) *OrgUnit {
 
 // NewOrgUnitFromHost returns a new OrgUnit struct populated from data we get from
 // the domain controller
-func NewOrgUnitFromHost(conf *config.ProviderConf, guid, name, path string) (*OrgUnit, error) {
-	var cmd string
-	if guid != "" {
-		cmd = fmt.Sprintf("Get-ADObject -Properties * -Identity %q", guid)
-	} else if name != "" && path != "" {
-		cmd = fmt.Sprintf("Get-ADObject -Properties * -Name %q -Path %q", name, path)
-	} else {
-		return nil, fmt.Errorf("invalid inputs, dn or a combination of path and name are required")
+func NewOrgUnitFromHost(ctx context.Context, conf *config.ProviderConf, guid, name, path string) (*OrgUnit, error) {
+	if conf.IsBackendLDAP() {
+		if guid == "" && (name == "" || path == "") {
+			return nil, fmt.Errorf("invalid inputs, dn or a combination of path and name are required")
+		}
+		return newOrgUnitFromLDAP(ctx, conf, guid, name, path)
+	}
+
+	identity := guid
+	if identity == "" {
+		if name == "" || path == "" {
+			return nil, fmt.Errorf("invalid inputs, dn or a combination of path and name are required")
+		}
+		identity = fmt.Sprintf("%s,%s", ldapRDN("OU", name), path)
 	}
+	cmd := NewPSCommandBuilder("Get-ADObject").AddParam("Identity", identity).AddParam("Properties", "*").String()
 	psOpts := CreatePSCommandOpts{
 		JSONOutput:      true,
+		JSONProperties:  orgUnitProperties,
 		ForceArray:      false,
 		ExecLocally:     conf.IsConnectionTypeLocal(),
 		PassCredentials: conf.IsPassCredentialsEnabled(),
-		Username:        conf.Settings.WinRMUsername,
-		Password:        conf.Settings.WinRMPassword,
-		Server:          conf.IdentifyDomainController(),
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
+		Server:          conf.IdentifyDomainController(ctx),
 	}
 	psCmd := NewPSCommand([]string{cmd}, psOpts)
-	result, err := psCmd.Run(conf)
+	result, err := psCmd.Run(ctx, conf)
 	if err != nil {
 		return nil, err
 	}
 	if result.ExitCode != 0 {
-		return nil, fmt.Errorf("Get-ADOrganizationalUnit exited with a non-zero exit code %d, stderr :%s", result.ExitCode, result.StdErr)
+		return nil, NewPSCommandError("Get-ADOrganizationalUnit", result)
 	}
 	ou, err := unmarshallOU([]byte(result.Stdout))
 	if err != nil {
@@ -73,39 +85,39 @@ func NewOrgUnitFromHost(conf *config.ProviderConf, guid, name, path string) (*Or
 }
 
 // Create creates a new OU in the AD tree
-func (o *OrgUnit) Create(conf *config.ProviderConf) (string, error) {
-
-	cmd := "New-ADOrganizationalUnit -Passthru"
+func (o *OrgUnit) Create(ctx context.Context, conf *config.ProviderConf) (string, error) {
 	if o.Name == "" {
 		return "", fmt.Errorf("missing required attribute name, cannot create OU")
 	}
-	cmd = fmt.Sprintf("%s -Name %q", cmd, o.Name)
-
-	if o.Description != "" {
-		cmd = fmt.Sprintf("%s -Description %q", cmd, o.Description)
-	}
 
-	if o.Path != "" {
-		cmd = fmt.Sprintf("%s -Path %q", cmd, o.Path)
+	if conf.IsBackendLDAP() {
+		return o.createLDAP(ctx, conf)
 	}
 
-	cmd = fmt.Sprintf("%s -ProtectedFromAccidentalDeletion:$%t", cmd, o.Protected)
+	cmd := NewPSCommandBuilder("New-ADOrganizationalUnit").
+		AddParam("PassThru", true).
+		AddParam("Name", o.Name).
+		AddParamIfNotEmpty("Description", o.Description).
+		AddParamIfNotEmpty("Path", o.Path).
+		AddParam("ProtectedFromAccidentalDeletion", o.Protected).
+		String()
 	psOpts := CreatePSCommandOpts{
 		JSONOutput:      true,
 		ForceArray:      false,
+		NonIdempotent:   true,
 		ExecLocally:     conf.IsConnectionTypeLocal(),
 		PassCredentials: conf.IsPassCredentialsEnabled(),
-		Username:        conf.Settings.WinRMUsername,
-		Password:        conf.Settings.WinRMPassword,
-		Server:          conf.IdentifyDomainController(),
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
+		Server:          conf.IdentifyDomainController(ctx),
 	}
 	psCmd := NewPSCommand([]string{cmd}, psOpts)
-	result, err := psCmd.Run(conf)
+	result, err := psCmd.Run(ctx, conf)
 	if err != nil {
 		return "", err
 	}
 	if result.ExitCode != 0 {
-		return "", fmt.Errorf("Get-ADOrganizationalUnit exited with a non-zero exit code %d, stderr :%s", result.ExitCode, result.StdErr)
+		return "", NewPSCommandError("Get-ADOrganizationalUnit", result)
 	}
 	ou, err := unmarshallOU([]byte(result.Stdout))
 	if err != nil {
@@ -116,11 +128,16 @@ func (o *OrgUnit) Create(conf *config.ProviderConf) (string, error) {
 }
 
 // Update updates an existing OU in the AD tree
-func (o *OrgUnit) Update(conf *config.ProviderConf, changes map[string]interface{}) error {
+func (o *OrgUnit) Update(ctx context.Context, conf *config.ProviderConf, changes map[string]interface{}) error {
 	if o.DistinguishedName == "" {
 		return fmt.Errorf("Cannot update OU with name %q, distiguished name is empty", o.Name)
 	}
-	cmd := fmt.Sprintf("Set-ADOrganizationalUnit -Identity %q", o.DistinguishedName)
+
+	if conf.IsBackendLDAP() {
+		return o.updateLDAP(ctx, conf, changes)
+	}
+
+	setCmd := NewPSCommandBuilder("Set-ADOrganizationalUnit").AddParam("Identity", o.DistinguishedName)
 
 	keyMap := map[string]string{
 		"display_name": "DisplayName",
@@ -129,147 +146,161 @@ func (o *OrgUnit) Update(conf *config.ProviderConf, changes map[string]interface
 
 	for k, v := range changes {
 		if paramName, ok := keyMap[k]; ok {
-			cmd = fmt.Sprintf("%s -%s %q", cmd, paramName, v.(string))
+			setCmd.AddParamOrNull(paramName, v.(string))
 		}
 	}
 
-	if cmd != "Set-ADOrganizationalUnit -Identity" {
+	if setCmd.Len() > 1 {
 		psOpts := CreatePSCommandOpts{
 			JSONOutput:      true,
 			ForceArray:      false,
 			ExecLocally:     conf.IsConnectionTypeLocal(),
 			PassCredentials: conf.IsPassCredentialsEnabled(),
-			Username:        conf.Settings.WinRMUsername,
-			Password:        conf.Settings.WinRMPassword,
-			Server:          conf.IdentifyDomainController(),
+			Username:        conf.CredentialUsername(),
+			Password:        conf.CredentialPassword(),
+			Server:          conf.IdentifyDomainController(ctx),
 		}
-		psCmd := NewPSCommand([]string{cmd}, psOpts)
-		result, err := psCmd.Run(conf)
+		psCmd := NewPSCommand([]string{setCmd.String()}, psOpts)
+		result, err := psCmd.Run(ctx, conf)
 		if err != nil {
 			return err
 		}
 		if result.ExitCode != 0 {
-			return fmt.Errorf("Set-ADOrganizationalUnit exited with a non-zero exit code %d, stderr :%s", result.ExitCode, result.StdErr)
+			return NewPSCommandError("Set-ADOrganizationalUnit", result)
 		}
 	}
 
 	if path, ok := changes["path"]; ok {
 		var unprotected bool
 		if o.Protected == true {
-			cmd := fmt.Sprintf("Set-ADOrganizationalUnit -Identity %q -ProtectedFromAccidentalDeletion:$false", o.GUID)
+			cmd := NewPSCommandBuilder("Set-ADOrganizationalUnit").
+				AddParam("Identity", o.GUID).
+				AddParam("ProtectedFromAccidentalDeletion", false).
+				String()
 			psOpts := CreatePSCommandOpts{
 				JSONOutput:      true,
 				ForceArray:      false,
 				ExecLocally:     conf.IsConnectionTypeLocal(),
 				PassCredentials: conf.IsPassCredentialsEnabled(),
-				Username:        conf.Settings.WinRMUsername,
-				Password:        conf.Settings.WinRMPassword,
-				Server:          conf.IdentifyDomainController(),
+				Username:        conf.CredentialUsername(),
+				Password:        conf.CredentialPassword(),
+				Server:          conf.IdentifyDomainController(ctx),
 			}
 			psCmd := NewPSCommand([]string{cmd}, psOpts)
-			result, err := psCmd.Run(conf)
+			result, err := psCmd.Run(ctx, conf)
 			if err != nil {
 				return fmt.Errorf("winrm execution failure while unprotecting OU object: %s", err)
 			}
 			if result.ExitCode != 0 {
-				return fmt.Errorf("Set-ADOrganizationalUnit exited with a non zero exit code (%d), stderr: %s", result.ExitCode, result.StdErr)
+				return NewPSCommandError("Set-ADOrganizationalUnit", result)
 			}
 			unprotected = true
 		}
 
-		cmd := fmt.Sprintf("Move-ADObject -Identity %q -TargetPath %q", o.GUID, path.(string))
+		cmd := NewPSCommandBuilder("Move-ADObject").AddParam("Identity", o.GUID).AddParam("TargetPath", path.(string)).String()
 		psOpts := CreatePSCommandOpts{
 			JSONOutput:      true,
 			ForceArray:      false,
 			ExecLocally:     conf.IsConnectionTypeLocal(),
 			PassCredentials: conf.IsPassCredentialsEnabled(),
-			Username:        conf.Settings.WinRMUsername,
-			Password:        conf.Settings.WinRMPassword,
-			Server:          conf.IdentifyDomainController(),
+			Username:        conf.CredentialUsername(),
+			Password:        conf.CredentialPassword(),
+			Server:          conf.IdentifyDomainController(ctx),
 		}
 		psCmd := NewPSCommand([]string{cmd}, psOpts)
-		result, err := psCmd.Run(conf)
+		result, err := psCmd.Run(ctx, conf)
 		if err != nil {
 			return fmt.Errorf("winrm execution failure while moving OU object: %s", err)
 		}
 		if result.ExitCode != 0 {
-			return fmt.Errorf("Move-ADObject exited with a non zero exit code (%d), stderr: %s", result.ExitCode, result.StdErr)
+			return NewPSCommandError("Move-ADObject", result)
 		}
 
 		if unprotected == true {
-			cmd := fmt.Sprintf("Set-ADOrganizationalUnit -Identity %q -ProtectedFromAccidentalDeletion:$true", o.GUID)
+			cmd := NewPSCommandBuilder("Set-ADOrganizationalUnit").
+				AddParam("Identity", o.GUID).
+				AddParam("ProtectedFromAccidentalDeletion", true).
+				String()
 			psOpts := CreatePSCommandOpts{
 				JSONOutput:      true,
 				ForceArray:      false,
 				ExecLocally:     conf.IsConnectionTypeLocal(),
 				PassCredentials: conf.IsPassCredentialsEnabled(),
-				Username:        conf.Settings.WinRMUsername,
-				Password:        conf.Settings.WinRMPassword,
-				Server:          conf.IdentifyDomainController(),
+				Username:        conf.CredentialUsername(),
+				Password:        conf.CredentialPassword(),
+				Server:          conf.IdentifyDomainController(ctx),
 			}
 			psCmd := NewPSCommand([]string{cmd}, psOpts)
-			result, err := psCmd.Run(conf)
+			result, err := psCmd.Run(ctx, conf)
 			if err != nil {
 				return fmt.Errorf("winrm execution failure while protecting OU object: %s", err)
 			}
 			if result.ExitCode != 0 {
-				return fmt.Errorf("Set-ADOrganizationalUnit exited with a non zero exit code (%d), stderr: %s", result.ExitCode, result.StdErr)
+				return NewPSCommandError("Set-ADOrganizationalUnit", result)
 			}
 		}
 	}
 
 	if protected, ok := changes["protected"]; ok {
-		cmd = fmt.Sprintf("Set-ADObject -Identity %s -ProtectedFromAccidentalDeletion:$%t", o.GUID, protected.(bool))
+		cmd := NewPSCommandBuilder("Set-ADObject").
+			AddParam("Identity", o.GUID).
+			AddParam("ProtectedFromAccidentalDeletion", protected.(bool)).
+			String()
 		psOpts := CreatePSCommandOpts{
 			JSONOutput:      true,
 			ForceArray:      false,
 			ExecLocally:     conf.IsConnectionTypeLocal(),
 			PassCredentials: conf.IsPassCredentialsEnabled(),
-			Username:        conf.Settings.WinRMUsername,
-			Password:        conf.Settings.WinRMPassword,
-			Server:          conf.IdentifyDomainController(),
+			Username:        conf.CredentialUsername(),
+			Password:        conf.CredentialPassword(),
+			Server:          conf.IdentifyDomainController(ctx),
 		}
 		psCmd := NewPSCommand([]string{cmd}, psOpts)
-		result, err := psCmd.Run(conf)
+		result, err := psCmd.Run(ctx, conf)
 		if err != nil {
 			return err
 		}
 		if result.ExitCode != 0 {
-			return fmt.Errorf("Set-ADObject exited with a non-zero exit code (%d) while updating OU's protected status, stderr :%s", result.ExitCode, result.StdErr)
+			return NewPSCommandError("Set-ADObject", result)
 		}
 	}
 
 	if name, ok := changes["name"]; ok {
-		cmd = fmt.Sprintf("Rename-ADObject -Identity %q %q ", o.GUID, name.(string))
+		cmd := NewPSCommandBuilder("Rename-ADObject").AddParam("Identity", o.GUID).AddParam("NewName", name.(string)).String()
 		psOpts := CreatePSCommandOpts{
 			JSONOutput:      true,
 			ForceArray:      false,
 			ExecLocally:     conf.IsConnectionTypeLocal(),
 			PassCredentials: conf.IsPassCredentialsEnabled(),
-			Username:        conf.Settings.WinRMUsername,
-			Password:        conf.Settings.WinRMPassword,
-			Server:          conf.IdentifyDomainController(),
+			Username:        conf.CredentialUsername(),
+			Password:        conf.CredentialPassword(),
+			Server:          conf.IdentifyDomainController(ctx),
 		}
 		psCmd := NewPSCommand([]string{cmd}, psOpts)
-		result, err := psCmd.Run(conf)
+		result, err := psCmd.Run(ctx, conf)
 		if err != nil {
 			return err
 		}
 		if result.ExitCode != 0 {
-			return fmt.Errorf("Set-ADObject exited with a non-zero exit code (%d) while renaming OU, stderr :%s", result.ExitCode, result.StdErr)
+			return NewPSCommandError("Set-ADObject", result)
 		}
 	}
 	return nil
 }
 
 // Delete deletes an existing OU from an AD tree
-func (o *OrgUnit) Delete(conf *config.ProviderConf) error {
+func (o *OrgUnit) Delete(ctx context.Context, conf *config.ProviderConf) error {
 	if o.DistinguishedName == "" {
 		return fmt.Errorf("Cannot remove OU with name %q, distiguished name is empty", o.Name)
 	}
+
+	if conf.IsBackendLDAP() {
+		return o.deleteLDAP(ctx, conf)
+	}
+
 	var cmds []string
 	subCmds := []string{
-		fmt.Sprintf("Get-ADObject -Properties * -Identity %q", o.DistinguishedName),
+		NewPSCommandBuilder("Get-ADObject").AddParam("Identity", o.DistinguishedName).AddParam("Properties", "*").String(),
 		"Set-ADObject -ProtectedFromAccidentalDeletion:$false -Passthru",
 		"Remove-ADOrganizationalUnit -confirm:$false",
 	}
@@ -279,10 +310,9 @@ func (o *OrgUnit) Delete(conf *config.ProviderConf) error {
 		ForceArray:      false,
 		ExecLocally:     conf.IsConnectionTypeLocal(),
 		PassCredentials: conf.IsPassCredentialsEnabled(),
-		Username:        conf.Settings.WinRMUsername,
-		Password:        conf.Settings.WinRMPassword,
-		Server:          conf.IdentifyDomainController(),
-		SkipCredPrefix:  true,
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
+		Server:          conf.IdentifyDomainController(ctx),
 	}
 
 	for _, subCmd := range subCmds {
@@ -295,18 +325,18 @@ func (o *OrgUnit) Delete(conf *config.ProviderConf) error {
 		ForceArray:      false,
 		ExecLocally:     conf.IsConnectionTypeLocal(),
 		PassCredentials: conf.IsPassCredentialsEnabled(),
-		Username:        conf.Settings.WinRMUsername,
-		Password:        conf.Settings.WinRMPassword,
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
 		SkipCredSuffix:  true,
 		Server:          "",
 	}
 	psCmd := NewPSCommand([]string{cmd}, psOpts)
-	result, err := psCmd.Run(conf)
+	result, err := psCmd.Run(ctx, conf)
 	if err != nil {
 		return err
 	}
 	if result.ExitCode != 0 {
-		return fmt.Errorf("Get-ADObject -Properties * exited with a non-zero exit code %d, stderr :%s", result.ExitCode, result.StdErr)
+		return NewPSCommandError("Get-ADObject -Properties *", result)
 	}
 	return nil
 }
@@ -2,15 +2,15 @@ package winrmhelper
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"log"
-	"strings"
 
 	"github.com/hashicorp/terraform-provider-ad/ad/internal/config"
 
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"github.com/hashicorp/terraform-provider-ad/ad/internal/gposec"
-	"github.com/packer-community/winrmcp/winrmcp"
 	"gopkg.in/ini.v1"
 )
 
@@ -42,11 +42,11 @@ func GetSecIniFromResource(d *schema.ResourceData, schemaKeys map[string]*schema
 
 // GetSecIniContents returns a byte array with the contents of the INF file
 // encoded in UTF-8 (since we get the ouput via stdout).
-func GetSecIniContents(conf *config.ProviderConf, gpo *GPO) ([]byte, error) {
+func GetSecIniContents(ctx context.Context, conf *config.ProviderConf, gpo *GPO) ([]byte, error) {
 	gptPath := fmt.Sprintf("%s\\Machine\\Microsoft\\Windows NT\\SecEdit\\GptTmpl.inf", gpo.basePath)
 	log.Printf("[DEBUG] Getting security settings inf from %s", gptPath)
 
-	cmd := fmt.Sprintf(`Get-Content "%s"`, gptPath)
+	cmd := NewPSCommandBuilder("Get-Content").AddParam("LiteralPath", gptPath).String()
 	domainName := conf.Settings.DomainName
 	if conf.Settings.KrbRealm == domainName {
 		domainName = "$env:computername"
@@ -56,18 +56,18 @@ func GetSecIniContents(conf *config.ProviderConf, gpo *GPO) ([]byte, error) {
 		ForceArray:      false,
 		ExecLocally:     conf.IsConnectionTypeLocal(),
 		PassCredentials: conf.IsPassCredentialsEnabled(),
-		Username:        conf.Settings.WinRMUsername,
-		Password:        conf.Settings.WinRMPassword,
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
 		Server:          domainName,
 		InvokeCommand:   conf.IsPassCredentialsEnabled(),
 	}
 	psCmd := NewPSCommand([]string{cmd}, psOpts)
-	result, err := psCmd.Run(conf)
+	result, err := psCmd.Run(ctx, conf)
 	if err != nil {
 		return nil, fmt.Errorf("error while retrieving contents of %q: %s", gptPath, err)
 	}
 	if result.ExitCode != 0 {
-		return nil, fmt.Errorf("command to retrieve contents of %q failed, stderr: %s, stdout: %s", gptPath, result.StdErr, result.Stdout)
+		return nil, fmt.Errorf("command to retrieve contents of %q failed: %w", gptPath, NewPSCommandError("Get-Content", result))
 	}
 
 	iniBytes := []byte(result.Stdout)
@@ -75,8 +75,8 @@ func GetSecIniContents(conf *config.ProviderConf, gpo *GPO) ([]byte, error) {
 }
 
 // GetSecIniFromHost returns a struct representing the data retrieved from the host.
-func GetSecIniFromHost(conf *config.ProviderConf, gpo *GPO) (*gposec.SecuritySettings, error) {
-	iniBytes, err := GetSecIniContents(conf, gpo)
+func GetSecIniFromHost(ctx context.Context, conf *config.ProviderConf, gpo *GPO) (*gposec.SecuritySettings, error) {
+	iniBytes, err := GetSecIniContents(ctx, conf, gpo)
 	if err != nil {
 		return nil, err
 	}
@@ -89,7 +89,7 @@ func GetSecIniFromHost(conf *config.ProviderConf, gpo *GPO) (*gposec.SecuritySet
 
 // UploadSecIni uploads the security settings ini to the correct folder of a GPO and updates
 // the GPO's gpt.ini by incrementing the computer version by 1.
-func UploadSecIni(conf *config.ProviderConf, cpClient *winrmcp.Winrmcp, gpo *GPO, iniFile *ini.File) error {
+func UploadSecIni(ctx context.Context, conf *config.ProviderConf, cpClient config.FileCopier, gpo *GPO, iniFile *ini.File) error {
 	ini.LineBreak = "\r\n"
 	buf := bytes.NewBuffer([]byte{})
 	iniLocation := fmt.Sprintf("%s\\Machine\\Microsoft\\Windows NT\\SecEdit\\GptTmpl.inf", gpo.basePath)
@@ -97,13 +97,13 @@ func UploadSecIni(conf *config.ProviderConf, cpClient *winrmcp.Winrmcp, gpo *GPO
 	if err != nil {
 		return fmt.Errorf("error while loading security INF file to buffer, error: %s ", err)
 	}
-	err = UploadFiletoSYSVOL(conf, cpClient, buf, iniLocation)
+	err = UploadFiletoSYSVOL(ctx, conf, cpClient, buf, iniLocation)
 	if err != nil {
 		return err
 	}
 
 	cVer := gpo.computerVersion + 1
-	err = gpo.SetGPOVersions(conf, cpClient, gpo.userVersion, cVer)
+	err = gpo.SetGPOVersions(ctx, conf, cpClient, gpo.userVersion, cVer)
 	if err != nil {
 		return err
 	}
@@ -112,11 +112,11 @@ func UploadSecIni(conf *config.ProviderConf, cpClient *winrmcp.Winrmcp, gpo *GPO
 
 // RemoveSecIni removes the ini file from the host and updates the GPO's  gpt.ini by incrementing the
 // computer version by 1.
-func RemoveSecIni(conf *config.ProviderConf, cpConn *winrmcp.Winrmcp, gpo *GPO) error {
+func RemoveSecIni(ctx context.Context, conf *config.ProviderConf, cpConn config.FileCopier, gpo *GPO) error {
 	gptPath := fmt.Sprintf("%s\\Machine\\Microsoft\\Windows NT\\SecEdit\\GptTmpl.inf", gpo.basePath)
 	log.Printf("[DEBUG] Getting security settings inf from %s", gptPath)
 
-	cmd := fmt.Sprintf(`Remove-Item "%s"`, gptPath)
+	cmd := NewPSCommandBuilder("Remove-Item").AddParam("LiteralPath", gptPath).String()
 	domainName := conf.Settings.DomainName
 	if conf.Settings.KrbRealm == domainName {
 		domainName = "$env:computername"
@@ -126,25 +126,25 @@ func RemoveSecIni(conf *config.ProviderConf, cpConn *winrmcp.Winrmcp, gpo *GPO)
 		ForceArray:      false,
 		ExecLocally:     conf.IsConnectionTypeLocal(),
 		PassCredentials: conf.IsPassCredentialsEnabled(),
-		Username:        conf.Settings.WinRMUsername,
-		Password:        conf.Settings.WinRMPassword,
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
 		Server:          domainName,
 		InvokeCommand:   conf.IsPassCredentialsEnabled(),
 	}
 	psCmd := NewPSCommand([]string{cmd}, psOpts)
-	result, err := psCmd.Run(conf)
+	result, err := psCmd.Run(ctx, conf)
 	if err != nil {
 		return fmt.Errorf("error while retrieving contents of %q: %s", gptPath, err)
 	}
 
 	if result.ExitCode != 0 {
-		if !strings.Contains(result.StdErr, "ItemNotFoundException") {
-			return fmt.Errorf("error while removing %q: %s", gptPath, err)
+		if err := NewPSCommandError("Remove-Item", result); !errors.Is(err, ErrNotFound) {
+			return fmt.Errorf("error while removing %q: %w", gptPath, err)
 		}
 	}
 
 	cVer := gpo.computerVersion + 1
-	err = gpo.SetGPOVersions(conf, cpConn, gpo.userVersion, cVer)
+	err = gpo.SetGPOVersions(ctx, conf, cpConn, gpo.userVersion, cVer)
 	if err != nil {
 		return err
 	}
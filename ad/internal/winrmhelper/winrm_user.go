@@ -1,7 +1,9 @@
 package winrmhelper
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"reflect"
@@ -62,178 +64,97 @@ type User struct {
 	CustomAttributes       map[string]interface{}
 }
 
+// userProperties are the properties of the users decoded into User.
+var userProperties = []string{
+	"ObjectGUID", "SamAccountName", "UserPrincipalName", "City", "Company", "Country", "Department",
+	"Description", "DisplayName", "DistinguishedName", "Division", "EmailAddress", "EmployeeID",
+	"EmployeeNumber", "Enabled", "Fax", "GivenName", "HomeDirectory", "HomeDrive", "HomePhone", "HomePage",
+	"Initials", "MobilePhone", "Office", "OfficePhone", "Organization", "OtherName", "POBox", "PostalCode",
+	"SID", "SmartcardLogonRequired", "State", "StreetAddress", "Surname", "Title", "TrustedForDelegation",
+	"userAccountControl",
+}
+
 // NewUser creates the user by running the New-ADUser powershell command
-func (u *User) NewUser(conf *config.ProviderConf) (string, error) {
+func (u *User) NewUser(ctx context.Context, conf *config.ProviderConf) (string, error) {
 	if u.Username == "" {
 		return "", fmt.Errorf("user principal name required")
 	}
 
-	log.Printf("Adding user with UPN: %q", u.PrincipalName)
-	cmds := []string{fmt.Sprintf("New-ADUser -Passthru -Name %q", u.Username)}
-
-	cmds = append(cmds, fmt.Sprintf("-CannotChangePassword $%t", u.CannotChangePassword))
-	cmds = append(cmds, fmt.Sprintf("-PasswordNeverExpires $%t", u.PasswordNeverExpires))
-	cmds = append(cmds, fmt.Sprintf("-Enabled $%t", u.Enabled))
-	cmds = append(cmds, fmt.Sprintf("-SmartcardLogonRequired $%t", u.SmartcardLogonRequired))
-	cmds = append(cmds, fmt.Sprintf("-TrustedForDelegation $%t", u.TrustedForDelegation))
-
-	if u.SAMAccountName != "" {
-		cmds = append(cmds, fmt.Sprintf("-SamAccountName %q", u.SAMAccountName))
+	if conf.IsBackendLDAP() {
+		return u.newUserLDAP(ctx, conf)
 	}
 
-	if u.PrincipalName != "" {
-		cmds = append(cmds, fmt.Sprintf("-UserPrincipalName %q", u.PrincipalName))
-	}
+	log.Printf("Adding user with UPN: %q", u.PrincipalName)
+	cmd := NewPSCommandBuilder("New-ADUser").
+		AddParam("PassThru", true).
+		AddParam("Name", u.Username).
+		AddParam("CannotChangePassword", u.CannotChangePassword).
+		AddParam("PasswordNeverExpires", u.PasswordNeverExpires).
+		AddParam("Enabled", u.Enabled).
+		AddParam("SmartcardLogonRequired", u.SmartcardLogonRequired).
+		AddParam("TrustedForDelegation", u.TrustedForDelegation).
+		AddParamIfNotEmpty("SamAccountName", u.SAMAccountName).
+		AddParamIfNotEmpty("UserPrincipalName", u.PrincipalName).
+		AddParamIfNotEmpty("DisplayName", u.DisplayName).
+		AddParamIfNotEmpty("Path", u.Container).
+		AddParamIfNotEmpty("City", u.City).
+		AddParamIfNotEmpty("Company", u.Company).
+		AddParamIfNotEmpty("Country", strings.ToUpper(u.Country)).
+		AddParamIfNotEmpty("Department", u.Department).
+		AddParamIfNotEmpty("Description", u.Description).
+		AddParamIfNotEmpty("Division", u.Division).
+		AddParamIfNotEmpty("EmailAddress", u.EmailAddress).
+		AddParamIfNotEmpty("EmployeeID", u.EmployeeID).
+		AddParamIfNotEmpty("EmployeeNumber", u.EmployeeNumber).
+		AddParamIfNotEmpty("Fax", u.Fax).
+		AddParamIfNotEmpty("GivenName", u.GivenName).
+		AddParamIfNotEmpty("HomeDirectory", u.HomeDirectory).
+		AddParamIfNotEmpty("HomeDrive", u.HomeDrive).
+		AddParamIfNotEmpty("HomePhone", u.HomePhone).
+		AddParamIfNotEmpty("HomePage", u.HomePage).
+		AddParamIfNotEmpty("Initials", u.Initials).
+		AddParamIfNotEmpty("MobilePhone", u.MobilePhone).
+		AddParamIfNotEmpty("Office", u.Office).
+		AddParamIfNotEmpty("OfficePhone", u.OfficePhone).
+		AddParamIfNotEmpty("Organization", u.Organization).
+		AddParamIfNotEmpty("OtherName", u.OtherName).
+		AddParamIfNotEmpty("POBox", u.POBox).
+		AddParamIfNotEmpty("PostalCode", u.PostalCode).
+		AddParamIfNotEmpty("State", u.State).
+		AddParamIfNotEmpty("StreetAddress", u.StreetAddress).
+		AddParamIfNotEmpty("Surname", u.Surname).
+		AddParamIfNotEmpty("Title", u.Title)
 
 	if u.Password != "" {
-		cmds = append(cmds, fmt.Sprintf("-AccountPassword (ConvertTo-SecureString -AsPlainText %q -Force)", u.Password))
-	}
-
-	if u.DisplayName != "" {
-		cmds = append(cmds, fmt.Sprintf("-DisplayName %q", u.DisplayName))
-	}
-
-	if u.Container != "" {
-		cmds = append(cmds, fmt.Sprintf("-Path %q", u.Container))
-	}
-
-	if u.City != "" {
-		cmds = append(cmds, fmt.Sprintf("-City %q", u.City))
-	}
-
-	if u.Company != "" {
-		cmds = append(cmds, fmt.Sprintf("-Company %q", u.Company))
-	}
-
-	if u.Country != "" {
-		country := strings.ToUpper(u.Country)
-		cmds = append(cmds, fmt.Sprintf("-Country %q", country))
-	}
-
-	if u.Department != "" {
-		cmds = append(cmds, fmt.Sprintf("-Department %q", u.Department))
-	}
-
-	if u.Description != "" {
-		cmds = append(cmds, fmt.Sprintf("-Description %q", u.Description))
-	}
-
-	if u.Division != "" {
-		cmds = append(cmds, fmt.Sprintf("-Division %q", u.Division))
-	}
-
-	if u.EmailAddress != "" {
-		cmds = append(cmds, fmt.Sprintf("-EmailAddress %q", u.EmailAddress))
-	}
-
-	if u.EmployeeID != "" {
-		cmds = append(cmds, fmt.Sprintf("-EmployeeID %q", u.EmployeeID))
-	}
-
-	if u.EmployeeNumber != "" {
-		cmds = append(cmds, fmt.Sprintf("-EmployeeNumber %q", u.EmployeeNumber))
-	}
-
-	if u.Fax != "" {
-		cmds = append(cmds, fmt.Sprintf("-Fax %q", u.Fax))
-	}
-
-	if u.GivenName != "" {
-		cmds = append(cmds, fmt.Sprintf("-GivenName %q", u.GivenName))
-	}
-
-	if u.HomeDirectory != "" {
-		cmds = append(cmds, fmt.Sprintf("-HomeDirectory %q", u.HomeDirectory))
-	}
-
-	if u.HomeDrive != "" {
-		cmds = append(cmds, fmt.Sprintf("-HomeDrive %q", u.HomeDrive))
-	}
-
-	if u.HomePhone != "" {
-		cmds = append(cmds, fmt.Sprintf("-HomePhone %q", u.HomePhone))
-	}
-
-	if u.HomePage != "" {
-		cmds = append(cmds, fmt.Sprintf("-HomePage %q", u.HomePage))
-	}
-
-	if u.Initials != "" {
-		cmds = append(cmds, fmt.Sprintf("-Initials %q", u.Initials))
-	}
-
-	if u.MobilePhone != "" {
-		cmds = append(cmds, fmt.Sprintf("-MobilePhone %q", u.MobilePhone))
-	}
-
-	if u.Office != "" {
-		cmds = append(cmds, fmt.Sprintf("-Office %q", u.Office))
-	}
-
-	if u.OfficePhone != "" {
-		cmds = append(cmds, fmt.Sprintf("-OfficePhone %q", u.OfficePhone))
-	}
-
-	if u.Organization != "" {
-		cmds = append(cmds, fmt.Sprintf("-Organization %q", u.Organization))
-	}
-
-	if u.OtherName != "" {
-		cmds = append(cmds, fmt.Sprintf("-OtherName %q", u.OtherName))
-	}
-
-	if u.POBox != "" {
-		cmds = append(cmds, fmt.Sprintf("-POBox %q", u.POBox))
-	}
-
-	if u.PostalCode != "" {
-		cmds = append(cmds, fmt.Sprintf("-PostalCode %q", u.PostalCode))
-	}
-
-	if u.State != "" {
-		cmds = append(cmds, fmt.Sprintf("-State %q", u.State))
-	}
-
-	if u.StreetAddress != "" {
-		cmds = append(cmds, fmt.Sprintf("-StreetAddress %q", u.StreetAddress))
-	}
-
-	if u.Surname != "" {
-		cmds = append(cmds, fmt.Sprintf("-Surname %q", u.Surname))
-	}
-
-	if u.Title != "" {
-		cmds = append(cmds, fmt.Sprintf("-Title %q", u.Title))
+		cmd.AddSecureParam("AccountPassword", u.Password)
 	}
 
 	if u.CustomAttributes != nil {
-		attrs, err := u.getOtherAttributes()
-		if err != nil {
-			return "", err
-		}
-		cmds = append(cmds, fmt.Sprintf("-OtherAttributes %s", attrs))
+		cmd.AddParam("OtherAttributes", u.getOtherAttributes())
 	}
 
 	psOpts := CreatePSCommandOpts{
 		JSONOutput:      true,
 		ForceArray:      false,
+		NonIdempotent:   true,
 		ExecLocally:     conf.IsConnectionTypeLocal(),
 		PassCredentials: conf.IsPassCredentialsEnabled(),
-		Username:        conf.Settings.WinRMUsername,
-		Password:        conf.Settings.WinRMPassword,
-		Server:          conf.IdentifyDomainController(),
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
+		Server:          conf.IdentifyDomainController(ctx),
 	}
-	psCmd := NewPSCommand(cmds, psOpts)
-	result, err := psCmd.Run(conf)
+	psCmd := NewPSCommand([]string{cmd.String()}, psOpts)
+	result, err := psCmd.RunBatched(ctx, conf)
 	if err != nil {
 		return "", err
 	}
 	if result.ExitCode != 0 {
 		log.Printf("[DEBUG] stderr: %s\nstdout: %s", result.StdErr, result.Stdout)
-		if strings.Contains(result.StdErr, "AlreadyExists") {
-			return "", fmt.Errorf("there is another User named %q", u.PrincipalName)
+		err := NewPSCommandError("New-ADUser", result)
+		if errors.Is(err, ErrAlreadyExists) {
+			return "", fmt.Errorf("there is another User named %q: %w", u.PrincipalName, err)
 		}
-		return "", fmt.Errorf("command New-ADUser exited with a non-zero exit code %d, stderr: %s", result.ExitCode, result.StdErr)
+		return "", err
 	}
 
 	user, err := unmarshallUser([]byte(result.Stdout), nil)
@@ -241,12 +162,18 @@ func (u *User) NewUser(conf *config.ProviderConf) (string, error) {
 		return "", fmt.Errorf("error while unmarshalling user json document: %s", err)
 	}
 
+	conf.InvalidateObject(user.GUID)
 	return user.GUID, nil
 }
 
 // ModifyUser updates the AD user's details based on what's changed in the resource.
-func (u *User) ModifyUser(d *schema.ResourceData, conf *config.ProviderConf) error {
+func (u *User) ModifyUser(ctx context.Context, d *schema.ResourceData, conf *config.ProviderConf) error {
 	log.Printf("Modifying user: %q", u.PrincipalName)
+	conf.InvalidateObject(u.GUID)
+	if conf.IsBackendLDAP() {
+		return u.modifyUserLDAP(ctx, d, conf)
+	}
+
 	strKeyMap := map[string]string{
 		"sam_account_name": "SamAccountName",
 		"display_name":     "DisplayName",
@@ -280,17 +207,11 @@ func (u *User) ModifyUser(d *schema.ResourceData, conf *config.ProviderConf) err
 		"title":            "Title",
 	}
 
-	cmds := []string{fmt.Sprintf("Set-ADUser -Identity %q", u.GUID)}
+	setCmd := NewPSCommandBuilder("Set-ADUser").AddParam("Identity", u.GUID)
 
 	for k, param := range strKeyMap {
 		if d.HasChange(k) {
-			value := SanitiseTFInput(d, k)
-			if value == "" {
-				value = "$null"
-			} else {
-				value = fmt.Sprintf(`"%s"`, value)
-			}
-			cmds = append(cmds, fmt.Sprintf(`-%s %s`, param, value))
+			setCmd.AddParamOrNull(param, d.Get(k).(string))
 		}
 	}
 
@@ -304,149 +225,98 @@ func (u *User) ModifyUser(d *schema.ResourceData, conf *config.ProviderConf) err
 
 	for k, param := range boolKeyMap {
 		if d.HasChange(k) {
-			value := d.Get(k).(bool)
-			cmds = append(cmds, fmt.Sprintf("-%s $%t", param, value))
+			setCmd.AddParam(param, d.Get(k).(bool))
 		}
 	}
 
 	if d.HasChange("custom_attributes") {
 		oldValue, newValue := d.GetChange("custom_attributes")
-		newMap, err := structure.ExpandJsonFromString(newValue.(string))
+		toClear, toReplace, toAdd, err := customAttributeChanges(oldValue.(string), newValue.(string))
 		if err != nil {
 			return err
 		}
 
-		newSortedMap := SortInnerSlice(newMap)
-		toClear := []string{}
-		toReplace := []string{}
-		toAdd := []string{}
-
-		var oldSortedMap map[string]interface{}
-		if oldValue.(string) != "" {
-			oldMap, err := structure.ExpandJsonFromString(oldValue.(string))
-			if err != nil {
-				return fmt.Errorf("while expanding CA json string %s: %s", oldValue.(string), err)
-			}
-			oldSortedMap = SortInnerSlice(oldMap)
-		}
-
-		for k, v := range oldSortedMap {
-			if newVal, ok := newSortedMap[k]; ok {
-				if !reflect.DeepEqual(v, newVal) {
-					var out string
-					if reflect.ValueOf(newVal).Kind() == reflect.Slice {
-						quotedStrings := make([]string, len(newVal.([]string)))
-						for idx, s := range newVal.([]string) {
-							// Using %q here will cause double quotes inside the string to be escaped with \"
-							// which is not desirable in Powershell
-							quotedStrings[idx] = fmt.Sprintf(`"%s"`, s)
-						}
-						out = strings.Join(quotedStrings, ",")
-					} else {
-						out = fmt.Sprintf(`"%s"`, newVal.(string))
-					}
-					toReplace = append(toReplace, fmt.Sprintf("%s=%s", SanitiseString(k), out))
-				}
-			} else {
-				toClear = append(toClear, SanitiseString(k))
-			}
-		}
-
-		for k, newVal := range newSortedMap {
-			if _, ok := oldSortedMap[k]; !ok {
-				var out string
-				if reflect.ValueOf(newVal).Kind() == reflect.Slice {
-					quotedStrings := make([]string, len(newVal.([]string)))
-					for idx, s := range newVal.([]string) {
-						// Using %q here will cause double quotes inside the string to be escaped with \"
-						// which is not desirable in Powershell
-						quotedStrings[idx] = s
-					}
-					out = strings.Join(quotedStrings, ",")
-				} else {
-					out = newVal.(string)
-				}
-				toAdd = append(toAdd, fmt.Sprintf("%s=%s", SanitiseString(k), out))
-			}
-		}
-
 		if len(toClear) > 0 {
-			cmds = append(cmds, fmt.Sprintf(`-Clear %s`, strings.Join(toClear, ";")))
+			setCmd.AddParam("Clear", toClear)
 		}
 
 		if len(toReplace) > 0 {
-			cmds = append(cmds, fmt.Sprintf(`-Replace @{%s}`, strings.Join(toReplace, ";")))
+			setCmd.AddParam("Replace", toReplace)
 		}
 
 		if len(toAdd) > 0 {
-			cmds = append(cmds, fmt.Sprintf(`-Add @{%s}`, strings.Join(toAdd, ";")))
+			setCmd.AddParam("Add", toAdd)
 		}
 
 	}
 
-	if len(cmds) > 1 {
+	if setCmd.Len() > 1 {
 		psOpts := CreatePSCommandOpts{
 			JSONOutput:      false,
 			ForceArray:      false,
 			ExecLocally:     conf.IsConnectionTypeLocal(),
 			PassCredentials: conf.IsPassCredentialsEnabled(),
-			Username:        conf.Settings.WinRMUsername,
-			Password:        conf.Settings.WinRMPassword,
-			Server:          conf.IdentifyDomainController(),
+			Username:        conf.CredentialUsername(),
+			Password:        conf.CredentialPassword(),
+			Server:          conf.IdentifyDomainController(ctx),
 		}
-		psCmd := NewPSCommand(cmds, psOpts)
-		result, err := psCmd.Run(conf)
+		psCmd := NewPSCommand([]string{setCmd.String()}, psOpts)
+		result, err := psCmd.Run(ctx, conf)
 
 		if err != nil {
 			return err
 		}
 		if result.ExitCode != 0 {
 			log.Printf("[DEBUG] stderr: %s\nstdout: %s", result.StdErr, result.Stdout)
-			return fmt.Errorf("command Set-ADUser exited with a non-zero exit code %d, stderr: %s", result.ExitCode, result.StdErr)
+			return NewPSCommandError("Set-ADUser", result)
 		}
 	}
 
 	if d.HasChange("initial_password") {
-		cmd := fmt.Sprintf("Set-ADAccountPassword -Identity %q -Reset -NewPassword (ConvertTo-SecureString -AsPlainText %q -Force)", u.GUID, u.Password)
+		cmd := NewPSCommandBuilder("Set-ADAccountPassword").
+			AddParam("Identity", u.GUID).
+			AddParam("Reset", true).
+			AddSecureParam("NewPassword", u.Password).
+			String()
 		psOpts := CreatePSCommandOpts{
 			JSONOutput:      false,
 			ForceArray:      false,
 			ExecLocally:     conf.IsConnectionTypeLocal(),
 			PassCredentials: conf.IsPassCredentialsEnabled(),
-			Username:        conf.Settings.WinRMUsername,
-			Password:        conf.Settings.WinRMPassword,
-			Server:          conf.IdentifyDomainController(),
+			Username:        conf.CredentialUsername(),
+			Password:        conf.CredentialPassword(),
+			Server:          conf.IdentifyDomainController(ctx),
 		}
 		psCmd := NewPSCommand([]string{cmd}, psOpts)
-		result, err := psCmd.Run(conf)
+		result, err := psCmd.Run(ctx, conf)
 		if err != nil {
 			return err
 		}
 		if result.ExitCode != 0 {
 			log.Printf("[DEBUG] stderr: %s\nstdout: %s", result.StdErr, result.Stdout)
-			return fmt.Errorf("command Set-AccountPassword exited with a non-zero exit code %d, stderr: %s", result.ExitCode, result.StdErr)
+			return NewPSCommandError("Set-AccountPassword", result)
 		}
 	}
 
 	if d.HasChange("container") {
 		path := d.Get("container").(string)
-		cmd := fmt.Sprintf("Move-AdObject -Identity %q -TargetPath %q", u.GUID, path)
+		cmd := NewPSCommandBuilder("Move-ADObject").AddParam("Identity", u.GUID).AddParam("TargetPath", path).String()
 		psOpts := CreatePSCommandOpts{
 			JSONOutput:      true,
 			ForceArray:      false,
 			ExecLocally:     conf.IsConnectionTypeLocal(),
 			PassCredentials: conf.IsPassCredentialsEnabled(),
-			Username:        conf.Settings.WinRMUsername,
-			Password:        conf.Settings.WinRMPassword,
-			Server:          conf.IdentifyDomainController(),
+			Username:        conf.CredentialUsername(),
+			Password:        conf.CredentialPassword(),
+			Server:          conf.IdentifyDomainController(ctx),
 		}
 		psCmd := NewPSCommand([]string{cmd}, psOpts)
-		result, err := psCmd.Run(conf)
+		result, err := psCmd.Run(ctx, conf)
 		if err != nil {
 			return fmt.Errorf("winrm execution failure while moving user object: %s", err)
 		}
 		if result.ExitCode != 0 {
-			return fmt.Errorf("Move-ADObject exited with a non zero exit code (%d), stderr: %s", result.ExitCode, result.StdErr)
+			return NewPSCommandError("Move-ADObject", result)
 		}
 	}
 
@@ -454,22 +324,31 @@ func (u *User) ModifyUser(d *schema.ResourceData, conf *config.ProviderConf) err
 }
 
 // DeleteUser deletes an AD user by calling Remove-ADUser
-func (u *User) DeleteUser(conf *config.ProviderConf) error {
-	cmd := fmt.Sprintf("Remove-ADUser -Identity %s -Confirm:$false", u.GUID)
+func (u *User) DeleteUser(ctx context.Context, conf *config.ProviderConf) error {
+	conf.InvalidateObject(u.GUID)
+	if conf.IsBackendLDAP() {
+		return u.deleteUserLDAP(ctx, conf)
+	}
+
+	cmd := NewPSCommandBuilder("Remove-ADUser").AddParam("Identity", u.GUID).AddParam("Confirm", false).String()
 	psOpts := CreatePSCommandOpts{
 		JSONOutput:      false,
 		ForceArray:      false,
 		ExecLocally:     conf.IsConnectionTypeLocal(),
 		PassCredentials: conf.IsPassCredentialsEnabled(),
-		Username:        conf.Settings.WinRMUsername,
-		Password:        conf.Settings.WinRMPassword,
-		Server:          conf.IdentifyDomainController(),
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
+		Server:          conf.IdentifyDomainController(ctx),
 	}
 	psCmd := NewPSCommand([]string{cmd}, psOpts)
-	_, err := psCmd.Run(conf)
+	result, err := psCmd.RunBatched(ctx, conf)
 	if err != nil {
+		return err
+	}
+	if result.ExitCode != 0 {
+		err := NewPSCommandError("Remove-ADUser", result)
 		// Check if the resource is already deleted
-		if strings.Contains(err.Error(), "ADIdentityNotFoundException") {
+		if errors.Is(err, ErrNotFound) {
 			return nil
 		}
 		return err
@@ -477,68 +356,114 @@ func (u *User) DeleteUser(conf *config.ProviderConf) error {
 	return nil
 }
 
-func (u *User) getOtherAttributes() (string, error) {
-	out := []string{}
-	for k, v := range u.CustomAttributes {
-		cleanKey := SanitiseString(k)
-		var cleanValue string
-		if reflect.ValueOf(v).Kind() == reflect.Slice {
-			quotedStrings := make([]string, len(v.([]interface{})))
-			for idx, s := range v.([]interface{}) {
-				// Using %q here will cause double quotes inside the string to be escaped with \"
-				// which is not desirable in Powershell
-				quotedStrings[idx] = GetString(s.(string))
+// customAttributeChanges compares two JSON encoded maps of custom attributes and returns the attributes
+// to clear, and the values of the attributes to replace and to add.
+func customAttributeChanges(oldValue, newValue string) ([]string, map[string]interface{}, map[string]interface{}, error) {
+	newMap := map[string]interface{}{}
+	if newValue != "" {
+		var err error
+		newMap, err = structure.ExpandJsonFromString(newValue)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	newAttributes := (&User{CustomAttributes: newMap}).getOtherAttributes()
+	newSortedMap := SortInnerSlice(newMap)
+	toClear := []string{}
+	toReplace := map[string]interface{}{}
+	toAdd := map[string]interface{}{}
+
+	var oldSortedMap map[string]interface{}
+	if oldValue != "" {
+		oldMap, err := structure.ExpandJsonFromString(oldValue)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("while expanding CA json string %s: %s", oldValue, err)
+		}
+		oldSortedMap = SortInnerSlice(oldMap)
+	}
+
+	for k, v := range oldSortedMap {
+		if newVal, ok := newSortedMap[k]; ok {
+			if !reflect.DeepEqual(v, newVal) {
+				toReplace[k] = newAttributes[k]
 			}
-			cleanValue = strings.Join(quotedStrings, ",")
 		} else {
-			cleanValue = GetString(v.(string))
+			toClear = append(toClear, k)
+		}
+	}
+
+	for k := range newSortedMap {
+		if _, ok := oldSortedMap[k]; !ok {
+			toAdd[k] = newAttributes[k]
+		}
+	}
+	return toClear, toReplace, toAdd, nil
+}
+
+// getOtherAttributes returns the custom attributes in the form expected by -OtherAttributes, with the
+// values of multi-valued attributes as lists.
+func (u *User) getOtherAttributes() map[string]interface{} {
+	out := make(map[string]interface{}, len(u.CustomAttributes))
+	for k, v := range u.CustomAttributes {
+		out[k] = customAttributeValue(v)
+	}
+	return out
+}
+
+// customAttributeValue converts the value of a custom attribute decoded from JSON to a string or a list
+// of strings.
+func customAttributeValue(v interface{}) interface{} {
+	if values, ok := v.([]interface{}); ok {
+		out := make([]string, len(values))
+		for idx, value := range values {
+			out[idx] = fmt.Sprintf("%v", value)
 		}
-		out = append(out, fmt.Sprintf(`'%s'=%s`, cleanKey, cleanValue))
+		return out
 	}
-	finalAttrString := strings.Join(out, ";")
-	return fmt.Sprintf("@{%s}", finalAttrString), nil
+	return fmt.Sprintf("%v", v)
 }
 
 // GetUserFromResource returns a user struct built from Resource data
 func GetUserFromResource(d *schema.ResourceData) (*User, error) {
 	user := User{
 		GUID:                   d.Id(),
-		SAMAccountName:         SanitiseTFInput(d, "sam_account_name"),
-		PrincipalName:          SanitiseTFInput(d, "principal_name"),
-		DisplayName:            SanitiseTFInput(d, "display_name"),
-		Container:              SanitiseTFInput(d, "container"),
-		Password:               SanitiseTFInput(d, "initial_password"),
+		SAMAccountName:         d.Get("sam_account_name").(string),
+		PrincipalName:          d.Get("principal_name").(string),
+		DisplayName:            d.Get("display_name").(string),
+		Container:              d.Get("container").(string),
+		Password:               d.Get("initial_password").(string),
 		Enabled:                d.Get("enabled").(bool),
 		PasswordNeverExpires:   d.Get("password_never_expires").(bool),
 		CannotChangePassword:   d.Get("cannot_change_password").(bool),
-		City:                   SanitiseTFInput(d, "city"),
-		Company:                SanitiseTFInput(d, "company"),
-		Country:                SanitiseTFInput(d, "country"),
-		Department:             SanitiseTFInput(d, "department"),
-		Description:            SanitiseTFInput(d, "description"),
-		Division:               SanitiseTFInput(d, "division"),
-		EmailAddress:           SanitiseTFInput(d, "email_address"),
-		EmployeeID:             SanitiseTFInput(d, "employee_id"),
-		EmployeeNumber:         SanitiseTFInput(d, "employee_number"),
-		Fax:                    SanitiseTFInput(d, "fax"),
-		GivenName:              SanitiseTFInput(d, "given_name"),
-		HomeDirectory:          SanitiseTFInput(d, "home_directory"),
-		HomeDrive:              SanitiseTFInput(d, "home_drive"),
-		HomePhone:              SanitiseTFInput(d, "home_phone"),
-		HomePage:               SanitiseTFInput(d, "home_page"),
-		Initials:               SanitiseTFInput(d, "initials"),
-		MobilePhone:            SanitiseTFInput(d, "mobile_phone"),
-		Office:                 SanitiseTFInput(d, "office"),
-		OfficePhone:            SanitiseTFInput(d, "office_phone"),
-		Organization:           SanitiseTFInput(d, "organization"),
-		OtherName:              SanitiseTFInput(d, "other_name"),
-		POBox:                  SanitiseTFInput(d, "po_box"),
-		PostalCode:             SanitiseTFInput(d, "postal_code"),
+		City:                   d.Get("city").(string),
+		Company:                d.Get("company").(string),
+		Country:                d.Get("country").(string),
+		Department:             d.Get("department").(string),
+		Description:            d.Get("description").(string),
+		Division:               d.Get("division").(string),
+		EmailAddress:           d.Get("email_address").(string),
+		EmployeeID:             d.Get("employee_id").(string),
+		EmployeeNumber:         d.Get("employee_number").(string),
+		Fax:                    d.Get("fax").(string),
+		GivenName:              d.Get("given_name").(string),
+		HomeDirectory:          d.Get("home_directory").(string),
+		HomeDrive:              d.Get("home_drive").(string),
+		HomePhone:              d.Get("home_phone").(string),
+		HomePage:               d.Get("home_page").(string),
+		Initials:               d.Get("initials").(string),
+		MobilePhone:            d.Get("mobile_phone").(string),
+		Office:                 d.Get("office").(string),
+		OfficePhone:            d.Get("office_phone").(string),
+		Organization:           d.Get("organization").(string),
+		OtherName:              d.Get("other_name").(string),
+		POBox:                  d.Get("po_box").(string),
+		PostalCode:             d.Get("postal_code").(string),
 		SmartcardLogonRequired: d.Get("smart_card_logon_required").(bool),
-		State:                  SanitiseTFInput(d, "state"),
-		StreetAddress:          SanitiseTFInput(d, "street_address"),
-		Surname:                SanitiseTFInput(d, "surname"),
-		Title:                  SanitiseTFInput(d, "title"),
+		State:                  d.Get("state").(string),
+		StreetAddress:          d.Get("street_address").(string),
+		Surname:                d.Get("surname").(string),
+		Title:                  d.Get("title").(string),
 		TrustedForDelegation:   d.Get("trusted_for_delegation").(bool),
 	}
 	if user.PrincipalName != "" {
@@ -564,26 +489,39 @@ func GetUserFromResource(d *schema.ResourceData) (*User, error) {
 
 // GetUserFromHost returns a User struct based on data
 // retrieved from the AD Domain Controller.
-func GetUserFromHost(conf *config.ProviderConf, guid string, customAttributes []string) (*User, error) {
-	cmd := fmt.Sprintf("Get-ADUser -identity %q -properties *", guid)
+func GetUserFromHost(ctx context.Context, conf *config.ProviderConf, guid string, customAttributes []string) (*User, error) {
+	if conf.IsBackendLDAP() {
+		return getUserFromLDAP(ctx, conf, guid, customAttributes)
+	}
+	if doc := conf.CachedObject(guid); doc != nil {
+		log.Printf("[DEBUG] Reading user %s from the object cache", guid)
+		u, err := unmarshallUser(doc, customAttributes)
+		if err != nil {
+			return nil, fmt.Errorf("error while unmarshalling user json document: %s", err)
+		}
+		return u, nil
+	}
+
+	cmd := NewPSCommandBuilder("Get-ADUser").AddParam("Identity", guid).AddParam("Properties", "*").String()
 	psOpts := CreatePSCommandOpts{
 		JSONOutput:      true,
+		JSONProperties:  append(append([]string(nil), userProperties...), customAttributes...),
 		ForceArray:      false,
 		ExecLocally:     conf.IsConnectionTypeLocal(),
 		PassCredentials: conf.IsPassCredentialsEnabled(),
-		Username:        conf.Settings.WinRMUsername,
-		Password:        conf.Settings.WinRMPassword,
-		Server:          conf.IdentifyDomainController(),
+		Username:        conf.CredentialUsername(),
+		Password:        conf.CredentialPassword(),
+		Server:          conf.IdentifyDomainController(ctx),
 	}
 	psCmd := NewPSCommand([]string{cmd}, psOpts)
-	result, err := psCmd.Run(conf)
+	result, err := psCmd.Run(ctx, conf)
 	if err != nil {
 		return nil, err
 	}
 
 	if result.ExitCode != 0 {
 		log.Printf("[DEBUG] stderr: %s\nstdout: %s", result.StdErr, result.Stdout)
-		return nil, fmt.Errorf("command Get-ADUser exited with a non-zero exit code %d, stderr: %s", result.ExitCode, result.StdErr)
+		return nil, NewPSCommandError("Get-ADUser", result)
 	}
 
 	u, err := unmarshallUser([]byte(result.Stdout), customAttributes)
@@ -641,7 +579,8 @@ func unmarshallUser(input []byte, customAttributes []string) (*User, error) {
 	userMap := userMapIntf.(map[string]interface{})
 	user.CustomAttributes = make(map[string]interface{})
 	for _, property := range customAttributes {
-		if val, ok := userMap[property]; ok {
+		// The projection of the output returns the attributes the user does not have as null.
+		if val, ok := userMap[property]; ok && val != nil {
 			user.CustomAttributes[property] = val
 		}
 	}
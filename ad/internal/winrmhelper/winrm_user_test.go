@@ -0,0 +1,74 @@
+package winrmhelper
+
+import (
+	"reflect"
+	"testing"
+)
+
+// userDocument is the output of Get-ADUser projected on the properties of User and four custom attributes,
+// one of which the user does not have, converted with a depth of 5.
+const userDocument = `{
+    "ObjectGUID":  "5d2f0b8e-1c9a-4f4b-9c1e-2a6b3f1d7e01",
+    "SamAccountName":  "jdoe",
+    "UserPrincipalName":  "jdoe@contoso.com",
+    "DistinguishedName":  "CN=jdoe,OU=Users,DC=contoso,DC=com",
+    "Enabled":  true,
+    "SID":  {
+                "BinaryLength":  28,
+                "AccountDomainSid":  {
+                                         "BinaryLength":  24,
+                                         "AccountDomainSid":  "S-1-5-21-3623811015-3361044348-30300820",
+                                         "Value":  "S-1-5-21-3623811015-3361044348-30300820"
+                                     },
+                "Value":  "S-1-5-21-3623811015-3361044348-30300820-1105"
+            },
+    "userAccountControl":  66048,
+    "mail":  null,
+    "otherTelephone":  [
+                           "555-0100",
+                           "555-0101"
+                       ],
+    "thumbnailPhoto":  [
+                           255,
+                           216,
+                           255
+                       ],
+    "userCertificate":  [
+                            [
+                                48,
+                                130
+                            ],
+                            [
+                                48,
+                                131
+                            ]
+                        ]
+}`
+
+func TestUnmarshallUser(t *testing.T) {
+	u, err := unmarshallUser([]byte(userDocument), []string{"otherTelephone", "thumbnailPhoto", "userCertificate", "mail"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.SID.Value != "S-1-5-21-3623811015-3361044348-30300820-1105" {
+		t.Errorf("unexpected SID %q", u.SID.Value)
+	}
+	if u.Username != "jdoe" || u.Domain != "contoso.com" || u.Container != "OU=Users,DC=contoso,DC=com" {
+		t.Errorf("unexpected username %q, domain %q or container %q", u.Username, u.Domain, u.Container)
+	}
+	if !u.Enabled || !u.PasswordNeverExpires || u.CannotChangePassword {
+		t.Errorf("unexpected flags decoded from the user account control %d", u.UserAccountControl)
+	}
+
+	expected := map[string]interface{}{
+		"otherTelephone": []interface{}{"555-0100", "555-0101"},
+		"thumbnailPhoto": []interface{}{255.0, 216.0, 255.0},
+		"userCertificate": []interface{}{
+			[]interface{}{48.0, 130.0},
+			[]interface{}{48.0, 131.0},
+		},
+	}
+	if !reflect.DeepEqual(u.CustomAttributes, expected) {
+		t.Errorf("unexpected custom attributes:\nactual: %#v\nexpected: %#v", u.CustomAttributes, expected)
+	}
+}
@@ -1,54 +1,72 @@
 package ad
 
 import (
+	"context"
 	"fmt"
-	"runtime"
 	"strings"
+	"sync"
 
 	"github.com/hashicorp/terraform-provider-ad/ad/internal/config"
+	"github.com/hashicorp/terraform-provider-ad/ad/internal/redact"
+	"github.com/hashicorp/terraform-provider-ad/ad/internal/winrmhelper"
 
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/structure"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
 )
 
+// providerConfs holds the configurations of the providers configured by this process, for their pooled
+// clients to be closed when it stops.
+var providerConfs struct {
+	sync.Mutex
+	confs []*config.ProviderConf
+}
+
+// CloseProviders closes the pooled clients of the configured providers. It is called once the plugin
+// server stops, so that the remote powershell sessions do not outlive the provider.
+func CloseProviders() {
+	providerConfs.Lock()
+	confs := providerConfs.confs
+	providerConfs.confs = nil
+	providerConfs.Unlock()
+	for _, pcfg := range confs {
+		pcfg.Close()
+	}
+}
+
 // Provider exports the provider schema
 func Provider() *schema.Provider {
-	return &schema.Provider{
+	provider := &schema.Provider{
 		Schema: map[string]*schema.Schema{
 			"winrm_username": {
 				Type:        schema.TypeString,
-				Required:    true,
-				DefaultFunc: schema.EnvDefaultFunc("AD_USER", nil),
-				Description: "The username used to authenticate to the server's WinRM service. (Environment variable: AD_USER)",
-				//lintignore: V013
-				ValidateFunc: func(val interface{}, key string) (warns []string, errs []error) {
-					v := val.(string)
-					os := runtime.GOOS
-					if v == "" && os != "windows" {
-						errs = append(errs, fmt.Errorf("%q is allowed to be empty only if terraform runs on windows, (current os: %q) ", key, os))
-					}
-					return
-				},
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("AD_USER", ""),
+				Description: "The username used to authenticate to the server's WinRM service. It can only be empty if terraform runs on windows or if `krb_ccache` is set. (Environment variable: AD_USER)",
 			},
 			"winrm_password": {
 				Type:        schema.TypeString,
-				Required:    true,
-				DefaultFunc: schema.EnvDefaultFunc("AD_PASSWORD", nil),
-				Description: "The password used to authenticate to the server's WinRM service. (Environment variable: AD_PASSWORD)",
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("AD_PASSWORD", ""),
+				Sensitive:   true,
+				Description: "The password used to authenticate to the server's WinRM service. It is not needed when `krb_keytab` or `krb_ccache` is set. (Environment variable: AD_PASSWORD)",
 			},
 			"winrm_hostname": {
 				Type:        schema.TypeString,
-				Required:    true,
-				DefaultFunc: schema.EnvDefaultFunc("AD_HOSTNAME", nil),
-				Description: "The hostname of the server we will use to run powershell scripts over WinRM. (Environment variable: AD_HOSTNAME)",
-				//lintignore: V013
-				ValidateFunc: func(val interface{}, key string) (warns []string, errs []error) {
-					v := val.(string)
-					os := runtime.GOOS
-					if v == "" && os != "windows" {
-						errs = append(errs, fmt.Errorf("%q is allowed to be empty only if terraform runs on windows, (current os: %q) ", key, os))
-					}
-					return
-				},
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("AD_HOSTNAME", ""),
+				Description: "The hostname of the server we will use to run powershell scripts over WinRM. Either `winrm_hostname` or `winrm_hostnames` must be set, unless terraform runs on windows. Conflicts with `winrm_hostnames`. (Environment variable: AD_HOSTNAME)",
+				// Missing hosts are reported by NewConfig, once AD_HOSTNAME is applied and only if terraform
+				// does not run on windows. The conflict is declared on winrm_hostnames only, as the default
+				// of winrm_hostname counts as a value here.
+			},
+			"winrm_hostnames": {
+				Type:          schema.TypeList,
+				Optional:      true,
+				Elem:          &schema.Schema{Type: schema.TypeString},
+				ConflictsWith: []string{"winrm_hostname"},
+				Description:   "The hostnames of the servers we will use to run powershell scripts over WinRM, in order of preference. The first reachable one is used and the next ones are failed over to when it goes down. Conflicts with `winrm_hostname`, only one of them can be set.",
 			},
 			"winrm_port": {
 				Type:        schema.TypeInt,
@@ -68,6 +86,25 @@ func Provider() *schema.Provider {
 				DefaultFunc: schema.EnvDefaultFunc("AD_WINRM_INSECURE", false),
 				Description: "Trust unknown certificates. (default: false, environment variable: AD_WINRM_INSECURE)",
 			},
+			"winrm_ca_cert": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("AD_WINRM_CA_CERT", ""),
+				Description: "The CA certificates the certificate of the WinRM server is verified against, as a path to a PEM file or as PEM content. (default: system certificates, environment variable: AD_WINRM_CA_CERT)",
+			},
+			"winrm_client_cert": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("AD_WINRM_CLIENT_CERT", ""),
+				Description: "The certificate used to authenticate to the WinRM service over HTTPS instead of a username and password, as a path to a PEM file or as PEM content. Requires `winrm_client_key`. (default: none, environment variable: AD_WINRM_CLIENT_CERT)",
+			},
+			"winrm_client_key": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("AD_WINRM_CLIENT_KEY", ""),
+				Sensitive:   true,
+				Description: "The private key of `winrm_client_cert`, as a path to a PEM file or as PEM content. (default: none, environment variable: AD_WINRM_CLIENT_KEY)",
+			},
 			"krb_realm": {
 				Type:        schema.TypeString,
 				Optional:    true,
@@ -92,24 +129,231 @@ func Provider() *schema.Provider {
 				DefaultFunc: schema.EnvDefaultFunc("AD_KRB_KEYTAB", ""),
 				Description: "Path to a keytab file to be used instead of a password",
 			},
+			"krb_ccache": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("AD_KRB_CCACHE", ""),
+				Description: "Path to a kerberos credentials cache, such as the one populated by `kinit`, to be used instead of a password or a keytab. `krb_realm` defaults to the realm of the cached tickets. (default: none, environment variable: AD_KRB_CCACHE)",
+			},
 			"winrm_use_ntlm": {
 				Type:        schema.TypeBool,
 				Optional:    true,
 				DefaultFunc: schema.EnvDefaultFunc("AD_WINRM_USE_NTLM", false),
 				Description: "Use NTLM authentication. (default: false, environment variable: AD_WINRM_USE_NTLM)",
 			},
+			"winrm_message_encryption": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("AD_WINRM_MESSAGE_ENCRYPTION", false),
+				Description: "Encrypt the WinRM messages sent over HTTP with the Kerberos or NTLM session keys. Requires `krb_realm` or `winrm_use_ntlm`. (default: false, environment variable: AD_WINRM_MESSAGE_ENCRYPTION)",
+			},
 			"winrm_pass_credentials": {
 				Type:        schema.TypeBool,
 				Optional:    true,
 				DefaultFunc: schema.EnvDefaultFunc("AD_WINRM_PASS_CREDENTIALS", false),
 				Description: "Pass credentials in WinRM session to create a System.Management.Automation.PSCredential. (default: false, environment variable: AD_WINRM_PASS_CREDENTIALS)",
 			},
+			"winrm_persistent_session": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("AD_WINRM_PERSISTENT_SESSION", false),
+				Description: "Run powershell commands in long lived remote sessions that have the ActiveDirectory and GroupPolicy modules already loaded, instead of starting a new powershell process for every command. (default: false, environment variable: AD_WINRM_PERSISTENT_SESSION)",
+			},
+			"winrm_transport": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				DefaultFunc:  schema.EnvDefaultFunc("AD_WINRM_TRANSPORT", config.TransportWinRS),
+				Description:  "How powershell commands are run over WinRM. `winrs` starts a powershell process for every command, `psrp` runs them in remote runspaces using the PowerShell Remoting Protocol. (default: winrs, environment variable: AD_WINRM_TRANSPORT)",
+				ValidateFunc: validation.StringInSlice([]string{config.TransportWinRS, config.TransportPSRP}, true),
+			},
+			"winrm_configuration_name": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("AD_WINRM_CONFIGURATION_NAME", ""),
+				Description: "The PowerShell session configuration to connect to, such as a Just Enough Administration endpoint. Commands are then built without the language features restricted endpoints forbid. Requires `winrm_transport` to be `psrp`. (default: Microsoft.PowerShell, environment variable: AD_WINRM_CONFIGURATION_NAME)",
+			},
+			"batch_window": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				DefaultFunc:  schema.EnvDefaultFunc("AD_BATCH_WINDOW", 0),
+				Description:  "How many milliseconds the commands creating and deleting users, groups and computers wait for other ones, to be run together as a single script. 0 disables batching. (default: 0, environment variable: AD_BATCH_WINDOW)",
+				ValidateFunc: validation.IntAtLeast(0),
+			},
+			"connection_type": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				DefaultFunc:  schema.EnvDefaultFunc("AD_CONNECTION_TYPE", config.ConnectionTypeWinRM),
+				Description:  "How the provider connects to `winrm_hostname`. `winrm` uses the WinRM service, `ssh` runs powershell commands through OpenSSH and uploads files with SFTP. (default: winrm, environment variable: AD_CONNECTION_TYPE)",
+				ValidateFunc: validation.StringInSlice([]string{config.ConnectionTypeWinRM, config.ConnectionTypeSSH}, true),
+			},
+			"ssh_port": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("AD_SSH_PORT", 22),
+				Description: "The port of the OpenSSH server. (default: 22, environment variable: AD_SSH_PORT)",
+			},
+			"ssh_private_key": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Sensitive:   true,
+				DefaultFunc: schema.EnvDefaultFunc("AD_SSH_PRIVATE_KEY", ""),
+				Description: "The private key used to authenticate over SSH, as a path to a file or as the key itself. `winrm_password` is tried after it if set. (default: none, environment variable: AD_SSH_PRIVATE_KEY)",
+			},
+			"ssh_private_key_passphrase": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Sensitive:   true,
+				DefaultFunc: schema.EnvDefaultFunc("AD_SSH_PRIVATE_KEY_PASSPHRASE", ""),
+				Description: "The passphrase of `ssh_private_key`. (default: none, environment variable: AD_SSH_PRIVATE_KEY_PASSPHRASE)",
+			},
+			"ssh_known_hosts": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("AD_SSH_KNOWN_HOSTS", ""),
+				Description: "The known hosts file the host keys of the servers are verified against. (default: ~/.ssh/known_hosts, environment variable: AD_SSH_KNOWN_HOSTS)",
+			},
+			"ssh_insecure": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("AD_SSH_INSECURE", false),
+				Description: "Accept any host key. (default: false, environment variable: AD_SSH_INSECURE)",
+			},
 			"domain_controller": {
 				Type:        schema.TypeString,
 				Optional:    true,
 				DefaultFunc: schema.EnvDefaultFunc("AD_DC", ""),
 				Description: "Use a specific domain controller. (default: none, environment variable: AD_DC)",
 			},
+			"domain_controllers": {
+				Type:          schema.TypeList,
+				Optional:      true,
+				Elem:          &schema.Schema{Type: schema.TypeString},
+				ConflictsWith: []string{"domain_controller"},
+				Description:   "The domain controllers to use, in order of preference. The first reachable one is used and the next ones are failed over to when it goes down. Conflicts with `domain_controller`, only one of them can be set.",
+			},
+			"domain_controller_discovery": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("AD_DC_DISCOVERY", false),
+				Description: "Discover the domain controllers of `krb_realm` from the `_ldap._tcp.dc._msdcs` DNS SRV records when neither `domain_controller` nor `domain_controllers` is set. (default: false, environment variable: AD_DC_DISCOVERY)",
+			},
+			"domain_controller_site": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("AD_DC_SITE", ""),
+				Description: "The Active Directory site whose domain controllers are preferred by `domain_controller_discovery`. When it is not set, the site of the host terraform runs on is asked to the discovered domain controllers with a netlogon ping. (default: none, environment variable: AD_DC_SITE)",
+			},
+			"backend": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				DefaultFunc:  schema.EnvDefaultFunc("AD_BACKEND", config.BackendWinRM),
+				Description:  "The backend used to manage users, groups, group memberships, computers and OUs. Can be `winrm` or `ldap`. GPO resources always use WinRM. (default: winrm, environment variable: AD_BACKEND)",
+				ValidateFunc: validation.StringInSlice([]string{config.BackendWinRM, config.BackendLDAP}, true),
+			},
+			"ldap_proto": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				DefaultFunc:  schema.EnvDefaultFunc("AD_LDAP_PROTO", "ldaps"),
+				Description:  "The LDAP protocol we will use when `backend` is `ldap`. Setting passwords requires `ldaps`. (default: ldaps, environment variable: AD_LDAP_PROTO)",
+				ValidateFunc: validation.StringInSlice([]string{"ldap", "ldaps"}, true),
+			},
+			"ldap_port": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("AD_LDAP_PORT", 0),
+				Description: "The port LDAP is listening for connections. (default: 636 for ldaps, 389 for ldap, environment variable: AD_LDAP_PORT)",
+			},
+			"ldap_insecure": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("AD_LDAP_INSECURE", false),
+				Description: "Trust unknown certificates when connecting over LDAPS. (default: false, environment variable: AD_LDAP_INSECURE)",
+			},
+			"json_depth": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				DefaultFunc:  schema.EnvDefaultFunc("AD_JSON_DEPTH", 5),
+				Description:  "How many levels of nested properties are serialized in the output of powershell commands. Deeper properties are returned as the name of their type. (default: 5, environment variable: AD_JSON_DEPTH)",
+				ValidateFunc: validation.IntBetween(1, 100),
+			},
+			"object_cache": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("AD_OBJECT_CACHE", false),
+				Description: "Retrieve the users, groups and computers of a container with a single query the first time one of them is read, and serve the next reads from a cache. Objects modified by the provider are retrieved again. (default: false, environment variable: AD_OBJECT_CACHE)",
+			},
+			"preflight": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("AD_PREFLIGHT", false),
+				Description: "Check the connection, the ActiveDirectory and GroupPolicy modules, the domain and the access to SYSVOL when the provider is configured, and report how to fix the problems found. (default: false, environment variable: AD_PREFLIGHT)",
+			},
+			"max_concurrent_operations": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				DefaultFunc:  schema.EnvDefaultFunc("AD_MAX_CONCURRENT_OPERATIONS", 0),
+				Description:  "The maximum number of powershell commands and LDAP operations run at the same time, and of files uploaded at the same time. Other operations wait for one of them to complete. Keep it below the MaxShellsPerUser quota of WinRM. 0 means no limit. (default: 0, environment variable: AD_MAX_CONCURRENT_OPERATIONS)",
+				ValidateFunc: validation.IntAtLeast(0),
+			},
+			"max_pool_size": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				DefaultFunc:  schema.EnvDefaultFunc("AD_MAX_POOL_SIZE", 10),
+				Description:  "The maximum number of idle clients kept for reuse in each pool. Idle clients are also closed after 5 minutes. 0 means no limit. (default: 10, environment variable: AD_MAX_POOL_SIZE)",
+				ValidateFunc: validation.IntAtLeast(0),
+			},
+			"max_retries": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				DefaultFunc:  schema.EnvDefaultFunc("AD_MAX_RETRIES", 3),
+				Description:  "How many times a powershell command that failed because of a transient error is retried. (default: 3, environment variable: AD_MAX_RETRIES)",
+				ValidateFunc: validation.IntAtLeast(0),
+			},
+			"retry_min_backoff": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				DefaultFunc:  schema.EnvDefaultFunc("AD_RETRY_MIN_BACKOFF", 1),
+				Description:  "How many seconds to wait before the first retry. The delay doubles with every attempt. (default: 1, environment variable: AD_RETRY_MIN_BACKOFF)",
+				ValidateFunc: validation.IntAtLeast(0),
+			},
+			"retry_max_backoff": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				DefaultFunc:  schema.EnvDefaultFunc("AD_RETRY_MAX_BACKOFF", 30),
+				Description:  "The maximum number of seconds to wait between two retries. (default: 30, environment variable: AD_RETRY_MAX_BACKOFF)",
+				ValidateFunc: validation.IntAtLeast(0),
+			},
+			"sensitive_custom_attributes": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Description: "The names of the custom attributes whose values are secrets. Their values are redacted from logs and error messages, like the values of sensitive arguments.",
+			},
+			"credential_profile": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Description: "Identities resources can pass to the cmdlets instead of `winrm_username`, by setting their `credential_profile` argument. The connection to the server is still authenticated as `winrm_username`, LDAP connections are bound as the profile. Requires `winrm_proto` to be https, `winrm_message_encryption` or `connection_type` to be ssh.",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"name": {
+							Type:        schema.TypeString,
+							Required:    true,
+							Description: "The name resources refer to the profile with.",
+						},
+						"username": {
+							Type:        schema.TypeString,
+							Required:    true,
+							Description: "The username passed to the cmdlets.",
+						},
+						"password": {
+							Type:        schema.TypeString,
+							Required:    true,
+							Sensitive:   true,
+							Description: "The password of `username`.",
+						},
+					},
+				},
+			},
 		},
 		DataSourcesMap: map[string]*schema.Resource{
 			"ad_user":     dataSourceADUser(),
@@ -117,28 +361,155 @@ func Provider() *schema.Provider {
 			"ad_gpo":      dataSourceADGPO(),
 			"ad_computer": dataSourceADComputer(),
 			"ad_ou":       dataSourceADOU(),
+			"ad_contact":  dataSourceADContact(),
 		},
 		ResourcesMap: map[string]*schema.Resource{
-			"ad_user":             resourceADUser(),
-			"ad_group":            resourceADGroup(),
-			"ad_group_membership": resourceADGroupMembership(),
-			"ad_gpo":              resourceADGPO(),
-			"ad_gpo_security":     resourceADGPOSecurity(),
-			"ad_computer":         resourceADComputer(),
-			"ad_ou":               resourceADOU(),
-			"ad_gplink":           resourceADGPLink(),
+			"ad_user":                           resourceADUser(),
+			"ad_group":                          resourceADGroup(),
+			"ad_group_membership":               resourceADGroupMembership(),
+			"ad_gpo":                            resourceADGPO(),
+			"ad_gpo_security":                   resourceADGPOSecurity(),
+			"ad_computer":                       resourceADComputer(),
+			"ad_ou":                             resourceADOU(),
+			"ad_gplink":                         resourceADGPLink(),
+			"ad_managed_service_account":        resourceADManagedServiceAccount(),
+			"ad_kds_root_key":                   resourceADKdsRootKey(),
+			"ad_fine_grained_password_policy":   resourceADFineGrainedPasswordPolicy(),
+			"ad_default_domain_password_policy": resourceADDefaultDomainPasswordPolicy(),
+			"ad_contact":                        resourceADContact(),
 		},
-		ConfigureFunc: initProviderConfig,
+		ConfigureContextFunc: initProviderConfig,
+	}
+
+	for _, r := range provider.DataSourcesMap {
+		redactSensitiveValues(r)
+		useCredentialProfile(r)
+	}
+	for _, r := range provider.ResourcesMap {
+		redactSensitiveValues(r)
+		useCredentialProfile(r)
 	}
+	return provider
 }
 
-func initProviderConfig(d *schema.ResourceData) (interface{}, error) {
+func initProviderConfig(ctx context.Context, d *schema.ResourceData) (interface{}, diag.Diagnostics) {
+	redact.FilterLog()
 	cfg, err := config.NewConfig(d)
 	if err != nil {
-		return nil, err
+		return nil, diag.FromErr(err)
 	}
 	pcfg := config.NewProviderConf(cfg)
-	return pcfg, nil
+	providerConfs.Lock()
+	providerConfs.confs = append(providerConfs.confs, pcfg)
+	providerConfs.Unlock()
+	if !cfg.Preflight {
+		return pcfg, nil
+	}
+
+	var diags diag.Diagnostics
+	fatal := false
+	for _, issue := range winrmhelper.Preflight(ctx, pcfg) {
+		severity := diag.Warning
+		if issue.Fatal {
+			severity = diag.Error
+			fatal = true
+		}
+		diags = append(diags, diag.Diagnostic{Severity: severity, Summary: issue.Summary, Detail: redact.String(issue.Detail)})
+	}
+	if fatal {
+		return nil, diags
+	}
+	return pcfg, diags
+}
+
+// redactSensitiveValues wraps the CRUD functions of r so that the values of its sensitive arguments, and of
+// the custom attributes listed in sensitive_custom_attributes, are registered for redaction before they
+// can reach a command, a log line or an error message.
+func redactSensitiveValues(r *schema.Resource) {
+	wrap := func(f func(context.Context, *schema.ResourceData, interface{}) diag.Diagnostics) func(context.Context, *schema.ResourceData, interface{}) diag.Diagnostics {
+		if f == nil {
+			return nil
+		}
+		return func(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+			for name, s := range r.Schema {
+				if s.Sensitive && s.Type == schema.TypeString {
+					redact.Register(d.Get(name).(string))
+				}
+			}
+			if pcfg, ok := meta.(*config.ProviderConf); ok && r.Schema["custom_attributes"] != nil {
+				registerSensitiveCustomAttributes(d.Get("custom_attributes").(string), pcfg.Settings.SensitiveCustomAttributes)
+			}
+			return f(ctx, d, meta)
+		}
+	}
+	r.CreateContext = wrap(r.CreateContext)
+	r.ReadContext = wrap(r.ReadContext)
+	r.UpdateContext = wrap(r.UpdateContext)
+	r.DeleteContext = wrap(r.DeleteContext)
+}
+
+// useCredentialProfile adds the credential_profile argument to r, and wraps its CRUD functions so that they
+// pass the credentials of the profile to the cmdlets.
+func useCredentialProfile(r *schema.Resource) {
+	r.Schema["credential_profile"] = &schema.Schema{
+		Type:        schema.TypeString,
+		Optional:    true,
+		Description: "The name of the `credential_profile` of the provider whose credentials are passed to the cmdlets, instead of the ones of the provider.",
+	}
+	if r.UpdateContext == nil {
+		// Every argument of the resources that cannot be updated forces a new resource.
+		r.Schema["credential_profile"].ForceNew = true
+	}
+	wrap := func(f func(context.Context, *schema.ResourceData, interface{}) diag.Diagnostics) func(context.Context, *schema.ResourceData, interface{}) diag.Diagnostics {
+		if f == nil {
+			return nil
+		}
+		return func(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+			if pcfg, ok := meta.(*config.ProviderConf); ok {
+				conf, err := pcfg.WithCredentialProfile(d.Get("credential_profile").(string))
+				if err != nil {
+					return diag.FromErr(err)
+				}
+				meta = conf
+			}
+			return f(ctx, d, meta)
+		}
+	}
+	r.CreateContext = wrap(r.CreateContext)
+	r.ReadContext = wrap(r.ReadContext)
+	r.UpdateContext = wrap(r.UpdateContext)
+	r.DeleteContext = wrap(r.DeleteContext)
+}
+
+// registerSensitiveCustomAttributes registers the values of the sensitive attributes found in the JSON
+// encoded custom attributes ca.
+func registerSensitiveCustomAttributes(ca string, sensitive []string) {
+	if ca == "" || len(sensitive) == 0 {
+		return
+	}
+	attributes, err := structure.ExpandJsonFromString(ca)
+	if err != nil {
+		return
+	}
+	for name, value := range attributes {
+		isSensitive := false
+		for _, s := range sensitive {
+			if strings.EqualFold(name, s) {
+				isSensitive = true
+				break
+			}
+		}
+		if !isSensitive {
+			continue
+		}
+		values, ok := value.([]interface{})
+		if !ok {
+			values = []interface{}{value}
+		}
+		for _, v := range values {
+			redact.Register(fmt.Sprintf("%v", v))
+		}
+	}
 }
 
 func suppressCaseDiff(k, old, new string, d *schema.ResourceData) bool {
@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
 )
 
 var testAccProviders map[string]*schema.Provider
@@ -24,3 +25,34 @@ func testAccPreCheck(t *testing.T, envVars []string) {
 		}
 	}
 }
+
+func TestProvider(t *testing.T) {
+	if err := Provider().InternalValidate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProviderValidateHostnames(t *testing.T) {
+	t.Setenv("AD_HOSTNAME", "")
+	cases := []struct {
+		name   string
+		raw    map[string]interface{}
+		errors bool
+	}{
+		{"hostnames only", map[string]interface{}{"winrm_hostnames": []interface{}{"dc1", "dc2"}}, false},
+		{"hostname only", map[string]interface{}{"winrm_hostname": "dc1"}, false},
+		{"both", map[string]interface{}{"winrm_hostname": "dc1", "winrm_hostnames": []interface{}{"dc2"}}, true},
+	}
+	for _, c := range cases {
+		diags := Provider().Validate(terraform.NewResourceConfigRaw(c.raw))
+		if diags.HasError() != c.errors {
+			t.Errorf("%s: expected errors %t, got %v", c.name, c.errors, diags)
+		}
+	}
+
+	// The hostname can come from the environment only, the missing hosts are reported by NewConfig.
+	t.Setenv("AD_HOSTNAME", "dc1")
+	if diags := Provider().Validate(terraform.NewResourceConfigRaw(map[string]interface{}{})); diags.HasError() {
+		t.Errorf("expected the hostname of the environment to be accepted, got %v", diags)
+	}
+}
@@ -1,11 +1,13 @@
 package ad
 
 import (
-	"fmt"
-	"strings"
+	"context"
+	"errors"
+	"time"
 
 	"github.com/hashicorp/terraform-provider-ad/ad/internal/config"
 
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"github.com/hashicorp/terraform-provider-ad/ad/internal/winrmhelper"
 )
@@ -16,10 +18,16 @@ func resourceADComputer() *schema.Resource {
 		Importer: &schema.ResourceImporter{
 			StateContext: schema.ImportStatePassthroughContext,
 		},
-		Read:   resourceADComputerRead,
-		Create: resourceADComputerCreate,
-		Update: resourceADComputerUpdate,
-		Delete: resourceADComputerDelete,
+		ReadContext:   resourceADComputerRead,
+		CreateContext: resourceADComputerCreate,
+		UpdateContext: resourceADComputerUpdate,
+		DeleteContext: resourceADComputerDelete,
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(5 * time.Minute),
+			Read:   schema.DefaultTimeout(5 * time.Minute),
+			Update: schema.DefaultTimeout(5 * time.Minute),
+			Delete: schema.DefaultTimeout(5 * time.Minute),
+		},
 		Schema: map[string]*schema.Schema{
 			"name": {
 				Type:             schema.TypeString,
@@ -63,19 +71,20 @@ func resourceADComputer() *schema.Resource {
 	}
 }
 
-func resourceADComputerRead(d *schema.ResourceData, meta interface{}) error {
+func resourceADComputerRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	if d.Id() == "" {
 		return nil
 	}
 
-	computer, err := winrmhelper.NewComputerFromHost(meta.(*config.ProviderConf), d.Id())
+	winrmhelper.PrefetchComputers(ctx, meta.(*config.ProviderConf), d.Get("container").(string))
+	computer, err := winrmhelper.NewComputerFromHost(ctx, meta.(*config.ProviderConf), d.Id())
 	if err != nil {
-		if strings.Contains(err.Error(), "ObjectNotFound") {
+		if errors.Is(err, winrmhelper.ErrNotFound) {
 			// Resource no longer exists
 			d.SetId("")
 			return nil
 		}
-		return fmt.Errorf("error while reading computer with GUID %q: %s", d.Id(), err)
+		return diag.Errorf("error while reading computer with GUID %q: %s", d.Id(), err)
 	}
 	_ = d.Set("name", computer.Name)
 	_ = d.Set("dn", computer.DN)
@@ -88,17 +97,17 @@ func resourceADComputerRead(d *schema.ResourceData, meta interface{}) error {
 	return nil
 }
 
-func resourceADComputerCreate(d *schema.ResourceData, meta interface{}) error {
+func resourceADComputerCreate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	computer := winrmhelper.NewComputerFromResource(d)
-	guid, err := computer.Create(meta.(*config.ProviderConf))
+	guid, err := computer.Create(ctx, meta.(*config.ProviderConf))
 	if err != nil {
-		return fmt.Errorf("error while creating new computer object: %s", err)
+		return diag.Errorf("error while creating new computer object: %s", err)
 	}
 	d.SetId(guid)
-	return resourceADComputerRead(d, meta)
+	return resourceADComputerRead(ctx, d, meta)
 }
 
-func resourceADComputerUpdate(d *schema.ResourceData, meta interface{}) error {
+func resourceADComputerUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	computer := winrmhelper.NewComputerFromResource(d)
 	keys := []string{"container", "description"}
 	changes := make(map[string]interface{})
@@ -108,21 +117,21 @@ func resourceADComputerUpdate(d *schema.ResourceData, meta interface{}) error {
 		}
 	}
 
-	err := computer.Update(meta.(*config.ProviderConf), changes)
+	err := computer.Update(ctx, meta.(*config.ProviderConf), changes)
 	if err != nil {
-		return fmt.Errorf("error while updating computer with id %q: %s", d.Id(), err)
+		return diag.Errorf("error while updating computer with id %q: %s", d.Id(), err)
 	}
-	return resourceADComputerRead(d, meta)
+	return resourceADComputerRead(ctx, d, meta)
 }
 
-func resourceADComputerDelete(d *schema.ResourceData, meta interface{}) error {
+func resourceADComputerDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	if d.Id() == "" {
 		return nil
 	}
 	computer := winrmhelper.NewComputerFromResource(d)
-	err := computer.Delete(meta.(*config.ProviderConf))
+	err := computer.Delete(ctx, meta.(*config.ProviderConf))
 	if err != nil {
-		return fmt.Errorf("error while deleting a computer object with id %q: %s", d.Id(), err)
+		return diag.Errorf("error while deleting a computer object with id %q: %s", d.Id(), err)
 	}
 
 	return nil
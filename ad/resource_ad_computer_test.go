@@ -1,9 +1,10 @@
 package ad
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
-	"strings"
 	"testing"
 
 	"github.com/hashicorp/terraform-provider-ad/ad/internal/config"
@@ -154,9 +155,9 @@ func testAccResourceADComputerExists(resource, name string, expected bool) resou
 		}
 
 		guid := rs.Primary.ID
-		computer, err := winrmhelper.NewComputerFromHost(testAccProvider.Meta().(*config.ProviderConf), guid)
+		computer, err := winrmhelper.NewComputerFromHost(context.Background(), testAccProvider.Meta().(*config.ProviderConf), guid)
 		if err != nil {
-			if strings.Contains(err.Error(), "ObjectNotFound") && !expected {
+			if errors.Is(err, winrmhelper.ErrNotFound) && !expected {
 				return nil
 			}
 			return err
@@ -177,9 +178,9 @@ func testAccResourceADComputerDescriptionExists(resource, description string, ex
 		}
 
 		guid := rs.Primary.ID
-		computer, err := winrmhelper.NewComputerFromHost(testAccProvider.Meta().(*config.ProviderConf), guid)
+		computer, err := winrmhelper.NewComputerFromHost(context.Background(), testAccProvider.Meta().(*config.ProviderConf), guid)
 		if err != nil {
-			if strings.Contains(err.Error(), "ObjectNotFound") && !expected {
+			if errors.Is(err, winrmhelper.ErrNotFound) && !expected {
 				return nil
 			}
 			return err
@@ -0,0 +1,250 @@
+package ad
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/hashicorp/terraform-provider-ad/ad/internal/config"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/structure"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+	"github.com/hashicorp/terraform-provider-ad/ad/internal/winrmhelper"
+)
+
+func resourceADContact() *schema.Resource {
+	return &schema.Resource{
+		Description:   "`ad_contact` manages contact objects in an Active Directory tree. Contacts hold the details of people outside of the organization, and can be members of distribution groups.",
+		CreateContext: resourceADContactCreate,
+		ReadContext:   resourceADContactRead,
+		UpdateContext: resourceADContactUpdate,
+		DeleteContext: resourceADContactDelete,
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(5 * time.Minute),
+			Read:   schema.DefaultTimeout(5 * time.Minute),
+			Update: schema.DefaultTimeout(5 * time.Minute),
+			Delete: schema.DefaultTimeout(5 * time.Minute),
+		},
+		Importer: &schema.ResourceImporter{
+			StateContext: schema.ImportStatePassthroughContext,
+		},
+		Schema: map[string]*schema.Schema{
+			"name": {
+				Type:        schema.TypeString,
+				Required:    true,
+				Description: "The name of the contact object.",
+			},
+			"container": {
+				Type:             schema.TypeString,
+				Required:         true,
+				DiffSuppressFunc: suppressCaseDiff,
+				Description:      "A DN of the container object that will be holding the contact.",
+			},
+			"display_name": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "The display name of the contact.",
+			},
+			"given_name": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "The given name of the contact.",
+			},
+			"surname": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "The surname of the contact.",
+			},
+			"initials": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "The initials of the contact.",
+			},
+			"description": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "The description of the contact.",
+			},
+			"email_address": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "The email address of the contact.",
+			},
+			"office_phone": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "The office phone number of the contact.",
+			},
+			"mobile_phone": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "The mobile phone number of the contact.",
+			},
+			"home_phone": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "The home phone number of the contact.",
+			},
+			"fax": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "The fax number of the contact.",
+			},
+			"street_address": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "The street address of the contact.",
+			},
+			"city": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "The city of the contact.",
+			},
+			"state": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "The state or province of the contact.",
+			},
+			"postal_code": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "The postal code of the contact.",
+			},
+			"country": {
+				Type:             schema.TypeString,
+				Optional:         true,
+				ValidateFunc:     validation.StringLenBetween(2, 2),
+				DiffSuppressFunc: suppressCaseDiff,
+				Description:      "The country of the contact, as a two-letter ISO 3166 code.",
+			},
+			"company": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "The company of the contact.",
+			},
+			"department": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "The department of the contact.",
+			},
+			"title": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "The job title of the contact.",
+			},
+			"office": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "The office of the contact.",
+			},
+			"custom_attributes": {
+				Type:             schema.TypeString,
+				Optional:         true,
+				Description:      "JSON encoded map that represents key/value pairs for custom attributes. Please note that `terraform import` will not import these attributes.",
+				ValidateFunc:     validation.StringIsJSON,
+				DiffSuppressFunc: suppressJsonDiff,
+			},
+			"dn": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The distinguished name of the contact object.",
+			},
+		},
+	}
+}
+
+func resourceADContactCreate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	c, err := winrmhelper.GetContactFromResource(d)
+	if err != nil {
+		return diag.Errorf("while building a Contact struct from resource data: %s", err)
+	}
+
+	guid, err := c.Create(ctx, meta.(*config.ProviderConf))
+	if err != nil {
+		return diag.Errorf("error while creating contact: %s", err)
+	}
+	d.SetId(guid)
+	return resourceADContactRead(ctx, d, meta)
+}
+
+func resourceADContactRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	log.Printf("Reading ad_contact resource for contact with guid: %q", d.Id())
+	caKeys, err := extractCustAttrKeys(d)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	c, err := winrmhelper.GetContactFromHost(ctx, meta.(*config.ProviderConf), d.Id(), caKeys)
+	if err != nil {
+		if errors.Is(err, winrmhelper.ErrNotFound) {
+			d.SetId("")
+			return nil
+		}
+		return diag.Errorf("error while reading contact with GUID %q: %s", d.Id(), err)
+	}
+	_ = d.Set("name", c.Name)
+	_ = d.Set("container", c.Container)
+	_ = d.Set("dn", c.DistinguishedName)
+	_ = d.Set("display_name", c.DisplayName)
+	_ = d.Set("given_name", c.GivenName)
+	_ = d.Set("surname", c.Surname)
+	_ = d.Set("initials", c.Initials)
+	_ = d.Set("description", c.Description)
+	_ = d.Set("email_address", c.EmailAddress)
+	_ = d.Set("office_phone", c.OfficePhone)
+	_ = d.Set("mobile_phone", c.MobilePhone)
+	_ = d.Set("home_phone", c.HomePhone)
+	_ = d.Set("fax", c.Fax)
+	_ = d.Set("street_address", c.StreetAddress)
+	_ = d.Set("city", c.City)
+	_ = d.Set("state", c.State)
+	_ = d.Set("postal_code", c.PostalCode)
+	_ = d.Set("country", c.Country)
+	_ = d.Set("company", c.Company)
+	_ = d.Set("department", c.Department)
+	_ = d.Set("title", c.Title)
+	_ = d.Set("office", c.Office)
+
+	if c.CustomAttributes != nil {
+		ca, err := structure.FlattenJsonToString(c.CustomAttributes)
+		if err != nil {
+			return diag.FromErr(err)
+		}
+		_ = d.Set("custom_attributes", ca)
+	}
+
+	return nil
+}
+
+func resourceADContactUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	c, err := winrmhelper.GetContactFromResource(d)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	err = c.Modify(ctx, d, meta.(*config.ProviderConf))
+	if err != nil {
+		return diag.Errorf("error while updating contact with GUID %q: %s", d.Id(), err)
+	}
+	return resourceADContactRead(ctx, d, meta)
+}
+
+func resourceADContactDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	if d.Id() == "" {
+		return nil
+	}
+	c, err := winrmhelper.GetContactFromResource(d)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	err = c.Delete(ctx, meta.(*config.ProviderConf))
+	if err != nil {
+		return diag.Errorf("error while deleting contact with GUID %q: %s", d.Id(), err)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package ad
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/hashicorp/terraform-provider-ad/ad/internal/config"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	"github.com/hashicorp/terraform-provider-ad/ad/internal/winrmhelper"
+)
+
+func TestAccResourceADContact_basic(t *testing.T) {
+	name := os.Getenv("TF_VAR_ad_contact_name")
+
+	envVars := []string{"TF_VAR_ad_contact_name", "TF_VAR_ad_contact_container"}
+	resource.Test(t, resource.TestCase{
+		PreCheck:  func() { testAccPreCheck(t, envVars) },
+		Providers: testAccProviders,
+		CheckDestroy: resource.ComposeTestCheckFunc(
+			testAccResourceADContactExists("ad_contact.c", name, false),
+		),
+		Steps: []resource.TestStep{
+			{
+				Config: testAccResourceADContactConfigBasic(name, "vendor@example.com"),
+				Check: resource.ComposeTestCheckFunc(
+					testAccResourceADContactExists("ad_contact.c", name, true),
+					resource.TestCheckResourceAttr("ad_contact.c", "email_address", "vendor@example.com"),
+				),
+			},
+			{
+				ResourceName:            "ad_contact.c",
+				ImportState:             true,
+				ImportStateVerify:       true,
+				ImportStateVerifyIgnore: []string{"custom_attributes"},
+			},
+			{
+				Config: testAccResourceADContactConfigBasic(name+"-renamed", "sales@example.com"),
+				Check: resource.ComposeTestCheckFunc(
+					testAccResourceADContactExists("ad_contact.c", name+"-renamed", true),
+					resource.TestCheckResourceAttr("ad_contact.c", "email_address", "sales@example.com"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccResourceADContact_move(t *testing.T) {
+	name := os.Getenv("TF_VAR_ad_contact_name")
+
+	envVars := []string{"TF_VAR_ad_contact_name", "TF_VAR_ad_contact_container"}
+	resource.Test(t, resource.TestCase{
+		PreCheck:  func() { testAccPreCheck(t, envVars) },
+		Providers: testAccProviders,
+		CheckDestroy: resource.ComposeTestCheckFunc(
+			testAccResourceADContactExists("ad_contact.c", name, false),
+		),
+		Steps: []resource.TestStep{
+			{
+				Config: testAccResourceADContactConfigMove(false),
+				Check: resource.ComposeTestCheckFunc(
+					testAccResourceADContactExists("ad_contact.c", name, true),
+				),
+			},
+			{
+				Config: testAccResourceADContactConfigMove(true),
+				Check: resource.ComposeTestCheckFunc(
+					testAccResourceADContactExists("ad_contact.c", name, true),
+					resource.TestCheckResourceAttrPair("ad_contact.c", "container", "ad_ou.o", "dn"),
+				),
+			},
+		},
+	})
+}
+
+func testAccResourceADContactConfigBasic(name, mail string) string {
+	return fmt.Sprintf(`
+variable "ad_contact_container" {}
+
+resource "ad_contact" "c" {
+	name = %q
+	container = var.ad_contact_container
+	display_name = "Vendor, Contact"
+	email_address = %q
+	office_phone = "555-0100"
+	city = "Springfield"
+	country = "US"
+	custom_attributes = jsonencode({
+		"info": "Managed by terraform"
+	})
+}
+`, name, mail)
+}
+
+func testAccResourceADContactConfigMove(moved bool) string {
+	container := "var.ad_contact_container"
+	if moved {
+		container = "ad_ou.o.dn"
+	}
+	return fmt.Sprintf(`
+variable "ad_contact_name" {}
+variable "ad_contact_container" {}
+
+resource "ad_ou" "o" {
+	name = "tfacc-contacts"
+	path = var.ad_contact_container
+	protected = false
+}
+
+resource "ad_contact" "c" {
+	name = var.ad_contact_name
+	container = %s
+}
+`, container)
+}
+
+func testAccResourceADContactExists(resource, name string, expected bool) resource.TestCheckFunc {
+	return func(s *terraform.State) error {
+		rs, ok := s.RootModule().Resources[resource]
+		if !ok {
+			return fmt.Errorf("%s key not found in state", resource)
+		}
+
+		guid := rs.Primary.ID
+		c, err := winrmhelper.GetContactFromHost(context.Background(), testAccProvider.Meta().(*config.ProviderConf), guid, nil)
+		if err != nil {
+			if errors.Is(err, winrmhelper.ErrNotFound) && !expected {
+				return nil
+			}
+			return err
+		}
+
+		if c.Name != name {
+			return fmt.Errorf("contact name %q does not match expected name %q", c.Name, name)
+		}
+		return nil
+	}
+}
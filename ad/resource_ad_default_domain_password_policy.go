@@ -0,0 +1,132 @@
+package ad
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform-provider-ad/ad/internal/config"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-provider-ad/ad/internal/winrmhelper"
+)
+
+func resourceADDefaultDomainPasswordPolicy() *schema.Resource {
+	s := passwordPolicySchema()
+	// The settings left out of the configuration are left as they are in the domain.
+	for _, setting := range s {
+		setting.Default = nil
+		setting.Computed = true
+	}
+	s["domain"] = &schema.Schema{
+		Type:             schema.TypeString,
+		Optional:         true,
+		ForceNew:         true,
+		DiffSuppressFunc: suppressCaseDiff,
+		Description:      "The DNS name or the distinguished name of the domain. Defaults to the domain of the domain controller the provider connects to.",
+	}
+	s["dn"] = &schema.Schema{
+		Type:        schema.TypeString,
+		Computed:    true,
+		Description: "The distinguished name of the domain.",
+	}
+
+	return &schema.Resource{
+		Description: "`ad_default_domain_password_policy` manages the password and lockout policy of a domain, set on the head of the domain. " +
+			"There is a single policy per domain, destroying the resource leaves it unchanged, and the settings left out of the configuration are left as they are. " +
+			"The Default Domain Policy GPO overwrites the policy when it is applied to the domain controllers: the settings of the GPO of the domain of the provider that differ from the policy are reported as warnings.",
+		CreateContext: resourceADDefaultDomainPasswordPolicyCreate,
+		ReadContext:   resourceADDefaultDomainPasswordPolicyRead,
+		UpdateContext: resourceADDefaultDomainPasswordPolicyUpdate,
+		DeleteContext: resourceADDefaultDomainPasswordPolicyDelete,
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(5 * time.Minute),
+			Read:   schema.DefaultTimeout(5 * time.Minute),
+			Update: schema.DefaultTimeout(5 * time.Minute),
+			Delete: schema.DefaultTimeout(5 * time.Minute),
+		},
+		Importer: &schema.ResourceImporter{
+			StateContext: schema.ImportStatePassthroughContext,
+		},
+		Schema: s,
+	}
+}
+
+func resourceADDefaultDomainPasswordPolicyCreate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	conf := meta.(*config.ProviderConf)
+	p, err := winrmhelper.GetDefaultDomainPasswordPolicy(ctx, conf, d.Get("domain").(string))
+	if err != nil {
+		return diag.Errorf("error while reading default domain password policy: %s", err)
+	}
+	err = winrmhelper.SetDefaultDomainPasswordPolicy(ctx, conf, p.DistinguishedName, d, false)
+	if err != nil {
+		return diag.Errorf("error while setting default domain password policy of %q: %s", p.DistinguishedName, err)
+	}
+	d.SetId(p.DistinguishedName)
+	return resourceADDefaultDomainPasswordPolicyRead(ctx, d, meta)
+}
+
+func resourceADDefaultDomainPasswordPolicyRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	log.Printf("Reading ad_default_domain_password_policy resource for domain: %q", d.Id())
+	conf := meta.(*config.ProviderConf)
+	p, err := winrmhelper.GetDefaultDomainPasswordPolicy(ctx, conf, d.Id())
+	if err != nil {
+		return diag.Errorf("error while reading default domain password policy of %q: %s", d.Id(), err)
+	}
+	_ = d.Set("dn", p.DistinguishedName)
+	setPasswordPolicy(d, &p.PasswordPolicy)
+
+	return gpoPasswordPolicyDiagnostics(ctx, conf, d, &p.PasswordPolicy)
+}
+
+// gpoPasswordPolicyDiagnostics returns a warning for each setting of the Default Domain Policy GPO that
+// differs from p, since the GPO overwrites it when it is applied. Only the GPO of the domain of the
+// provider is read.
+func gpoPasswordPolicyDiagnostics(ctx context.Context, conf *config.ProviderConf, d *schema.ResourceData, p *winrmhelper.PasswordPolicy) diag.Diagnostics {
+	domainDN := "DC=" + strings.Join(strings.Split(conf.Settings.DomainName, "."), ",DC=")
+	if conf.Settings.DomainName == "" || !strings.EqualFold(d.Id(), domainDN) {
+		return nil
+	}
+	gpoSettings, err := winrmhelper.GetDefaultDomainPolicyGPOSettings(ctx, conf)
+	if err != nil {
+		log.Printf("[WARN] The password policy of %q was not compared to the Default Domain Policy GPO: %s", d.Id(), err)
+		return nil
+	}
+
+	keys := make([]string, 0, len(gpoSettings))
+	for k := range gpoSettings {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var diags diag.Diagnostics
+	settings := p.Settings()
+	for _, k := range keys {
+		if settings[k] == gpoSettings[k] {
+			continue
+		}
+		diags = append(diags, diag.Diagnostic{
+			Severity: diag.Warning,
+			Summary:  "The Default Domain Policy GPO overwrites the password policy",
+			Detail:   fmt.Sprintf("%s is %s in the Default Domain Policy GPO and %s on %s, the GPO value is set again when the GPO is applied to the domain controllers.", k, gpoSettings[k], settings[k], d.Id()),
+		})
+	}
+	return diags
+}
+
+func resourceADDefaultDomainPasswordPolicyUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	err := winrmhelper.SetDefaultDomainPasswordPolicy(ctx, meta.(*config.ProviderConf), d.Id(), d, true)
+	if err != nil {
+		return diag.Errorf("error while setting default domain password policy of %q: %s", d.Id(), err)
+	}
+	return resourceADDefaultDomainPasswordPolicyRead(ctx, d, meta)
+}
+
+func resourceADDefaultDomainPasswordPolicyDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	log.Printf("[WARN] the default domain password policy of %q is left unchanged, it is only removed from the state", d.Id())
+	return nil
+}
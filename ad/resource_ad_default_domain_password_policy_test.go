@@ -0,0 +1,73 @@
+package ad
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/terraform-provider-ad/ad/internal/config"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	"github.com/hashicorp/terraform-provider-ad/ad/internal/winrmhelper"
+)
+
+// This test changes the password policy of the domain and leaves it changed, run it against a lab forest.
+func TestAccResourceADDefaultDomainPasswordPolicy_basic(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:  func() { testAccPreCheck(t, []string{}) },
+		Providers: testAccProviders,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccResourceADDefaultDomainPasswordPolicyConfig(7),
+				Check: resource.ComposeTestCheckFunc(
+					testAccResourceADDefaultDomainPasswordPolicyLength("ad_default_domain_password_policy.p", 7),
+				),
+			},
+			{
+				Config: testAccResourceADDefaultDomainPasswordPolicyConfig(12),
+				Check: resource.ComposeTestCheckFunc(
+					testAccResourceADDefaultDomainPasswordPolicyLength("ad_default_domain_password_policy.p", 12),
+					resource.TestCheckResourceAttr("ad_default_domain_password_policy.p", "lockout_duration", "00:30:00"),
+				),
+			},
+			{
+				ResourceName:      "ad_default_domain_password_policy.p",
+				ImportState:       true,
+				ImportStateVerify: true,
+			},
+			{
+				Config: testAccResourceADDefaultDomainPasswordPolicyConfig(7),
+				Check: resource.ComposeTestCheckFunc(
+					testAccResourceADDefaultDomainPasswordPolicyLength("ad_default_domain_password_policy.p", 7),
+				),
+			},
+		},
+	})
+}
+
+func testAccResourceADDefaultDomainPasswordPolicyConfig(length int) string {
+	return fmt.Sprintf(`
+resource "ad_default_domain_password_policy" "p" {
+	min_password_length = %d
+}
+`, length)
+}
+
+func testAccResourceADDefaultDomainPasswordPolicyLength(resource string, length int) resource.TestCheckFunc {
+	return func(s *terraform.State) error {
+		rs, ok := s.RootModule().Resources[resource]
+		if !ok {
+			return fmt.Errorf("%s key not found in state", resource)
+		}
+
+		p, err := winrmhelper.GetDefaultDomainPasswordPolicy(context.Background(), testAccProvider.Meta().(*config.ProviderConf), rs.Primary.ID)
+		if err != nil {
+			return err
+		}
+		if p.MinPasswordLength != length {
+			return fmt.Errorf("expected a minimum password length of %d, got %d", length, p.MinPasswordLength)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,242 @@
+package ad
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/hashicorp/terraform-provider-ad/ad/internal/config"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+	"github.com/hashicorp/terraform-provider-ad/ad/internal/winrmhelper"
+)
+
+func resourceADFineGrainedPasswordPolicy() *schema.Resource {
+	s := passwordPolicySchema()
+	s["name"] = &schema.Schema{
+		Type:        schema.TypeString,
+		Required:    true,
+		ForceNew:    true,
+		Description: "The name of the password policy.",
+	}
+	s["precedence"] = &schema.Schema{
+		Type:         schema.TypeInt,
+		Required:     true,
+		ValidateFunc: validation.IntAtLeast(1),
+		Description:  "The precedence of the policy. When several policies apply to a user, the one with the lowest precedence wins.",
+	}
+	s["display_name"] = &schema.Schema{
+		Type:        schema.TypeString,
+		Optional:    true,
+		Description: "The display name of the password policy.",
+	}
+	s["description"] = &schema.Schema{
+		Type:        schema.TypeString,
+		Optional:    true,
+		Description: "The description of the password policy.",
+	}
+	s["protected"] = &schema.Schema{
+		Type:        schema.TypeBool,
+		Optional:    true,
+		Default:     false,
+		Description: "If set to true, the policy is protected from accidental deletion. The protection is removed before the policy is destroyed.",
+	}
+	s["subjects"] = &schema.Schema{
+		Type:        schema.TypeSet,
+		Optional:    true,
+		Elem:        &schema.Schema{Type: schema.TypeString},
+		Description: "The GUIDs of the users and global security groups the policy applies to. The policy is removed from the other users and groups it applies to.",
+	}
+	s["dn"] = &schema.Schema{
+		Type:        schema.TypeString,
+		Computed:    true,
+		Description: "The distinguished name of the password policy object.",
+	}
+
+	return &schema.Resource{
+		Description:   "`ad_fine_grained_password_policy` manages fine grained password policies, also known as Password Settings Objects, and the users and groups they apply to.",
+		CreateContext: resourceADFineGrainedPasswordPolicyCreate,
+		ReadContext:   resourceADFineGrainedPasswordPolicyRead,
+		UpdateContext: resourceADFineGrainedPasswordPolicyUpdate,
+		DeleteContext: resourceADFineGrainedPasswordPolicyDelete,
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(5 * time.Minute),
+			Read:   schema.DefaultTimeout(5 * time.Minute),
+			Update: schema.DefaultTimeout(5 * time.Minute),
+			Delete: schema.DefaultTimeout(5 * time.Minute),
+		},
+		Importer: &schema.ResourceImporter{
+			StateContext: schema.ImportStatePassthroughContext,
+		},
+		Schema: s,
+	}
+}
+
+// passwordPolicySchema returns the arguments shared by the password policy resources. Their defaults
+// are the ones of the Default Domain Policy.
+func passwordPolicySchema() map[string]*schema.Schema {
+	return map[string]*schema.Schema{
+		"complexity_enabled": {
+			Type:        schema.TypeBool,
+			Optional:    true,
+			Default:     true,
+			Description: "If set to true, passwords must meet the complexity requirements.",
+		},
+		"reversible_encryption_enabled": {
+			Type:        schema.TypeBool,
+			Optional:    true,
+			Default:     false,
+			Description: "If set to true, passwords are stored with reversible encryption.",
+		},
+		"min_password_length": {
+			Type:         schema.TypeInt,
+			Optional:     true,
+			Default:      7,
+			ValidateFunc: validation.IntBetween(0, 255),
+			Description:  "The minimum length of passwords.",
+		},
+		"password_history_count": {
+			Type:         schema.TypeInt,
+			Optional:     true,
+			Default:      24,
+			ValidateFunc: validation.IntBetween(0, 1024),
+			Description:  "How many previous passwords cannot be reused.",
+		},
+		"lockout_threshold": {
+			Type:         schema.TypeInt,
+			Optional:     true,
+			Default:      0,
+			ValidateFunc: validation.IntBetween(0, 65535),
+			Description:  "How many failed logons lock an account out. Accounts are never locked out if set to 0.",
+		},
+		"lockout_duration": {
+			Type:             schema.TypeString,
+			Optional:         true,
+			Default:          "00:30:00",
+			ValidateFunc:     validateTimeSpan,
+			DiffSuppressFunc: suppressTimeSpanDiff,
+			Description:      "How long accounts stay locked out, in the `[d.]hh:mm:ss` format.",
+		},
+		"lockout_observation_window": {
+			Type:             schema.TypeString,
+			Optional:         true,
+			Default:          "00:30:00",
+			ValidateFunc:     validateTimeSpan,
+			DiffSuppressFunc: suppressTimeSpanDiff,
+			Description:      "How long after a failed logon the count of failed logons is reset, in the `[d.]hh:mm:ss` format.",
+		},
+		"min_password_age": {
+			Type:             schema.TypeString,
+			Optional:         true,
+			Default:          "1.00:00:00",
+			ValidateFunc:     validateTimeSpan,
+			DiffSuppressFunc: suppressTimeSpanDiff,
+			Description:      "How long a password must be used before it can be changed, in the `[d.]hh:mm:ss` format.",
+		},
+		"max_password_age": {
+			Type:             schema.TypeString,
+			Optional:         true,
+			Default:          "42.00:00:00",
+			ValidateFunc:     validateTimeSpan,
+			DiffSuppressFunc: suppressTimeSpanDiff,
+			Description:      "How long a password can be used before it must be changed, in the `[d.]hh:mm:ss` format. Passwords never expire if set to `00:00:00`.",
+		},
+	}
+}
+
+// setPasswordPolicy sets the arguments returned by passwordPolicySchema from p.
+func setPasswordPolicy(d *schema.ResourceData, p *winrmhelper.PasswordPolicy) {
+	_ = d.Set("complexity_enabled", p.ComplexityEnabled)
+	_ = d.Set("reversible_encryption_enabled", p.ReversibleEncryptionEnabled)
+	_ = d.Set("min_password_length", p.MinPasswordLength)
+	_ = d.Set("password_history_count", p.PasswordHistoryCount)
+	_ = d.Set("lockout_threshold", p.LockoutThreshold)
+	_ = d.Set("lockout_duration", winrmhelper.FormatTimeSpan(p.LockoutDuration.Duration))
+	_ = d.Set("lockout_observation_window", winrmhelper.FormatTimeSpan(p.LockoutObservationWindow.Duration))
+	_ = d.Set("min_password_age", winrmhelper.FormatTimeSpan(p.MinPasswordAge.Duration))
+	_ = d.Set("max_password_age", winrmhelper.FormatTimeSpan(p.MaxPasswordAge.Duration))
+}
+
+func validateTimeSpan(v interface{}, k string) ([]string, []error) {
+	if _, err := winrmhelper.ParseTimeSpan(v.(string)); err != nil {
+		return nil, []error{fmt.Errorf("%s: %s", k, err)}
+	}
+	return nil, nil
+}
+
+// suppressTimeSpanDiff suppresses the differences between two representations of the same time span,
+// for instance 1.00:00:00 and 1.0:00:00.
+func suppressTimeSpanDiff(k, old, new string, d *schema.ResourceData) bool {
+	oldSpan, err := winrmhelper.ParseTimeSpan(old)
+	if err != nil {
+		return false
+	}
+	newSpan, err := winrmhelper.ParseTimeSpan(new)
+	if err != nil {
+		return false
+	}
+	return oldSpan == newSpan
+}
+
+func resourceADFineGrainedPasswordPolicyCreate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	conf := meta.(*config.ProviderConf)
+	p := winrmhelper.GetFineGrainedPasswordPolicyFromResource(d)
+	guid, err := p.Create(ctx, d, conf)
+	if err != nil {
+		return diag.Errorf("error while creating password policy: %s", err)
+	}
+	d.SetId(guid)
+	p.GUID = guid
+
+	if err := p.UpdateSubjects(ctx, conf); err != nil {
+		return diag.Errorf("error while applying password policy %q: %s", d.Id(), err)
+	}
+	return resourceADFineGrainedPasswordPolicyRead(ctx, d, meta)
+}
+
+func resourceADFineGrainedPasswordPolicyRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	log.Printf("Reading ad_fine_grained_password_policy resource for policy with guid: %q", d.Id())
+	p, err := winrmhelper.GetFineGrainedPasswordPolicyFromHost(ctx, meta.(*config.ProviderConf), d.Id())
+	if err != nil {
+		if errors.Is(err, winrmhelper.ErrNotFound) {
+			d.SetId("")
+			return nil
+		}
+		return diag.Errorf("error while reading password policy with GUID %q: %s", d.Id(), err)
+	}
+	_ = d.Set("name", p.Name)
+	_ = d.Set("precedence", p.Precedence)
+	_ = d.Set("display_name", p.DisplayName)
+	_ = d.Set("description", p.Description)
+	_ = d.Set("protected", p.Protected)
+	_ = d.Set("subjects", p.Subjects)
+	_ = d.Set("dn", p.DistinguishedName)
+	setPasswordPolicy(d, &p.PasswordPolicy)
+
+	return nil
+}
+
+func resourceADFineGrainedPasswordPolicyUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	p := winrmhelper.GetFineGrainedPasswordPolicyFromResource(d)
+	err := p.Modify(ctx, d, meta.(*config.ProviderConf))
+	if err != nil {
+		return diag.Errorf("error while updating password policy with GUID %q: %s", d.Id(), err)
+	}
+	return resourceADFineGrainedPasswordPolicyRead(ctx, d, meta)
+}
+
+func resourceADFineGrainedPasswordPolicyDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	if d.Id() == "" {
+		return nil
+	}
+	p := winrmhelper.GetFineGrainedPasswordPolicyFromResource(d)
+	err := p.Delete(ctx, meta.(*config.ProviderConf))
+	if err != nil {
+		return diag.Errorf("error while deleting password policy with GUID %q: %s", d.Id(), err)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package ad
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/hashicorp/terraform-provider-ad/ad/internal/config"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	"github.com/hashicorp/terraform-provider-ad/ad/internal/winrmhelper"
+)
+
+func TestAccResourceADFineGrainedPasswordPolicy_basic(t *testing.T) {
+	name := os.Getenv("TF_VAR_ad_fgpp_name")
+
+	envVars := []string{"TF_VAR_ad_fgpp_name", "TF_VAR_ad_group_name", "TF_VAR_ad_group_sam", "TF_VAR_ad_group_container"}
+	resource.Test(t, resource.TestCase{
+		PreCheck:  func() { testAccPreCheck(t, envVars) },
+		Providers: testAccProviders,
+		CheckDestroy: resource.ComposeTestCheckFunc(
+			testAccResourceADFineGrainedPasswordPolicyExists("ad_fine_grained_password_policy.p", name, 0, false),
+		),
+		Steps: []resource.TestStep{
+			{
+				Config: testAccResourceADFineGrainedPasswordPolicyConfigBasic(),
+				Check: resource.ComposeTestCheckFunc(
+					testAccResourceADFineGrainedPasswordPolicyExists("ad_fine_grained_password_policy.p", name, 0, true),
+					resource.TestCheckResourceAttr("ad_fine_grained_password_policy.p", "max_password_age", "42.00:00:00"),
+				),
+			},
+			{
+				Config: testAccResourceADFineGrainedPasswordPolicyConfigSubjects(),
+				Check: resource.ComposeTestCheckFunc(
+					testAccResourceADFineGrainedPasswordPolicyExists("ad_fine_grained_password_policy.p", name, 1, true),
+					resource.TestCheckResourceAttr("ad_fine_grained_password_policy.p", "min_password_length", "14"),
+					resource.TestCheckResourceAttr("ad_fine_grained_password_policy.p", "lockout_threshold", "5"),
+				),
+			},
+			{
+				ResourceName:      "ad_fine_grained_password_policy.p",
+				ImportState:       true,
+				ImportStateVerify: true,
+			},
+			{
+				Config: testAccResourceADFineGrainedPasswordPolicyConfigBasic(),
+				Check: resource.ComposeTestCheckFunc(
+					testAccResourceADFineGrainedPasswordPolicyExists("ad_fine_grained_password_policy.p", name, 0, true),
+				),
+			},
+		},
+	})
+}
+
+func testAccResourceADFineGrainedPasswordPolicyConfigBasic() string {
+	return `
+variable "ad_fgpp_name" {}
+
+resource "ad_fine_grained_password_policy" "p" {
+	name = var.ad_fgpp_name
+	precedence = 10
+	protected = true
+}
+`
+}
+
+func testAccResourceADFineGrainedPasswordPolicyConfigSubjects() string {
+	return `
+variable "ad_fgpp_name" {}
+variable "ad_group_name" {}
+variable "ad_group_sam" {}
+variable "ad_group_container" {}
+
+resource "ad_group" "g" {
+	name = var.ad_group_name
+	sam_account_name = var.ad_group_sam
+	container = var.ad_group_container
+}
+
+resource "ad_fine_grained_password_policy" "p" {
+	name = var.ad_fgpp_name
+	precedence = 10
+	protected = true
+	min_password_length = 14
+	lockout_threshold = 5
+	lockout_observation_window = "00:15:00"
+	min_password_age = "1.0:00:00"
+	subjects = [ad_group.g.id]
+}
+`
+}
+
+func testAccResourceADFineGrainedPasswordPolicyExists(resource, name string, subjects int, expected bool) resource.TestCheckFunc {
+	return func(s *terraform.State) error {
+		rs, ok := s.RootModule().Resources[resource]
+		if !ok {
+			return fmt.Errorf("%s key not found in state", resource)
+		}
+
+		guid := rs.Primary.ID
+		p, err := winrmhelper.GetFineGrainedPasswordPolicyFromHost(context.Background(), testAccProvider.Meta().(*config.ProviderConf), guid)
+		if err != nil {
+			if errors.Is(err, winrmhelper.ErrNotFound) && !expected {
+				return nil
+			}
+			return err
+		}
+
+		if p.Name != name {
+			return fmt.Errorf("password policy name %q does not match expected name %q", p.Name, name)
+		}
+		if len(p.Subjects) != subjects {
+			return fmt.Errorf("expected %d subjects, got %v", subjects, p.Subjects)
+		}
+		return nil
+	}
+}
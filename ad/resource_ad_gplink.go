@@ -1,23 +1,33 @@
 package ad
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/hashicorp/terraform-provider-ad/ad/internal/config"
 
 	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"github.com/hashicorp/terraform-provider-ad/ad/internal/winrmhelper"
 )
 
 func resourceADGPLink() *schema.Resource {
 	return &schema.Resource{
-		Description: "`ad_gplink` manages links between GPOs and container objects such as OUs.",
-		Create:      resourceADGPLinkCreate,
-		Read:        resourceADGPLinkRead,
-		Update:      resourceADGPLinkUpdate,
-		Delete:      resourceADGPLinkDelete,
+		Description:   "`ad_gplink` manages links between GPOs and container objects such as OUs.",
+		CreateContext: resourceADGPLinkCreate,
+		ReadContext:   resourceADGPLinkRead,
+		UpdateContext: resourceADGPLinkUpdate,
+		DeleteContext: resourceADGPLinkDelete,
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(5 * time.Minute),
+			Read:   schema.DefaultTimeout(5 * time.Minute),
+			Update: schema.DefaultTimeout(5 * time.Minute),
+			Delete: schema.DefaultTimeout(5 * time.Minute),
+		},
 		Importer: &schema.ResourceImporter{
 			StateContext: schema.ImportStatePassthroughContext,
 		},
@@ -64,18 +74,18 @@ func resourceADGPLink() *schema.Resource {
 	}
 }
 
-func resourceADGPLinkRead(d *schema.ResourceData, meta interface{}) error {
+func resourceADGPLinkRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	idParts := strings.SplitN(d.Id(), "_", 2)
 	if len(idParts) != 2 {
-		return fmt.Errorf("malformed ID for GPLink resource with ID %q", d.Id())
+		return diag.Errorf("malformed ID for GPLink resource with ID %q", d.Id())
 	}
-	gplink, err := winrmhelper.GetGPLinkFromHost(meta.(*config.ProviderConf), idParts[0], idParts[1])
+	gplink, err := winrmhelper.GetGPLinkFromHost(ctx, meta.(*config.ProviderConf), idParts[0], idParts[1])
 	if err != nil {
-		if strings.Contains(err.Error(), "did not find") {
+		if errors.Is(err, winrmhelper.ErrNotFound) {
 			d.SetId("")
 			return nil
 		}
-		return fmt.Errorf("while reading resource with id %q: %s", d.Id(), err)
+		return diag.Errorf("while reading resource with id %q: %s", d.Id(), err)
 	}
 
 	_ = d.Set("gpo_guid", gplink.GPOGuid)
@@ -87,18 +97,18 @@ func resourceADGPLinkRead(d *schema.ResourceData, meta interface{}) error {
 	return nil
 }
 
-func resourceADGPLinkCreate(d *schema.ResourceData, meta interface{}) error {
+func resourceADGPLinkCreate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	gplink := winrmhelper.GetGPLinkFromResource(d)
-	gpLinkID, err := gplink.NewGPLink(meta.(*config.ProviderConf))
+	gpLinkID, err := gplink.NewGPLink(ctx, meta.(*config.ProviderConf))
 	if err != nil {
-		return fmt.Errorf("while creating GPLink resource: %s", err)
+		return diag.Errorf("while creating GPLink resource: %s", err)
 	}
 	d.SetId(gpLinkID)
 
-	return resourceADGPLinkRead(d, meta)
+	return resourceADGPLinkRead(ctx, d, meta)
 }
 
-func resourceADGPLinkUpdate(d *schema.ResourceData, meta interface{}) error {
+func resourceADGPLinkUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	keys := []string{"enforced", "enabled", "order"}
 	changes := make(map[string]interface{})
 	for _, key := range keys {
@@ -107,19 +117,19 @@ func resourceADGPLinkUpdate(d *schema.ResourceData, meta interface{}) error {
 		}
 	}
 	gplink := winrmhelper.GetGPLinkFromResource(d)
-	err := gplink.ModifyGPLink(meta.(*config.ProviderConf), changes)
+	err := gplink.ModifyGPLink(ctx, meta.(*config.ProviderConf), changes)
 	if err != nil {
-		return fmt.Errorf("while modifying GPLink with id %q: %s", d.Id(), err)
+		return diag.Errorf("while modifying GPLink with id %q: %s", d.Id(), err)
 	}
 
-	return resourceADGPLinkRead(d, meta)
+	return resourceADGPLinkRead(ctx, d, meta)
 }
 
-func resourceADGPLinkDelete(d *schema.ResourceData, meta interface{}) error {
+func resourceADGPLinkDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	gplink := winrmhelper.GetGPLinkFromResource(d)
-	err := gplink.RemoveGPLink(meta.(*config.ProviderConf))
+	err := gplink.RemoveGPLink(ctx, meta.(*config.ProviderConf))
 	if err != nil {
-		return fmt.Errorf("while deleting resource with ID %q: %s", d.Id(), err)
+		return diag.Errorf("while deleting resource with ID %q: %s", d.Id(), err)
 	}
 
 	return nil
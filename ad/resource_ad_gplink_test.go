@@ -1,6 +1,7 @@
 package ad
 
 import (
+	"context"
 	"fmt"
 	"regexp"
 	"strings"
@@ -172,7 +173,7 @@ func testAccResourceADGPLinkExists(resourceName string, order int, enforced, ena
 		if len(idParts) != 2 {
 			return fmt.Errorf("malformed ID for GPLink resource with ID %q", id)
 		}
-		gplink, err := winrmhelper.GetGPLinkFromHost(testAccProvider.Meta().(*config.ProviderConf), idParts[0], idParts[1])
+		gplink, err := winrmhelper.GetGPLinkFromHost(context.Background(), testAccProvider.Meta().(*config.ProviderConf), idParts[0], idParts[1])
 		if err != nil {
 			// Check that the err is really because the GPO was not found
 			// and not because of other issues
@@ -1,10 +1,13 @@
 package ad
 
 import (
-	"strings"
+	"context"
+	"errors"
+	"time"
 
 	"github.com/hashicorp/terraform-provider-ad/ad/internal/config"
 
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
 	"github.com/hashicorp/terraform-provider-ad/ad/internal/winrmhelper"
@@ -12,11 +15,17 @@ import (
 
 func resourceADGPO() *schema.Resource {
 	return &schema.Resource{
-		Description: "`ad_gpo` manages Group Policy Objects (GPOs).",
-		Create:      resourceADGPOCreate,
-		Read:        resourceADGPORead,
-		Update:      resourceADGPOUpdate,
-		Delete:      resourceADGPODelete,
+		Description:   "`ad_gpo` manages Group Policy Objects (GPOs).",
+		CreateContext: resourceADGPOCreate,
+		ReadContext:   resourceADGPORead,
+		UpdateContext: resourceADGPOUpdate,
+		DeleteContext: resourceADGPODelete,
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(5 * time.Minute),
+			Read:   schema.DefaultTimeout(5 * time.Minute),
+			Update: schema.DefaultTimeout(5 * time.Minute),
+			Delete: schema.DefaultTimeout(5 * time.Minute),
+		},
 		Importer: &schema.ResourceImporter{
 			StateContext: schema.ImportStatePassthroughContext,
 		},
@@ -55,27 +64,27 @@ func resourceADGPO() *schema.Resource {
 	}
 }
 
-func resourceADGPOCreate(d *schema.ResourceData, meta interface{}) error {
+func resourceADGPOCreate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	g := winrmhelper.GetGPOFromResource(d)
-	guid, err := g.NewGPO(meta.(*config.ProviderConf))
+	guid, err := g.NewGPO(ctx, meta.(*config.ProviderConf))
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 	d.SetId(guid)
-	return resourceADGPORead(d, meta)
+	return resourceADGPORead(ctx, d, meta)
 }
 
-func resourceADGPORead(d *schema.ResourceData, meta interface{}) error {
+func resourceADGPORead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	if d.Id() == "" {
 		return nil
 	}
-	g, err := winrmhelper.GetGPOFromHost(meta.(*config.ProviderConf), "", d.Id())
+	g, err := winrmhelper.GetGPOFromHost(ctx, meta.(*config.ProviderConf), "", d.Id())
 	if err != nil {
-		if strings.Contains(err.Error(), "GpoWithNameNotFound") || strings.Contains(err.Error(), "GpoWithIdNotFound") {
+		if errors.Is(err, winrmhelper.ErrNotFound) {
 			d.SetId("")
 			return nil
 		}
-		return err
+		return diag.FromErr(err)
 	}
 	_ = d.Set("domain", g.Domain)
 	_ = d.Set("description", g.Description)
@@ -85,20 +94,20 @@ func resourceADGPORead(d *schema.ResourceData, meta interface{}) error {
 	return nil
 }
 
-func resourceADGPOUpdate(d *schema.ResourceData, meta interface{}) error {
+func resourceADGPOUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	g := winrmhelper.GetGPOFromResource(d)
-	_, err := g.UpdateGPO(meta.(*config.ProviderConf), d)
+	_, err := g.UpdateGPO(ctx, meta.(*config.ProviderConf), d)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
-	return resourceADGPORead(d, meta)
+	return resourceADGPORead(ctx, d, meta)
 }
 
-func resourceADGPODelete(d *schema.ResourceData, meta interface{}) error {
+func resourceADGPODelete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	g := winrmhelper.GetGPOFromResource(d)
-	err := g.DeleteGPO(meta.(*config.ProviderConf))
+	err := g.DeleteGPO(ctx, meta.(*config.ProviderConf))
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 	return nil
 }
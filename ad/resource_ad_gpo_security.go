@@ -2,14 +2,18 @@ package ad
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
+	"time"
 
 	"github.com/hashicorp/terraform-provider-ad/ad/internal/config"
 
 	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"github.com/hashicorp/terraform-provider-ad/ad/internal/adschema"
 	"github.com/hashicorp/terraform-provider-ad/ad/internal/gposec"
@@ -18,11 +22,17 @@ import (
 
 func resourceADGPOSecurity() *schema.Resource {
 	return &schema.Resource{
-		Description: "`ad_gpo_security` manages the security settings portion of a Group Policy Object (GPO).",
-		Create:      resourceADGPOSecurityCreate,
-		Read:        resourceADGPOSecurityRead,
-		Update:      resourceADGPOSecurityUpdate,
-		Delete:      resourceADGPOSecurityDelete,
+		Description:   "`ad_gpo_security` manages the security settings portion of a Group Policy Object (GPO).",
+		CreateContext: resourceADGPOSecurityCreate,
+		ReadContext:   resourceADGPOSecurityRead,
+		UpdateContext: resourceADGPOSecurityUpdate,
+		DeleteContext: resourceADGPOSecurityDelete,
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(5 * time.Minute),
+			Read:   schema.DefaultTimeout(5 * time.Minute),
+			Update: schema.DefaultTimeout(5 * time.Minute),
+			Delete: schema.DefaultTimeout(5 * time.Minute),
+		},
 		Importer: &schema.ResourceImporter{
 			StateContext: schema.ImportStatePassthroughContext,
 		},
@@ -30,152 +40,152 @@ func resourceADGPOSecurity() *schema.Resource {
 	}
 }
 
-func resourceADGPOSecurityCreate(d *schema.ResourceData, meta interface{}) error {
-	winrmCPClient, err := meta.(*config.ProviderConf).AcquireWinRMCPClient()
+func resourceADGPOSecurityCreate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	cpClient, err := meta.(*config.ProviderConf).AcquireFileCopier(ctx)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
-	defer meta.(*config.ProviderConf).ReleaseWinRMCPClient(winrmCPClient)
+	defer meta.(*config.ProviderConf).ReleaseFileCopier(cpClient)
 
 	guid := d.Get("gpo_container").(string)
 	if guid == "" {
-		return fmt.Errorf("Cannot handle empty GPO GUID")
+		return diag.Errorf("Cannot handle empty GPO GUID")
 	}
 	_, err = uuid.ParseUUID(guid)
 	if err != nil {
-		return fmt.Errorf("Cannot parse GUID %q: %s", guid, err)
+		return diag.Errorf("Cannot parse GUID %q: %s", guid, err)
 	}
 	iniFile, err := winrmhelper.GetSecIniFromResource(d, adschema.GpoSecuritySchema())
 	if err != nil {
-		return fmt.Errorf("error while generating ini file from resource data: %s", err)
+		return diag.Errorf("error while generating ini file from resource data: %s", err)
 	}
 
-	gpo, err := winrmhelper.GetGPOFromHost(meta.(*config.ProviderConf), "", guid)
+	gpo, err := winrmhelper.GetGPOFromHost(ctx, meta.(*config.ProviderConf), "", guid)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
-	err = winrmhelper.UploadSecIni(meta.(*config.ProviderConf), winrmCPClient, gpo, iniFile)
+	err = winrmhelper.UploadSecIni(ctx, meta.(*config.ProviderConf), cpClient, gpo, iniFile)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
 	// GUIDs for security settings are defined here:
 	// https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-gpsb/55bb803e-b35f-4ce8-b558-4c1e92ad77a4
-	err = winrmhelper.SetMachineExtensionNames(meta.(*config.ProviderConf), gpo.DN, "[{827D319E-6EAC-11D2-A4EA-00C04F79F83A}{803E14A0-B4FB-11D0-A0D0-00A0C90F574B}]")
+	err = winrmhelper.SetMachineExtensionNames(ctx, meta.(*config.ProviderConf), gpo.DN, "[{827D319E-6EAC-11D2-A4EA-00C04F79F83A}{803E14A0-B4FB-11D0-A0D0-00A0C90F574B}]")
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
 	d.SetId(fmt.Sprintf("%s_securitysettings", guid))
 
-	return resourceADGPOSecurityRead(d, meta)
+	return resourceADGPOSecurityRead(ctx, d, meta)
 }
 
-func resourceADGPOSecurityRead(d *schema.ResourceData, meta interface{}) error {
+func resourceADGPOSecurityRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	resourceID := d.Id()
 	toks := strings.Split(resourceID, "_")
 	if len(toks) != 2 {
-		return fmt.Errorf("resource ID %q does not match <guid>_securitysettings", resourceID)
+		return diag.Errorf("resource ID %q does not match <guid>_securitysettings", resourceID)
 	}
 	guid := toks[0]
 
-	gpo, err := winrmhelper.GetGPOFromHost(meta.(*config.ProviderConf), "", guid)
+	gpo, err := winrmhelper.GetGPOFromHost(ctx, meta.(*config.ProviderConf), "", guid)
 	if err != nil {
-		if strings.Contains(err.Error(), "NotFound") {
+		if errors.Is(err, winrmhelper.ErrNotFound) {
 			log.Printf("[DEBUG] GPO with guid %q not found", guid)
 			d.SetId("")
 			return nil
 		}
-		return err
+		return diag.FromErr(err)
 	}
 	_ = d.Set("gpo_container", guid)
 
-	hostSecIni, err := winrmhelper.GetSecIniFromHost(meta.(*config.ProviderConf), gpo)
+	hostSecIni, err := winrmhelper.GetSecIniFromHost(ctx, meta.(*config.ProviderConf), gpo)
 	if err != nil {
-		if strings.Contains(err.Error(), "ItemNotFoundException") {
+		if errors.Is(err, winrmhelper.ErrNotFound) {
 			log.Printf("[DEBUG] inf file not found, marking resource as gone")
 			d.SetId("")
 			return nil
 		}
-		return err
+		return diag.FromErr(err)
 	}
 
 	err = gposec.HandleSectionRead(adschema.GPOSecuritySchemaKeys, hostSecIni, d)
-	return err
+	return diag.FromErr(err)
 }
 
-func resourceADGPOSecurityUpdate(d *schema.ResourceData, meta interface{}) error {
-	winrmCPClient, err := meta.(*config.ProviderConf).AcquireWinRMCPClient()
+func resourceADGPOSecurityUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	cpClient, err := meta.(*config.ProviderConf).AcquireFileCopier(ctx)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
-	defer meta.(*config.ProviderConf).ReleaseWinRMCPClient(winrmCPClient)
+	defer meta.(*config.ProviderConf).ReleaseFileCopier(cpClient)
 
 	guid := d.Get("gpo_container").(string)
 	if guid == "" {
-		return fmt.Errorf("Cannot handle empty GPO GUID")
+		return diag.Errorf("Cannot handle empty GPO GUID")
 	}
 	_, err = uuid.ParseUUID(guid)
 	if err != nil {
-		return fmt.Errorf("Cannot parse GUID %q: %s", guid, err)
+		return diag.Errorf("Cannot parse GUID %q: %s", guid, err)
 	}
 
-	gpo, err := winrmhelper.GetGPOFromHost(meta.(*config.ProviderConf), "", guid)
+	gpo, err := winrmhelper.GetGPOFromHost(ctx, meta.(*config.ProviderConf), "", guid)
 	if err != nil {
-		return fmt.Errorf("error while retrieving GPO with guid %q: %s", guid, err)
+		return diag.Errorf("error while retrieving GPO with guid %q: %s", guid, err)
 	}
 
 	iniFile, err := winrmhelper.GetSecIniFromResource(d, adschema.GpoSecuritySchema())
 	if err != nil {
-		return fmt.Errorf("error while generating ini file from resource data: %s", err)
+		return diag.Errorf("error while generating ini file from resource data: %s", err)
 	}
 
 	iniBuf := bytes.NewBuffer([]byte{})
 	_, err = iniFile.WriteTo(iniBuf)
 	if err != nil {
-		return fmt.Errorf("error while writing INI file in buffer")
+		return diag.Errorf("error while writing INI file in buffer")
 	}
 	iniSum := sha256.Sum256(iniBuf.Bytes())
 
-	hostSecIniBytes, err := winrmhelper.GetSecIniContents(meta.(*config.ProviderConf), gpo)
+	hostSecIniBytes, err := winrmhelper.GetSecIniContents(ctx, meta.(*config.ProviderConf), gpo)
 	if err != nil {
-		return fmt.Errorf("error while retrieving security settings contents for GPO with guid %q: %s", guid, err)
+		return diag.Errorf("error while retrieving security settings contents for GPO with guid %q: %s", guid, err)
 	}
 
 	hostSum := sha256.Sum256(hostSecIniBytes)
 
 	if iniSum != hostSum {
-		err = winrmhelper.UploadSecIni(meta.(*config.ProviderConf), winrmCPClient, gpo, iniFile)
+		err = winrmhelper.UploadSecIni(ctx, meta.(*config.ProviderConf), cpClient, gpo, iniFile)
 		if err != nil {
-			return fmt.Errorf("error while uploading security settings file for GPO with guid %q: %s", guid, err)
+			return diag.Errorf("error while uploading security settings file for GPO with guid %q: %s", guid, err)
 		}
 
 	}
-	return resourceADGPOSecurityRead(d, meta)
+	return resourceADGPOSecurityRead(ctx, d, meta)
 }
 
-func resourceADGPOSecurityDelete(d *schema.ResourceData, meta interface{}) error {
-	winrmCPClient, err := meta.(*config.ProviderConf).AcquireWinRMCPClient()
+func resourceADGPOSecurityDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	cpClient, err := meta.(*config.ProviderConf).AcquireFileCopier(ctx)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
-	defer meta.(*config.ProviderConf).ReleaseWinRMCPClient(winrmCPClient)
+	defer meta.(*config.ProviderConf).ReleaseFileCopier(cpClient)
 	resourceID := d.Id()
 	toks := strings.Split(resourceID, "_")
 	if len(toks) != 2 {
-		return fmt.Errorf("resource ID %q does not match <guid>_securitysettings", resourceID)
+		return diag.Errorf("resource ID %q does not match <guid>_securitysettings", resourceID)
 	}
 	guid := toks[0]
 
-	gpo, err := winrmhelper.GetGPOFromHost(meta.(*config.ProviderConf), "", guid)
+	gpo, err := winrmhelper.GetGPOFromHost(ctx, meta.(*config.ProviderConf), "", guid)
 	if err != nil {
-		return fmt.Errorf("error while retrieving GPO with guid %q: %s", guid, err)
+		return diag.Errorf("error while retrieving GPO with guid %q: %s", guid, err)
 	}
 
-	err = winrmhelper.RemoveSecIni(meta.(*config.ProviderConf), winrmCPClient, gpo)
+	err = winrmhelper.RemoveSecIni(ctx, meta.(*config.ProviderConf), cpClient, gpo)
 	if err != nil {
-		return fmt.Errorf("error while removing security settings INF file for GPO with guid %q: %s", guid, err)
+		return diag.Errorf("error while removing security settings INF file for GPO with guid %q: %s", guid, err)
 	}
 	return nil
 }
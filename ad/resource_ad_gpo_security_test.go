@@ -1,6 +1,8 @@
 package ad
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"strings"
 	"testing"
@@ -50,18 +52,18 @@ func testAccResourceADGPOSecurityExists(resourceName string, desired bool) resou
 		}
 		guid := toks[0]
 
-		gpo, err := winrmhelper.GetGPOFromHost(testAccProvider.Meta().(*config.ProviderConf), "", guid)
+		gpo, err := winrmhelper.GetGPOFromHost(context.Background(), testAccProvider.Meta().(*config.ProviderConf), "", guid)
 		if err != nil {
 			// if the GPO got destroyed first then the rest of the entities depending on it
 			// are also destroyed.
-			if !desired && strings.Contains(err.Error(), "NotFound") {
+			if !desired && errors.Is(err, winrmhelper.ErrNotFound) {
 				return nil
 			}
 			return err
 		}
-		_, err = winrmhelper.GetSecIniFromHost(testAccProvider.Meta().(*config.ProviderConf), gpo)
+		_, err = winrmhelper.GetSecIniFromHost(context.Background(), testAccProvider.Meta().(*config.ProviderConf), gpo)
 		if err != nil {
-			if !desired && strings.Contains(err.Error(), "NotFound") {
+			if !desired && errors.Is(err, winrmhelper.ErrNotFound) {
 				return nil
 			}
 			return err
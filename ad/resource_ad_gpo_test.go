@@ -1,9 +1,10 @@
 package ad
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
-	"strings"
 	"testing"
 
 	"github.com/hashicorp/terraform-provider-ad/ad/internal/config"
@@ -80,17 +81,11 @@ func testAccResourceADGPOExists(resourceName, name string, expected bool) resour
 			return fmt.Errorf("%s key not found in state", resourceName)
 		}
 		guid := rs.Primary.ID
-		client, err := testAccProvider.Meta().(*config.ProviderConf).AcquireWinRMClient()
-		if err != nil {
-			return err
-		}
-		defer testAccProvider.Meta().(*config.ProviderConf).ReleaseWinRMClient(client)
-
-		gpo, err := winrmhelper.GetGPOFromHost(testAccProvider.Meta().(*config.ProviderConf), "", guid)
+		gpo, err := winrmhelper.GetGPOFromHost(context.Background(), testAccProvider.Meta().(*config.ProviderConf), "", guid)
 		if err != nil {
 			// Check that the err is really because the GPO was not found
 			// and not because of other issues
-			if strings.Contains(err.Error(), "GpoWithIdNotFound") && !expected {
+			if errors.Is(err, winrmhelper.ErrNotFound) && !expected {
 				return nil
 			}
 			return err
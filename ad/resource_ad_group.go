@@ -1,11 +1,13 @@
 package ad
 
 import (
-	"fmt"
-	"strings"
+	"context"
+	"errors"
+	"time"
 
 	"github.com/hashicorp/terraform-provider-ad/ad/internal/config"
 
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
 	"github.com/hashicorp/terraform-provider-ad/ad/internal/winrmhelper"
@@ -13,11 +15,17 @@ import (
 
 func resourceADGroup() *schema.Resource {
 	return &schema.Resource{
-		Description: "`ad_group` manages Group objects in an Active Directory tree.",
-		Create:      resourceADGroupCreate,
-		Read:        resourceADGroupRead,
-		Update:      resourceADGroupUpdate,
-		Delete:      resourceADGroupDelete,
+		Description:   "`ad_group` manages Group objects in an Active Directory tree.",
+		CreateContext: resourceADGroupCreate,
+		ReadContext:   resourceADGroupRead,
+		UpdateContext: resourceADGroupUpdate,
+		DeleteContext: resourceADGroupDelete,
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(5 * time.Minute),
+			Read:   schema.DefaultTimeout(5 * time.Minute),
+			Update: schema.DefaultTimeout(5 * time.Minute),
+			Delete: schema.DefaultTimeout(5 * time.Minute),
+		},
 		Importer: &schema.ResourceImporter{
 			StateContext: schema.ImportStatePassthroughContext,
 		},
@@ -71,24 +79,25 @@ func resourceADGroup() *schema.Resource {
 	}
 }
 
-func resourceADGroupCreate(d *schema.ResourceData, meta interface{}) error {
+func resourceADGroupCreate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	u := winrmhelper.GetGroupFromResource(d)
-	guid, err := u.AddGroup(meta.(*config.ProviderConf))
+	guid, err := u.AddGroup(ctx, meta.(*config.ProviderConf))
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 	d.SetId(guid)
-	return resourceADGroupRead(d, meta)
+	return resourceADGroupRead(ctx, d, meta)
 }
 
-func resourceADGroupRead(d *schema.ResourceData, meta interface{}) error {
-	g, err := winrmhelper.GetGroupFromHost(meta.(*config.ProviderConf), d.Id())
+func resourceADGroupRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	winrmhelper.PrefetchGroups(ctx, meta.(*config.ProviderConf), d.Get("container").(string))
+	g, err := winrmhelper.GetGroupFromHost(ctx, meta.(*config.ProviderConf), d.Id())
 	if err != nil {
-		if strings.Contains(err.Error(), "ADIdentityNotFoundException") {
+		if errors.Is(err, winrmhelper.ErrNotFound) {
 			d.SetId("")
 			return nil
 		}
-		return err
+		return diag.FromErr(err)
 	}
 	if g == nil {
 		d.SetId("")
@@ -106,26 +115,26 @@ func resourceADGroupRead(d *schema.ResourceData, meta interface{}) error {
 	return nil
 }
 
-func resourceADGroupUpdate(d *schema.ResourceData, meta interface{}) error {
+func resourceADGroupUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	g := winrmhelper.GetGroupFromResource(d)
-	err := g.ModifyGroup(d, meta.(*config.ProviderConf))
+	err := g.ModifyGroup(ctx, d, meta.(*config.ProviderConf))
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
-	return resourceADGroupRead(d, meta)
+	return resourceADGroupRead(ctx, d, meta)
 }
 
-func resourceADGroupDelete(d *schema.ResourceData, meta interface{}) error {
-	g, err := winrmhelper.GetGroupFromHost(meta.(*config.ProviderConf), d.Id())
+func resourceADGroupDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	g, err := winrmhelper.GetGroupFromHost(ctx, meta.(*config.ProviderConf), d.Id())
 	if err != nil {
-		if strings.Contains(err.Error(), "ADIdentityNotFoundException") {
+		if errors.Is(err, winrmhelper.ErrNotFound) {
 			return nil
 		}
-		return err
+		return diag.FromErr(err)
 	}
-	err = g.DeleteGroup(meta.(*config.ProviderConf))
+	err = g.DeleteGroup(ctx, meta.(*config.ProviderConf))
 	if err != nil {
-		return fmt.Errorf("while deleting group: %s", err)
+		return diag.Errorf("while deleting group: %s", err)
 	}
 	return nil
 }
@@ -1,23 +1,32 @@
 package ad
 
 import (
+	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/hashicorp/terraform-provider-ad/ad/internal/config"
 
 	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"github.com/hashicorp/terraform-provider-ad/ad/internal/winrmhelper"
 )
 
 func resourceADGroupMembership() *schema.Resource {
 	return &schema.Resource{
-		Description: "`ad_group_membership` manages the members of a given Active Directory group.",
-		Create:      resourceADGroupMembershipCreate,
-		Read:        resourceADGroupMembershipRead,
-		Update:      resourceADGroupMembershipUpdate,
-		Delete:      resourceADGroupMembershipDelete,
+		Description:   "`ad_group_membership` manages the members of a given Active Directory group.",
+		CreateContext: resourceADGroupMembershipCreate,
+		ReadContext:   resourceADGroupMembershipRead,
+		UpdateContext: resourceADGroupMembershipUpdate,
+		DeleteContext: resourceADGroupMembershipDelete,
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(5 * time.Minute),
+			Read:   schema.DefaultTimeout(5 * time.Minute),
+			Update: schema.DefaultTimeout(5 * time.Minute),
+			Delete: schema.DefaultTimeout(5 * time.Minute),
+		},
 		Importer: &schema.ResourceImporter{
 			StateContext: schema.ImportStatePassthroughContext,
 		},
@@ -31,7 +40,7 @@ func resourceADGroupMembership() *schema.Resource {
 			"group_members": {
 				Type:        schema.TypeSet,
 				Required:    true,
-				Description: "A list of member AD Principals. Each principal can be identified by its GUID, SID, Distinguished Name, or SAM Account Name. Only one is required",
+				Description: "A list of member AD Principals. Each principal can be identified by its GUID, SID, Distinguished Name, or SAM Account Name. Only one is required. Contacts, which are not principals, can be members too, identified by their GUID or Distinguished Name.",
 				Elem:        &schema.Schema{Type: schema.TypeString},
 				MinItems:    1,
 			},
@@ -39,12 +48,12 @@ func resourceADGroupMembership() *schema.Resource {
 	}
 }
 
-func resourceADGroupMembershipRead(d *schema.ResourceData, meta interface{}) error {
+func resourceADGroupMembershipRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	toks := strings.Split(d.Id(), "_")
 
-	gm, err := winrmhelper.NewGroupMembershipFromHost(meta.(*config.ProviderConf), toks[0])
+	gm, err := winrmhelper.NewGroupMembershipFromHost(ctx, meta.(*config.ProviderConf), toks[0])
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 	memberList := make([]string, len(gm.GroupMembers))
 
@@ -56,20 +65,20 @@ func resourceADGroupMembershipRead(d *schema.ResourceData, meta interface{}) err
 	return nil
 }
 
-func resourceADGroupMembershipCreate(d *schema.ResourceData, meta interface{}) error {
+func resourceADGroupMembershipCreate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	gm, err := winrmhelper.NewGroupMembershipFromState(d)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
-	err = gm.Create(meta.(*config.ProviderConf))
+	err = gm.Create(ctx, meta.(*config.ProviderConf))
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
 	membershipUUID, err := uuid.GenerateUUID()
 	if err != nil {
-		return fmt.Errorf("while generating UUID to use as unique membership ID: %s", err)
+		return diag.Errorf("while generating UUID to use as unique membership ID: %s", err)
 	}
 
 	id := fmt.Sprintf("%s_%s", gm.GroupGUID, membershipUUID)
@@ -78,29 +87,29 @@ func resourceADGroupMembershipCreate(d *schema.ResourceData, meta interface{}) e
 	return nil
 }
 
-func resourceADGroupMembershipUpdate(d *schema.ResourceData, meta interface{}) error {
+func resourceADGroupMembershipUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	gm, err := winrmhelper.NewGroupMembershipFromState(d)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
-	err = gm.Update(meta.(*config.ProviderConf), gm.GroupMembers)
+	err = gm.Update(ctx, meta.(*config.ProviderConf), gm.GroupMembers)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
-	return resourceADGroupMembershipRead(d, meta)
+	return resourceADGroupMembershipRead(ctx, d, meta)
 }
 
-func resourceADGroupMembershipDelete(d *schema.ResourceData, meta interface{}) error {
+func resourceADGroupMembershipDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	gm, err := winrmhelper.NewGroupMembershipFromState(d)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
-	err = gm.Delete(meta.(*config.ProviderConf))
+	err = gm.Delete(ctx, meta.(*config.ProviderConf))
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
 	d.SetId("")
@@ -1,6 +1,8 @@
 package ad
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"strings"
 	"testing"
@@ -91,9 +93,9 @@ func testAccResourceADGroupMembershipExists(resourceName string, expected bool,
 		}
 
 		toks := strings.Split(rs.Primary.ID, "_")
-		gm, err := winrmhelper.NewGroupMembershipFromHost(testAccProvider.Meta().(*config.ProviderConf), toks[0])
+		gm, err := winrmhelper.NewGroupMembershipFromHost(context.Background(), testAccProvider.Meta().(*config.ProviderConf), toks[0])
 		if err != nil {
-			if strings.Contains(err.Error(), "ADIdentityNotFoundException") && !expected {
+			if errors.Is(err, winrmhelper.ErrNotFound) && !expected {
 				return nil
 			}
 			return err
@@ -203,3 +205,75 @@ func testAccResourceADGroupMembershipUpdate() string {
 		}
 `
 }
+
+func TestAccResourceADGroupMembership_Contact(t *testing.T) {
+	envVars := []string{
+		"TF_VAR_ad_group_name",
+		"TF_VAR_ad_group_sam",
+		"TF_VAR_ad_group_container",
+		"TF_VAR_ad_group2_name",
+		"TF_VAR_ad_group2_sam",
+		"TF_VAR_ad_group2_container",
+		"TF_VAR_ad_contact_name",
+		"TF_VAR_ad_contact_container",
+	}
+	resource.Test(t, resource.TestCase{
+		PreCheck:  func() { testAccPreCheck(t, envVars) },
+		Providers: testAccProviders,
+		CheckDestroy: resource.ComposeTestCheckFunc(
+			testAccResourceADGroupMembershipExists("ad_group_membership.gm", false, 0),
+		),
+		Steps: []resource.TestStep{
+			{
+				Config: testAccResourceADGroupMembershipContact(),
+				Check: resource.ComposeTestCheckFunc(
+					testAccResourceADGroupMembershipExists("ad_group_membership.gm", true, 2),
+				),
+			},
+			{
+				ResourceName:      "ad_group_membership.gm",
+				ImportState:       true,
+				ImportStateVerify: true,
+			},
+		},
+	})
+}
+
+func testAccResourceADGroupMembershipContact() string {
+	return `
+		variable "ad_group_name" {}
+		variable "ad_group_sam" {}
+		variable "ad_group_container" {}
+
+		variable "ad_group2_name" {}
+		variable "ad_group2_sam" {}
+		variable "ad_group2_container" {}
+
+		variable "ad_contact_name" {}
+		variable "ad_contact_container" {}
+
+		resource ad_group "g" {
+			name             = var.ad_group_name
+			sam_account_name = var.ad_group_sam
+			container        = var.ad_group_container
+			category         = "distribution"
+		}
+
+		resource ad_group "g2" {
+			name             = var.ad_group2_name
+			sam_account_name = var.ad_group2_sam
+			container        = var.ad_group2_container
+		}
+
+		resource ad_contact "c" {
+			name          = var.ad_contact_name
+			container     = var.ad_contact_container
+			email_address = "vendor@example.com"
+		}
+
+		resource ad_group_membership "gm" {
+			group_id = ad_group.g.id
+			group_members  = [ad_group.g2.id, ad_contact.c.id]
+		}
+	`
+}
@@ -1,9 +1,10 @@
 package ad
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
-	"strings"
 	"testing"
 
 	"github.com/hashicorp/terraform-provider-ad/ad/internal/config"
@@ -145,15 +146,9 @@ func testAccResourceADGroupExists(name, groupSAM string, expected bool) resource
 		if !ok {
 			return fmt.Errorf("%s key not found on the server", name)
 		}
-		client, err := conf.AcquireWinRMClient()
+		u, err := winrmhelper.GetGroupFromHost(context.Background(), conf, rs.Primary.ID)
 		if err != nil {
-			return err
-		}
-		defer conf.ReleaseWinRMClient(client)
-
-		u, err := winrmhelper.GetGroupFromHost(conf, rs.Primary.ID)
-		if err != nil {
-			if strings.Contains(err.Error(), "ADIdentityNotFoundException") && !expected {
+			if errors.Is(err, winrmhelper.ErrNotFound) && !expected {
 				return nil
 			}
 			return err
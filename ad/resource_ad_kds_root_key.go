@@ -0,0 +1,115 @@
+package ad
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/hashicorp/terraform-provider-ad/ad/internal/config"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+	"github.com/hashicorp/terraform-provider-ad/ad/internal/winrmhelper"
+)
+
+func resourceADKdsRootKey() *schema.Resource {
+	return &schema.Resource{
+		Description:   "`ad_kds_root_key` manages the root keys of the Group Key Distribution Service, which group Managed Service Accounts require. Root keys cannot be removed: destroying the resource only removes it from the state.",
+		CreateContext: resourceADKdsRootKeyCreate,
+		ReadContext:   resourceADKdsRootKeyRead,
+		DeleteContext: resourceADKdsRootKeyDelete,
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(5 * time.Minute),
+			Read:   schema.DefaultTimeout(5 * time.Minute),
+			Delete: schema.DefaultTimeout(5 * time.Minute),
+		},
+		Importer: &schema.ResourceImporter{
+			StateContext: schema.ImportStatePassthroughContext,
+		},
+		Schema: map[string]*schema.Schema{
+			"effective_immediately": {
+				Type:          schema.TypeBool,
+				Optional:      true,
+				Default:       false,
+				ForceNew:      true,
+				ConflictsWith: []string{"effective_time"},
+				Description:   "If set to true, the key is effective as soon as it is created. Domain controllers still wait for 10 hours before using it, to let it replicate. Set `effective_time` 10 hours in the past instead in lab forests with a single domain controller.",
+			},
+			"effective_time": {
+				Type:             schema.TypeString,
+				Optional:         true,
+				Computed:         true,
+				ForceNew:         true,
+				ValidateFunc:     validation.IsRFC3339Time,
+				DiffSuppressFunc: suppressTimeDiff,
+				Description:      "The time the key becomes effective, in RFC 3339 format. Defaults to 10 hours after its creation.",
+			},
+			"key_id": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The ID of the key.",
+			},
+			"creation_time": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The time the key was created, in RFC 3339 format.",
+			},
+		},
+	}
+}
+
+// suppressTimeDiff suppresses the differences between two RFC 3339 representations of the same time.
+func suppressTimeDiff(k, old, new string, d *schema.ResourceData) bool {
+	oldTime, err := time.Parse(time.RFC3339, old)
+	if err != nil {
+		return false
+	}
+	newTime, err := time.Parse(time.RFC3339, new)
+	if err != nil {
+		return false
+	}
+	return oldTime.Equal(newTime)
+}
+
+func resourceADKdsRootKeyCreate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	var effectiveTime time.Time
+	if v := d.Get("effective_time").(string); v != "" {
+		// The value was validated as an RFC 3339 time.
+		effectiveTime, _ = time.Parse(time.RFC3339, v)
+	}
+	keyID, err := winrmhelper.AddKdsRootKey(ctx, meta.(*config.ProviderConf), d.Get("effective_immediately").(bool), effectiveTime)
+	if err != nil {
+		return diag.Errorf("error while creating KDS root key: %s", err)
+	}
+	d.SetId(keyID)
+	return resourceADKdsRootKeyRead(ctx, d, meta)
+}
+
+func resourceADKdsRootKeyRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	if d.Id() == "" {
+		return nil
+	}
+	key, err := winrmhelper.GetKdsRootKey(ctx, meta.(*config.ProviderConf), d.Id())
+	if err != nil {
+		if errors.Is(err, winrmhelper.ErrNotFound) {
+			d.SetId("")
+			return nil
+		}
+		return diag.Errorf("error while reading KDS root key %q: %s", d.Id(), err)
+	}
+	_ = d.Set("key_id", key.KeyID)
+	_ = d.Set("effective_time", key.EffectiveTime.Format(time.RFC3339))
+	_ = d.Set("creation_time", key.CreationTime.Format(time.RFC3339))
+	return nil
+}
+
+func resourceADKdsRootKeyDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	log.Printf("[WARN] KDS root key %q cannot be removed, it is only removed from the state", d.Id())
+	return diag.Diagnostics{{
+		Severity: diag.Warning,
+		Summary:  "KDS root keys cannot be removed",
+		Detail:   "The key " + d.Id() + " was removed from the state, but it is still used by the domain controllers.",
+	}}
+}
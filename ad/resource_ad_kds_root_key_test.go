@@ -0,0 +1,64 @@
+package ad
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/terraform-provider-ad/ad/internal/config"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	"github.com/hashicorp/terraform-provider-ad/ad/internal/winrmhelper"
+)
+
+// The keys created by this test cannot be removed, run it against a lab forest.
+func TestAccResourceADKdsRootKey_basic(t *testing.T) {
+	envVars := []string{"TF_VAR_ad_kds_effective_time"}
+	resource.Test(t, resource.TestCase{
+		PreCheck:  func() { testAccPreCheck(t, envVars) },
+		Providers: testAccProviders,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccResourceADKdsRootKeyConfigBasic(),
+				Check: resource.ComposeTestCheckFunc(
+					testAccResourceADKdsRootKeyExists("ad_kds_root_key.k"),
+					resource.TestCheckResourceAttrSet("ad_kds_root_key.k", "creation_time"),
+				),
+			},
+			{
+				ResourceName:      "ad_kds_root_key.k",
+				ImportState:       true,
+				ImportStateVerify: true,
+			},
+		},
+	})
+}
+
+func testAccResourceADKdsRootKeyConfigBasic() string {
+	return `
+variable "ad_kds_effective_time" {}
+
+resource "ad_kds_root_key" "k" {
+	effective_time = var.ad_kds_effective_time
+}
+`
+}
+
+func testAccResourceADKdsRootKeyExists(resource string) resource.TestCheckFunc {
+	return func(s *terraform.State) error {
+		rs, ok := s.RootModule().Resources[resource]
+		if !ok {
+			return fmt.Errorf("%s key not found in state", resource)
+		}
+
+		key, err := winrmhelper.GetKdsRootKey(context.Background(), testAccProvider.Meta().(*config.ProviderConf), rs.Primary.ID)
+		if err != nil {
+			return err
+		}
+		if key.EffectiveTime.IsZero() {
+			return fmt.Errorf("KDS root key %q has no effective time", key.KeyID)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,183 @@
+package ad
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/hashicorp/terraform-provider-ad/ad/internal/config"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+	"github.com/hashicorp/terraform-provider-ad/ad/internal/winrmhelper"
+)
+
+func resourceADManagedServiceAccount() *schema.Resource {
+	return &schema.Resource{
+		Description:   "`ad_managed_service_account` manages standalone and group Managed Service Accounts in an Active Directory tree.",
+		CreateContext: resourceADManagedServiceAccountCreate,
+		ReadContext:   resourceADManagedServiceAccountRead,
+		UpdateContext: resourceADManagedServiceAccountUpdate,
+		DeleteContext: resourceADManagedServiceAccountDelete,
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(5 * time.Minute),
+			Read:   schema.DefaultTimeout(5 * time.Minute),
+			Update: schema.DefaultTimeout(5 * time.Minute),
+			Delete: schema.DefaultTimeout(5 * time.Minute),
+		},
+		Importer: &schema.ResourceImporter{
+			StateContext: schema.ImportStatePassthroughContext,
+		},
+		Schema: map[string]*schema.Schema{
+			"name": {
+				Type:             schema.TypeString,
+				Required:         true,
+				ForceNew:         true,
+				DiffSuppressFunc: suppressCaseDiff,
+				ValidateFunc:     validation.StringLenBetween(1, 15),
+				Description:      "The name of the service account. Its SAM account name is the name followed by `$`.",
+			},
+			"standalone": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				ForceNew:    true,
+				Description: "If set to true, a standalone Managed Service Account restricted to a single computer is created instead of a group Managed Service Account.",
+			},
+			"container": {
+				Type:             schema.TypeString,
+				Optional:         true,
+				Computed:         true,
+				DiffSuppressFunc: suppressCaseDiff,
+				Description:      "A DN of the container object that will be holding the service account. Defaults to the Managed Service Accounts container of the domain.",
+			},
+			"dns_host_name": {
+				Type:             schema.TypeString,
+				Optional:         true,
+				DiffSuppressFunc: suppressCaseDiff,
+				Description:      "The DNS host name of the service account. It is required by group Managed Service Accounts used for inbound authentication.",
+			},
+			"description": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Specifies a description of the object. This parameter sets the value of the Description property for the service account object.",
+			},
+			"display_name": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "The display name of the service account.",
+			},
+			"enabled": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     true,
+				Description: "If set to false, the service account will be disabled.",
+			},
+			"principals_allowed_to_retrieve_password": {
+				Type:        schema.TypeSet,
+				Optional:    true,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Description: "The GUIDs of the groups and computers allowed to retrieve the password of a group Managed Service Account.",
+			},
+			"service_principal_names": {
+				Type:        schema.TypeSet,
+				Optional:    true,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Description: "The Service Principal Names of the service account, for instance `HTTP/web.contoso.com`.",
+			},
+			"kerberos_encryption_types": {
+				Type:     schema.TypeSet,
+				Optional: true,
+				Elem: &schema.Schema{
+					Type:         schema.TypeString,
+					ValidateFunc: validation.StringInSlice([]string{"DES", "RC4", "AES128", "AES256"}, false),
+				},
+				Description: "The Kerberos encryption types the service account supports. Can be `DES`, `RC4`, `AES128` and `AES256`. When none is set, msDS-SupportedEncryptionTypes is left empty and the domain controllers use the default encryption types of the domain.",
+			},
+			"password_change_interval": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Computed:     true,
+				ForceNew:     true,
+				ValidateFunc: validation.IntAtLeast(1),
+				Description:  "How many days pass before the password of a group Managed Service Account changes. It can only be set when the account is created. Defaults to 30.",
+			},
+			"sam_account_name": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The SAM account name of the service account.",
+			},
+			"sid": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The SID of the service account object.",
+			},
+			"dn": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The distinguished name of the service account object.",
+			},
+		},
+	}
+}
+
+func resourceADManagedServiceAccountCreate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	m := winrmhelper.GetManagedServiceAccountFromResource(d)
+	guid, err := m.Create(ctx, meta.(*config.ProviderConf))
+	if err != nil {
+		return diag.Errorf("error while creating service account: %s", err)
+	}
+	d.SetId(guid)
+	return resourceADManagedServiceAccountRead(ctx, d, meta)
+}
+
+func resourceADManagedServiceAccountRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	log.Printf("Reading ad_managed_service_account resource for service account with guid: %q", d.Id())
+	m, err := winrmhelper.GetManagedServiceAccountFromHost(ctx, meta.(*config.ProviderConf), d.Id())
+	if err != nil {
+		if errors.Is(err, winrmhelper.ErrNotFound) {
+			d.SetId("")
+			return nil
+		}
+		return diag.Errorf("error while reading service account with GUID %q: %s", d.Id(), err)
+	}
+	_ = d.Set("name", m.Name)
+	_ = d.Set("standalone", m.Standalone)
+	_ = d.Set("container", m.Container)
+	_ = d.Set("dns_host_name", m.DNSHostName)
+	_ = d.Set("description", m.Description)
+	_ = d.Set("display_name", m.DisplayName)
+	_ = d.Set("enabled", m.Enabled)
+	_ = d.Set("principals_allowed_to_retrieve_password", []string(m.PrincipalsAllowedToRetrieveManagedPassword))
+	_ = d.Set("service_principal_names", []string(m.ServicePrincipalNames))
+	_ = d.Set("kerberos_encryption_types", m.KerberosEncryptionTypes)
+	_ = d.Set("password_change_interval", m.ManagedPasswordInterval)
+	_ = d.Set("sam_account_name", m.SAMAccountName)
+	_ = d.Set("sid", m.SID.Value)
+	_ = d.Set("dn", m.DistinguishedName)
+
+	return nil
+}
+
+func resourceADManagedServiceAccountUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	m := winrmhelper.GetManagedServiceAccountFromResource(d)
+	err := m.Modify(ctx, d, meta.(*config.ProviderConf))
+	if err != nil {
+		return diag.Errorf("error while updating service account with GUID %q: %s", d.Id(), err)
+	}
+	return resourceADManagedServiceAccountRead(ctx, d, meta)
+}
+
+func resourceADManagedServiceAccountDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	if d.Id() == "" {
+		return nil
+	}
+	m := winrmhelper.GetManagedServiceAccountFromResource(d)
+	err := m.Delete(ctx, meta.(*config.ProviderConf))
+	if err != nil {
+		return diag.Errorf("error while deleting service account with GUID %q: %s", d.Id(), err)
+	}
+	return nil
+}
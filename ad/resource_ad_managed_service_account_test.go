@@ -0,0 +1,118 @@
+package ad
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/hashicorp/terraform-provider-ad/ad/internal/config"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	"github.com/hashicorp/terraform-provider-ad/ad/internal/winrmhelper"
+)
+
+func TestAccResourceADManagedServiceAccount_basic(t *testing.T) {
+	name := os.Getenv("TF_VAR_ad_msa_name")
+
+	envVars := []string{"TF_VAR_ad_msa_name", "TF_VAR_ad_msa_dns_host_name", "TF_VAR_ad_group_name", "TF_VAR_ad_group_sam", "TF_VAR_ad_group_container"}
+	resource.Test(t, resource.TestCase{
+		PreCheck:  func() { testAccPreCheck(t, envVars) },
+		Providers: testAccProviders,
+		CheckDestroy: resource.ComposeTestCheckFunc(
+			testAccResourceADManagedServiceAccountExists("ad_managed_service_account.m", name, 0, false),
+		),
+		Steps: []resource.TestStep{
+			{
+				Config: testAccResourceADManagedServiceAccountConfigBasic(),
+				Check: resource.ComposeTestCheckFunc(
+					testAccResourceADManagedServiceAccountExists("ad_managed_service_account.m", name, 0, true),
+				),
+			},
+			{
+				Config: testAccResourceADManagedServiceAccountConfigPrincipals(),
+				Check: resource.ComposeTestCheckFunc(
+					testAccResourceADManagedServiceAccountExists("ad_managed_service_account.m", name, 1, true),
+					resource.TestCheckResourceAttr("ad_managed_service_account.m", "service_principal_names.#", "1"),
+					resource.TestCheckResourceAttr("ad_managed_service_account.m", "kerberos_encryption_types.#", "2"),
+				),
+			},
+			{
+				ResourceName:      "ad_managed_service_account.m",
+				ImportState:       true,
+				ImportStateVerify: true,
+			},
+			{
+				Config: testAccResourceADManagedServiceAccountConfigBasic(),
+				Check: resource.ComposeTestCheckFunc(
+					testAccResourceADManagedServiceAccountExists("ad_managed_service_account.m", name, 0, true),
+					resource.TestCheckResourceAttr("ad_managed_service_account.m", "service_principal_names.#", "0"),
+				),
+			},
+		},
+	})
+}
+
+func testAccResourceADManagedServiceAccountConfigBasic() string {
+	return `
+variable "ad_msa_name" {}
+variable "ad_msa_dns_host_name" {}
+
+resource "ad_managed_service_account" "m" {
+	name = var.ad_msa_name
+	dns_host_name = var.ad_msa_dns_host_name
+}
+`
+}
+
+func testAccResourceADManagedServiceAccountConfigPrincipals() string {
+	return `
+variable "ad_msa_name" {}
+variable "ad_msa_dns_host_name" {}
+variable "ad_group_name" {}
+variable "ad_group_sam" {}
+variable "ad_group_container" {}
+
+resource "ad_group" "g" {
+	name = var.ad_group_name
+	sam_account_name = var.ad_group_sam
+	container = var.ad_group_container
+}
+
+resource "ad_managed_service_account" "m" {
+	name = var.ad_msa_name
+	dns_host_name = var.ad_msa_dns_host_name
+	principals_allowed_to_retrieve_password = [ad_group.g.id]
+	service_principal_names = ["HTTP/${var.ad_msa_dns_host_name}"]
+	kerberos_encryption_types = ["AES128", "AES256"]
+}
+`
+}
+
+func testAccResourceADManagedServiceAccountExists(resource, name string, principals int, expected bool) resource.TestCheckFunc {
+	return func(s *terraform.State) error {
+		rs, ok := s.RootModule().Resources[resource]
+		if !ok {
+			return fmt.Errorf("%s key not found in state", resource)
+		}
+
+		guid := rs.Primary.ID
+		m, err := winrmhelper.GetManagedServiceAccountFromHost(context.Background(), testAccProvider.Meta().(*config.ProviderConf), guid)
+		if err != nil {
+			if errors.Is(err, winrmhelper.ErrNotFound) && !expected {
+				return nil
+			}
+			return err
+		}
+
+		if m.Name != name {
+			return fmt.Errorf("service account name %q does not match expected name %q", m.Name, name)
+		}
+		if len(m.PrincipalsAllowedToRetrieveManagedPassword) != principals {
+			return fmt.Errorf("expected %d principals allowed to retrieve the password, got %v", principals, m.PrincipalsAllowedToRetrieveManagedPassword)
+		}
+		return nil
+	}
+}
@@ -1,21 +1,30 @@
 package ad
 
 import (
-	"strings"
+	"context"
+	"errors"
+	"time"
 
 	"github.com/hashicorp/terraform-provider-ad/ad/internal/config"
 
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"github.com/hashicorp/terraform-provider-ad/ad/internal/winrmhelper"
 )
 
 func resourceADOU() *schema.Resource {
 	return &schema.Resource{
-		Description: "`ad_ou` manages OU objects in an AD tree.",
-		Read:        resourceADOURead,
-		Create:      resourceADOUCreate,
-		Update:      resourceADOUUpdate,
-		Delete:      resourceADOUDelete,
+		Description:   "`ad_ou` manages OU objects in an AD tree.",
+		ReadContext:   resourceADOURead,
+		CreateContext: resourceADOUCreate,
+		UpdateContext: resourceADOUUpdate,
+		DeleteContext: resourceADOUDelete,
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(5 * time.Minute),
+			Read:   schema.DefaultTimeout(5 * time.Minute),
+			Update: schema.DefaultTimeout(5 * time.Minute),
+			Delete: schema.DefaultTimeout(5 * time.Minute),
+		},
 		Importer: &schema.ResourceImporter{
 			StateContext: schema.ImportStatePassthroughContext,
 		},
@@ -56,19 +65,19 @@ func resourceADOU() *schema.Resource {
 	}
 }
 
-func resourceADOURead(d *schema.ResourceData, meta interface{}) error {
+func resourceADOURead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	if d.Id() == "" {
 		return nil
 	}
 
-	ou, err := winrmhelper.NewOrgUnitFromHost(meta.(*config.ProviderConf), d.Id(), "", "")
+	ou, err := winrmhelper.NewOrgUnitFromHost(ctx, meta.(*config.ProviderConf), d.Id(), "", "")
 	if err != nil {
-		if strings.Contains(err.Error(), "ObjectNotFound") {
+		if errors.Is(err, winrmhelper.ErrNotFound) {
 			// Resource no longer exists
 			d.SetId("")
 			return nil
 		}
-		return err
+		return diag.FromErr(err)
 	}
 
 	_ = d.Set("name", ou.Name)
@@ -81,18 +90,18 @@ func resourceADOURead(d *schema.ResourceData, meta interface{}) error {
 	return nil
 }
 
-func resourceADOUCreate(d *schema.ResourceData, meta interface{}) error {
+func resourceADOUCreate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	ou := winrmhelper.NewOrgUnitFromResource(d)
-	guid, err := ou.Create(meta.(*config.ProviderConf))
+	guid, err := ou.Create(ctx, meta.(*config.ProviderConf))
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 	d.SetId(guid)
 
-	return resourceADOURead(d, meta)
+	return resourceADOURead(ctx, d, meta)
 }
 
-func resourceADOUUpdate(d *schema.ResourceData, meta interface{}) error {
+func resourceADOUUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	ou := winrmhelper.NewOrgUnitFromResource(d)
 
 	keys := []string{"description", "name", "path", "protected"}
@@ -103,18 +112,18 @@ func resourceADOUUpdate(d *schema.ResourceData, meta interface{}) error {
 		}
 	}
 
-	err := ou.Update(meta.(*config.ProviderConf), changes)
+	err := ou.Update(ctx, meta.(*config.ProviderConf), changes)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
-	return resourceADOURead(d, meta)
+	return resourceADOURead(ctx, d, meta)
 }
 
-func resourceADOUDelete(d *schema.ResourceData, meta interface{}) error {
+func resourceADOUDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	ou := winrmhelper.NewOrgUnitFromResource(d)
-	err := ou.Delete(meta.(*config.ProviderConf))
+	err := ou.Delete(ctx, meta.(*config.ProviderConf))
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
 	return nil
@@ -1,9 +1,10 @@
 package ad
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
-	"strings"
 	"testing"
 
 	"github.com/hashicorp/terraform-provider-ad/ad/internal/config"
@@ -79,9 +80,9 @@ func testAccResourceADOUExists(resource, name string, expected bool) resource.Te
 			return fmt.Errorf("%s key not found in state", resource)
 		}
 		guid := rs.Primary.ID
-		ou, err := winrmhelper.NewOrgUnitFromHost(testAccProvider.Meta().(*config.ProviderConf), guid, "", "")
+		ou, err := winrmhelper.NewOrgUnitFromHost(context.Background(), testAccProvider.Meta().(*config.ProviderConf), guid, "", "")
 		if err != nil {
-			if strings.Contains(err.Error(), "ObjectNotFound") && !expected {
+			if errors.Is(err, winrmhelper.ErrNotFound) && !expected {
 				return nil
 			}
 			return err
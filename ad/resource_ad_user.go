@@ -1,15 +1,17 @@
 package ad
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"log"
 	"reflect"
-	"strings"
+	"time"
 
 	"github.com/hashicorp/terraform-provider-ad/ad/internal/config"
 
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/structure"
 
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
 	"github.com/hashicorp/terraform-provider-ad/ad/internal/winrmhelper"
@@ -17,11 +19,17 @@ import (
 
 func resourceADUser() *schema.Resource {
 	return &schema.Resource{
-		Description: "`ad_user` manages User objects in an Active Directory tree.",
-		Create:      resourceADUserCreate,
-		Read:        resourceADUserRead,
-		Update:      resourceADUserUpdate,
-		Delete:      resourceADUserDelete,
+		Description:   "`ad_user` manages User objects in an Active Directory tree.",
+		CreateContext: resourceADUserCreate,
+		ReadContext:   resourceADUserRead,
+		UpdateContext: resourceADUserUpdate,
+		DeleteContext: resourceADUserDelete,
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(5 * time.Minute),
+			Read:   schema.DefaultTimeout(5 * time.Minute),
+			Update: schema.DefaultTimeout(5 * time.Minute),
+			Delete: schema.DefaultTimeout(5 * time.Minute),
+		},
 		Importer: &schema.ResourceImporter{
 			StateContext: schema.ImportStatePassthroughContext,
 		},
@@ -44,6 +52,7 @@ func resourceADUser() *schema.Resource {
 			"initial_password": {
 				Type:        schema.TypeString,
 				Optional:    true,
+				Sensitive:   true,
 				Description: "The user's initial password. This will be set on creation but will *not* be enforced in subsequent plans.",
 			},
 			"container": {
@@ -257,15 +266,15 @@ func suppressJsonDiff(k, old, new string, d *schema.ResourceData) bool {
 	return reflect.DeepEqual(oldSortedMap, newSortedMap)
 }
 
-func resourceADUserCreate(d *schema.ResourceData, meta interface{}) error {
+func resourceADUserCreate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	u, err := winrmhelper.GetUserFromResource(d)
 	if err != nil {
-		return fmt.Errorf("while building a User struct from resource data: %s", err)
+		return diag.Errorf("while building a User struct from resource data: %s", err)
 	}
 
-	guid, err := u.NewUser(meta.(*config.ProviderConf))
+	guid, err := u.NewUser(ctx, meta.(*config.ProviderConf))
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 	d.SetId(guid)
 	// We need to set this so we can then retrieve the list of attributes to look for while "reading"
@@ -276,29 +285,30 @@ func resourceADUserCreate(d *schema.ResourceData, meta interface{}) error {
 		}
 		ca, err := structure.FlattenJsonToString(caMap)
 		if err != nil {
-			return err
+			return diag.FromErr(err)
 		}
 		_ = d.Set("custom_attributes", ca)
 	}
 
-	return resourceADUserRead(d, meta)
+	return resourceADUserRead(ctx, d, meta)
 }
 
-func resourceADUserRead(d *schema.ResourceData, meta interface{}) error {
+func resourceADUserRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	log.Printf("Reading ad_user resource for user with guid: %q", d.Id())
 	// get attribute keys from json blob
 	caKeys, err := extractCustAttrKeys(d)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
-	u, err := winrmhelper.GetUserFromHost(meta.(*config.ProviderConf), d.Id(), caKeys)
+	winrmhelper.PrefetchUsers(ctx, meta.(*config.ProviderConf), d.Get("container").(string))
+	u, err := winrmhelper.GetUserFromHost(ctx, meta.(*config.ProviderConf), d.Id(), caKeys)
 	if err != nil {
-		if strings.Contains(err.Error(), "ADIdentityNotFoundException") {
+		if errors.Is(err, winrmhelper.ErrNotFound) {
 			d.SetId("")
 			return nil
 		}
-		return err
+		return diag.FromErr(err)
 	}
 	if u == nil {
 		d.SetId("")
@@ -346,7 +356,7 @@ func resourceADUserRead(d *schema.ResourceData, meta interface{}) error {
 	if u.CustomAttributes != nil {
 		ca, err := structure.FlattenJsonToString(u.CustomAttributes)
 		if err != nil {
-			return err
+			return diag.FromErr(err)
 		}
 		_ = d.Set("custom_attributes", ca)
 	}
@@ -354,30 +364,30 @@ func resourceADUserRead(d *schema.ResourceData, meta interface{}) error {
 	return nil
 }
 
-func resourceADUserUpdate(d *schema.ResourceData, meta interface{}) error {
+func resourceADUserUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	u, err := winrmhelper.GetUserFromResource(d)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
-	err = u.ModifyUser(d, meta.(*config.ProviderConf))
+	err = u.ModifyUser(ctx, d, meta.(*config.ProviderConf))
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
-	return resourceADUserRead(d, meta)
+	return resourceADUserRead(ctx, d, meta)
 }
 
-func resourceADUserDelete(d *schema.ResourceData, meta interface{}) error {
-	u, err := winrmhelper.GetUserFromHost(meta.(*config.ProviderConf), d.Id(), nil)
+func resourceADUserDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	u, err := winrmhelper.GetUserFromHost(ctx, meta.(*config.ProviderConf), d.Id(), nil)
 	if err != nil {
-		if strings.Contains(err.Error(), "ADIdentityNotFoundException") {
+		if errors.Is(err, winrmhelper.ErrNotFound) {
 			return nil
 		}
-		return fmt.Errorf("while retrieving user data from host: %s", err)
+		return diag.Errorf("while retrieving user data from host: %s", err)
 	}
-	err = u.DeleteUser(meta.(*config.ProviderConf))
+	err = u.DeleteUser(ctx, meta.(*config.ProviderConf))
 	if err != nil {
-		return fmt.Errorf("while deleting user: %s", err)
+		return diag.Errorf("while deleting user: %s", err)
 	}
 	return nil
 }
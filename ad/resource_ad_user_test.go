@@ -1,6 +1,8 @@
 package ad
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"reflect"
@@ -360,7 +362,7 @@ func retrieveADUserFromRunningState(name string, s *terraform.State, attributeLi
 	if !ok {
 		return nil, fmt.Errorf("%s key not found in state", name)
 	}
-	u, err := winrmhelper.GetUserFromHost(testAccProvider.Meta().(*config.ProviderConf), rs.Primary.ID, attributeList)
+	u, err := winrmhelper.GetUserFromHost(context.Background(), testAccProvider.Meta().(*config.ProviderConf), rs.Primary.ID, attributeList)
 
 	return u, err
 
@@ -385,7 +387,7 @@ func testAccResourceADUserExists(name, username string, expected bool) resource.
 	return func(s *terraform.State) error {
 		u, err := retrieveADUserFromRunningState(name, s, nil)
 		if err != nil {
-			if strings.Contains(err.Error(), "ADIdentityNotFoundException") && !expected {
+			if errors.Is(err, winrmhelper.ErrNotFound) && !expected {
 				return nil
 			}
 			return err
@@ -0,0 +1,7 @@
+data "ad_contact" "c" {
+  contact_id = "CN=Doe\\, Jane,OU=Vendors,DC=contoso,DC=com"
+}
+
+output "contact_mail" {
+  value = data.ad_contact.c.email_address
+}
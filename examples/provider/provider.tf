@@ -44,6 +44,15 @@ provider "ad" {
   krb_conf       = "/etc/krb5.conf"
 }
 
+// users, groups, computers and OUs managed over LDAPS using Kerberos authentication
+provider "ad" {
+  winrm_hostname = var.hostname
+  winrm_username = var.username
+  winrm_password = var.password
+  krb_realm      = "YOURDOMAIN.COM"
+  backend        = "ldap"
+}
+
 // local (windows only)
 provider "ad" {
   winrm_hostname = ""
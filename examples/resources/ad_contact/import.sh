@@ -0,0 +1 @@
+$ terraform import ad_contact 0B7A3C5E-8D2F-4E1A-9B6C-4F3E2D1C0B9A
@@ -0,0 +1,29 @@
+resource "ad_contact" "vendor" {
+  name           = "Doe, Jane"
+  container      = "OU=Vendors,DC=contoso,DC=com"
+  display_name   = "Jane Doe (Fabrikam)"
+  given_name     = "Jane"
+  surname        = "Doe"
+  email_address  = "jane.doe@fabrikam.com"
+  office_phone   = "+1 555 0100"
+  company        = "Fabrikam"
+  street_address = "1 Main Street"
+  city           = "Springfield"
+  postal_code    = "12345"
+  country        = "US"
+  custom_attributes = jsonencode({
+    "info" : "Account manager"
+  })
+}
+
+resource "ad_group" "vendors" {
+  name             = "vendors"
+  sam_account_name = "vendors"
+  container        = "OU=Groups,DC=contoso,DC=com"
+  category         = "distribution"
+}
+
+resource "ad_group_membership" "vendors" {
+  group_id      = ad_group.vendors.id
+  group_members = [ad_contact.vendor.id]
+}
@@ -0,0 +1 @@
+$ terraform import ad_default_domain_password_policy "DC=contoso,DC=com"
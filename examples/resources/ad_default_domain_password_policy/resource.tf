@@ -0,0 +1,8 @@
+resource "ad_default_domain_password_policy" "contoso" {
+  min_password_length        = 12
+  password_history_count     = 24
+  max_password_age           = "90.00:00:00"
+  lockout_threshold          = 10
+  lockout_duration           = "00:15:00"
+  lockout_observation_window = "00:15:00"
+}
@@ -0,0 +1 @@
+$ terraform import ad_fine_grained_password_policy 7A2E4C6B-1D3F-4E5A-9B8C-6D5E4F3A2B1C
@@ -0,0 +1,17 @@
+resource "ad_group" "admins" {
+  name             = "tier0-admins"
+  sam_account_name = "tier0-admins"
+  container        = "OU=Groups,DC=contoso,DC=com"
+}
+
+resource "ad_fine_grained_password_policy" "admins" {
+  name                       = "tier0-admins"
+  precedence                 = 10
+  min_password_length        = 15
+  max_password_age           = "90.00:00:00"
+  lockout_threshold          = 5
+  lockout_duration           = "01:00:00"
+  lockout_observation_window = "00:15:00"
+  protected                  = true
+  subjects                   = [ad_group.admins.id]
+}
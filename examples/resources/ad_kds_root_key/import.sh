@@ -0,0 +1 @@
+$ terraform import ad_kds_root_key 9C4E1D2A-7B3F-4A8E-B1C2-D3E4F5A6B7C8
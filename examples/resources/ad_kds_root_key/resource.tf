@@ -0,0 +1,16 @@
+# In a lab forest, an effective time 10 hours in the past lets group Managed
+# Service Accounts be created right away.
+resource "ad_kds_root_key" "k" {
+  effective_time = timeadd(plantimestamp(), "-10h")
+
+  lifecycle {
+    ignore_changes = [effective_time]
+  }
+}
+
+resource "ad_managed_service_account" "web" {
+  name          = "svc-web"
+  dns_host_name = "svc-web.contoso.com"
+
+  depends_on = [ad_kds_root_key.k]
+}
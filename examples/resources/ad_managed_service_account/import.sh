@@ -0,0 +1 @@
+$ terraform import ad_managed_service_account 3F1C8E2A-9B4D-4C6E-8A1F-2D3E4F5A6B7C
@@ -0,0 +1,13 @@
+resource "ad_group" "web_servers" {
+  name             = "web-servers"
+  sam_account_name = "web-servers"
+  container        = "OU=Groups,DC=contoso,DC=com"
+}
+
+resource "ad_managed_service_account" "web" {
+  name                                    = "svc-web"
+  dns_host_name                           = "svc-web.contoso.com"
+  principals_allowed_to_retrieve_password = [ad_group.web_servers.id]
+  service_principal_names                 = ["HTTP/web.contoso.com"]
+  kerberos_encryption_types               = ["AES128", "AES256"]
+}
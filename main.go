@@ -8,4 +8,5 @@ import (
 func main() {
 	plugin.Serve(&plugin.ServeOpts{
 		ProviderFunc: ad.Provider})
+	ad.CloseProviders()
 }